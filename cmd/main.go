@@ -2,11 +2,22 @@ package main
 
 import (
 	"airdao-mobile-api/config"
+	"airdao-mobile-api/pkg/apierror"
+	"airdao-mobile-api/pkg/deviceauth"
 	"airdao-mobile-api/pkg/firebase"
 	cloudmessaging "airdao-mobile-api/pkg/firebase/cloud-messaging"
+	"airdao-mobile-api/pkg/hmacauth"
+	"airdao-mobile-api/pkg/idempotency"
 	"airdao-mobile-api/pkg/logger"
+	"airdao-mobile-api/pkg/metrics"
 	"airdao-mobile-api/pkg/mongodb"
+	"airdao-mobile-api/pkg/openapi"
+	"airdao-mobile-api/pkg/pricefeed"
+	"airdao-mobile-api/pkg/ratelimit"
+	"airdao-mobile-api/services/admin"
+	"airdao-mobile-api/services/broadcast"
 	"airdao-mobile-api/services/health"
+	"airdao-mobile-api/services/price"
 	"airdao-mobile-api/services/watcher"
 	"context"
 	"errors"
@@ -74,14 +85,139 @@ func main() {
 		zapLogger.Fatalf("failed to create firebase message service - %v", err)
 	}
 
+	// Metrics
+	metricsRegistry := metrics.NewRegistry()
+
 	// Repository
 	watcherRepository, err := watcher.NewRepository(db, cfg.MongoDb.MongoDbName, zapLogger)
 	if err != nil {
 		zapLogger.Fatalf("failed to create watcher repository - %v", err)
 	}
 
+	subscriptionRepository, err := watcher.NewSubscriptionRepository(db, cfg.MongoDb.MongoDbName, zapLogger)
+	if err != nil {
+		zapLogger.Fatalf("failed to create subscription repository - %v", err)
+	}
+
+	priceRepository, err := price.NewRepository(db, cfg.MongoDb.MongoDbName, zapLogger)
+	if err != nil {
+		zapLogger.Fatalf("failed to create price repository - %v", err)
+	}
+
+	callbackRepository, err := watcher.NewCallbackRepository(db, cfg.MongoDb.MongoDbName, zapLogger)
+	if err != nil {
+		zapLogger.Fatalf("failed to create callback repository - %v", err)
+	}
+
+	txHistoryRepository, err := watcher.NewTxHistoryRepository(db, cfg.MongoDb.MongoDbName, zapLogger)
+	if err != nil {
+		zapLogger.Fatalf("failed to create tx history repository - %v", err)
+	}
+
+	// Price providers
+	tokenPriceProvider, err := pricefeed.NewTokenPriceProvider(cfg.TokenPriceUrl)
+	if err != nil {
+		zapLogger.Fatalf("failed to create token price provider - %v", err)
+	}
+
+	coinGecko, err := pricefeed.NewCoinGecko(cfg.Price.CoinGeckoApiUrl, cfg.Price.CoinGeckoCoinId)
+	if err != nil {
+		zapLogger.Fatalf("failed to create coingecko provider - %v", err)
+	}
+
+	priceProviders := []pricefeed.Provider{tokenPriceProvider, coinGecko}
+
+	// The DEX pool is an optional extra source
+	if cfg.Price.DexRpcUrl != "" {
+		dexPoolProvider, err := pricefeed.NewDexPoolProvider(pricefeed.DexPoolConfig{
+			RpcUrl:        cfg.Price.DexRpcUrl,
+			PairAddress:   cfg.Price.DexPairAddress,
+			BaseIsToken0:  cfg.Price.DexBaseIsToken0,
+			BaseDecimals:  cfg.Price.DexBaseDecimals,
+			QuoteDecimals: cfg.Price.DexQuoteDecimals,
+		})
+		if err != nil {
+			zapLogger.Fatalf("failed to create dex pool provider - %v", err)
+		}
+		priceProviders = append(priceProviders, dexPoolProvider)
+	}
+
+	aggregatorCfg := pricefeed.AggregatorConfig{
+		Interval:     cfg.Price.PollInterval,
+		MaxAge:       cfg.Price.MaxAge,
+		MaxDeviation: cfg.Price.MaxDeviation,
+		MinSources:   cfg.Price.MinSources,
+	}
+
+	priceFeed, err := pricefeed.NewAggregator(priceProviders, aggregatorCfg, zapLogger)
+	if err != nil {
+		zapLogger.Fatalf("failed to create price aggregator - %v", err)
+	}
+
+	priceFeeds := map[string]*pricefeed.Aggregator{price.DefaultToken: priceFeed}
+	priceCharts := map[string]*pricefeed.CoinGecko{price.DefaultToken: coinGecko}
+
+	// Other tokens are priced by CoinGecko alone
+	tokenCfg := aggregatorCfg
+	tokenCfg.MinSources = 1
+	tokenContracts := make(map[string]string)
+	for _, token := range cfg.Price.Tokens {
+		tokenCoinGecko, err := pricefeed.NewCoinGecko(cfg.Price.CoinGeckoApiUrl, token.CoinId)
+		if err != nil {
+			zapLogger.Fatalf("failed to create %s coingecko provider - %v", token.Symbol, err)
+		}
+
+		tokenFeed, err := pricefeed.NewAggregator([]pricefeed.Provider{tokenCoinGecko}, tokenCfg, zapLogger)
+		if err != nil {
+			zapLogger.Fatalf("failed to create %s price aggregator - %v", token.Symbol, err)
+		}
+
+		priceFeeds[token.Symbol] = tokenFeed
+		priceCharts[token.Symbol] = tokenCoinGecko
+		if token.Address != "" {
+			tokenContracts[token.Address] = token.Symbol
+		}
+	}
+
+	feeds, err := pricefeed.NewFeeds(priceFeeds, tokenContracts)
+	if err != nil {
+		zapLogger.Fatalf("failed to create price feeds - %v", err)
+	}
+
+	exchangeRateApi, err := pricefeed.NewExchangeRateApi(cfg.Fx.ApiUrl)
+	if err != nil {
+		zapLogger.Fatalf("failed to create exchange rate provider - %v", err)
+	}
+
+	fxRates, err := pricefeed.NewFxRates([]pricefeed.RateProvider{exchangeRateApi, coinGecko}, pricefeed.FxConfig{
+		Currencies: cfg.Fx.Currencies,
+		Interval:   cfg.Fx.PollInterval,
+		MaxAge:     cfg.Fx.MaxAge,
+	}, zapLogger)
+	if err != nil {
+		zapLogger.Fatalf("failed to create fx rates - %v", err)
+	}
+
+	deviceSigner, err := deviceauth.NewSigner(cfg.DeviceAuth.Secrets, cfg.DeviceAuth.AccessTokenTTL)
+	if err != nil {
+		zapLogger.Fatalf("failed to create device signer - %v", err)
+	}
+
+	if !cfg.DeviceAuth.AllowPushToken && !deviceSigner.Enabled() {
+		zapLogger.Fatal("push token auth is disabled but no device auth secrets are set")
+	}
+
 	// Services
-	watcherService, err := watcher.NewService(watcherRepository, cloudMessagingService, zapLogger, cfg.ExplorerApi, cfg.TokenPriceUrl, cfg.CallbackUrl, cfg.ExplorerToken)
+	priceService, err := price.NewService(priceRepository, feeds, priceCharts, zapLogger, cfg.PriceHistory)
+	if err != nil {
+		zapLogger.Fatalf("failed to create price service - %v", err)
+	}
+
+	if err := priceService.Init(context.Background()); err != nil {
+		zapLogger.Fatalf("failed to init price service - %v", err)
+	}
+
+	watcherService, err := watcher.NewService(watcherRepository, subscriptionRepository, txHistoryRepository, cloudMessagingService, zapLogger, metricsRegistry, feeds, fxRates, priceService, cfg.ExplorerApi, cfg.CallbackUrl, cfg.ExplorerToken, cfg.Backfill, cfg.Reconcile, cfg.Heartbeat, cfg.PriceAlert, deviceSigner, cfg.DeviceAuth)
 	if err != nil {
 		zapLogger.Fatalf("failed to create watcher service - %v", err)
 	}
@@ -90,17 +226,137 @@ func main() {
 		zapLogger.Fatalf("failed to init watchers - %v", err)
 	}
 
+	// Explorer callbacks are processed in the background until shutdown
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	callbackQueue, err := watcher.NewCallbackQueue(watcherService, callbackRepository, zapLogger, metricsRegistry, cfg.Callback.Workers, cfg.Callback.QueueSize)
+	if err != nil {
+		zapLogger.Fatalf("failed to create callback queue - %v", err)
+	}
+
+	if err := callbackQueue.Start(workersCtx); err != nil {
+		zapLogger.Fatalf("failed to start callback queue - %v", err)
+	}
+
+	broadcastRepository, err := broadcast.NewRepository(db, cfg.MongoDb.MongoDbName, zapLogger)
+	if err != nil {
+		zapLogger.Fatalf("failed to create broadcast repository - %v", err)
+	}
+
+	broadcastService, err := broadcast.NewService(broadcastRepository, watcherRepository, watcherService, cloudMessagingService, metricsRegistry, zapLogger, cfg.Broadcast)
+	if err != nil {
+		zapLogger.Fatalf("failed to create broadcast service - %v", err)
+	}
+
+	// Unfinished broadcasts resume here, or on another instance
+	if err := broadcastService.Init(workersCtx); err != nil {
+		zapLogger.Fatalf("failed to init broadcasts - %v", err)
+	}
+
 	// Handlers
-	healthHandler := health.NewHandler()
+	healthHandler := health.NewHandler(metricsRegistry, watcherService)
+
+	callbackVerifier, err := hmacauth.NewVerifier(cfg.Callback.Secrets, cfg.Callback.MaxSkew)
+	if err != nil {
+		zapLogger.Fatalf("failed to create callback verifier - %v", err)
+	}
+
+	// Rate limits
+	rateLimits := make(map[string]ratelimit.Limit)
+	if cfg.RateLimit.Enabled {
+		for group, value := range map[string]string{
+			watcher.RateLimitAuth:  cfg.RateLimit.Auth,
+			watcher.RateLimitRead:  cfg.RateLimit.Read,
+			watcher.RateLimitWrite: cfg.RateLimit.Write,
+		} {
+			limit, err := ratelimit.ParseLimit(value)
+			if err != nil {
+				zapLogger.Fatalf("failed to parse %s rate limit - %v", group, err)
+			}
+			rateLimits[group] = limit
+		}
+	}
+
+	// The admin API is limited whatever RATE_LIMIT_ENABLED says, its
+	// refused requests are audited
+	adminLimit, err := ratelimit.ParseLimit(cfg.Admin.RateLimit)
+	if err != nil {
+		zapLogger.Fatalf("failed to parse admin rate limit - %v", err)
+	}
+	rateLimits[admin.RateLimitAdmin] = adminLimit
+
+	var rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RateLimit.Store == config.StoreMongo {
+		mongoStore, err := ratelimit.NewMongoStore(db, cfg.MongoDb.MongoDbName, zapLogger)
+		if err != nil {
+			zapLogger.Fatalf("failed to create rate limit store - %v", err)
+		}
+		if err := mongoStore.EnsureIndexes(context.Background()); err != nil {
+			zapLogger.Fatalf("failed to init rate limit store - %v", err)
+		}
+		rateLimitStore = mongoStore
+	}
+
+	rateLimiter, err := ratelimit.NewLimiter(rateLimitStore, rateLimits, metricsRegistry, zapLogger)
+	if err != nil {
+		zapLogger.Fatalf("failed to create rate limiter - %v", err)
+	}
+
+	// Idempotency keys
+	var idempotencyStore idempotency.Store = idempotency.NewMemoryStore()
+	if cfg.Idempotency.Store == config.StoreMongo {
+		mongoStore, err := idempotency.NewMongoStore(db, cfg.MongoDb.MongoDbName, zapLogger)
+		if err != nil {
+			zapLogger.Fatalf("failed to create idempotency store - %v", err)
+		}
+		if err := mongoStore.EnsureIndexes(context.Background()); err != nil {
+			zapLogger.Fatalf("failed to init idempotency store - %v", err)
+		}
+		idempotencyStore = mongoStore
+	}
 
-	watcherHandler, err := watcher.NewHandler(watcherService)
+	idempotencyKeys, err := idempotency.New(idempotencyStore, cfg.Idempotency.TTL, metricsRegistry, zapLogger)
+	if err != nil {
+		zapLogger.Fatalf("failed to create idempotency keys - %v", err)
+	}
+
+	watcherHandler, err := watcher.NewHandler(watcherService, callbackQueue, callbackVerifier, cfg.Callback.AllowUnsigned, cfg.DeviceAuth.AllowPushToken, rateLimiter, idempotencyKeys, zapLogger)
 	if err != nil {
 		zapLogger.Fatalf("failed to create watcher handler - %v", err)
 	}
 
+	priceHandler, err := price.NewHandler(priceService, fxRates)
+	if err != nil {
+		zapLogger.Fatalf("failed to create price handler - %v", err)
+	}
+
+	var adminHandler *admin.Handler
+	if len(cfg.Admin.Keys) > 0 {
+		auditRepository, err := admin.NewAuditRepository(db, cfg.MongoDb.MongoDbName, zapLogger)
+		if err != nil {
+			zapLogger.Fatalf("failed to create admin audit repository - %v", err)
+		}
+		if err := auditRepository.EnsureIndexes(context.Background(), cfg.Admin.AuditTTL); err != nil {
+			zapLogger.Errorf("failed to init admin audit repository - %v", err)
+		}
+
+		adminHandler, err = admin.NewHandler(watcherService, broadcastService, auditRepository, rateLimiter, cfg.Admin.Keys, zapLogger)
+		if err != nil {
+			zapLogger.Fatalf("failed to create admin handler - %v", err)
+		}
+	}
+
 	// Create config variable
 	config := fiber.Config{
 		ServerHeader: "AIRDAO-Mobile-Api", // add custom server header
+		ErrorHandler: apierror.Handler(zapLogger),
+		ProxyHeader:  cfg.RateLimit.ProxyHeader,
+		// The proxy header is only trusted from the load balancers, and
+		// only when it holds an IP
+		EnableTrustedProxyCheck: cfg.RateLimit.ProxyHeader != "",
+		TrustedProxies:          cfg.RateLimit.TrustedProxies,
+		EnableIPValidation:      cfg.RateLimit.ProxyHeader != "",
 	}
 
 	// Run DeleteWatchersWithStaleData on start for check and delete stale data
@@ -126,8 +382,8 @@ func main() {
 	// Init cors
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     "*",
-		AllowHeaders:     "Origin, Content-Type, Accept, Content-Length, Accept-Encoding, Authorization",
-		AllowMethods:     "GET, POST, PUT, DELETE, OPTIONS",
+		AllowHeaders:     "Origin, Content-Type, Accept, Content-Length, Accept-Encoding, Authorization, Idempotency-Key, X-Admin-Key",
+		AllowMethods:     "GET, POST, PUT, PATCH, DELETE, OPTIONS",
 		AllowCredentials: true,
 	}))
 
@@ -135,11 +391,30 @@ func main() {
 	app.Route("/api/v1", func(router fiber.Router) {
 		healthHandler.SetupRoutes(router)
 		watcherHandler.SetupRoutes(router)
+		priceHandler.SetupRoutes(router)
 	})
 
+	app.Route("/api/v2", func(router fiber.Router) {
+		watcherHandler.SetupRoutesV2(router)
+	})
+
+	// API docs, described next to the routes they document
+	doc := openapi.New("AirDAO Mobile API", "1.0.0", apierror.Response{})
+	healthHandler.DescribeRoutes(doc, "/api/v1")
+	watcherHandler.DescribeRoutes(doc, "/api/v1")
+	priceHandler.DescribeRoutes(doc, "/api/v1")
+	watcherHandler.DescribeRoutesV2(doc, "/api/v2")
+
+	app.Route("/api/docs", doc.SetupRoutes)
+
+	// Left out of the API docs on purpose
+	if adminHandler != nil {
+		app.Route("/admin", adminHandler.SetupRoutes)
+	}
+
 	// Handle 404 page
 	app.Use(func(c *fiber.Ctx) error {
-		return c.Status(fiber.StatusNotFound).JSON(map[string]string{"error": "page not found"})
+		return apierror.New(fiber.StatusNotFound, "route_not_found", "page not found")
 	})
 
 	// Start the server
@@ -1,7 +1,12 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
@@ -17,6 +22,19 @@ type Config struct {
 
 	MongoDb
 	Firebase
+	Callback
+	Backfill
+	Reconcile
+	Heartbeat
+	Price
+	PriceHistory
+	Fx
+	PriceAlert
+	DeviceAuth
+	RateLimit
+	Idempotency
+	Admin
+	Broadcast
 }
 
 type MongoDb struct {
@@ -29,6 +47,178 @@ type Firebase struct {
 	AndroidChannelName string `required:"true" envconfig:"ANDROID_CHANNEL_NAME"`
 }
 
+type Callback struct {
+	Secrets       []string      `envconfig:"CALLBACK_SECRETS"`
+	AllowUnsigned bool          `default:"true" envconfig:"CALLBACK_ALLOW_UNSIGNED"`
+	MaxSkew       time.Duration `default:"5m" envconfig:"CALLBACK_MAX_SKEW"`
+	Workers       int           `default:"8" envconfig:"CALLBACK_WORKERS"`
+	QueueSize     int           `default:"1000" envconfig:"CALLBACK_QUEUE_SIZE"`
+}
+
+type Backfill struct {
+	MaxPages         int `default:"5" envconfig:"BACKFILL_MAX_PAGES"`
+	PageSize         int `default:"50" envconfig:"BACKFILL_PAGE_SIZE"`
+	SummaryThreshold int `default:"5" envconfig:"BACKFILL_SUMMARY_THRESHOLD"`
+}
+
+type Reconcile struct {
+	Interval  time.Duration `default:"1h" envconfig:"RECONCILE_INTERVAL"`
+	ChunkSize int           `default:"500" envconfig:"RECONCILE_CHUNK_SIZE"`
+}
+
+type Heartbeat struct {
+	SilenceTimeout time.Duration `default:"30m" envconfig:"HEARTBEAT_SILENCE_TIMEOUT"`
+	CheckInterval  time.Duration `default:"1m" envconfig:"HEARTBEAT_CHECK_INTERVAL"`
+}
+
+type Price struct {
+	PollInterval     time.Duration `default:"5m" envconfig:"PRICE_POLL_INTERVAL"`
+	MaxAge           time.Duration `default:"15m" envconfig:"PRICE_MAX_AGE"`
+	MaxDeviation     float64       `default:"5" envconfig:"PRICE_MAX_DEVIATION"`
+	MinSources       int           `default:"1" envconfig:"PRICE_MIN_SOURCES"`
+	CoinGeckoApiUrl  string        `default:"https://api.coingecko.com/api/v3" envconfig:"COINGECKO_API_URL"`
+	CoinGeckoCoinId  string        `default:"amber" envconfig:"COINGECKO_COIN_ID"`
+	DexRpcUrl        string        `envconfig:"DEX_RPC_URL"`
+	DexPairAddress   string        `envconfig:"DEX_PAIR_ADDRESS"`
+	DexBaseIsToken0  bool          `default:"true" envconfig:"DEX_BASE_IS_TOKEN0"`
+	DexBaseDecimals  int           `default:"18" envconfig:"DEX_BASE_DECIMALS"`
+	DexQuoteDecimals int           `default:"18" envconfig:"DEX_QUOTE_DECIMALS"`
+	// Tokens are the other tracked tokens, like
+	// "USDC:usd-coin:0xFF9F502976E7bD2b4901aD7Dd1131Bb81E5567de"
+	Tokens PriceTokens `envconfig:"PRICE_TOKENS"`
+}
+
+// PriceToken is a tracked token priced by CoinGecko. Address is its contract
+// on AirDAO: balances and transfers are matched to the token by contract, so
+// a token without one gets prices and alerts but never values a balance.
+type PriceToken struct {
+	Symbol  string
+	CoinId  string
+	Address string
+}
+
+var contractAddress = regexp.MustCompile(`^0[xX][0-9a-fA-F]{40}$`)
+
+// PriceTokens decodes a comma separated list of SYMBOL:coin-id[:contract].
+type PriceTokens []PriceToken
+
+func (t *PriceTokens) Decode(value string) error {
+	*t = nil
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("invalid price token %q, want SYMBOL:coin-id[:contract]", item)
+		}
+
+		token := PriceToken{Symbol: strings.ToUpper(parts[0]), CoinId: parts[1]}
+		if len(parts) == 3 {
+			if !contractAddress.MatchString(parts[2]) {
+				return fmt.Errorf("invalid price token %q contract", item)
+			}
+			token.Address = parts[2]
+		}
+		*t = append(*t, token)
+	}
+
+	return nil
+}
+
+type PriceHistory struct {
+	RawRetention         time.Duration `default:"168h" envconfig:"PRICE_RAW_RETENTION"`
+	DownsampleInterval   time.Duration `default:"1h" envconfig:"PRICE_DOWNSAMPLE_INTERVAL"`
+	DownsampledRetention time.Duration `default:"0s" envconfig:"PRICE_DOWNSAMPLED_RETENTION"`
+	BootstrapDays        int           `default:"30" envconfig:"PRICE_BOOTSTRAP_DAYS"`
+}
+
+type Fx struct {
+	Currencies   []string      `default:"EUR,GBP,TRY,JPY" envconfig:"FX_CURRENCIES"`
+	ApiUrl       string        `default:"https://open.er-api.com/v6/latest/USD" envconfig:"FX_API_URL"`
+	PollInterval time.Duration `default:"1h" envconfig:"FX_POLL_INTERVAL"`
+	MaxAge       time.Duration `default:"24h" envconfig:"FX_MAX_AGE"`
+}
+
+type PriceAlert struct {
+	Cooldown   time.Duration `default:"30m" envconfig:"PRICE_ALERT_COOLDOWN"`
+	Hysteresis float64       `default:"1" envconfig:"PRICE_ALERT_HYSTERESIS"`
+}
+
+type DeviceAuth struct {
+	Secrets         []string      `envconfig:"DEVICE_AUTH_SECRETS"`
+	AccessTokenTTL  time.Duration `default:"15m" envconfig:"DEVICE_AUTH_ACCESS_TOKEN_TTL"`
+	RefreshTokenTTL time.Duration `default:"720h" envconfig:"DEVICE_AUTH_REFRESH_TOKEN_TTL"`
+	// RefreshGrace is how long the refresh token just rotated away still
+	// returns the new one, for devices retrying a refresh they lost the
+	// response to. Using it later revokes the device.
+	RefreshGrace time.Duration `default:"30s" envconfig:"DEVICE_AUTH_REFRESH_GRACE"`
+	// AllowPushToken keeps the legacy push token identification working for
+	// apps that have not registered a device credential yet
+	AllowPushToken bool `default:"true" envconfig:"DEVICE_AUTH_ALLOW_PUSH_TOKEN"`
+}
+
+// Store is where state meant to be shared between instances is kept.
+type Store string
+
+const (
+	StoreMemory Store = "memory"
+	StoreMongo  Store = "mongo"
+)
+
+func (s *Store) Decode(value string) error {
+	switch Store(value) {
+	case StoreMemory, StoreMongo:
+		*s = Store(value)
+		return nil
+	}
+
+	return fmt.Errorf("invalid store %q, want memory or mongo", value)
+}
+
+type RateLimit struct {
+	Enabled bool `default:"true" envconfig:"RATE_LIMIT_ENABLED"`
+	// Store is memory, or mongo to share the limits between instances
+	Store Store `default:"memory" envconfig:"RATE_LIMIT_STORE"`
+	// Limits are "<requests>/<s|m|h>" per device, or per IP without a device
+	// credential, "off" disables one
+	Auth  string `default:"10/m" envconfig:"RATE_LIMIT_AUTH"`
+	Read  string `default:"120/m" envconfig:"RATE_LIMIT_READ"`
+	Write string `default:"30/m" envconfig:"RATE_LIMIT_WRITE"`
+	// ProxyHeader carries the client IP behind a load balancer. It is only
+	// read on requests from TrustedProxies and its first valid IP is used,
+	// so it must be one the load balancer sets rather than appends to, like
+	// X-Real-IP.
+	ProxyHeader    string   `envconfig:"RATE_LIMIT_PROXY_HEADER"`
+	TrustedProxies []string `envconfig:"RATE_LIMIT_TRUSTED_PROXIES"`
+}
+
+type Idempotency struct {
+	// TTL is how long a response is replayed to retries with the same key
+	TTL time.Duration `default:"24h" envconfig:"IDEMPOTENCY_TTL"`
+	// Store is memory, or mongo to replay retries landing on another instance
+	Store Store `default:"memory" envconfig:"IDEMPOTENCY_STORE"`
+}
+
+type Admin struct {
+	// Keys authenticate the admin API, which is only served when one is set.
+	// More than one lets a key be rotated.
+	Keys []string `envconfig:"ADMIN_KEYS"`
+	// RateLimit is "<requests>/<s|m|h>" per IP, applied before a request is
+	// audited, even with RATE_LIMIT_ENABLED off
+	RateLimit string `default:"60/m" envconfig:"ADMIN_RATE_LIMIT"`
+	// AuditTTL is how long audit entries are kept
+	AuditTTL time.Duration `default:"2160h" envconfig:"ADMIN_AUDIT_TTL"`
+}
+
+type Broadcast struct {
+	// Rate is how many messages per second a broadcast sends at most
+	Rate      int `default:"100" envconfig:"BROADCAST_RATE"`
+	BatchSize int `default:"500" envconfig:"BROADCAST_BATCH_SIZE"`
+}
+
 var (
 	once   sync.Once
 	config *Config
@@ -44,6 +234,12 @@ func GetConfig() (*Config, error) {
 			return
 		}
 
+		// Any client could pick the IP it is limited by otherwise
+		if cfg.RateLimit.ProxyHeader != "" && len(cfg.RateLimit.TrustedProxies) == 0 {
+			err = errors.New("RATE_LIMIT_PROXY_HEADER needs RATE_LIMIT_TRUSTED_PROXIES")
+			return
+		}
+
 		config = &cfg
 	})
 
@@ -4,6 +4,7 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	"airdao-mobile-api/config"
 )
@@ -76,6 +77,76 @@ func TestInit(t *testing.T) {
 					CredPath:           "./example.json",
 					AndroidChannelName: "example",
 				},
+				Callback: config.Callback{
+					AllowUnsigned: true,
+					MaxSkew:       5 * time.Minute,
+					Workers:       8,
+					QueueSize:     1000,
+				},
+				Backfill: config.Backfill{
+					MaxPages:         5,
+					PageSize:         50,
+					SummaryThreshold: 5,
+				},
+				Reconcile: config.Reconcile{
+					Interval:  time.Hour,
+					ChunkSize: 500,
+				},
+				Heartbeat: config.Heartbeat{
+					SilenceTimeout: 30 * time.Minute,
+					CheckInterval:  time.Minute,
+				},
+				Price: config.Price{
+					PollInterval:     5 * time.Minute,
+					MaxAge:           15 * time.Minute,
+					MaxDeviation:     5,
+					MinSources:       1,
+					CoinGeckoApiUrl:  "https://api.coingecko.com/api/v3",
+					CoinGeckoCoinId:  "amber",
+					DexBaseIsToken0:  true,
+					DexBaseDecimals:  18,
+					DexQuoteDecimals: 18,
+				},
+				PriceHistory: config.PriceHistory{
+					RawRetention:       7 * 24 * time.Hour,
+					DownsampleInterval: time.Hour,
+					BootstrapDays:      30,
+				},
+				Fx: config.Fx{
+					Currencies:   []string{"EUR", "GBP", "TRY", "JPY"},
+					ApiUrl:       "https://open.er-api.com/v6/latest/USD",
+					PollInterval: time.Hour,
+					MaxAge:       24 * time.Hour,
+				},
+				PriceAlert: config.PriceAlert{
+					Cooldown:   30 * time.Minute,
+					Hysteresis: 1,
+				},
+				DeviceAuth: config.DeviceAuth{
+					AccessTokenTTL:  15 * time.Minute,
+					RefreshTokenTTL: 720 * time.Hour,
+					RefreshGrace:    30 * time.Second,
+					AllowPushToken:  true,
+				},
+				RateLimit: config.RateLimit{
+					Enabled: true,
+					Store:   "memory",
+					Auth:    "10/m",
+					Read:    "120/m",
+					Write:   "30/m",
+				},
+				Idempotency: config.Idempotency{
+					TTL:   24 * time.Hour,
+					Store: "memory",
+				},
+				Admin: config.Admin{
+					RateLimit: "60/m",
+					AuditTTL:  90 * 24 * time.Hour,
+				},
+				Broadcast: config.Broadcast{
+					Rate:      100,
+					BatchSize: 500,
+				},
 			},
 		},
 	}
@@ -97,3 +168,61 @@ func TestInit(t *testing.T) {
 		})
 	}
 }
+
+func TestPriceTokensDecode(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		want      config.PriceTokens
+		wantError bool
+	}{
+		{name: "should decode nothing", value: ""},
+		{
+			name:  "should decode tokens with and without contract",
+			value: "usdc:usd-coin:0xFF9F502976E7bD2b4901aD7Dd1131Bb81E5567de, ETH:ethereum",
+			want: config.PriceTokens{
+				{Symbol: "USDC", CoinId: "usd-coin", Address: "0xFF9F502976E7bD2b4901aD7Dd1131Bb81E5567de"},
+				{Symbol: "ETH", CoinId: "ethereum"},
+			},
+		},
+		{name: "should refuse a token without coin id", value: "USDC", wantError: true},
+		{name: "should refuse an invalid contract", value: "USDC:usd-coin:0x123", wantError: true},
+	}
+
+	for _, test := range tests {
+		var tokens config.PriceTokens
+		err := tokens.Decode(test.value)
+		if (err != nil) != test.wantError {
+			t.Errorf("%s: error = %v, wantError %v", test.name, err, test.wantError)
+			continue
+		}
+		if !test.wantError && !reflect.DeepEqual(tokens, test.want) {
+			t.Errorf("%s: got = %v, want %v", test.name, tokens, test.want)
+		}
+	}
+}
+
+func TestStoreDecode(t *testing.T) {
+	tests := []struct {
+		value     string
+		want      config.Store
+		wantError bool
+	}{
+		{value: "memory", want: config.StoreMemory},
+		{value: "mongo", want: config.StoreMongo},
+		{value: "redis", wantError: true},
+		{value: "", wantError: true},
+	}
+
+	for _, test := range tests {
+		var store config.Store
+		err := store.Decode(test.value)
+		if (err != nil) != test.wantError {
+			t.Errorf("%q: error = %v, wantError %v", test.value, err, test.wantError)
+			continue
+		}
+		if store != test.want {
+			t.Errorf("%q: got = %v, want %v", test.value, store, test.want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package alertindex
+
+import (
+	"sort"
+	"sync"
+)
+
+// Entry is a percent threshold around a reference price in one book, like
+// the AMB price in EUR. A zero reference marks an entry that still waits for
+// its first price; it is matched by any price.
+type Entry struct {
+	Book      string
+	Reference float64
+	Threshold float64
+}
+
+type bound struct {
+	owner string
+	price float64
+}
+
+type book struct {
+	entries map[string]Entry
+
+	// Trigger prices, upper ascending and lower descending, so the entries
+	// hit by a price are always a prefix. Rebuilt lazily after changes.
+	upper []bound
+	lower []bound
+	dirty bool
+}
+
+// Index finds the owners whose price alerts a new price triggers without
+// looking at every alert.
+type Index struct {
+	mx     sync.Mutex
+	books  map[string]*book
+	owners map[string][]string
+}
+
+func New() *Index {
+	return &Index{
+		books:  make(map[string]*book),
+		owners: make(map[string][]string),
+	}
+}
+
+// Replace sets all entries of owner, dropping the ones it had before.
+func (i *Index) Replace(owner string, entries []Entry) {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+
+	i.remove(owner)
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Threshold <= 0 {
+			continue
+		}
+
+		b, ok := i.books[entry.Book]
+		if !ok {
+			b = &book{entries: make(map[string]Entry)}
+			i.books[entry.Book] = b
+		}
+		b.entries[owner] = entry
+		b.dirty = true
+
+		names = append(names, entry.Book)
+	}
+
+	if len(names) > 0 {
+		i.owners[owner] = names
+	}
+}
+
+func (i *Index) Remove(owner string) {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+
+	i.remove(owner)
+}
+
+func (i *Index) remove(owner string) {
+	for _, name := range i.owners[owner] {
+		b, ok := i.books[name]
+		if !ok {
+			continue
+		}
+
+		delete(b.entries, owner)
+		b.dirty = true
+		if len(b.entries) == 0 {
+			delete(i.books, name)
+		}
+	}
+
+	delete(i.owners, owner)
+}
+
+// Triggered returns the owners in book whose threshold price moved past.
+func (i *Index) Triggered(name string, price float64) []string {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+
+	b, ok := i.books[name]
+	if !ok {
+		return nil
+	}
+	if b.dirty {
+		b.rebuild()
+	}
+
+	seen := make(map[string]struct{})
+	var owners []string
+	add := func(owner string) {
+		if _, ok := seen[owner]; !ok {
+			seen[owner] = struct{}{}
+			owners = append(owners, owner)
+		}
+	}
+
+	n := sort.Search(len(b.upper), func(j int) bool { return b.upper[j].price > price })
+	for _, v := range b.upper[:n] {
+		add(v.owner)
+	}
+
+	n = sort.Search(len(b.lower), func(j int) bool { return b.lower[j].price < price })
+	for _, v := range b.lower[:n] {
+		add(v.owner)
+	}
+
+	return owners
+}
+
+// Entries returns the entries of owner, in no particular order.
+func (i *Index) Entries(owner string) []Entry {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+
+	entries := make([]Entry, 0, len(i.owners[owner]))
+	for _, name := range i.owners[owner] {
+		if b, ok := i.books[name]; ok {
+			entries = append(entries, b.entries[owner])
+		}
+	}
+
+	return entries
+}
+
+// Len returns the number of entries across all books.
+func (i *Index) Len() int {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+
+	var n int
+	for _, b := range i.books {
+		n += len(b.entries)
+	}
+
+	return n
+}
+
+func (b *book) rebuild() {
+	b.upper = b.upper[:0]
+	b.lower = b.lower[:0]
+
+	for owner, entry := range b.entries {
+		if entry.Reference <= 0 {
+			// Matched by any price
+			b.upper = append(b.upper, bound{owner: owner, price: 0})
+			continue
+		}
+
+		b.upper = append(b.upper, bound{owner: owner, price: entry.Reference * (1 + entry.Threshold/100)})
+		b.lower = append(b.lower, bound{owner: owner, price: entry.Reference * (1 - entry.Threshold/100)})
+	}
+
+	sort.Slice(b.upper, func(x, y int) bool { return b.upper[x].price < b.upper[y].price })
+	sort.Slice(b.lower, func(x, y int) bool { return b.lower[x].price > b.lower[y].price })
+	b.dirty = false
+}
@@ -0,0 +1,70 @@
+package alertindex_test
+
+import (
+	"sort"
+	"testing"
+
+	"airdao-mobile-api/pkg/alertindex"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexTriggered(t *testing.T) {
+	index := alertindex.New()
+	index.Replace("a", []alertindex.Entry{{Book: "AMB/USD", Reference: 1, Threshold: 5}})
+	index.Replace("b", []alertindex.Entry{{Book: "AMB/USD", Reference: 1, Threshold: 10}})
+	index.Replace("c", []alertindex.Entry{
+		{Book: "AMB/USD", Reference: 1.02, Threshold: 10},
+		{Book: "USDC/USD", Reference: 1, Threshold: 5},
+	})
+	index.Replace("d", []alertindex.Entry{{Book: "AMB/EUR", Reference: 0, Threshold: 5}})
+
+	tests := []struct {
+		name   string
+		book   string
+		price  float64
+		expect []string
+	}{
+		{name: "should match nothing inside the thresholds", book: "AMB/USD", price: 1.04, expect: nil},
+		{name: "should match rises past the threshold", book: "AMB/USD", price: 1.05, expect: []string{"a"}},
+		{name: "should match every threshold passed", book: "AMB/USD", price: 1.2, expect: []string{"a", "b", "c"}},
+		{name: "should match drops past the threshold", book: "AMB/USD", price: 0.91, expect: []string{"a", "c"}},
+		{name: "should keep books apart", book: "USDC/USD", price: 0.9, expect: []string{"c"}},
+		{name: "should match pending entries with any price", book: "AMB/EUR", price: 1, expect: []string{"d"}},
+		{name: "should match nothing in unknown books", book: "HBR/USD", price: 1, expect: nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := index.Triggered(tc.book, tc.price)
+			sort.Strings(got)
+			assert.Equal(t, tc.expect, got)
+		})
+	}
+}
+
+func TestIndexReplaceAndRemove(t *testing.T) {
+	index := alertindex.New()
+	index.Replace("a", []alertindex.Entry{
+		{Book: "AMB/USD", Reference: 1, Threshold: 5},
+		{Book: "USDC/USD", Reference: 1, Threshold: 5},
+	})
+	assert.Equal(t, 2, index.Len())
+	assert.Equal(t, []string{"a"}, index.Triggered("AMB/USD", 1.1))
+
+	// A new reference moves the trigger prices
+	index.Replace("a", []alertindex.Entry{{Book: "AMB/USD", Reference: 1.1, Threshold: 5}})
+	assert.Equal(t, 1, index.Len())
+	assert.Equal(t, []alertindex.Entry{{Book: "AMB/USD", Reference: 1.1, Threshold: 5}}, index.Entries("a"))
+	assert.Nil(t, index.Triggered("AMB/USD", 1.1))
+	assert.Nil(t, index.Triggered("USDC/USD", 2))
+
+	// Entries without a threshold aren't indexed
+	index.Replace("b", []alertindex.Entry{{Book: "AMB/USD", Reference: 1, Threshold: 0}})
+	assert.Equal(t, 1, index.Len())
+
+	index.Remove("a")
+	assert.Equal(t, 0, index.Len())
+	assert.Empty(t, index.Entries("a"))
+	assert.Nil(t, index.Triggered("AMB/USD", 2))
+}
@@ -0,0 +1,118 @@
+package apierror
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+const (
+	CodeInvalidBody      = "invalid_body"
+	CodeValidationFailed = "validation_failed"
+	CodeInternal         = "internal_error"
+)
+
+// Error is an error the API reports to clients. Code is stable and meant for
+// machines, the message may change.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	Fields  []FieldError
+}
+
+// FieldError describes one invalid request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Response is the body of every error response.
+type Response struct {
+	Error  string       `json:"error"`
+	Code   string       `json:"code"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+func New(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// InvalidBody wraps a request body parse error.
+func InvalidBody(err error) *Error {
+	return New(fiber.StatusBadRequest, CodeInvalidBody, err.Error())
+}
+
+// Validation reports invalid request fields.
+func Validation(fields []FieldError) *Error {
+	messages := make([]string, 0, len(fields))
+	for _, field := range fields {
+		messages = append(messages, field.Field+" - "+field.Message)
+	}
+
+	return &Error{
+		Status:  fiber.StatusBadRequest,
+		Code:    CodeValidationFailed,
+		Message: strings.Join(messages, ", "),
+		Fields:  fields,
+	}
+}
+
+// Handler renders errors returned by route handlers. Errors wrapping an
+// *Error keep the wrapped status and code with the full message; anything
+// else is logged and reported as an internal error so repository details
+// don't leak to clients.
+func Handler(logger *zap.SugaredLogger) fiber.ErrorHandler {
+	return func(c *fiber.Ctx, err error) error {
+		var apiErr *Error
+		var fiberErr *fiber.Error
+
+		switch {
+		case errors.As(err, &apiErr):
+			return c.Status(apiErr.Status).JSON(Response{Error: err.Error(), Code: apiErr.Code, Fields: apiErr.Fields})
+		case errors.As(err, &fiberErr):
+			return c.Status(fiberErr.Code).JSON(Response{Error: fiberErr.Message, Code: codeForStatus(fiberErr.Code)})
+		}
+
+		logger.Errorf("%s %s error %v", c.Method(), c.Path(), err)
+
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{Error: "internal error", Code: CodeInternal})
+	}
+}
+
+// codeForStatus names the errors fiber raises itself, like unknown methods.
+func codeForStatus(status int) string {
+	switch status {
+	case fiber.StatusBadRequest:
+		return "bad_request"
+	case fiber.StatusUnauthorized:
+		return "unauthorized"
+	case fiber.StatusForbidden:
+		return "forbidden"
+	case fiber.StatusNotFound:
+		return "not_found"
+	case fiber.StatusMethodNotAllowed:
+		return "method_not_allowed"
+	case fiber.StatusRequestEntityTooLarge:
+		return "body_too_large"
+	case fiber.StatusUnprocessableEntity:
+		return CodeInvalidBody
+	case fiber.StatusTooManyRequests:
+		return "too_many_requests"
+	case fiber.StatusServiceUnavailable:
+		return "unavailable"
+	}
+
+	if status >= fiber.StatusInternalServerError {
+		return CodeInternal
+	}
+
+	return "error"
+}
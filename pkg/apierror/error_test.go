@@ -0,0 +1,76 @@
+package apierror_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"airdao-mobile-api/pkg/apierror"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestHandler(t *testing.T) {
+	errNotFound := apierror.New(fiber.StatusNotFound, "watcher_not_found", "watcher not found")
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		want       apierror.Response
+	}{
+		{
+			name:       "should render api error",
+			err:        errNotFound,
+			wantStatus: fiber.StatusNotFound,
+			want:       apierror.Response{Error: "watcher not found", Code: "watcher_not_found"},
+		},
+		{
+			name:       "should keep message of wrapped api error",
+			err:        fmt.Errorf("%w: USDC", errNotFound),
+			wantStatus: fiber.StatusNotFound,
+			want:       apierror.Response{Error: "watcher not found: USDC", Code: "watcher_not_found"},
+		},
+		{
+			name:       "should render validation fields",
+			err:        apierror.Validation([]apierror.FieldError{{Field: "PushToken", Rule: "required", Message: "is required"}}),
+			wantStatus: fiber.StatusBadRequest,
+			want: apierror.Response{
+				Error:  "PushToken - is required",
+				Code:   apierror.CodeValidationFailed,
+				Fields: []apierror.FieldError{{Field: "PushToken", Rule: "required", Message: "is required"}},
+			},
+		},
+		{
+			name:       "should render fiber error",
+			err:        fiber.ErrMethodNotAllowed,
+			wantStatus: fiber.StatusMethodNotAllowed,
+			want:       apierror.Response{Error: "Method Not Allowed", Code: "method_not_allowed"},
+		},
+		{
+			name:       "should hide unknown error",
+			err:        errors.New("failed to update watcher"),
+			wantStatus: fiber.StatusInternalServerError,
+			want:       apierror.Response{Error: "internal error", Code: apierror.CodeInternal},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New(fiber.Config{ErrorHandler: apierror.Handler(zap.NewNop().Sugar())})
+			app.Get("/", func(c *fiber.Ctx) error { return tt.err })
+
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/", nil))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+
+			var got apierror.Response
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
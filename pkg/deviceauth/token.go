@@ -0,0 +1,161 @@
+package deviceauth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotConfigured = errors.New("device credentials are not configured")
+	ErrInvalidToken  = errors.New("invalid device token")
+	ErrExpiredToken  = errors.New("device token has expired")
+)
+
+// header is the only JOSE header the signer produces and accepts. Pinning the
+// algorithm keeps "alg: none" and key confusion tricks out.
+var header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Claims identify the device a token was issued to. Version is bumped when
+// the device credentials are revoked, which invalidates older tokens.
+type Claims struct {
+	Subject   string `json:"sub"`
+	Version   int    `json:"ver"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Signer issues and verifies HS256 JWT access tokens. Tokens are signed with
+// the first secret and accepted with any of them, so secrets can be rotated
+// without logging devices out.
+type Signer struct {
+	secrets [][]byte
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+func NewSigner(secrets []string, ttl time.Duration) (*Signer, error) {
+	if ttl <= 0 {
+		return nil, errors.New("[deviceauth] invalid token ttl")
+	}
+
+	keys := make([][]byte, 0, len(secrets))
+	for _, secret := range secrets {
+		secret = strings.TrimSpace(secret)
+		if secret == "" {
+			continue
+		}
+		keys = append(keys, []byte(secret))
+	}
+
+	return &Signer{secrets: keys, ttl: ttl, now: time.Now}, nil
+}
+
+// Enabled reports whether at least one secret is configured.
+func (s *Signer) Enabled() bool {
+	return len(s.secrets) > 0
+}
+
+// Issue returns an access token for subject and its expiry.
+func (s *Signer) Issue(subject string, version int) (string, time.Time, error) {
+	if !s.Enabled() {
+		return "", time.Time{}, ErrNotConfigured
+	}
+
+	now := s.now()
+	expiresAt := now.Add(s.ttl)
+	payload, err := json.Marshal(Claims{Subject: subject, Version: version, IssuedAt: now.Unix(), ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	signingInput := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature := base64.RawURLEncoding.EncodeToString(sign(s.secrets[0], signingInput))
+
+	return signingInput + "." + signature, expiresAt, nil
+}
+
+// Verify checks the token signature and expiry and returns its claims.
+func (s *Signer) Verify(token string) (*Claims, error) {
+	if !s.Enabled() {
+		return nil, ErrNotConfigured
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != header {
+		return nil, ErrInvalidToken
+	}
+
+	got, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	signingInput := parts[0] + "." + parts[1]
+	valid := false
+	for _, secret := range s.secrets {
+		if hmac.Equal(got, sign(secret, signingInput)) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+
+	if !s.now().Before(time.Unix(claims.ExpiresAt, 0)) {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
+
+// NewRefreshToken returns a random opaque refresh token.
+func NewRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// RotatedRefreshToken derives the refresh token replacing the one hashed as
+// previousHash. Deriving it rather than drawing it at random lets a device
+// that lost the response to a refresh get the same token again.
+func (s *Signer) RotatedRefreshToken(previousHash string) (string, error) {
+	if !s.Enabled() {
+		return "", ErrNotConfigured
+	}
+
+	return base64.RawURLEncoding.EncodeToString(sign(s.secrets[0], "refresh."+previousHash)), nil
+}
+
+// HashToken is what gets stored in place of a refresh token, so a database
+// leak doesn't hand out live credentials.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func sign(secret []byte, signingInput string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(signingInput))
+	return h.Sum(nil)
+}
@@ -0,0 +1,137 @@
+package deviceauth_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"airdao-mobile-api/pkg/deviceauth"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	signer, err := deviceauth.NewSigner([]string{"current", "previous"}, time.Hour)
+	assert.NoError(t, err)
+
+	rotated, err := deviceauth.NewSigner([]string{"previous"}, time.Hour)
+	assert.NoError(t, err)
+
+	unknown, err := deviceauth.NewSigner([]string{"unknown"}, time.Hour)
+	assert.NoError(t, err)
+
+	expired, err := deviceauth.NewSigner([]string{"current"}, time.Nanosecond)
+	assert.NoError(t, err)
+
+	issue := func(signer *deviceauth.Signer) string {
+		token, _, err := signer.Issue("device-1", 2)
+		assert.NoError(t, err)
+		return token
+	}
+
+	valid := issue(signer)
+	parts := strings.Split(valid, ".")
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{
+			name:  "should accept token signed with current secret",
+			token: valid,
+		},
+		{
+			name:  "should accept token signed with rotated secret",
+			token: issue(rotated),
+		},
+		{
+			name:    "should reject unknown secret",
+			token:   issue(unknown),
+			wantErr: deviceauth.ErrInvalidToken,
+		},
+		{
+			name:    "should reject expired token",
+			token:   issue(expired),
+			wantErr: deviceauth.ErrExpiredToken,
+		},
+		{
+			name:    "should reject tampered claims",
+			token:   parts[0] + "." + parts[1] + "e30." + parts[2],
+			wantErr: deviceauth.ErrInvalidToken,
+		},
+		{
+			name:    "should reject unsigned token",
+			token:   "eyJhbGciOiJub25lIiwidHlwIjoiSldUIn0." + parts[1] + ".",
+			wantErr: deviceauth.ErrInvalidToken,
+		},
+		{
+			name:    "should reject garbage",
+			token:   "not-a-token",
+			wantErr: deviceauth.ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := signer.Verify(tt.token)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, "device-1", claims.Subject)
+			assert.Equal(t, 2, claims.Version)
+		})
+	}
+}
+
+func TestNotConfigured(t *testing.T) {
+	signer, err := deviceauth.NewSigner(nil, time.Hour)
+	assert.NoError(t, err)
+	assert.False(t, signer.Enabled())
+
+	_, _, err = signer.Issue("device-1", 0)
+	assert.ErrorIs(t, err, deviceauth.ErrNotConfigured)
+
+	_, err = signer.Verify("a.b.c")
+	assert.ErrorIs(t, err, deviceauth.ErrNotConfigured)
+}
+
+func TestRefreshToken(t *testing.T) {
+	a, err := deviceauth.NewRefreshToken()
+	assert.NoError(t, err)
+	b, err := deviceauth.NewRefreshToken()
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, a, b)
+	assert.Equal(t, deviceauth.HashToken(a), deviceauth.HashToken(a))
+	assert.NotEqual(t, a, deviceauth.HashToken(a))
+}
+
+func TestRotatedRefreshToken(t *testing.T) {
+	signer, err := deviceauth.NewSigner([]string{"current"}, time.Hour)
+	assert.NoError(t, err)
+
+	a, err := signer.RotatedRefreshToken(deviceauth.HashToken("first"))
+	assert.NoError(t, err)
+	b, err := signer.RotatedRefreshToken(deviceauth.HashToken("first"))
+	assert.NoError(t, err)
+	c, err := signer.RotatedRefreshToken(deviceauth.HashToken("second"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, a, b, "should derive the same token from the same previous one")
+	assert.NotEqual(t, a, c)
+
+	other, err := deviceauth.NewSigner([]string{"other"}, time.Hour)
+	assert.NoError(t, err)
+	d, err := other.RotatedRefreshToken(deviceauth.HashToken("first"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, a, d, "should depend on the secret")
+
+	disabled, err := deviceauth.NewSigner(nil, time.Hour)
+	assert.NoError(t, err)
+	_, err = disabled.RotatedRefreshToken(deviceauth.HashToken("first"))
+	assert.ErrorIs(t, err, deviceauth.ErrNotConfigured)
+}
@@ -0,0 +1,79 @@
+package ethsig
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"golang.org/x/crypto/sha3"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrInvalidAddress   = errors.New("invalid address")
+)
+
+// HashMessage is the EIP-191 personal_sign hash of message.
+func HashMessage(message string) []byte {
+	return keccak256([]byte("\x19Ethereum Signed Message:\n" + strconv.Itoa(len(message)) + message))
+}
+
+// RecoverAddress returns the lowercase 0x address that signed message with
+// personal_sign. The signature is 65 hex encoded bytes r || s || v, with v
+// either 0/1 or 27/28.
+func RecoverAddress(message string, signature string) (string, error) {
+	sig, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(signature, "0x"), "0X"))
+	if err != nil || len(sig) != 65 {
+		return "", ErrInvalidSignature
+	}
+
+	v := sig[64]
+	if v >= 27 {
+		v -= 27
+	}
+	if v > 1 {
+		return "", ErrInvalidSignature
+	}
+
+	// RecoverCompact takes the recovery code first, offset by 27 for an
+	// uncompressed key
+	compact := make([]byte, 65)
+	compact[0] = 27 + v
+	copy(compact[1:], sig[:64])
+
+	pub, _, err := ecdsa.RecoverCompact(compact, HashMessage(message))
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+
+	// The address hashes the key without its 0x04 prefix
+	key := pub.SerializeUncompressed()[1:]
+
+	return "0x" + hex.EncodeToString(keccak256(key)[12:]), nil
+}
+
+// Verify reports whether message was signed by address.
+func Verify(address, message, signature string) (bool, error) {
+	want := strings.ToLower(address)
+	if len(want) != 42 || !strings.HasPrefix(want, "0x") {
+		return false, ErrInvalidAddress
+	}
+	if _, err := hex.DecodeString(want[2:]); err != nil {
+		return false, ErrInvalidAddress
+	}
+
+	got, err := RecoverAddress(message, signature)
+	if err != nil {
+		return false, err
+	}
+
+	return got == want, nil
+}
+
+func keccak256(data []byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write(data)
+	return h.Sum(nil)
+}
@@ -0,0 +1,100 @@
+package ethsig_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"airdao-mobile-api/pkg/ethsig"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Signed by private key 0x4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318
+const (
+	signer    = "0x2c7536E3605D9C16a7a3D7b1898e529396a65c23"
+	message   = "Some data"
+	signature = "0xb91467e570a6466aa9e9876cbcd013baba02900b8979d43fe208a4a4f339f5fd6007e74cd82e037b800186422fc2da167c747ef045e5d18a5f5d4300f8e1a0291c"
+)
+
+func TestHashMessage(t *testing.T) {
+	assert.Equal(t, "1da44b586eb0729ff70a73c326926f6ed5a25f5b056e7f47fbc6e58d86871655", hex.EncodeToString(ethsig.HashMessage(message)))
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name      string
+		address   string
+		message   string
+		signature string
+		want      bool
+		wantErr   error
+	}{
+		{
+			name:      "should accept signer",
+			address:   signer,
+			message:   message,
+			signature: signature,
+			want:      true,
+		},
+		{
+			name:      "should accept v as recovery id",
+			address:   signer,
+			message:   message,
+			signature: signature[:len(signature)-2] + "01",
+			want:      true,
+		},
+		{
+			name:      "should reject other address",
+			address:   "0x0000000000000000000000000000000000000001",
+			message:   message,
+			signature: signature,
+		},
+		{
+			name:      "should reject other message",
+			address:   signer,
+			message:   "Other data",
+			signature: signature,
+		},
+		{
+			name:      "should reject invalid v",
+			address:   signer,
+			message:   message,
+			signature: signature[:len(signature)-2] + "1f",
+			wantErr:   ethsig.ErrInvalidSignature,
+		},
+		{
+			name:      "should reject short signature",
+			address:   signer,
+			message:   message,
+			signature: signature[:10],
+			wantErr:   ethsig.ErrInvalidSignature,
+		},
+		{
+			name:      "should reject zero r",
+			address:   signer,
+			message:   message,
+			signature: "0x" + "0000000000000000000000000000000000000000000000000000000000000000" + signature[66:],
+			wantErr:   ethsig.ErrInvalidSignature,
+		},
+		{
+			name:      "should reject invalid address",
+			address:   "0x123",
+			message:   message,
+			signature: signature,
+			wantErr:   ethsig.ErrInvalidAddress,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ethsig.Verify(tt.address, tt.message, tt.signature)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package cloudmessaging
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"firebase.google.com/go/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSender fails the tokens in fails and counts the requests in flight
+type fakeSender struct {
+	fails map[string]error
+
+	mx       sync.Mutex
+	inFlight int32
+	maxSeen  int32
+}
+
+func (s *fakeSender) Send(ctx context.Context, message *messaging.Message) (string, error) {
+	n := atomic.AddInt32(&s.inFlight, 1)
+	defer atomic.AddInt32(&s.inFlight, -1)
+
+	s.mx.Lock()
+	if n > s.maxSeen {
+		s.maxSeen = n
+	}
+	s.mx.Unlock()
+
+	if err, ok := s.fails[message.Token]; ok {
+		return "", err
+	}
+	return "id-" + message.Token, nil
+}
+
+func TestSendMessages(t *testing.T) {
+	sendErr := errors.New("unavailable")
+	fcm := &fakeSender{fails: map[string]error{"token-1": sendErr}}
+	s := &service{fcmClient: fcm, androidChannel: "channel"}
+
+	messages := make([]*Message, 0, 120)
+	for i := 0; i < 120; i++ {
+		messages = append(messages, &Message{Title: "title", Body: "body", PushToken: "token-" + string(rune('0'+i%10))})
+	}
+
+	results, err := s.SendMessages(context.Background(), messages)
+	require.NoError(t, err)
+	require.Len(t, results, len(messages))
+
+	for i, result := range results {
+		if messages[i].PushToken == "token-1" {
+			assert.Equal(t, sendErr, result.Err)
+			assert.Nil(t, result.MessageId)
+			continue
+		}
+		require.NoError(t, result.Err)
+		assert.Equal(t, "id-"+messages[i].PushToken, *result.MessageId)
+	}
+	assert.LessOrEqual(t, fcm.maxSeen, int32(maxConcurrentSends))
+}
+
+func TestSendMessagesCancelled(t *testing.T) {
+	s := &service{fcmClient: &fakeSender{}, androidChannel: "channel"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results, err := s.SendMessages(ctx, []*Message{{PushToken: "token"}})
+	assert.Equal(t, context.Canceled, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, context.Canceled, results[0].Err)
+}
@@ -5,16 +5,41 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"sync"
 
 	"firebase.google.com/go/messaging"
 )
 
+// maxConcurrentSends bounds the FCM requests SendMessages has in flight
+const maxConcurrentSends = 50
+
+type Message struct {
+	Title     string
+	Body      string
+	PushToken string
+	Data      map[string]interface{}
+}
+
+// SendResult is the outcome of one message of SendMessages. MessageId is set when
+// the message was accepted.
+type SendResult struct {
+	MessageId *string
+	Err       error
+}
+
 type Service interface {
 	SendMessage(ctx context.Context, title, body, pushToken string, data map[string]interface{}) (*string, error)
+	SendMessages(ctx context.Context, messages []*Message) ([]*SendResult, error)
+	SendTopicMessage(ctx context.Context, title, body, topic string, data map[string]interface{}) (*string, error)
+}
+
+// sender is the part of *messaging.Client the service uses
+type sender interface {
+	Send(ctx context.Context, message *messaging.Message) (string, error)
 }
 
 type service struct {
-	fcmClient      *messaging.Client
+	fcmClient      sender
 	androidChannel string
 }
 
@@ -30,7 +55,99 @@ func NewCloudMessagingService(fcmClient *messaging.Client, androidChannel string
 }
 
 func (s *service) SendMessage(ctx context.Context, title, body, pushToken string, data map[string]interface{}) (*string, error) {
+	message := s.newMessage(title, body, pushToken, data)
+
+	fmt.Printf("AndroidData: %+v\n", message.Android.Data)
+	fmt.Printf("IOSData: %+v\n", data)
+
+	response, err := s.fcmClient.Send(ctx, message)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, err
+}
+
+// SendMessages sends every message with its own request to the v1 API, at
+// most maxConcurrentSends at a time; FCM retired the batch endpoint. The
+// results are in the order of messages. An error is only returned when ctx
+// ends before every message was sent, the unsent ones then carry ctx.Err().
+func (s *service) SendMessages(ctx context.Context, messages []*Message) ([]*SendResult, error) {
+	results := make([]*SendResult, len(messages))
+
+	workers := maxConcurrentSends
+	if len(messages) < workers {
+		workers = len(messages)
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				message := messages[i]
+				response, err := s.fcmClient.Send(ctx, s.newMessage(message.Title, message.Body, message.PushToken, message.Data))
+
+				result := &SendResult{Err: err}
+				if err == nil {
+					result.MessageId = &response
+				}
+				results[i] = result
+			}
+		}()
+	}
+
+	queued := 0
+queue:
+	for ; queued < len(messages) && ctx.Err() == nil; queued++ {
+		select {
+		case <-ctx.Done():
+			break queue
+		case jobs <- queued:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if queued < len(messages) {
+		for i := queued; i < len(messages); i++ {
+			results[i] = &SendResult{Err: ctx.Err()}
+		}
+		return results, ctx.Err()
+	}
+
+	return results, nil
+}
 
+// SendTopicMessage sends one message to every device subscribed to topic.
+func (s *service) SendTopicMessage(ctx context.Context, title, body, topic string, data map[string]interface{}) (*string, error) {
+	message := s.newMessage(title, body, "", data)
+	message.Topic = topic
+
+	response, err := s.fcmClient.Send(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// IsUnregistered reports whether err means the push token is no longer
+// registered and the device won't receive anything anymore.
+func IsUnregistered(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	return messaging.IsRegistrationTokenNotRegistered(err) ||
+		err.Error() == "http error status: 404; reason: app instance has been unregistered; code: registration-token-not-registered; details: Requested entity was not found."
+}
+
+func (s *service) newMessage(title, body, pushToken string, data map[string]interface{}) *messaging.Message {
 	androidData := make(map[string]string)
 	for key, value := range data {
 		switch v := value.(type) {
@@ -45,10 +162,7 @@ func (s *service) SendMessage(ctx context.Context, title, body, pushToken string
 		}
 	}
 
-	fmt.Printf("AndroidData: %+v\n", androidData)
-	fmt.Printf("IOSData: %+v\n", data)
-
-	response, err := s.fcmClient.Send(ctx, &messaging.Message{
+	return &messaging.Message{
 
 		// iOS
 		APNS: &messaging.APNSConfig{
@@ -75,11 +189,5 @@ func (s *service) SendMessage(ctx context.Context, title, body, pushToken string
 			Data: androidData,
 		},
 		Token: pushToken,
-	})
-
-	if err != nil {
-		return nil, err
 	}
-
-	return &response, err
 }
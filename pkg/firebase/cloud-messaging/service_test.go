@@ -2,6 +2,7 @@ package cloudmessaging_test
 
 import (
 	cloudmessaging "airdao-mobile-api/pkg/firebase/cloud-messaging"
+	"errors"
 	"testing"
 
 	"firebase.google.com/go/messaging"
@@ -40,3 +41,24 @@ func TestNewCloudMessagingService(t *testing.T) {
 		})
 	}
 }
+
+func TestIsUnregistered(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		expect bool
+	}{
+		{name: "should ignore nil", err: nil, expect: false},
+		{name: "should ignore other errors", err: errors.New("http error status: 500"), expect: false},
+		{
+			name:   "should detect unregistered tokens",
+			err:    errors.New("http error status: 404; reason: app instance has been unregistered; code: registration-token-not-registered; details: Requested entity was not found."),
+			expect: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expect, cloudmessaging.IsUnregistered(tc.err))
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package hmacauth_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"airdao-mobile-api/pkg/hmacauth"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"id":"explorer","items":[]}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	verifier, err := hmacauth.NewVerifier([]string{"current", "previous"}, 5*time.Minute)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		timestamp string
+		nonce     string
+		signature string
+		body      []byte
+		wantErr   error
+	}{
+		{
+			name:      "should accept signature with current secret",
+			timestamp: now,
+			nonce:     "nonce-1",
+			signature: hmacauth.Sign("current", now, "nonce-1", body),
+			body:      body,
+		},
+		{
+			name:      "should accept signature with rotated secret",
+			timestamp: now,
+			nonce:     "nonce-2",
+			signature: hmacauth.Sign("previous", now, "nonce-2", body),
+			body:      body,
+		},
+		{
+			name:      "should reject replayed nonce",
+			timestamp: now,
+			nonce:     "nonce-1",
+			signature: hmacauth.Sign("current", now, "nonce-1", body),
+			body:      body,
+			wantErr:   hmacauth.ErrReplayedNonce,
+		},
+		{
+			name:      "should reject unknown secret",
+			timestamp: now,
+			nonce:     "nonce-3",
+			signature: hmacauth.Sign("unknown", now, "nonce-3", body),
+			body:      body,
+			wantErr:   hmacauth.ErrInvalidSignature,
+		},
+		{
+			name:      "should reject tampered body",
+			timestamp: now,
+			nonce:     "nonce-4",
+			signature: hmacauth.Sign("current", now, "nonce-4", body),
+			body:      []byte(`{"id":"explorer","items":[{}]}`),
+			wantErr:   hmacauth.ErrInvalidSignature,
+		},
+		{
+			name:      "should reject old timestamp",
+			timestamp: old,
+			nonce:     "nonce-5",
+			signature: hmacauth.Sign("current", old, "nonce-5", body),
+			body:      body,
+			wantErr:   hmacauth.ErrTimestampSkew,
+		},
+		{
+			name:      "should reject missing signature",
+			timestamp: now,
+			nonce:     "nonce-6",
+			body:      body,
+			wantErr:   hmacauth.ErrMissingSignature,
+		},
+		{
+			name:      "should reject missing nonce",
+			timestamp: now,
+			signature: hmacauth.Sign("current", now, "", body),
+			body:      body,
+			wantErr:   hmacauth.ErrMissingNonce,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := verifier.Verify(tc.timestamp, tc.nonce, tc.signature, tc.body)
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestVerifyNotConfigured(t *testing.T) {
+	verifier, err := hmacauth.NewVerifier(nil, time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, verifier.Enabled())
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	err = verifier.Verify(now, "nonce", hmacauth.Sign("secret", now, "nonce", nil), nil)
+	assert.Equal(t, hmacauth.ErrNotConfigured, err)
+}
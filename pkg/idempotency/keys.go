@@ -0,0 +1,225 @@
+package idempotency
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"airdao-mobile-api/pkg/apierror"
+	"airdao-mobile-api/pkg/metrics"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+const (
+	HeaderKey      = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+
+	maxKeyLength = 255
+	// inFlightTTL frees keys of requests that never completed, like on a
+	// crash, so their retries are not refused forever
+	inFlightTTL = time.Minute
+)
+
+var (
+	ErrInvalidKey = apierror.New(fiber.StatusBadRequest, "invalid_idempotency_key", "idempotency key must be at most 255 characters")
+	ErrKeyReused  = apierror.New(fiber.StatusUnprocessableEntity, "idempotency_key_reused", "idempotency key was used for another request")
+	ErrInProgress = apierror.New(fiber.StatusConflict, "idempotency_request_in_progress", "a request with this idempotency key is in progress")
+)
+
+// ScopeFunc returns who a key belongs to, so clients can't replay each
+// other's responses.
+type ScopeFunc func(c *fiber.Ctx) string
+
+// Keys replays the response of a request to its retries carrying the same
+// Idempotency-Key header.
+type Keys struct {
+	store   Store
+	ttl     time.Duration
+	logger  *zap.SugaredLogger
+	replays *metrics.Counter
+	now     func() time.Time
+}
+
+func New(store Store, ttl time.Duration, registry *metrics.Registry, logger *zap.SugaredLogger) (*Keys, error) {
+	if store == nil {
+		return nil, errors.New("[idempotency] invalid store")
+	}
+	if ttl <= 0 {
+		return nil, errors.New("[idempotency] invalid ttl")
+	}
+	if registry == nil {
+		return nil, errors.New("[idempotency] invalid metrics registry")
+	}
+	if logger == nil {
+		return nil, errors.New("[idempotency] invalid logger")
+	}
+
+	return &Keys{store: store, ttl: ttl, logger: logger, replays: registry.Counter("idempotency_replays_total"), now: time.Now}, nil
+}
+
+// Middleware handles the keys of POST, PUT, PATCH and DELETE requests.
+// Responses below 500 are kept for the TTL, server errors release the key
+// so the retry runs again. Responses can hold credentials, so they are kept
+// encrypted with a key only a retry of the request can derive: records are
+// stored under a hash of the idempotency key.
+func (k *Keys) Middleware(scope ScopeFunc) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(HeaderKey)
+		if key == "" || !mutating(c.Method()) {
+			return c.Next()
+		}
+		if len(key) > maxKeyLength {
+			return ErrInvalidKey
+		}
+
+		now := k.now()
+		scopedKey := scope(c) + ":" + key
+		record := &Record{Key: recordKey(scopedKey), RequestHash: requestHash(c), ExpiresAt: now.Add(inFlightTTL)}
+
+		existing, err := k.store.Reserve(c.Context(), record, now)
+		if err != nil {
+			// Without the store the request runs like one without a key
+			k.logger.Errorf("Middleware reserve error %v\n", err)
+			return c.Next()
+		}
+
+		if existing != nil {
+			if existing.RequestHash != record.RequestHash {
+				return ErrKeyReused
+			}
+			if !existing.Done {
+				c.Set(fiber.HeaderRetryAfter, "1")
+				return ErrInProgress
+			}
+
+			body, err := open(sealKey(scopedKey, c), existing.Body)
+			if err != nil {
+				k.logger.Errorf("Middleware open error %v\n", err)
+				return err
+			}
+
+			k.replays.Inc()
+			c.Set(HeaderReplayed, "true")
+			if existing.ContentType != "" {
+				c.Set(fiber.HeaderContentType, existing.ContentType)
+			}
+			return c.Status(existing.Status).Send(body)
+		}
+
+		// Errors are rendered here rather than by the app, so their
+		// response can be kept too
+		if err := c.Next(); err != nil {
+			if err := c.App().Config().ErrorHandler(c, err); err != nil {
+				k.release(c, record.Key)
+				return err
+			}
+		}
+
+		status := c.Response().StatusCode()
+		if status >= fiber.StatusInternalServerError {
+			k.release(c, record.Key)
+			return nil
+		}
+
+		body, err := seal(sealKey(scopedKey, c), c.Response().Body())
+		if err != nil {
+			k.logger.Errorf("Middleware seal error %v\n", err)
+			k.release(c, record.Key)
+			return nil
+		}
+
+		record.Done = true
+		record.Status = status
+		record.ContentType = string(c.Response().Header.ContentType())
+		record.Body = body
+		record.ExpiresAt = now.Add(k.ttl)
+
+		if err := k.store.Complete(c.Context(), record); err != nil {
+			k.logger.Errorf("Middleware complete error %v\n", err)
+			k.release(c, record.Key)
+		}
+
+		return nil
+	}
+}
+
+func (k *Keys) release(c *fiber.Ctx, key string) {
+	if err := k.store.Release(c.Context(), key); err != nil {
+		k.logger.Errorf("Middleware release error %v\n", err)
+	}
+}
+
+func mutating(method string) bool {
+	switch method {
+	case fiber.MethodPost, fiber.MethodPut, fiber.MethodPatch, fiber.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// requestHash tells a retry from another request reusing the key.
+func requestHash(c *fiber.Ctx) string {
+	hash := sha256.New()
+	hash.Write([]byte(c.Method() + "\n" + c.OriginalURL() + "\n"))
+	hash.Write(c.Body())
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// recordKey is what a record is stored under, so the idempotency key that
+// the response is encrypted with is not stored.
+func recordKey(scopedKey string) string {
+	hash := sha256.Sum256([]byte("record\n" + scopedKey))
+	return hex.EncodeToString(hash[:])
+}
+
+// sealKey is the key the response to a request is encrypted with.
+func sealKey(scopedKey string, c *fiber.Ctx) []byte {
+	hash := sha256.New()
+	hash.Write([]byte("response\n" + scopedKey + "\n"))
+	hash.Write(c.Body())
+
+	return hash.Sum(nil)
+}
+
+// seal encrypts body with AES-GCM, the nonce first.
+func seal(key, body []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, body, nil), nil
+}
+
+func open(key, sealed []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed response too short")
+	}
+
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
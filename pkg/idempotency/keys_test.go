@@ -0,0 +1,212 @@
+package idempotency_test
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"airdao-mobile-api/pkg/apierror"
+	"airdao-mobile-api/pkg/idempotency"
+	"airdao-mobile-api/pkg/metrics"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var errAlreadyExists = apierror.New(fiber.StatusConflict, "watcher_already_exists", "watcher already exists")
+
+func newApp(t *testing.T, store idempotency.Store, registry *metrics.Registry) (*fiber.App, *int) {
+	keys, err := idempotency.New(store, time.Hour, registry, zap.NewNop().Sugar())
+	assert.NoError(t, err)
+
+	calls := 0
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.Handler(zap.NewNop().Sugar())})
+	app.Use(keys.Middleware(func(c *fiber.Ctx) string { return c.Get("X-Device") }))
+
+	created := false
+	app.Post("/watcher", func(c *fiber.Ctx) error {
+		calls++
+		if created {
+			return errAlreadyExists
+		}
+		created = true
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "OK"})
+	})
+	app.Put("/failing", func(c *fiber.Ctx) error {
+		calls++
+		return fiber.ErrServiceUnavailable
+	})
+
+	return app, &calls
+}
+
+func request(app *fiber.App, method, path, device, key, body string) (int, string, string) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("X-Device", device)
+	if key != "" {
+		req.Header.Set(idempotency.HeaderKey, key)
+	}
+
+	resp, err := app.Test(req)
+	if err != nil {
+		return 0, "", ""
+	}
+	out, _ := io.ReadAll(resp.Body)
+
+	return resp.StatusCode, string(out), resp.Header.Get(idempotency.HeaderReplayed)
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		keys         []string
+		devices      []string
+		bodies       []string
+		wantStatus   []int
+		wantReplayed []string
+		wantCalls    int
+	}{
+		{
+			name:         "should replay the response of a retry",
+			method:       fiber.MethodPost,
+			path:         "/watcher",
+			keys:         []string{"k1", "k1"},
+			devices:      []string{"a", "a"},
+			bodies:       []string{`{"push_token":"t"}`, `{"push_token":"t"}`},
+			wantStatus:   []int{fiber.StatusCreated, fiber.StatusCreated},
+			wantReplayed: []string{"", "true"},
+			wantCalls:    1,
+		},
+		{
+			name:         "should run requests without a key",
+			method:       fiber.MethodPost,
+			path:         "/watcher",
+			keys:         []string{"", ""},
+			devices:      []string{"a", "a"},
+			bodies:       []string{"{}", "{}"},
+			wantStatus:   []int{fiber.StatusCreated, fiber.StatusConflict},
+			wantReplayed: []string{"", ""},
+			wantCalls:    2,
+		},
+		{
+			name:         "should refuse a key reused for another body",
+			method:       fiber.MethodPost,
+			path:         "/watcher",
+			keys:         []string{"k1", "k1"},
+			devices:      []string{"a", "a"},
+			bodies:       []string{`{"push_token":"t"}`, `{"push_token":"u"}`},
+			wantStatus:   []int{fiber.StatusCreated, fiber.StatusUnprocessableEntity},
+			wantReplayed: []string{"", ""},
+			wantCalls:    1,
+		},
+		{
+			name:         "should scope keys by device",
+			method:       fiber.MethodPost,
+			path:         "/watcher",
+			keys:         []string{"k1", "k1"},
+			devices:      []string{"a", "b"},
+			bodies:       []string{"{}", "{}"},
+			wantStatus:   []int{fiber.StatusCreated, fiber.StatusConflict},
+			wantReplayed: []string{"", ""},
+			wantCalls:    2,
+		},
+		{
+			name:         "should run the retry of a server error again",
+			method:       fiber.MethodPut,
+			path:         "/failing",
+			keys:         []string{"k1", "k1"},
+			devices:      []string{"a", "a"},
+			bodies:       []string{"{}", "{}"},
+			wantStatus:   []int{fiber.StatusServiceUnavailable, fiber.StatusServiceUnavailable},
+			wantReplayed: []string{"", ""},
+			wantCalls:    2,
+		},
+		{
+			name:         "should refuse too long keys",
+			method:       fiber.MethodPost,
+			path:         "/watcher",
+			keys:         []string{strings.Repeat("k", 256)},
+			devices:      []string{"a"},
+			bodies:       []string{"{}"},
+			wantStatus:   []int{fiber.StatusBadRequest},
+			wantReplayed: []string{""},
+			wantCalls:    0,
+		},
+	}
+
+	for _, test := range tests {
+		registry := metrics.NewRegistry()
+		app, calls := newApp(t, idempotency.NewMemoryStore(), registry)
+
+		var first string
+		for i := range test.keys {
+			status, body, replayed := request(app, test.method, test.path, test.devices[i], test.keys[i], test.bodies[i])
+			assert.Equal(t, test.wantStatus[i], status, test.name)
+			assert.Equal(t, test.wantReplayed[i], replayed, test.name)
+
+			if i == 0 {
+				first = body
+			} else if replayed != "" {
+				assert.Equal(t, first, body, test.name)
+			}
+		}
+
+		assert.Equal(t, test.wantCalls, *calls, test.name)
+	}
+}
+
+func TestMemoryStoreInFlight(t *testing.T) {
+	store := idempotency.NewMemoryStore()
+	now := time.Now()
+
+	existing, err := store.Reserve(context.Background(), &idempotency.Record{Key: "a:k1", RequestHash: "h", ExpiresAt: now.Add(time.Minute)}, now)
+	assert.NoError(t, err)
+	assert.Nil(t, existing)
+
+	existing, _ = store.Reserve(context.Background(), &idempotency.Record{Key: "a:k1", RequestHash: "h", ExpiresAt: now.Add(time.Minute)}, now)
+	assert.NotNil(t, existing)
+	assert.False(t, existing.Done)
+
+	// Expired records free their key
+	existing, _ = store.Reserve(context.Background(), &idempotency.Record{Key: "a:k1", RequestHash: "h", ExpiresAt: now.Add(3 * time.Minute)}, now.Add(2*time.Minute))
+	assert.Nil(t, existing)
+}
+
+// recordingStore keeps the completed records
+type recordingStore struct {
+	*idempotency.MemoryStore
+
+	completed []*idempotency.Record
+}
+
+func (s *recordingStore) Complete(ctx context.Context, record *idempotency.Record) error {
+	copied := *record
+	s.completed = append(s.completed, &copied)
+	return s.MemoryStore.Complete(ctx, record)
+}
+
+func TestMiddlewareSealsResponses(t *testing.T) {
+	store := &recordingStore{MemoryStore: idempotency.NewMemoryStore()}
+	app, calls := newApp(t, store, metrics.NewRegistry())
+
+	status, body, _ := request(app, fiber.MethodPost, "/watcher", "a", "secret-key", `{"push_token":"t"}`)
+	assert.Equal(t, fiber.StatusCreated, status)
+
+	require.Len(t, store.completed, 1)
+	record := store.completed[0]
+	assert.NotContains(t, record.Key, "secret-key", "should not store the key the response is encrypted with")
+	assert.NotContains(t, string(record.Body), "OK", "should not store the response in clear")
+
+	status, replayed, header := request(app, fiber.MethodPost, "/watcher", "a", "secret-key", `{"push_token":"t"}`)
+	assert.Equal(t, fiber.StatusCreated, status)
+	assert.Equal(t, "true", header)
+	assert.Equal(t, body, replayed)
+	assert.Equal(t, 1, *calls)
+}
@@ -0,0 +1,176 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// Record is a request made with an idempotency key. It is in flight until
+// Done, then it holds the response replayed to retries, its body encrypted.
+type Record struct {
+	Key         string    `bson:"_id"`
+	RequestHash string    `bson:"request_hash"`
+	Done        bool      `bson:"done"`
+	Status      int       `bson:"status"`
+	ContentType string    `bson:"content_type"`
+	Body        []byte    `bson:"body"`
+	ExpiresAt   time.Time `bson:"expires_at"`
+}
+
+//go:generate mockgen -source=store.go -destination=mocks/store_mock.go
+type Store interface {
+	// Reserve saves record unless an unexpired record has its key, which
+	// is returned instead.
+	Reserve(ctx context.Context, record *Record, now time.Time) (*Record, error)
+	Complete(ctx context.Context, record *Record) error
+	Release(ctx context.Context, key string) error
+}
+
+// MemoryStore keeps the records of one instance in memory.
+type MemoryStore struct {
+	mx        sync.Mutex
+	records   map[string]*Record
+	lastPurge time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]*Record)}
+}
+
+func (s *MemoryStore) Reserve(_ context.Context, record *Record, now time.Time) (*Record, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.purge(now)
+
+	if existing, ok := s.records[record.Key]; ok && existing.ExpiresAt.After(now) {
+		copied := *existing
+		return &copied, nil
+	}
+
+	copied := *record
+	s.records[record.Key] = &copied
+
+	return nil, nil
+}
+
+func (s *MemoryStore) Complete(_ context.Context, record *Record) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	copied := *record
+	s.records[record.Key] = &copied
+
+	return nil
+}
+
+func (s *MemoryStore) Release(_ context.Context, key string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	delete(s.records, key)
+
+	return nil
+}
+
+func (s *MemoryStore) purge(now time.Time) {
+	if now.Sub(s.lastPurge) < time.Minute {
+		return
+	}
+	s.lastPurge = now
+
+	for key, record := range s.records {
+		if !record.ExpiresAt.After(now) {
+			delete(s.records, key)
+		}
+	}
+}
+
+// MongoStore shares the records between instances, so a retry landing on
+// another instance is still replayed.
+type MongoStore struct {
+	db               *mongo.Client
+	dbName           string
+	dbCollectionName string
+	logger           *zap.SugaredLogger
+}
+
+func NewMongoStore(db *mongo.Client, dbName string, logger *zap.SugaredLogger) (*MongoStore, error) {
+	if db == nil {
+		return nil, errors.New("[idempotency] invalid user database")
+	}
+	if dbName == "" {
+		return nil, errors.New("[idempotency] invalid database name")
+	}
+	if logger == nil {
+		return nil, errors.New("[idempotency] invalid logger")
+	}
+
+	return &MongoStore{db: db, dbName: dbName, dbCollectionName: "idempotency_keys", logger: logger}, nil
+}
+
+func (s *MongoStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.db.Database(s.dbName).Collection(s.dbCollectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		s.logger.Errorf("EnsureIndexes error %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *MongoStore) Reserve(ctx context.Context, record *Record, now time.Time) (*Record, error) {
+	collection := s.db.Database(s.dbName).Collection(s.dbCollectionName)
+
+	// The TTL monitor runs about once a minute, an expired record may still
+	// be there and is cleared first
+	if _, err := collection.DeleteOne(ctx, bson.M{"_id": record.Key, "expires_at": bson.M{"$lte": now}}); err != nil {
+		s.logger.Errorf("Reserve delete error %v\n", err)
+		return nil, err
+	}
+
+	_, err := collection.InsertOne(ctx, record)
+	if err == nil {
+		return nil, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		s.logger.Errorf("Reserve insert error %v\n", err)
+		return nil, err
+	}
+
+	var existing Record
+	if err := collection.FindOne(ctx, bson.M{"_id": record.Key}).Decode(&existing); err != nil {
+		s.logger.Errorf("Reserve find error %v\n", err)
+		return nil, err
+	}
+
+	return &existing, nil
+}
+
+func (s *MongoStore) Complete(ctx context.Context, record *Record) error {
+	if _, err := s.db.Database(s.dbName).Collection(s.dbCollectionName).ReplaceOne(ctx, bson.M{"_id": record.Key}, record); err != nil {
+		s.logger.Errorf("Complete error %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *MongoStore) Release(ctx context.Context, key string) error {
+	if _, err := s.db.Database(s.dbName).Collection(s.dbCollectionName).DeleteOne(ctx, bson.M{"_id": key}); err != nil {
+		s.logger.Errorf("Release error %v\n", err)
+		return err
+	}
+
+	return nil
+}
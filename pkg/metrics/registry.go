@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type Counter struct {
+	value int64
+}
+
+func (c *Counter) Inc() {
+	atomic.AddInt64(&c.value, 1)
+}
+
+func (c *Counter) Add(n int64) {
+	atomic.AddInt64(&c.value, n)
+}
+
+func (c *Counter) Value() int64 {
+	return atomic.LoadInt64(&c.value)
+}
+
+type Gauge struct {
+	value int64
+}
+
+func (g *Gauge) Set(n int64) {
+	atomic.StoreInt64(&g.value, n)
+}
+
+func (g *Gauge) Add(n int64) {
+	atomic.AddInt64(&g.value, n)
+}
+
+func (g *Gauge) Value() int64 {
+	return atomic.LoadInt64(&g.value)
+}
+
+// Registry holds named counters and gauges. Metrics are created on first use
+// and live for the lifetime of the registry.
+type Registry struct {
+	mx       sync.RWMutex
+	counters map[string]*Counter
+	gauges   map[string]*Gauge
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		counters: make(map[string]*Counter),
+		gauges:   make(map[string]*Gauge),
+	}
+}
+
+func (r *Registry) Counter(name string) *Counter {
+	r.mx.RLock()
+	counter, ok := r.counters[name]
+	r.mx.RUnlock()
+	if ok {
+		return counter
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if counter, ok = r.counters[name]; !ok {
+		counter = new(Counter)
+		r.counters[name] = counter
+	}
+
+	return counter
+}
+
+func (r *Registry) Gauge(name string) *Gauge {
+	r.mx.RLock()
+	gauge, ok := r.gauges[name]
+	r.mx.RUnlock()
+	if ok {
+		return gauge
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if gauge, ok = r.gauges[name]; !ok {
+		gauge = new(Gauge)
+		r.gauges[name] = gauge
+	}
+
+	return gauge
+}
+
+// Snapshot returns the current value of every metric by name.
+func (r *Registry) Snapshot() map[string]int64 {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	out := make(map[string]int64, len(r.counters)+len(r.gauges))
+	for name, counter := range r.counters {
+		out[name] = counter.Value()
+	}
+	for name, gauge := range r.gauges {
+		out[name] = gauge.Value()
+	}
+
+	return out
+}
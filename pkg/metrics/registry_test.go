@@ -0,0 +1,30 @@
+package metrics_test
+
+import (
+	"sync"
+	"testing"
+
+	"airdao-mobile-api/pkg/metrics"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := metrics.NewRegistry()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			registry.Counter("processed").Inc()
+			registry.Gauge("depth").Add(2)
+		}()
+	}
+	wg.Wait()
+
+	registry.Gauge("depth").Add(-5)
+
+	assert.Same(t, registry.Counter("processed"), registry.Counter("processed"))
+	assert.Equal(t, map[string]int64{"processed": 10, "depth": 15}, registry.Snapshot())
+}
@@ -0,0 +1,227 @@
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const Version = "3.0.3"
+
+// Document is an OpenAPI 3 document built from route descriptions. Request
+// and response schemas are generated from Go types, their json tags and
+// their validate tags.
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+
+	errorSchema *Schema
+	validators  map[string]func(*Schema)
+	types       map[reflect.Type]*Schema
+	names       map[string]reflect.Type
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem maps lowercase HTTP methods to operations.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	Tags        []string              `json:"tags,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	OperationId string                `json:"operationId,omitempty"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Required    bool    `json:"required,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+// Route describes one handler. Path uses the fiber syntax, path parameters
+// are documented from it.
+type Route struct {
+	Method      string
+	Path        string
+	Summary     string
+	Tags        []string
+	Deprecated  bool
+	Parameters  []Parameter
+	Body        interface{}
+	Response    interface{}
+	Status      int
+	Security    []string
+	OperationId string
+}
+
+// New starts a document. errorBody is the body of every error response.
+func New(title, version string, errorBody interface{}) *Document {
+	d := &Document{
+		OpenAPI:    Version,
+		Info:       Info{Title: title, Version: version},
+		Paths:      make(map[string]PathItem),
+		Components: Components{Schemas: make(map[string]*Schema)},
+		validators: make(map[string]func(*Schema)),
+		types:      make(map[reflect.Type]*Schema),
+		names:      make(map[string]reflect.Type),
+	}
+	d.registerDefaultTypes()
+
+	if errorBody != nil {
+		d.errorSchema = d.SchemaOf(errorBody)
+	}
+
+	return d
+}
+
+// Validator documents a custom validate tag, like a pattern for "address".
+func (d *Document) Validator(tag string, fn func(*Schema)) {
+	d.validators[tag] = fn
+}
+
+// Type documents t with a fixed schema, for types with custom marshalling.
+func (d *Document) Type(t reflect.Type, schema *Schema) {
+	d.types[t] = schema
+}
+
+func (d *Document) SecurityScheme(name string, scheme SecurityScheme) {
+	if d.Components.SecuritySchemes == nil {
+		d.Components.SecuritySchemes = make(map[string]SecurityScheme)
+	}
+	d.Components.SecuritySchemes[name] = scheme
+}
+
+func (d *Document) Add(route Route) {
+	path, params := Path(route.Path)
+
+	op := &Operation{
+		Tags:        route.Tags,
+		Summary:     route.Summary,
+		OperationId: route.OperationId,
+		Deprecated:  route.Deprecated,
+		Responses:   make(map[string]Response),
+	}
+
+	for _, name := range params {
+		op.Parameters = append(op.Parameters, Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+	}
+	op.Parameters = append(op.Parameters, route.Parameters...)
+
+	if route.Body != nil {
+		op.RequestBody = &RequestBody{Required: true, Content: jsonContent(d.SchemaOf(route.Body))}
+	}
+
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	response := Response{Description: http.StatusText(status)}
+	if route.Response != nil {
+		response.Content = jsonContent(d.SchemaOf(route.Response))
+	}
+	op.Responses[strconv.Itoa(status)] = response
+
+	if d.errorSchema != nil {
+		op.Responses["default"] = Response{Description: "Error", Content: jsonContent(d.errorSchema)}
+	}
+
+	for _, name := range route.Security {
+		op.Security = append(op.Security, map[string][]string{name: {}})
+	}
+
+	item, ok := d.Paths[path]
+	if !ok {
+		item = make(PathItem)
+		d.Paths[path] = item
+	}
+	item[strings.ToLower(route.Method)] = op
+}
+
+// Operations lists the documented routes as "METHOD /path" in the fiber
+// path syntax, sorted.
+func (d *Document) Operations() []string {
+	var out []string
+	for path, item := range d.Paths {
+		for method := range item {
+			out = append(out, strings.ToUpper(method)+" "+FiberPath(path))
+		}
+	}
+	sort.Strings(out)
+
+	return out
+}
+
+// Path converts a fiber path to the OpenAPI syntax and returns its
+// parameters.
+func Path(fiberPath string) (string, []string) {
+	segments := strings.Split(fiberPath, "/")
+	var params []string
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			name := strings.TrimSuffix(strings.TrimPrefix(segment, ":"), "?")
+			params = append(params, name)
+			segments[i] = "{" + name + "}"
+		}
+	}
+
+	return strings.Join(segments, "/"), params
+}
+
+// FiberPath converts an OpenAPI path back to the fiber syntax.
+func FiberPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			segments[i] = ":" + segment[1:len(segment)-1]
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func jsonContent(schema *Schema) map[string]MediaType {
+	return map[string]MediaType{"application/json": {Schema: schema}}
+}
@@ -0,0 +1,101 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"airdao-mobile-api/pkg/openapi"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+type state struct {
+	Mode string `json:"mode" validate:"omitempty,oneof=a b"`
+}
+
+type request struct {
+	PushToken string    `json:"push_token" validate:"required"`
+	Threshold *float64  `json:"threshold" validate:"omitempty,gt=0"`
+	Addresses []string  `json:"addresses" validate:"required,min=1,dive,address"`
+	Secret    string    `json:"-"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Nested    *request  `json:"nested"`
+	Value     struct {
+		N int `json:"n"`
+	} `json:"value"`
+
+	state
+}
+
+func TestSchemaOf(t *testing.T) {
+	doc := openapi.New("test", "1", errorBody{})
+	doc.Validator("address", func(s *openapi.Schema) { s.Pattern = "^0x[0-9a-fA-F]{40}$" })
+
+	schema := doc.SchemaOf(request{})
+	assert.Equal(t, "#/components/schemas/request", schema.Ref)
+
+	got := doc.Components.Schemas["request"]
+	assert.Equal(t, []string{"push_token", "addresses"}, got.Required)
+	assert.NotContains(t, got.Properties, "Secret")
+
+	zero := 0.0
+	assert.Equal(t, &openapi.Schema{Type: "number", Format: "double", Nullable: true, Minimum: &zero, ExclusiveMinimum: true}, got.Properties["threshold"])
+
+	one := 1
+	assert.Equal(t, &openapi.Schema{Type: "array", MinItems: &one, Items: &openapi.Schema{Type: "string", Pattern: "^0x[0-9a-fA-F]{40}$"}}, got.Properties["addresses"])
+
+	assert.Equal(t, "date-time", got.Properties["updated_at"].Format)
+	assert.Equal(t, "#/components/schemas/request", got.Properties["nested"].Ref)
+	assert.Equal(t, "integer", got.Properties["value"].Properties["n"].Type)
+	assert.Equal(t, []string{"a", "b"}, got.Properties["mode"].Enum)
+}
+
+func TestAdd(t *testing.T) {
+	doc := openapi.New("test", "1", errorBody{})
+	doc.Add(openapi.Route{Method: fiber.MethodGet, Path: "/devices/:id/addresses/:address", Response: request{}})
+	doc.Add(openapi.Route{Method: fiber.MethodPost, Path: "/devices", Body: request{}, Status: fiber.StatusCreated})
+
+	assert.Equal(t, []string{"GET /devices/:id/addresses/:address", "POST /devices"}, doc.Operations())
+
+	op := doc.Paths["/devices/{id}/addresses/{address}"]["get"]
+	assert.Len(t, op.Parameters, 2)
+	assert.Equal(t, "address", op.Parameters[1].Name)
+	assert.Contains(t, op.Responses, "200")
+	assert.Contains(t, op.Responses, "default")
+
+	assert.Contains(t, doc.Paths["/devices"]["post"].Responses, "201")
+	assert.NotNil(t, doc.Paths["/devices"]["post"].RequestBody)
+}
+
+func TestSetupRoutes(t *testing.T) {
+	doc := openapi.New("test", "1", errorBody{})
+	doc.Add(openapi.Route{Method: fiber.MethodGet, Path: "/health"})
+
+	app := fiber.New()
+	app.Route("/api/docs", doc.SetupRoutes)
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/api/docs/openapi.json", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var got map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+	assert.Equal(t, openapi.Version, got["openapi"])
+
+	resp, err = app.Test(httptest.NewRequest(fiber.MethodGet, "/api/docs", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), `"/api/docs/openapi.json"`)
+	assert.Contains(t, string(body), `src="https://unpkg.com/swagger-ui-dist@5.29.1/swagger-ui-bundle.js" integrity="sha384-`)
+	assert.Contains(t, string(body), `href="https://unpkg.com/swagger-ui-dist@5.29.1/swagger-ui.css" integrity="sha384-`)
+}
@@ -0,0 +1,196 @@
+package openapi
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	ExclusiveMinimum     bool               `json:"exclusiveMinimum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+}
+
+func (d *Document) registerDefaultTypes() {
+	d.Type(reflect.TypeOf(time.Time{}), &Schema{Type: "string", Format: "date-time"})
+	d.Type(reflect.TypeOf(time.Duration(0)), &Schema{Type: "integer", Format: "int64", Description: "nanoseconds"})
+	d.Type(reflect.TypeOf(primitive.ObjectID{}), &Schema{Type: "string", Pattern: "^[0-9a-f]{24}$"})
+}
+
+// SchemaOf returns the schema of the type of v. Named structs are added to
+// the components and referenced.
+func (d *Document) SchemaOf(v interface{}) *Schema {
+	return d.schema(reflect.TypeOf(v))
+}
+
+func (d *Document) schema(t reflect.Type) *Schema {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if schema, ok := d.types[t]; ok {
+		copied := *schema
+		return &copied
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: d.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.structSchema(t)
+		}
+		return d.ref(t)
+	}
+
+	// Interfaces and anything else accept any value
+	return &Schema{}
+}
+
+func (d *Document) ref(t reflect.Type) *Schema {
+	name := t.Name()
+	if other, ok := d.names[name]; ok && other != t {
+		name = strings.Title(pkgName(t)) + name
+	}
+
+	if _, ok := d.Components.Schemas[name]; !ok {
+		d.names[name] = t
+		// Placeholder first, so recursive types terminate
+		d.Components.Schemas[name] = &Schema{Type: "object"}
+		d.Components.Schemas[name] = d.structSchema(t)
+	}
+
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func (d *Document) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	d.addFields(schema, t)
+
+	return schema
+}
+
+func (d *Document) addFields(schema *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		// Embedded structs without a json name are flattened like encoding/json does
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				d.addFields(schema, embedded)
+				continue
+			}
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		fieldSchema := d.schema(field.Type)
+		if field.Type.Kind() == reflect.Pointer && fieldSchema.Ref == "" {
+			fieldSchema.Nullable = true
+		}
+
+		if d.applyValidate(fieldSchema, field.Tag.Get("validate")) {
+			schema.Required = append(schema.Required, name)
+		}
+
+		schema.Properties[name] = fieldSchema
+	}
+}
+
+// applyValidate documents the validate rules of a field and reports whether
+// it is required. Rules after "dive" apply to the items.
+func (d *Document) applyValidate(schema *Schema, tag string) bool {
+	if tag == "" || schema.Ref != "" {
+		return strings.HasPrefix(tag, "required")
+	}
+
+	required := false
+	target := schema
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+
+		switch name {
+		case "required":
+			required = required || target == schema
+		case "dive":
+			if target.Items == nil {
+				return required
+			}
+			target = target.Items
+		case "oneof":
+			target.Enum = strings.Fields(param)
+		case "gt", "gte":
+			if v, err := strconv.ParseFloat(param, 64); err == nil {
+				target.Minimum = &v
+				target.ExclusiveMinimum = name == "gt"
+			}
+		case "min":
+			if v, err := strconv.Atoi(param); err == nil {
+				switch target.Type {
+				case "string":
+					target.MinLength = &v
+				case "array":
+					target.MinItems = &v
+				default:
+					f := float64(v)
+					target.Minimum = &f
+				}
+			}
+		default:
+			if fn, ok := d.validators[name]; ok {
+				fn(target)
+			}
+		}
+	}
+
+	return required
+}
+
+func pkgName(t reflect.Type) string {
+	path := t.PkgPath()
+	return path[strings.LastIndex(path, "/")+1:]
+}
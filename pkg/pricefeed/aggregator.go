@@ -0,0 +1,237 @@
+package pricefeed
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Aggregate is the price agreed on by the providers.
+type Aggregate struct {
+	Price     float64   `json:"price"`
+	Volume24h float64   `json:"volume_24h"`
+	MarketCap float64   `json:"market_cap"`
+	Sources   []string  `json:"sources"`
+	Rejected  []string  `json:"rejected,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Fresh is set when enough recent quotes agreed on the price. Consumers
+	// that act on the price, like alerts, should ignore aggregates that
+	// aren't fresh.
+	Fresh bool `json:"fresh"`
+}
+
+type AggregatorConfig struct {
+	Interval     time.Duration
+	MaxAge       time.Duration
+	MaxDeviation float64
+	MinSources   int
+}
+
+// Aggregator polls every provider, drops stale quotes and outliers and takes
+// the median of the rest. A provider that fails keeps its last quote until it
+// goes stale, so the others take over without a gap. Providers are listed in
+// order of preference: with fewer than three fresh quotes there is no median
+// to tell the outlier, and the preferred quote is kept.
+type Aggregator struct {
+	providers []Provider
+	cfg       AggregatorConfig
+	logger    *zap.SugaredLogger
+
+	mx          sync.RWMutex
+	quotes      map[string]*Quote
+	latest      Aggregate
+	subscribers []func(Aggregate)
+}
+
+func NewAggregator(providers []Provider, cfg AggregatorConfig, logger *zap.SugaredLogger) (*Aggregator, error) {
+	if len(providers) == 0 {
+		return nil, errors.New("[price_aggregator] no price providers")
+	}
+	if cfg.Interval <= 0 || cfg.MaxAge <= 0 {
+		return nil, errors.New("[price_aggregator] invalid intervals")
+	}
+	if cfg.MaxDeviation <= 0 {
+		return nil, errors.New("[price_aggregator] invalid max deviation")
+	}
+	if cfg.MinSources <= 0 || cfg.MinSources > len(providers) {
+		return nil, errors.New("[price_aggregator] invalid min sources")
+	}
+	if logger == nil {
+		return nil, errors.New("[price_aggregator] invalid logger")
+	}
+
+	return &Aggregator{
+		providers: providers,
+		cfg:       cfg,
+		logger:    logger,
+		quotes:    make(map[string]*Quote),
+	}, nil
+}
+
+// Subscribe registers fn to be called with every new aggregate.
+func (a *Aggregator) Subscribe(fn func(Aggregate)) {
+	a.mx.Lock()
+	a.subscribers = append(a.subscribers, fn)
+	a.mx.Unlock()
+}
+
+func (a *Aggregator) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.Refresh(ctx)
+		}
+	}
+}
+
+// Refresh fetches all providers once and publishes the new aggregate.
+func (a *Aggregator) Refresh(ctx context.Context) Aggregate {
+	var wg sync.WaitGroup
+	results := make([]*Quote, len(a.providers))
+	for i, provider := range a.providers {
+		wg.Add(1)
+		go func(i int, provider Provider) {
+			defer wg.Done()
+
+			quote, err := provider.Fetch(ctx)
+			if err != nil {
+				a.logger.Errorf("price provider %s error %v", provider.Name(), err)
+				return
+			}
+			results[i] = quote
+		}(i, provider)
+	}
+	wg.Wait()
+
+	a.mx.Lock()
+	for _, quote := range results {
+		if quote != nil {
+			a.quotes[quote.Source] = quote
+		}
+	}
+
+	quotes := make([]*Quote, 0, len(a.quotes))
+	for _, provider := range a.providers {
+		if quote, ok := a.quotes[provider.Name()]; ok {
+			quotes = append(quotes, quote)
+		}
+	}
+
+	next := aggregate(quotes, time.Now(), a.cfg)
+	if next.Price == 0 {
+		// Nothing usable, keep the last known price but flag it as stale
+		next = a.latest
+		next.Fresh = false
+	}
+	a.latest = next
+	subscribers := a.subscribers
+	a.mx.Unlock()
+
+	if !next.Fresh {
+		a.logger.Warnf("price aggregate is not fresh: sources %v, rejected %v", next.Sources, next.Rejected)
+	}
+
+	for _, fn := range subscribers {
+		fn(next)
+	}
+
+	return next
+}
+
+// Latest returns the last aggregate, marked stale once it is older than MaxAge.
+func (a *Aggregator) Latest() Aggregate {
+	a.mx.RLock()
+	latest := a.latest
+	a.mx.RUnlock()
+
+	if time.Since(latest.UpdatedAt) > a.cfg.MaxAge {
+		latest.Fresh = false
+	}
+
+	return latest
+}
+
+// minMedianQuotes is how many fresh quotes it takes for the median to outvote
+// an outlier
+const minMedianQuotes = 3
+
+// aggregate expects the quotes in order of preference.
+func aggregate(quotes []*Quote, now time.Time, cfg AggregatorConfig) Aggregate {
+	var recent []*Quote
+	var rejected []string
+	for _, quote := range quotes {
+		if quote.Price > 0 && now.Sub(quote.Timestamp) <= cfg.MaxAge {
+			recent = append(recent, quote)
+		} else {
+			rejected = append(rejected, quote.Source)
+		}
+	}
+	if len(recent) == 0 {
+		return Aggregate{Rejected: rejected}
+	}
+
+	// Two quotes that disagree are both half the spread away from their
+	// median, measuring from the preferred one keeps a price
+	mid := recent[0].Price
+	if len(recent) >= minMedianQuotes {
+		mid = median(recent)
+	}
+
+	var agreed []*Quote
+	for _, quote := range recent {
+		if math.Abs(quote.Price-mid)/mid*100 <= cfg.MaxDeviation {
+			agreed = append(agreed, quote)
+		} else {
+			rejected = append(rejected, quote.Source)
+		}
+	}
+	if len(agreed) == 0 {
+		return Aggregate{Rejected: rejected}
+	}
+
+	// Sources measure volume on different venues, the widest view wins
+	var volume, marketCap float64
+	sources := make([]string, 0, len(agreed))
+	for _, quote := range agreed {
+		sources = append(sources, quote.Source)
+		volume = math.Max(volume, quote.Volume24h)
+		marketCap = math.Max(marketCap, quote.MarketCap)
+	}
+	sort.Strings(sources)
+	sort.Strings(rejected)
+
+	return Aggregate{
+		Price:     median(agreed),
+		Volume24h: volume,
+		MarketCap: marketCap,
+		Sources:   sources,
+		Rejected:  rejected,
+		UpdatedAt: now,
+		Fresh:     len(agreed) >= cfg.MinSources,
+	}
+}
+
+func median(quotes []*Quote) float64 {
+	prices := make([]float64, 0, len(quotes))
+	for _, quote := range quotes {
+		prices = append(prices, quote.Price)
+	}
+	sort.Float64s(prices)
+
+	n := len(prices)
+	if n%2 == 1 {
+		return prices[n/2]
+	}
+
+	return (prices[n/2-1] + prices[n/2]) / 2
+}
@@ -0,0 +1,183 @@
+package pricefeed_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"airdao-mobile-api/pkg/pricefeed"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type stubProvider struct {
+	name  string
+	price float64
+	age   time.Duration
+	err   error
+}
+
+func (p *stubProvider) Name() string {
+	return p.name
+}
+
+func (p *stubProvider) Fetch(ctx context.Context) (*pricefeed.Quote, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	return &pricefeed.Quote{Source: p.name, Price: p.price, Timestamp: time.Now().Add(-p.age)}, nil
+}
+
+func TestAggregatorRefresh(t *testing.T) {
+	cfg := pricefeed.AggregatorConfig{Interval: time.Minute, MaxAge: 10 * time.Minute, MaxDeviation: 5, MinSources: 2}
+
+	tests := []struct {
+		name      string
+		providers []pricefeed.Provider
+		expect    func(t *testing.T, got pricefeed.Aggregate)
+	}{
+		{
+			name: "should take the median of agreeing sources",
+			providers: []pricefeed.Provider{
+				&stubProvider{name: "a", price: 1.00},
+				&stubProvider{name: "b", price: 1.02},
+				&stubProvider{name: "c", price: 1.01},
+			},
+			expect: func(t *testing.T, got pricefeed.Aggregate) {
+				assert.Equal(t, 1.01, got.Price)
+				assert.Equal(t, []string{"a", "b", "c"}, got.Sources)
+				assert.True(t, got.Fresh)
+			},
+		},
+		{
+			name: "should reject outliers",
+			providers: []pricefeed.Provider{
+				&stubProvider{name: "a", price: 1.00},
+				&stubProvider{name: "b", price: 1.02},
+				&stubProvider{name: "c", price: 2.00},
+			},
+			expect: func(t *testing.T, got pricefeed.Aggregate) {
+				assert.Equal(t, 1.01, got.Price)
+				assert.Equal(t, []string{"c"}, got.Rejected)
+				assert.True(t, got.Fresh)
+			},
+		},
+		{
+			name: "should keep the preferred of two disagreeing sources",
+			providers: []pricefeed.Provider{
+				&stubProvider{name: "a", price: 1.00},
+				&stubProvider{name: "b", price: 1.30},
+			},
+			expect: func(t *testing.T, got pricefeed.Aggregate) {
+				assert.Equal(t, 1.00, got.Price)
+				assert.Equal(t, []string{"a"}, got.Sources)
+				assert.Equal(t, []string{"b"}, got.Rejected)
+				assert.False(t, got.Fresh, "should not be fresh below the min sources")
+			},
+		},
+		{
+			name: "should average two agreeing sources",
+			providers: []pricefeed.Provider{
+				&stubProvider{name: "a", price: 1.00},
+				&stubProvider{name: "b", price: 1.02},
+			},
+			expect: func(t *testing.T, got pricefeed.Aggregate) {
+				assert.Equal(t, 1.01, got.Price)
+				assert.Equal(t, []string{"a", "b"}, got.Sources)
+				assert.True(t, got.Fresh)
+			},
+		},
+		{
+			name: "should fail over when a source errors and drop stale quotes",
+			providers: []pricefeed.Provider{
+				&stubProvider{name: "a", price: 1.00},
+				&stubProvider{name: "b", err: errors.New("unavailable")},
+				&stubProvider{name: "c", price: 1.50, age: time.Hour},
+			},
+			expect: func(t *testing.T, got pricefeed.Aggregate) {
+				assert.Equal(t, 1.00, got.Price)
+				assert.Equal(t, []string{"a"}, got.Sources)
+				assert.Equal(t, []string{"c"}, got.Rejected)
+				assert.False(t, got.Fresh)
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			aggregator, err := pricefeed.NewAggregator(tc.providers, cfg, zap.NewNop().Sugar())
+			assert.NoError(t, err)
+
+			var published pricefeed.Aggregate
+			aggregator.Subscribe(func(a pricefeed.Aggregate) { published = a })
+
+			got := aggregator.Refresh(context.Background())
+			tc.expect(t, got)
+			assert.Equal(t, got, published)
+		})
+	}
+}
+
+func TestAggregatorKeepsLastPriceWhenAllSourcesFail(t *testing.T) {
+	provider := &stubProvider{name: "a", price: 1.00}
+	cfg := pricefeed.AggregatorConfig{Interval: time.Minute, MaxAge: 10 * time.Minute, MaxDeviation: 5, MinSources: 1}
+
+	aggregator, err := pricefeed.NewAggregator([]pricefeed.Provider{provider}, cfg, zap.NewNop().Sugar())
+	assert.NoError(t, err)
+
+	assert.True(t, aggregator.Refresh(context.Background()).Fresh)
+
+	provider.err = errors.New("unavailable")
+	provider.price = 0
+
+	// The cached quote is still recent, so the price stays usable
+	got := aggregator.Refresh(context.Background())
+	assert.Equal(t, 1.00, got.Price)
+	assert.True(t, got.Fresh)
+	assert.Equal(t, got, aggregator.Latest())
+}
+
+func TestFeeds(t *testing.T) {
+	cfg := pricefeed.AggregatorConfig{Interval: time.Minute, MaxAge: 10 * time.Minute, MaxDeviation: 5, MinSources: 1}
+
+	amb, err := pricefeed.NewAggregator([]pricefeed.Provider{&stubProvider{name: "a", price: 0.01}}, cfg, zap.NewNop().Sugar())
+	assert.NoError(t, err)
+	usdc, err := pricefeed.NewAggregator([]pricefeed.Provider{&stubProvider{name: "a", price: 1}}, cfg, zap.NewNop().Sugar())
+	assert.NoError(t, err)
+
+	feeds, err := pricefeed.NewFeeds(map[string]*pricefeed.Aggregator{"AMB": amb, "USDC": usdc}, map[string]string{"0xAbC": "USDC"})
+	assert.NoError(t, err)
+
+	_, err = pricefeed.NewFeeds(map[string]*pricefeed.Aggregator{"AMB": amb}, map[string]string{"0xabc": "USDC"})
+	assert.Error(t, err)
+
+	var mx sync.Mutex
+	got := make(map[string]float64)
+	feeds.Subscribe(func(token string, aggregate pricefeed.Aggregate) {
+		mx.Lock()
+		got[token] = aggregate.Price
+		mx.Unlock()
+	})
+	feeds.Refresh(context.Background())
+
+	assert.Equal(t, []string{"AMB", "USDC"}, feeds.Tokens())
+	assert.Equal(t, map[string]float64{"AMB": 0.01, "USDC": 1}, got)
+
+	latest, ok := feeds.Latest("USDC")
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, latest.Price)
+
+	_, ok = feeds.Latest("HBR")
+	assert.False(t, ok)
+
+	token, ok := feeds.TokenOf("0xabc")
+	assert.True(t, ok)
+	assert.Equal(t, "USDC", token)
+
+	_, ok = feeds.TokenOf("0xdef")
+	assert.False(t, ok)
+}
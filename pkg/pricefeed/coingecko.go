@@ -0,0 +1,100 @@
+package pricefeed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type MarketChart struct {
+	Prices       [][]float64 `json:"prices"`
+	TotalVolumes [][]float64 `json:"total_volumes"`
+}
+
+// CoinGecko is both a spot price provider and the source of the market chart.
+type CoinGecko struct {
+	apiUrl string
+	coinId string
+}
+
+func NewCoinGecko(apiUrl, coinId string) (*CoinGecko, error) {
+	if apiUrl == "" {
+		return nil, errors.New("[coingecko] invalid api url")
+	}
+	if coinId == "" {
+		return nil, errors.New("[coingecko] invalid coin id")
+	}
+
+	return &CoinGecko{apiUrl: strings.TrimRight(apiUrl, "/"), coinId: coinId}, nil
+}
+
+func (p *CoinGecko) Name() string {
+	return "coingecko"
+}
+
+func (p *CoinGecko) Fetch(ctx context.Context) (*Quote, error) {
+	var res map[string]struct {
+		USD           float64 `json:"usd"`
+		USD24hVol     float64 `json:"usd_24h_vol"`
+		USDMarketCap  float64 `json:"usd_market_cap"`
+		LastUpdatedAt int64   `json:"last_updated_at"`
+	}
+
+	url := fmt.Sprintf("%s/simple/price?ids=%s&vs_currencies=usd&include_24hr_vol=true&include_market_cap=true&include_last_updated_at=true", p.apiUrl, p.coinId)
+	if err := getJSON(ctx, url, &res); err != nil {
+		return nil, err
+	}
+
+	price, ok := res[p.coinId]
+	if !ok || price.USD <= 0 {
+		return nil, errors.New("coingecko returned no price")
+	}
+
+	timestamp := time.Now()
+	if price.LastUpdatedAt > 0 {
+		timestamp = time.Unix(price.LastUpdatedAt, 0)
+	}
+
+	return &Quote{Source: p.Name(), Price: price.USD, Volume24h: price.USD24hVol, MarketCap: price.USDMarketCap, Timestamp: timestamp}, nil
+}
+
+func (p *CoinGecko) MarketChart(ctx context.Context, days int) (*MarketChart, error) {
+	var res *MarketChart
+
+	url := fmt.Sprintf("%s/coins/%s/market_chart?vs_currency=usd&days=%d", p.apiUrl, p.coinId, days)
+	if err := getJSON(ctx, url, &res); err != nil {
+		return nil, err
+	}
+	if res == nil {
+		return nil, errors.New("coingecko returned empty market chart")
+	}
+
+	return res, nil
+}
+
+// FetchRates derives fiat rates from the coin price in every currency.
+func (p *CoinGecko) FetchRates(ctx context.Context, currencies []string) (map[string]float64, error) {
+	var res map[string]map[string]float64
+
+	vsCurrencies := append([]string{BaseCurrency}, currencies...)
+	url := fmt.Sprintf("%s/simple/price?ids=%s&vs_currencies=%s", p.apiUrl, p.coinId, strings.ToLower(strings.Join(vsCurrencies, ",")))
+	if err := getJSON(ctx, url, &res); err != nil {
+		return nil, err
+	}
+
+	prices, ok := res[p.coinId]
+	if !ok || prices["usd"] <= 0 {
+		return nil, errors.New("coingecko returned no price")
+	}
+
+	rates := make(map[string]float64, len(currencies))
+	for _, currency := range currencies {
+		if price := prices[strings.ToLower(currency)]; price > 0 {
+			rates[currency] = price / prices["usd"]
+		}
+	}
+
+	return rates, nil
+}
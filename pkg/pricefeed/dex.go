@@ -0,0 +1,161 @@
+package pricefeed
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// getReserves() of a Uniswap V2 style pair
+const getReservesSelector = "0x0902f1ac"
+
+type DexPoolConfig struct {
+	RpcUrl        string
+	PairAddress   string
+	BaseIsToken0  bool
+	BaseDecimals  int
+	QuoteDecimals int
+}
+
+// DexPoolProvider derives the price from the reserves of a pool that pairs the
+// token with a USD stablecoin, read straight from the chain.
+type DexPoolProvider struct {
+	cfg DexPoolConfig
+}
+
+func NewDexPoolProvider(cfg DexPoolConfig) (*DexPoolProvider, error) {
+	if cfg.RpcUrl == "" {
+		return nil, errors.New("[dex_pool_provider] invalid rpc url")
+	}
+	if len(hexToBytes(cfg.PairAddress)) != 20 {
+		return nil, errors.New("[dex_pool_provider] invalid pair address")
+	}
+	if cfg.BaseDecimals < 0 || cfg.QuoteDecimals < 0 {
+		return nil, errors.New("[dex_pool_provider] invalid decimals")
+	}
+
+	return &DexPoolProvider{cfg: cfg}, nil
+}
+
+func (p *DexPoolProvider) Name() string {
+	return "dex-pool"
+}
+
+type rpcRequest struct {
+	JsonRpc string        `json:"jsonrpc"`
+	Id      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	Result string `json:"result"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *DexPoolProvider) Fetch(ctx context.Context) (*Quote, error) {
+	reqBody, err := json.Marshal(&rpcRequest{
+		JsonRpc: "2.0",
+		Id:      1,
+		Method:  "eth_call",
+		Params:  []interface{}{map[string]string{"to": p.cfg.PairAddress, "data": getReservesSelector}, "latest"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.RpcUrl, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var res rpcResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+	if res.Error != nil {
+		return nil, fmt.Errorf("eth_call error: %s", res.Error.Message)
+	}
+
+	reserve0, reserve1, err := decodeReserves(res.Result)
+	if err != nil {
+		return nil, err
+	}
+
+	base, quote := reserve0, reserve1
+	if !p.cfg.BaseIsToken0 {
+		base, quote = reserve1, reserve0
+	}
+	if base.Sign() == 0 || quote.Sign() == 0 {
+		return nil, errors.New("dex pool has no liquidity")
+	}
+
+	price := poolPrice(base, quote, p.cfg.BaseDecimals, p.cfg.QuoteDecimals)
+
+	return &Quote{Source: p.Name(), Price: price, Timestamp: time.Now()}, nil
+}
+
+func decodeReserves(result string) (*big.Int, *big.Int, error) {
+	data := hexToBytes(result)
+	if len(data) < 64 {
+		return nil, nil, errors.New("invalid getReserves response")
+	}
+
+	return new(big.Int).SetBytes(data[:32]), new(big.Int).SetBytes(data[32:64]), nil
+}
+
+// poolPrice is the amount of quote token paid for one whole base token.
+func poolPrice(base, quote *big.Int, baseDecimals, quoteDecimals int) float64 {
+	price := new(big.Float).Quo(new(big.Float).SetInt(quote), new(big.Float).SetInt(base))
+
+	scale := new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(baseDecimals-quoteDecimals))), nil))
+	if baseDecimals > quoteDecimals {
+		price.Mul(price, scale)
+	} else {
+		price.Quo(price, scale)
+	}
+
+	out, _ := price.Float64()
+	return out
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func hexToBytes(s string) []byte {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	if len(s)%2 == 1 {
+		s = "0" + s
+	}
+	out, err := hex.DecodeString(s)
+	if err != nil {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,39 @@
+package pricefeed_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"airdao-mobile-api/pkg/pricefeed"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDexPoolProviderFetch(t *testing.T) {
+	// reserve0 = 2000 AMB (18 decimals), reserve1 = 20 USDC (6 decimals)
+	result := "0x" +
+		"00000000000000000000000000000000000000000000006c6b935b8bbd400000" +
+		"0000000000000000000000000000000000000000000000000000000001312d00" +
+		"0000000000000000000000000000000000000000000000000000000065000000"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"` + result + `"}`))
+	}))
+	defer server.Close()
+
+	provider, err := pricefeed.NewDexPoolProvider(pricefeed.DexPoolConfig{
+		RpcUrl:        server.URL,
+		PairAddress:   "0x0000000000000000000000000000000000000001",
+		BaseIsToken0:  true,
+		BaseDecimals:  18,
+		QuoteDecimals: 6,
+	})
+	assert.NoError(t, err)
+
+	quote, err := provider.Fetch(context.Background())
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.01, quote.Price, 1e-12)
+	assert.Equal(t, "dex-pool", quote.Source)
+}
@@ -0,0 +1,52 @@
+package pricefeed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ExchangeRateApi reads fiat rates from an open.er-api.com compatible
+// endpoint returning the latest USD based rates.
+type ExchangeRateApi struct {
+	url string
+}
+
+func NewExchangeRateApi(url string) (*ExchangeRateApi, error) {
+	if url == "" {
+		return nil, errors.New("[exchange_rate_api] invalid url")
+	}
+
+	return &ExchangeRateApi{url: url}, nil
+}
+
+func (p *ExchangeRateApi) Name() string {
+	return "exchange-rate-api"
+}
+
+func (p *ExchangeRateApi) FetchRates(ctx context.Context, currencies []string) (map[string]float64, error) {
+	var res struct {
+		Result   string             `json:"result"`
+		BaseCode string             `json:"base_code"`
+		Rates    map[string]float64 `json:"rates"`
+	}
+
+	if err := getJSON(ctx, p.url, &res); err != nil {
+		return nil, err
+	}
+	if res.Result != "" && res.Result != "success" {
+		return nil, fmt.Errorf("exchange rate api returned %s", res.Result)
+	}
+	if res.BaseCode != "" && res.BaseCode != BaseCurrency {
+		return nil, fmt.Errorf("exchange rate api returned %s based rates", res.BaseCode)
+	}
+
+	rates := make(map[string]float64, len(currencies))
+	for _, currency := range currencies {
+		if rate, ok := res.Rates[currency]; ok && rate > 0 {
+			rates[currency] = rate
+		}
+	}
+
+	return rates, nil
+}
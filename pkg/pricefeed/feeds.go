@@ -0,0 +1,106 @@
+package pricefeed
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Feeds tracks the price of many tokens, one aggregator per token symbol.
+// Contracts maps the AirDAO contract of a token to its symbol, the symbol an
+// explorer reports being set by whoever deployed the contract.
+type Feeds struct {
+	feeds     map[string]*Aggregator
+	contracts map[string]string
+}
+
+func NewFeeds(feeds map[string]*Aggregator, contracts map[string]string) (*Feeds, error) {
+	if len(feeds) == 0 {
+		return nil, errors.New("[price_feeds] no price feeds")
+	}
+	for token, feed := range feeds {
+		if token == "" || feed == nil {
+			return nil, errors.New("[price_feeds] invalid price feed")
+		}
+	}
+
+	byContract := make(map[string]string, len(contracts))
+	for contract, token := range contracts {
+		if _, ok := feeds[token]; !ok || contract == "" {
+			return nil, errors.New("[price_feeds] invalid token contract")
+		}
+		byContract[strings.ToLower(contract)] = token
+	}
+
+	return &Feeds{feeds: feeds, contracts: byContract}, nil
+}
+
+// Tokens returns the symbols of every tracked token, sorted.
+func (f *Feeds) Tokens() []string {
+	tokens := make([]string, 0, len(f.feeds))
+	for token := range f.feeds {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+
+	return tokens
+}
+
+func (f *Feeds) Has(token string) bool {
+	_, ok := f.feeds[token]
+	return ok
+}
+
+// TokenOf returns the symbol of the tracked token deployed at contract.
+func (f *Feeds) TokenOf(contract string) (string, bool) {
+	token, ok := f.contracts[strings.ToLower(contract)]
+	return token, ok
+}
+
+// Subscribe registers fn to be called with every new aggregate of any token.
+func (f *Feeds) Subscribe(fn func(token string, aggregate Aggregate)) {
+	for token, feed := range f.feeds {
+		token := token
+		feed.Subscribe(func(aggregate Aggregate) {
+			fn(token, aggregate)
+		})
+	}
+}
+
+// Run polls every feed until ctx is done.
+func (f *Feeds) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, feed := range f.feeds {
+		wg.Add(1)
+		go func(feed *Aggregator) {
+			defer wg.Done()
+			feed.Run(ctx)
+		}(feed)
+	}
+	wg.Wait()
+}
+
+// Refresh fetches every feed once.
+func (f *Feeds) Refresh(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, feed := range f.feeds {
+		wg.Add(1)
+		go func(feed *Aggregator) {
+			defer wg.Done()
+			feed.Refresh(ctx)
+		}(feed)
+	}
+	wg.Wait()
+}
+
+// Latest returns the last aggregate of token and whether the token is tracked.
+func (f *Feeds) Latest(token string) (Aggregate, bool) {
+	feed, ok := f.feeds[token]
+	if !ok {
+		return Aggregate{}, false
+	}
+
+	return feed.Latest(), true
+}
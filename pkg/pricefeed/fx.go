@@ -0,0 +1,182 @@
+package pricefeed
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// BaseCurrency is the currency every quote and aggregate is reported in.
+const BaseCurrency = "USD"
+
+// RateProvider reports how much of each currency one USD buys.
+type RateProvider interface {
+	Name() string
+	FetchRates(ctx context.Context, currencies []string) (map[string]float64, error)
+}
+
+type FxConfig struct {
+	Currencies []string
+	Interval   time.Duration
+	MaxAge     time.Duration
+}
+
+// FxRates keeps the median USD rate of every configured currency across the
+// rate providers. Rates are kept until they are older than MaxAge, so a
+// provider outage doesn't switch users back to USD.
+type FxRates struct {
+	providers  []RateProvider
+	currencies []string
+	cfg        FxConfig
+	logger     *zap.SugaredLogger
+
+	mx        sync.RWMutex
+	rates     map[string]float64
+	updatedAt map[string]time.Time
+}
+
+func NewFxRates(providers []RateProvider, cfg FxConfig, logger *zap.SugaredLogger) (*FxRates, error) {
+	if len(providers) == 0 {
+		return nil, errors.New("[fx_rates] no rate providers")
+	}
+	if cfg.Interval <= 0 || cfg.MaxAge <= 0 {
+		return nil, errors.New("[fx_rates] invalid intervals")
+	}
+	if logger == nil {
+		return nil, errors.New("[fx_rates] invalid logger")
+	}
+
+	currencies := make([]string, 0, len(cfg.Currencies))
+	for _, currency := range cfg.Currencies {
+		currency = strings.ToUpper(strings.TrimSpace(currency))
+		if currency != "" && currency != BaseCurrency {
+			currencies = append(currencies, currency)
+		}
+	}
+
+	return &FxRates{
+		providers:  providers,
+		currencies: currencies,
+		cfg:        cfg,
+		logger:     logger,
+		rates:      make(map[string]float64),
+		updatedAt:  make(map[string]time.Time),
+	}, nil
+}
+
+// Currencies returns every supported currency, USD first.
+func (f *FxRates) Currencies() []string {
+	return append([]string{BaseCurrency}, f.currencies...)
+}
+
+func (f *FxRates) Supports(currency string) bool {
+	for _, v := range f.Currencies() {
+		if v == currency {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *FxRates) Run(ctx context.Context) {
+	ticker := time.NewTicker(f.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f.Refresh(ctx)
+		}
+	}
+}
+
+// Refresh fetches all providers once and updates the rates they report.
+func (f *FxRates) Refresh(ctx context.Context) {
+	if len(f.currencies) == 0 {
+		return
+	}
+
+	var wg sync.WaitGroup
+	results := make([]map[string]float64, len(f.providers))
+	for i, provider := range f.providers {
+		wg.Add(1)
+		go func(i int, provider RateProvider) {
+			defer wg.Done()
+
+			rates, err := provider.FetchRates(ctx, f.currencies)
+			if err != nil {
+				f.logger.Errorf("fx rate provider %s error %v", provider.Name(), err)
+				return
+			}
+			results[i] = rates
+		}(i, provider)
+	}
+	wg.Wait()
+
+	now := time.Now()
+
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	for _, currency := range f.currencies {
+		var values []float64
+		for _, rates := range results {
+			if rate, ok := rates[currency]; ok && rate > 0 {
+				values = append(values, rate)
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		f.rates[currency] = medianOf(values)
+		f.updatedAt[currency] = now
+	}
+}
+
+// Rate returns how much of currency one USD buys and whether the rate is
+// recent enough to use.
+func (f *FxRates) Rate(currency string) (float64, bool) {
+	if currency == "" || currency == BaseCurrency {
+		return 1, true
+	}
+
+	f.mx.RLock()
+	defer f.mx.RUnlock()
+
+	rate, ok := f.rates[currency]
+	if !ok || time.Since(f.updatedAt[currency]) > f.cfg.MaxAge {
+		return 0, false
+	}
+
+	return rate, true
+}
+
+// Convert converts a USD amount to currency.
+func (f *FxRates) Convert(usd float64, currency string) (float64, bool) {
+	rate, ok := f.Rate(currency)
+	if !ok {
+		return 0, false
+	}
+
+	return usd * rate, true
+}
+
+func medianOf(values []float64) float64 {
+	sort.Float64s(values)
+
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+
+	return (values[n/2-1] + values[n/2]) / 2
+}
@@ -0,0 +1,92 @@
+package pricefeed_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"airdao-mobile-api/pkg/pricefeed"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type stubRateProvider struct {
+	name  string
+	rates map[string]float64
+	err   error
+}
+
+func (p *stubRateProvider) Name() string {
+	return p.name
+}
+
+func (p *stubRateProvider) FetchRates(ctx context.Context, currencies []string) (map[string]float64, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	return p.rates, nil
+}
+
+func TestFxRates(t *testing.T) {
+	cfg := pricefeed.FxConfig{Currencies: []string{"eur", "GBP", "USD"}, Interval: time.Minute, MaxAge: time.Hour}
+
+	tests := []struct {
+		name      string
+		providers []pricefeed.RateProvider
+		expect    func(t *testing.T, fx *pricefeed.FxRates)
+	}{
+		{
+			name: "should take the median rate of the providers",
+			providers: []pricefeed.RateProvider{
+				&stubRateProvider{name: "a", rates: map[string]float64{"EUR": 0.90, "GBP": 0.80}},
+				&stubRateProvider{name: "b", rates: map[string]float64{"EUR": 0.92}},
+				&stubRateProvider{name: "c", rates: map[string]float64{"EUR": 0.91, "GBP": 0.78}},
+			},
+			expect: func(t *testing.T, fx *pricefeed.FxRates) {
+				rate, ok := fx.Rate("EUR")
+				assert.True(t, ok)
+				assert.Equal(t, 0.91, rate)
+
+				rate, ok = fx.Rate("GBP")
+				assert.True(t, ok)
+				assert.InDelta(t, 0.79, rate, 1e-9)
+
+				value, ok := fx.Convert(10, "EUR")
+				assert.True(t, ok)
+				assert.InDelta(t, 9.1, value, 1e-9)
+			},
+		},
+		{
+			name: "should always support usd",
+			providers: []pricefeed.RateProvider{
+				&stubRateProvider{name: "a", err: errors.New("down")},
+			},
+			expect: func(t *testing.T, fx *pricefeed.FxRates) {
+				rate, ok := fx.Rate("USD")
+				assert.True(t, ok)
+				assert.Equal(t, 1.0, rate)
+
+				_, ok = fx.Rate("EUR")
+				assert.False(t, ok)
+
+				assert.Equal(t, []string{"USD", "EUR", "GBP"}, fx.Currencies())
+				assert.True(t, fx.Supports("GBP"))
+				assert.False(t, fx.Supports("JPY"))
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fx, err := pricefeed.NewFxRates(tc.providers, cfg, zap.NewNop().Sugar())
+			assert.NoError(t, err)
+
+			fx.Refresh(context.Background())
+
+			tc.expect(t, fx)
+		})
+	}
+}
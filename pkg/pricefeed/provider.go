@@ -0,0 +1,53 @@
+package pricefeed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Quote is a USD price reported by one source. Volume24h and MarketCap are
+// zero when the source doesn't report them.
+type Quote struct {
+	Source    string
+	Price     float64
+	Volume24h float64
+	MarketCap float64
+	Timestamp time.Time
+}
+
+//go:generate mockgen -source=provider.go -destination=mocks/provider_mock.go
+type Provider interface {
+	Name() string
+	Fetch(ctx context.Context) (*Quote, error)
+}
+
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+func getJSON(ctx context.Context, url string, res interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return json.Unmarshal(body, res)
+}
@@ -0,0 +1,42 @@
+package pricefeed
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type tokenPriceResponse struct {
+	Data struct {
+		PriceUSD float64 `json:"price_usd"`
+	} `json:"data"`
+}
+
+// TokenPriceProvider reads the AirDAO token price API.
+type TokenPriceProvider struct {
+	url string
+}
+
+func NewTokenPriceProvider(url string) (*TokenPriceProvider, error) {
+	if url == "" {
+		return nil, errors.New("[token_price_provider] invalid url")
+	}
+
+	return &TokenPriceProvider{url: url}, nil
+}
+
+func (p *TokenPriceProvider) Name() string {
+	return "token-price-api"
+}
+
+func (p *TokenPriceProvider) Fetch(ctx context.Context) (*Quote, error) {
+	var res tokenPriceResponse
+	if err := getJSON(ctx, p.url, &res); err != nil {
+		return nil, err
+	}
+	if res.Data.PriceUSD <= 0 {
+		return nil, errors.New("token price api returned no price")
+	}
+
+	return &Quote{Source: p.Name(), Price: res.Data.PriceUSD, Timestamp: time.Now()}, nil
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidLimit = errors.New("invalid rate limit, expected <requests>/<s|m|h>")
+
+// Limit is a token bucket holding Requests tokens, refilled evenly over
+// Period. The zero Limit doesn't limit anything.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseLimit parses limits like "30/m". An empty string or "off" disables
+// the limit.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "off" {
+		return Limit{}, nil
+	}
+
+	count, unit, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, ErrInvalidLimit
+	}
+
+	requests, err := strconv.Atoi(count)
+	if err != nil || requests <= 0 {
+		return Limit{}, ErrInvalidLimit
+	}
+
+	var period time.Duration
+	switch unit {
+	case "s":
+		period = time.Second
+	case "m":
+		period = time.Minute
+	case "h":
+		period = time.Hour
+	default:
+		return Limit{}, ErrInvalidLimit
+	}
+
+	return Limit{Requests: requests, Period: period}, nil
+}
+
+func (l Limit) Unlimited() bool {
+	return l.Requests <= 0 || l.Period <= 0
+}
+
+// rate is the refill rate in tokens per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// refill returns the tokens of a bucket last updated at updatedAt.
+func (l Limit) refill(tokens float64, updatedAt, now time.Time) float64 {
+	elapsed := now.Sub(updatedAt).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	return math.Min(float64(l.Requests), tokens+elapsed*l.rate())
+}
+
+// result takes a token from a bucket holding tokens, if there is one.
+func (l Limit) result(tokens float64) (Result, float64) {
+	if tokens >= 1 {
+		tokens--
+		return Result{Allowed: true, Remaining: int(tokens)}, tokens
+	}
+
+	wait := (1 - tokens) / l.rate()
+	return Result{RetryAfter: time.Duration(wait * float64(time.Second))}, tokens
+}
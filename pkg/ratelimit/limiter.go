@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"airdao-mobile-api/pkg/apierror"
+	"airdao-mobile-api/pkg/metrics"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+const (
+	HeaderLimit     = "X-RateLimit-Limit"
+	HeaderRemaining = "X-RateLimit-Remaining"
+)
+
+var ErrRateLimited = apierror.New(fiber.StatusTooManyRequests, "rate_limited", "too many requests")
+
+// KeyFunc returns the identity a request is limited by, like the device or
+// the client IP.
+type KeyFunc func(c *fiber.Ctx) string
+
+// Limiter applies the limit of a route group to each identity separately.
+type Limiter struct {
+	store    Store
+	limits   map[string]Limit
+	registry *metrics.Registry
+	logger   *zap.SugaredLogger
+	now      func() time.Time
+}
+
+// NewLimiter creates a limiter for limits by route group. Groups without a
+// limit are not limited.
+func NewLimiter(store Store, limits map[string]Limit, registry *metrics.Registry, logger *zap.SugaredLogger) (*Limiter, error) {
+	if store == nil {
+		return nil, errors.New("[ratelimit] invalid store")
+	}
+	if registry == nil {
+		return nil, errors.New("[ratelimit] invalid metrics registry")
+	}
+	if logger == nil {
+		return nil, errors.New("[ratelimit] invalid logger")
+	}
+
+	return &Limiter{store: store, limits: limits, registry: registry, logger: logger, now: time.Now}, nil
+}
+
+// Middleware limits the requests of group. Store failures let the request
+// through, a broken limiter must not take the API down with it.
+func (l *Limiter) Middleware(group string, key KeyFunc) fiber.Handler {
+	limit := l.limits[group]
+	if limit.Unlimited() {
+		return func(c *fiber.Ctx) error { return c.Next() }
+	}
+
+	allowed := l.registry.Counter("rate_limit_" + group + "_allowed_total")
+	limited := l.registry.Counter("rate_limit_" + group + "_limited_total")
+	failed := l.registry.Counter("rate_limit_errors_total")
+
+	return func(c *fiber.Ctx) error {
+		result, err := l.store.Take(c.Context(), group+":"+key(c), limit, l.now())
+		if err != nil {
+			l.logger.Errorf("Middleware take error %v\n", err)
+			failed.Inc()
+			return c.Next()
+		}
+
+		c.Set(HeaderLimit, strconv.Itoa(limit.Requests))
+		c.Set(HeaderRemaining, strconv.Itoa(result.Remaining))
+
+		if !result.Allowed {
+			limited.Inc()
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+			return ErrRateLimited
+		}
+
+		allowed.Inc()
+		return c.Next()
+	}
+}
+
+// IPKey limits by client IP.
+func IPKey(c *fiber.Ctx) string {
+	return "ip:" + c.IP()
+}
@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type mongoBucket struct {
+	Tokens    float64   `bson:"tokens"`
+	Allowed   bool      `bson:"allowed"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// MongoStore shares the buckets between instances. Every take is a single
+// atomic update, so concurrent requests can't spend the same token.
+type MongoStore struct {
+	db               *mongo.Client
+	dbName           string
+	dbCollectionName string
+	logger           *zap.SugaredLogger
+}
+
+func NewMongoStore(db *mongo.Client, dbName string, logger *zap.SugaredLogger) (*MongoStore, error) {
+	if db == nil {
+		return nil, errors.New("[ratelimit] invalid user database")
+	}
+	if dbName == "" {
+		return nil, errors.New("[ratelimit] invalid database name")
+	}
+	if logger == nil {
+		return nil, errors.New("[ratelimit] invalid logger")
+	}
+
+	return &MongoStore{db: db, dbName: dbName, dbCollectionName: "rate_limits", logger: logger}, nil
+}
+
+// EnsureIndexes expires the buckets once they refilled completely.
+func (s *MongoStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.db.Database(s.dbName).Collection(s.dbCollectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		s.logger.Errorf("EnsureIndexes error %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *MongoStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	now = now.Truncate(time.Millisecond)
+	burst := float64(limit.Requests)
+
+	// The same refill and take as the memory store, evaluated by the server
+	refilled := bson.M{"$min": bson.A{burst, bson.M{"$add": bson.A{
+		bson.M{"$ifNull": bson.A{"$tokens", burst}},
+		bson.M{"$multiply": bson.A{
+			bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{now, bson.M{"$ifNull": bson.A{"$updated_at", now}}}}, 1000}},
+			limit.rate(),
+		}},
+	}}}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"tokens": refilled, "updated_at": now, "expires_at": now.Add(limit.Period)}}},
+		{{Key: "$set", Value: bson.M{"allowed": bson.M{"$gte": bson.A{"$tokens", 1}}}}},
+		{{Key: "$set", Value: bson.M{"tokens": bson.M{"$cond": bson.A{"$allowed", bson.M{"$subtract": bson.A{"$tokens", 1}}, "$tokens"}}}}},
+	}
+
+	var b mongoBucket
+	err := s.db.Database(s.dbName).Collection(s.dbCollectionName).FindOneAndUpdate(ctx,
+		bson.M{"_id": key},
+		pipeline,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&b)
+	if err != nil {
+		s.logger.Errorf("Take error %v\n", err)
+		return Result{}, err
+	}
+
+	if b.Allowed {
+		return Result{Allowed: true, Remaining: int(b.Tokens)}, nil
+	}
+
+	result, _ := limit.result(b.Tokens)
+	return result, nil
+}
@@ -0,0 +1,144 @@
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"airdao-mobile-api/pkg/apierror"
+	"airdao-mobile-api/pkg/metrics"
+	"airdao-mobile-api/pkg/ratelimit"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    ratelimit.Limit
+		wantErr error
+	}{
+		{name: "should parse per minute limit", value: "30/m", want: ratelimit.Limit{Requests: 30, Period: time.Minute}},
+		{name: "should parse per second limit", value: " 5/s ", want: ratelimit.Limit{Requests: 5, Period: time.Second}},
+		{name: "should disable empty limit", value: ""},
+		{name: "should disable off limit", value: "off"},
+		{name: "should reject missing period", value: "30", wantErr: ratelimit.ErrInvalidLimit},
+		{name: "should reject unknown period", value: "30/d", wantErr: ratelimit.ErrInvalidLimit},
+		{name: "should reject zero requests", value: "0/m", wantErr: ratelimit.ErrInvalidLimit},
+	}
+
+	for _, test := range tests {
+		got, err := ratelimit.ParseLimit(test.value)
+		assert.Equal(t, test.wantErr, err, test.name)
+		assert.Equal(t, test.want, got, test.name)
+	}
+}
+
+func TestMemoryStoreTake(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	limit := ratelimit.Limit{Requests: 2, Period: time.Minute}
+	now := time.Now()
+
+	result, err := store.Take(context.Background(), "a", limit, now)
+	assert.NoError(t, err)
+	assert.Equal(t, ratelimit.Result{Allowed: true, Remaining: 1}, result)
+
+	result, _ = store.Take(context.Background(), "a", limit, now)
+	assert.Equal(t, ratelimit.Result{Allowed: true, Remaining: 0}, result)
+
+	// Empty, the next token comes in half the period
+	result, _ = store.Take(context.Background(), "a", limit, now)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 30*time.Second, result.RetryAfter)
+
+	// Other keys have their own bucket
+	result, _ = store.Take(context.Background(), "b", limit, now)
+	assert.True(t, result.Allowed)
+
+	result, _ = store.Take(context.Background(), "a", limit, now.Add(30*time.Second))
+	assert.True(t, result.Allowed)
+
+	// Buckets that refilled are dropped
+	store.Take(context.Background(), "c", limit, now.Add(time.Hour))
+	assert.Equal(t, 1, store.Len())
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, ratelimit.Limit, time.Time) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("store down")
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name         string
+		store        ratelimit.Store
+		limits       map[string]ratelimit.Limit
+		requests     int
+		wantStatus   int
+		wantRetry    string
+		wantLimited  int64
+		wantFailures int64
+	}{
+		{
+			name:       "should allow requests within the limit",
+			store:      ratelimit.NewMemoryStore(),
+			limits:     map[string]ratelimit.Limit{"write": {Requests: 2, Period: time.Hour}},
+			requests:   2,
+			wantStatus: fiber.StatusOK,
+		},
+		{
+			name:        "should reject requests over the limit",
+			store:       ratelimit.NewMemoryStore(),
+			limits:      map[string]ratelimit.Limit{"write": {Requests: 2, Period: time.Hour}},
+			requests:    3,
+			wantStatus:  fiber.StatusTooManyRequests,
+			wantRetry:   "1800",
+			wantLimited: 1,
+		},
+		{
+			name:       "should not limit groups without a limit",
+			store:      ratelimit.NewMemoryStore(),
+			requests:   3,
+			wantStatus: fiber.StatusOK,
+		},
+		{
+			name:         "should let requests through when the store fails",
+			store:        failingStore{},
+			limits:       map[string]ratelimit.Limit{"write": {Requests: 1, Period: time.Hour}},
+			requests:     2,
+			wantStatus:   fiber.StatusOK,
+			wantFailures: 2,
+		},
+	}
+
+	for _, test := range tests {
+		registry := metrics.NewRegistry()
+
+		limiter, err := ratelimit.NewLimiter(test.store, test.limits, registry, zap.NewNop().Sugar())
+		assert.NoError(t, err)
+
+		app := fiber.New(fiber.Config{ErrorHandler: apierror.Handler(zap.NewNop().Sugar())})
+		app.Post("/watcher", limiter.Middleware("write", ratelimit.IPKey), func(c *fiber.Ctx) error {
+			return c.SendStatus(fiber.StatusOK)
+		})
+
+		var status int
+		var retry string
+		for i := 0; i < test.requests; i++ {
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, "/watcher", nil))
+			assert.NoError(t, err)
+			status, retry = resp.StatusCode, resp.Header.Get(fiber.HeaderRetryAfter)
+		}
+
+		assert.Equal(t, test.wantStatus, status, test.name)
+		assert.Equal(t, test.wantRetry, retry, test.name)
+		assert.Equal(t, test.wantLimited, registry.Counter("rate_limit_write_limited_total").Value(), test.name)
+		assert.Equal(t, test.wantFailures, registry.Counter("rate_limit_errors_total").Value(), test.name)
+	}
+}
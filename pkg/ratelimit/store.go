@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Result is the outcome of taking a token. RetryAfter is how long until the
+// next token when the request was not allowed.
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+//go:generate mockgen -source=store.go -destination=mocks/store_mock.go
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	period    time.Duration
+}
+
+// MemoryStore keeps the buckets of one instance in memory.
+type MemoryStore struct {
+	mx        sync.Mutex
+	buckets   map[string]*bucket
+	lastPurge time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.purge(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Requests), updatedAt: now}
+		s.buckets[key] = b
+	}
+
+	result, tokens := limit.result(limit.refill(b.tokens, b.updatedAt, now))
+	b.tokens, b.updatedAt, b.period = tokens, now, limit.Period
+
+	return result, nil
+}
+
+// purge drops the buckets that refilled completely, they are the same as
+// missing ones.
+func (s *MemoryStore) purge(now time.Time) {
+	if now.Sub(s.lastPurge) < time.Minute {
+		return
+	}
+	s.lastPurge = now
+
+	for key, b := range s.buckets {
+		if now.Sub(b.updatedAt) > b.period {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// Len returns the number of buckets held.
+func (s *MemoryStore) Len() int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return len(s.buckets)
+}
@@ -0,0 +1,105 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// AuditEntry is one request to the admin API, refused ones included.
+// Action is the method and matched route, Path the requested one. Body is
+// the request body of the authenticated actions changing something.
+type AuditEntry struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Actor     string             `json:"actor" bson:"actor"`
+	Action    string             `json:"action" bson:"action"`
+	Path      string             `json:"path" bson:"path"`
+	Params    map[string]string  `json:"params" bson:"params"`
+	Query     map[string]string  `json:"query" bson:"query"`
+	Body      string             `json:"body,omitempty" bson:"body,omitempty"`
+	IP        string             `json:"ip" bson:"ip"`
+	Status    int                `json:"status" bson:"status"`
+	Error     string             `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+//go:generate mockgen -source=audit.go -destination=mocks/audit_mock.go
+type AuditRepository interface {
+	EnsureIndexes(ctx context.Context, ttl time.Duration) error
+	AddEntry(ctx context.Context, entry *AuditEntry) error
+	// GetEntries returns a page of entries, newest first
+	GetEntries(ctx context.Context, page, limit int) ([]*AuditEntry, error)
+}
+
+type auditRepository struct {
+	db               *mongo.Client
+	dbName           string
+	dbCollectionName string
+	logger           *zap.SugaredLogger
+}
+
+func NewAuditRepository(db *mongo.Client, dbName string, logger *zap.SugaredLogger) (AuditRepository, error) {
+	if db == nil {
+		return nil, errors.New("[admin_audit_repository] invalid user database")
+	}
+	if dbName == "" {
+		return nil, errors.New("[admin_audit_repository] invalid database name")
+	}
+	if logger == nil {
+		return nil, errors.New("[admin_audit_repository] invalid logger")
+	}
+
+	return &auditRepository{db: db, dbName: dbName, dbCollectionName: "admin_audit", logger: logger}, nil
+}
+
+// EnsureIndexes creates the TTL index expiring entries, which also serves
+// the newest first listing.
+func (r *auditRepository) EnsureIndexes(ctx context.Context, ttl time.Duration) error {
+	_, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(ttl.Seconds())),
+	})
+	if err != nil {
+		r.logger.Errorf("EnsureIndexes error %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *auditRepository) AddEntry(ctx context.Context, entry *AuditEntry) error {
+	if _, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).InsertOne(ctx, entry); err != nil {
+		r.logger.Errorf("failed to insert audit entry: %s", err)
+		return errors.New("failed to add audit entry")
+	}
+
+	return nil
+}
+
+func (r *auditRepository) GetEntries(ctx context.Context, page, limit int) ([]*AuditEntry, error) {
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+
+	cur, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).Find(ctx, bson.M{}, findOptions)
+	if err != nil {
+		r.logger.Errorf("unable to find audit entries due to internal error: %v", err)
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	entries := make([]*AuditEntry, 0)
+	if err := cur.All(ctx, &entries); err != nil {
+		r.logger.Errorf("unable to decode audit entries: %v", err)
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,369 @@
+package admin
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"airdao-mobile-api/pkg/apierror"
+	"airdao-mobile-api/pkg/ratelimit"
+	"airdao-mobile-api/services/broadcast"
+	"airdao-mobile-api/services/watcher"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const (
+	HeaderAdminKey = "X-Admin-Key"
+
+	// actorLocal carries who made the request, for the audit entry
+	actorLocal = "admin_actor"
+
+	statsDefaultDays = 30
+	statsMaxDays     = 365
+
+	auditDefaultLimit = 50
+	auditMaxLimit     = 500
+
+	broadcastsDefaultLimit = 20
+	broadcastsMaxLimit     = 100
+
+	// auditMaxBody bounds the request body kept in an audit entry
+	auditMaxBody = 4096
+)
+
+// RateLimitAdmin covers the admin API, per IP
+const RateLimitAdmin = "admin"
+
+var (
+	ErrInvalidAdminKey = apierror.New(fiber.StatusUnauthorized, "invalid_admin_key", "invalid admin key")
+	ErrInvalidParams   = watcher.ErrInvalidParams
+)
+
+// Handler serves the admin API used by ops. Every request is authenticated
+// with an admin key, distinct from any device credential, and audited.
+type Handler struct {
+	watcherService   watcher.Service
+	broadcastService broadcast.Service
+	auditRepository  AuditRepository
+	limit            fiber.Handler
+	keys             [][]byte
+	logger           *zap.SugaredLogger
+}
+
+// NewHandler takes the accepted admin keys; several keys let one be rotated
+// without downtime.
+func NewHandler(watcherService watcher.Service, broadcastService broadcast.Service, auditRepository AuditRepository, limiter *ratelimit.Limiter, keys []string, logger *zap.SugaredLogger) (*Handler, error) {
+	if watcherService == nil {
+		return nil, errors.New("[admin_handler] invalid watcher service")
+	}
+	if broadcastService == nil {
+		return nil, errors.New("[admin_handler] invalid broadcast service")
+	}
+	if auditRepository == nil {
+		return nil, errors.New("[admin_handler] invalid audit repository")
+	}
+	if limiter == nil {
+		return nil, errors.New("[admin_handler] invalid rate limiter")
+	}
+	if logger == nil {
+		return nil, errors.New("[admin_handler] invalid logger")
+	}
+
+	h := &Handler{
+		watcherService:   watcherService,
+		broadcastService: broadcastService,
+		auditRepository:  auditRepository,
+		limit:            limiter.Middleware(RateLimitAdmin, ratelimit.IPKey),
+		logger:           logger,
+	}
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key != "" {
+			h.keys = append(h.keys, []byte(key))
+		}
+	}
+	if len(h.keys) == 0 {
+		return nil, errors.New("[admin_handler] invalid admin keys")
+	}
+
+	return h, nil
+}
+
+func (h *Handler) SetupRoutes(router fiber.Router) {
+	// Requests over the limit are refused before they are audited, so they
+	// can't flood the audit
+	router.Use(h.limit, h.audit, h.authenticate)
+
+	router.Get("/watchers", h.SearchWatchersHandler)
+	router.Get("/watchers/:id", h.GetWatcherHandler)
+	router.Get("/watchers/:id/state", h.GetWatcherStateHandler)
+	router.Post("/watchers/:id/disable", h.DisableWatcherHandler)
+	router.Post("/watchers/:id/enable", h.EnableWatcherHandler)
+	router.Post("/watchers/:id/notifications/:index/resend", h.ResendNotificationHandler)
+
+	router.Post("/addresses/:address/resubscribe", h.ResubscribeAddressHandler)
+	router.Post("/addresses/:address/backfill", h.BackfillAddressHandler)
+
+	router.Post("/broadcasts", h.CreateBroadcastHandler)
+	router.Get("/broadcasts", h.GetBroadcastsHandler)
+	router.Get("/broadcasts/:id", h.GetBroadcastHandler)
+	router.Post("/broadcasts/:id/cancel", h.CancelBroadcastHandler)
+
+	router.Get("/stats", h.StatsHandler)
+	router.Get("/audit", h.AuditHandler)
+}
+
+// audit records every request, including the ones refused by authenticate,
+// which is why it runs first. Errors are rendered here to know the status
+// the client got.
+func (h *Handler) audit(c *fiber.Ctx) error {
+	entry := &AuditEntry{
+		ID:        primitive.NewObjectID(),
+		Actor:     "unauthenticated",
+		Path:      c.Path(),
+		Query:     c.Queries(),
+		IP:        c.IP(),
+		CreatedAt: time.Now(),
+	}
+
+	err := c.Next()
+	if err != nil {
+		entry.Error = err.Error()
+		if err = c.App().Config().ErrorHandler(c, err); err != nil {
+			c.Status(fiber.StatusInternalServerError)
+		}
+	}
+
+	if actor, ok := c.Locals(actorLocal).(string); ok {
+		entry.Actor = actor
+		if c.Method() != fiber.MethodGet {
+			entry.Body = auditBody(c.Body())
+		}
+	}
+	entry.Action = c.Method() + " " + c.Route().Path
+	entry.Params = c.AllParams()
+	entry.Status = c.Response().StatusCode()
+
+	if err := h.auditRepository.AddEntry(c.Context(), entry); err != nil {
+		h.logger.Errorf("audit auditRepository.AddEntry error %v\n", err)
+	}
+
+	return err
+}
+
+// auditBody returns body as kept in an audit entry, cut at auditMaxBody.
+func auditBody(body []byte) string {
+	if len(body) > auditMaxBody {
+		return string(body[:auditMaxBody]) + "..."
+	}
+
+	return string(body)
+}
+
+// authenticate accepts any of the admin keys, compared in constant time.
+// The actor is a fingerprint of the key so the audit never holds a key.
+func (h *Handler) authenticate(c *fiber.Ctx) error {
+	key := []byte(c.Get(HeaderAdminKey))
+
+	valid := false
+	for _, adminKey := range h.keys {
+		if subtle.ConstantTimeCompare(key, adminKey) == 1 {
+			valid = true
+		}
+	}
+	if !valid {
+		return ErrInvalidAdminKey
+	}
+
+	hash := sha256.Sum256(key)
+	c.Locals(actorLocal, "key:"+hex.EncodeToString(hash[:])[:12])
+
+	return c.Next()
+}
+
+func (h *Handler) SearchWatchersHandler(c *fiber.Ctx) error {
+	watchers, err := h.watcherService.SearchWatchers(c.Context(), watcher.WatcherQuery{
+		DeviceId:  c.Query("device_id"),
+		Address:   c.Query("address"),
+		PushToken: c.Query("token"),
+	}, c.QueryInt("page", 1))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(WatchersResponse{Watchers: watchers})
+}
+
+func (h *Handler) GetWatcherHandler(c *fiber.Ctx) error {
+	item, err := h.watcherService.GetWatcherById(c.Context(), c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(item)
+}
+
+func (h *Handler) GetWatcherStateHandler(c *fiber.Ctx) error {
+	state, err := h.watcherService.GetWatcherState(c.Context(), c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(state)
+}
+
+func (h *Handler) DisableWatcherHandler(c *fiber.Ctx) error {
+	item, err := h.watcherService.SetWatcherDisabled(c.Context(), c.Params("id"), true)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(item)
+}
+
+func (h *Handler) EnableWatcherHandler(c *fiber.Ctx) error {
+	item, err := h.watcherService.SetWatcherDisabled(c.Context(), c.Params("id"), false)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(item)
+}
+
+// ResendNotificationHandler resends the notification at :index of the
+// watcher historical_notifications.
+func (h *Handler) ResendNotificationHandler(c *fiber.Ctx) error {
+	index, err := strconv.Atoi(c.Params("index"))
+	if err != nil {
+		return ErrInvalidParams
+	}
+
+	notification, err := h.watcherService.ResendNotification(c.Context(), c.Params("id"), index)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(notification)
+}
+
+func (h *Handler) ResubscribeAddressHandler(c *fiber.Ctx) error {
+	if err := h.watcherService.ResubscribeAddress(c.Context(), c.Params("address")); err != nil {
+		return err
+	}
+
+	return c.JSON(StatusResponse{Status: "OK"})
+}
+
+func (h *Handler) BackfillAddressHandler(c *fiber.Ctx) error {
+	if err := h.watcherService.BackfillAddress(c.Context(), c.Params("address")); err != nil {
+		return err
+	}
+
+	return c.JSON(StatusResponse{Status: "OK"})
+}
+
+// CreateBroadcastHandler starts sending a broadcast and answers right away,
+// the progress is read from GetBroadcastHandler.
+func (h *Handler) CreateBroadcastHandler(c *fiber.Ctx) error {
+	var reqBody broadcast.CreateBroadcast
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return apierror.InvalidBody(err)
+	}
+
+	if err := watcher.Validate(reqBody); err != nil {
+		return err
+	}
+
+	actor, _ := c.Locals(actorLocal).(string)
+	created, err := h.broadcastService.CreateBroadcast(c.Context(), reqBody, actor)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(created)
+}
+
+func (h *Handler) GetBroadcastsHandler(c *fiber.Ctx) error {
+	page := c.QueryInt("page", 1)
+	limit := c.QueryInt("limit", broadcastsDefaultLimit)
+	if page < 1 || limit < 1 || limit > broadcastsMaxLimit {
+		return ErrInvalidParams
+	}
+
+	broadcasts, err := h.broadcastService.GetBroadcasts(c.Context(), page, limit)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(BroadcastsResponse{Broadcasts: broadcasts})
+}
+
+func (h *Handler) GetBroadcastHandler(c *fiber.Ctx) error {
+	item, err := h.broadcastService.GetBroadcast(c.Context(), c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(item)
+}
+
+func (h *Handler) CancelBroadcastHandler(c *fiber.Ctx) error {
+	item, err := h.broadcastService.CancelBroadcast(c.Context(), c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(item)
+}
+
+func (h *Handler) StatsHandler(c *fiber.Ctx) error {
+	days := c.QueryInt("days", statsDefaultDays)
+	if days < 1 || days > statsMaxDays {
+		return ErrInvalidParams
+	}
+
+	stats, err := h.watcherService.GetStats(c.Context(), days)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(stats)
+}
+
+func (h *Handler) AuditHandler(c *fiber.Ctx) error {
+	page := c.QueryInt("page", 1)
+	limit := c.QueryInt("limit", auditDefaultLimit)
+	if page < 1 || limit < 1 || limit > auditMaxLimit {
+		return ErrInvalidParams
+	}
+
+	entries, err := h.auditRepository.GetEntries(c.Context(), page, limit)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(AuditResponse{Entries: entries})
+}
+
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+type WatchersResponse struct {
+	Watchers []*watcher.Watcher `json:"watchers"`
+}
+
+type BroadcastsResponse struct {
+	Broadcasts []*broadcast.Broadcast `json:"broadcasts"`
+}
+
+type AuditResponse struct {
+	Entries []*AuditEntry `json:"entries"`
+}
@@ -0,0 +1,149 @@
+package admin_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"airdao-mobile-api/pkg/apierror"
+	"airdao-mobile-api/pkg/metrics"
+	"airdao-mobile-api/pkg/ratelimit"
+	"airdao-mobile-api/services/admin"
+	"airdao-mobile-api/services/broadcast"
+	"airdao-mobile-api/services/watcher"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type stubService struct{ watcher.Service }
+
+type stubBroadcastService struct{ broadcast.Service }
+
+func (stubService) GetStats(ctx context.Context, days int) (*watcher.Stats, error) {
+	return &watcher.Stats{}, nil
+}
+
+func (stubService) SetWatcherDisabled(ctx context.Context, id string, disabled bool) (*watcher.Watcher, error) {
+	return &watcher.Watcher{Disabled: disabled}, nil
+}
+
+func newLimiter(t *testing.T, limit string) *ratelimit.Limiter {
+	limits := map[string]ratelimit.Limit{}
+	if limit != "" {
+		parsed, err := ratelimit.ParseLimit(limit)
+		require.NoError(t, err)
+		limits[admin.RateLimitAdmin] = parsed
+	}
+
+	limiter, err := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), limits, metrics.NewRegistry(), zap.NewNop().Sugar())
+	require.NoError(t, err)
+
+	return limiter
+}
+
+type auditRepository struct {
+	entries []*admin.AuditEntry
+}
+
+func (r *auditRepository) EnsureIndexes(ctx context.Context, ttl time.Duration) error { return nil }
+
+func (r *auditRepository) AddEntry(ctx context.Context, entry *admin.AuditEntry) error {
+	r.entries = append(r.entries, entry)
+	return nil
+}
+
+func (r *auditRepository) GetEntries(ctx context.Context, page, limit int) ([]*admin.AuditEntry, error) {
+	return r.entries, nil
+}
+
+func TestAdminAuthAndAudit(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		key        string
+		wantStatus int
+		wantActor  bool
+		wantAction string
+	}{
+		{name: "should refuse a request without a key", path: "/admin/stats", wantStatus: fiber.StatusUnauthorized, wantAction: "GET /admin"},
+		{name: "should refuse a wrong key", path: "/admin/stats", key: "wrong", wantStatus: fiber.StatusUnauthorized, wantAction: "GET /admin"},
+		{name: "should accept a rotated key", path: "/admin/stats", key: "old-key", wantStatus: fiber.StatusOK, wantActor: true, wantAction: "GET /admin/stats"},
+		{name: "should refuse invalid params", path: "/admin/stats?days=0", key: "new-key", wantStatus: fiber.StatusBadRequest, wantActor: true, wantAction: "GET /admin/stats"},
+	}
+
+	for _, test := range tests {
+		audit := &auditRepository{}
+		h, err := admin.NewHandler(stubService{}, stubBroadcastService{}, audit, newLimiter(t, ""), []string{"new-key", " old-key "}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		app := fiber.New(fiber.Config{ErrorHandler: apierror.Handler(zap.NewNop().Sugar())})
+		app.Route("/admin", h.SetupRoutes)
+
+		req := httptest.NewRequest(fiber.MethodGet, test.path, nil)
+		if test.key != "" {
+			req.Header.Set(admin.HeaderAdminKey, test.key)
+		}
+
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, test.wantStatus, resp.StatusCode, test.name)
+
+		require.Len(t, audit.entries, 1, test.name)
+		entry := audit.entries[0]
+		assert.Equal(t, test.wantStatus, entry.Status, test.name)
+		assert.Equal(t, test.wantAction, entry.Action, test.name)
+		assert.Equal(t, test.wantActor, entry.Actor != "unauthenticated", test.name)
+		if test.key != "" {
+			assert.NotContains(t, entry.Actor, test.key, test.name)
+		}
+	}
+}
+
+func TestNewHandlerRequiresKey(t *testing.T) {
+	_, err := admin.NewHandler(stubService{}, stubBroadcastService{}, &auditRepository{}, newLimiter(t, ""), []string{" "}, zap.NewNop().Sugar())
+	assert.Error(t, err)
+}
+
+func TestAuditBody(t *testing.T) {
+	audit := &auditRepository{}
+	h, err := admin.NewHandler(stubService{}, stubBroadcastService{}, audit, newLimiter(t, ""), []string{"key"}, zap.NewNop().Sugar())
+	require.NoError(t, err)
+
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.Handler(zap.NewNop().Sugar())})
+	app.Route("/admin", h.SetupRoutes)
+
+	for _, key := range []string{"key", "wrong"} {
+		req := httptest.NewRequest(fiber.MethodPost, "/admin/watchers/1/disable", strings.NewReader(`{"reason":"spam"}`))
+		req.Header.Set(admin.HeaderAdminKey, key)
+		_, err := app.Test(req)
+		require.NoError(t, err)
+	}
+
+	require.Len(t, audit.entries, 2)
+	assert.Equal(t, `{"reason":"spam"}`, audit.entries[0].Body, "should keep the body of an action")
+	assert.Empty(t, audit.entries[1].Body, "should not keep the body of a refused request")
+}
+
+func TestAdminRateLimit(t *testing.T) {
+	audit := &auditRepository{}
+	h, err := admin.NewHandler(stubService{}, stubBroadcastService{}, audit, newLimiter(t, "2/m"), []string{"key"}, zap.NewNop().Sugar())
+	require.NoError(t, err)
+
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.Handler(zap.NewNop().Sugar())})
+	app.Route("/admin", h.SetupRoutes)
+
+	var statuses []int
+	for i := 0; i < 4; i++ {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/admin/stats", nil))
+		require.NoError(t, err)
+		statuses = append(statuses, resp.StatusCode)
+	}
+
+	assert.Equal(t, []int{fiber.StatusUnauthorized, fiber.StatusUnauthorized, fiber.StatusTooManyRequests, fiber.StatusTooManyRequests}, statuses)
+	assert.Len(t, audit.entries, 2, "should not audit the requests over the limit")
+}
@@ -0,0 +1,263 @@
+package broadcast
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"airdao-mobile-api/pkg/apierror"
+	"airdao-mobile-api/services/price"
+	"airdao-mobile-api/services/watcher"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusSending   = "sending"
+	StatusDone      = "done"
+	StatusCancelled = "cancelled"
+	StatusFailed    = "failed"
+
+	DefaultLocale = "en"
+)
+
+var (
+	ErrBroadcastNotFound     = apierror.New(fiber.StatusNotFound, "broadcast_not_found", "broadcast not found")
+	ErrBroadcastFinished     = apierror.New(fiber.StatusConflict, "broadcast_finished", "broadcast already finished")
+	ErrMissingDefaultMessage = apierror.New(fiber.StatusBadRequest, "missing_default_message", "broadcast needs a message in its default locale")
+	ErrTopicWithSegment      = apierror.New(fiber.StatusBadRequest, "topic_with_segment", "topic broadcasts can't target a segment")
+)
+
+// Message is the title and body of a broadcast in one locale.
+type Message struct {
+	Title string `json:"title" bson:"title" validate:"required,max=200"`
+	Body  string `json:"body" bson:"body" validate:"required,max=2000"`
+}
+
+// Segment narrows the watchers a broadcast goes to. Every set field has to
+// match; an empty segment targets every watcher.
+type Segment struct {
+	Platforms []string `json:"platforms" bson:"platforms" validate:"omitempty,dive,oneof=ios android"`
+	// Locales match the app locale or its language, "pt" matches "pt-BR"
+	Locales []string `json:"locales" bson:"locales" validate:"omitempty,dive,min=2,max=35"`
+	// App versions are compared number by number, bounds included
+	MinAppVersion string `json:"min_app_version" bson:"min_app_version" validate:"omitempty,max=32"`
+	MaxAppVersion string `json:"max_app_version" bson:"max_app_version" validate:"omitempty,max=32"`
+	// Addresses selects the watchers of any of the addresses
+	Addresses []string `json:"addresses" bson:"addresses" validate:"omitempty,addresses"`
+	// Tokens selects the watchers with a price alert on any of the tokens
+	Tokens []string `json:"tokens" bson:"tokens" validate:"omitempty,dive,min=1"`
+}
+
+// Progress counts the watchers a broadcast went through. Total is the
+// number matching the segment when sending started, before the app version
+// bounds are applied.
+type Progress struct {
+	Total    int64 `json:"total" bson:"total"`
+	Targeted int64 `json:"targeted" bson:"targeted"`
+	Sent     int64 `json:"sent" bson:"sent"`
+	Failed   int64 `json:"failed" bson:"failed"`
+}
+
+// Broadcast is an announcement to every watcher or a segment of them. A
+// broadcast to an FCM topic is sent as one message in the default locale,
+// to the devices the app subscribed to it.
+type Broadcast struct {
+	ID            primitive.ObjectID  `json:"id" bson:"_id"`
+	Messages      map[string]*Message `json:"messages" bson:"messages"`
+	DefaultLocale string              `json:"default_locale" bson:"default_locale"`
+	Segment       *Segment            `json:"segment" bson:"segment"`
+	Topic         string              `json:"topic,omitempty" bson:"topic,omitempty"`
+
+	Status   string   `json:"status" bson:"status"`
+	Progress Progress `json:"progress" bson:"progress"`
+	// Cursor is the id of the last watcher handled, sending resumes after it
+	Cursor primitive.ObjectID `json:"-" bson:"cursor"`
+	Error  string             `json:"error,omitempty" bson:"error,omitempty"`
+
+	CreatedBy  string     `json:"created_by" bson:"created_by"`
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+	StartedAt  *time.Time `json:"started_at" bson:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at" bson:"finished_at,omitempty"`
+	UpdatedAt  time.Time  `json:"updated_at" bson:"updated_at"`
+}
+
+type CreateBroadcast struct {
+	// Messages are keyed by locale, like en or pt-BR
+	Messages      map[string]*Message `json:"messages" validate:"required,min=1,dive,keys,min=2,max=35,endkeys,required"`
+	DefaultLocale string              `json:"default_locale" validate:"omitempty,min=2,max=35"`
+	Segment       *Segment            `json:"segment" validate:"omitempty"`
+	// Topic is an FCM topic the app subscribes its devices to
+	Topic string `json:"topic" validate:"omitempty,max=900"`
+}
+
+// NewBroadcast creates a pending broadcast from a validated request.
+func NewBroadcast(req CreateBroadcast, createdBy string, now time.Time) (*Broadcast, error) {
+	b := &Broadcast{
+		ID:            primitive.NewObjectID(),
+		Messages:      make(map[string]*Message, len(req.Messages)),
+		DefaultLocale: normalizeLocale(req.DefaultLocale),
+		Segment:       req.Segment,
+		Topic:         req.Topic,
+		Status:        StatusPending,
+		CreatedBy:     createdBy,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if b.DefaultLocale == "" {
+		b.DefaultLocale = DefaultLocale
+	}
+	for locale, message := range req.Messages {
+		b.Messages[normalizeLocale(locale)] = message
+	}
+
+	if _, ok := b.Messages[b.DefaultLocale]; !ok {
+		return nil, ErrMissingDefaultMessage
+	}
+	if b.Topic != "" && !b.Segment.IsEmpty() {
+		return nil, ErrTopicWithSegment
+	}
+
+	return b, nil
+}
+
+// MessageFor picks the message of the locale, else of its language, else
+// the default one.
+func (b *Broadcast) MessageFor(locale string) *Message {
+	locale = normalizeLocale(locale)
+	if message, ok := b.Messages[locale]; ok {
+		return message
+	}
+
+	if language, _, ok := strings.Cut(locale, "-"); ok {
+		if message, ok := b.Messages[language]; ok {
+			return message
+		}
+	}
+
+	return b.Messages[b.DefaultLocale]
+}
+
+// Finished reports whether the broadcast won't send anything anymore.
+func (b *Broadcast) Finished() bool {
+	return b.Status == StatusDone || b.Status == StatusCancelled || b.Status == StatusFailed
+}
+
+func (s *Segment) IsEmpty() bool {
+	return s == nil || (len(s.Platforms) == 0 && len(s.Locales) == 0 && s.MinAppVersion == "" && s.MaxAppVersion == "" &&
+		len(s.Addresses) == 0 && len(s.Tokens) == 0)
+}
+
+// Filters returns the repository filters of the segment. Disabled watchers
+// are always left out. App versions are checked by MatchesApp, Mongo would
+// compare them as strings.
+func (s *Segment) Filters() bson.M {
+	filters := bson.M{"disabled": bson.M{"$ne": true}}
+	if s == nil {
+		return filters
+	}
+
+	if len(s.Platforms) > 0 {
+		filters["app.platform"] = bson.M{"$in": s.Platforms}
+	}
+
+	if len(s.Locales) > 0 {
+		patterns := make([]string, 0, len(s.Locales))
+		for _, locale := range s.Locales {
+			// Apps report both pt_BR and pt-BR
+			patterns = append(patterns, strings.ReplaceAll(regexp.QuoteMeta(normalizeLocale(locale)), "-", "[-_]"))
+		}
+		filters["app.locale"] = primitive.Regex{Pattern: "^(" + strings.Join(patterns, "|") + ")([-_]|$)", Options: "i"}
+	}
+
+	if len(s.Addresses) > 0 {
+		filters["addresses.address"] = bson.M{"$in": s.Addresses}
+	}
+
+	if len(s.Tokens) > 0 {
+		// The AMB alert is kept in the original watcher fields
+		or := bson.A{}
+		tokens := make([]string, 0, len(s.Tokens))
+		for _, token := range s.Tokens {
+			token = strings.ToUpper(token)
+			if token == price.DefaultToken {
+				or = append(or, bson.M{"threshold": bson.M{"$ne": nil}})
+				continue
+			}
+			tokens = append(tokens, token)
+		}
+		if len(tokens) > 0 {
+			or = append(or, bson.M{"token_alerts.token": bson.M{"$in": tokens}})
+		}
+		filters["$or"] = or
+	}
+
+	return filters
+}
+
+// MatchesApp checks the app version bounds of the segment.
+func (s *Segment) MatchesApp(app *watcher.AppInfo) bool {
+	if s == nil || (s.MinAppVersion == "" && s.MaxAppVersion == "") {
+		return true
+	}
+	if app == nil || app.AppVersion == "" {
+		return false
+	}
+
+	if s.MinAppVersion != "" && CompareVersions(app.AppVersion, s.MinAppVersion) < 0 {
+		return false
+	}
+	if s.MaxAppVersion != "" && CompareVersions(app.AppVersion, s.MaxAppVersion) > 0 {
+		return false
+	}
+
+	return true
+}
+
+// CompareVersions compares dotted versions number by number, so 1.10 is
+// above 1.9. Suffixes like -beta are ignored and missing parts count as 0.
+func CompareVersions(a, b string) int {
+	partsA, partsB := versionParts(a), versionParts(b)
+	for i := 0; i < len(partsA) || i < len(partsB); i++ {
+		var x, y int
+		if i < len(partsA) {
+			x = partsA[i]
+		}
+		if i < len(partsB) {
+			y = partsB[i]
+		}
+
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func versionParts(version string) []int {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(version, "-+ "); i >= 0 {
+		version = version[:i]
+	}
+
+	var parts []int
+	for _, part := range strings.Split(version, ".") {
+		n, _ := strconv.Atoi(part)
+		parts = append(parts, n)
+	}
+
+	return parts
+}
+
+// normalizeLocale lowercases the locale and uses - as separator.
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
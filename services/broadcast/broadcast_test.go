@@ -0,0 +1,111 @@
+package broadcast_test
+
+import (
+	"testing"
+	"time"
+
+	"airdao-mobile-api/services/broadcast"
+	"airdao-mobile-api/services/watcher"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b   string
+		expect int
+	}{
+		{a: "1.10.0", b: "1.9.3", expect: 1},
+		{a: "1.2", b: "1.2.0", expect: 0},
+		{a: "v2.0.0-beta", b: "2.0.0", expect: 0},
+		{a: "1.2.3", b: "1.2.4", expect: -1},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expect, broadcast.CompareVersions(test.a, test.b), test.a+" vs "+test.b)
+	}
+}
+
+func TestNewBroadcast(t *testing.T) {
+	messages := map[string]*broadcast.Message{
+		"en":    {Title: "Upgrade", Body: "Network upgrade tonight"},
+		"pt_BR": {Title: "Atualização", Body: "Atualização da rede hoje"},
+		"de":    {Title: "Upgrade", Body: "Netzwerk-Upgrade heute"},
+	}
+
+	tests := []struct {
+		name    string
+		req     broadcast.CreateBroadcast
+		wantErr error
+	}{
+		{name: "should default to english", req: broadcast.CreateBroadcast{Messages: messages}},
+		{name: "should need the default locale message", req: broadcast.CreateBroadcast{Messages: messages, DefaultLocale: "fr"}, wantErr: broadcast.ErrMissingDefaultMessage},
+		{
+			name:    "should refuse a segmented topic broadcast",
+			req:     broadcast.CreateBroadcast{Messages: messages, Topic: "news", Segment: &broadcast.Segment{Platforms: []string{"ios"}}},
+			wantErr: broadcast.ErrTopicWithSegment,
+		},
+	}
+
+	for _, test := range tests {
+		b, err := broadcast.NewBroadcast(test.req, "key:abc", time.Now())
+		assert.Equal(t, test.wantErr, err, test.name)
+		if test.wantErr != nil {
+			continue
+		}
+
+		assert.Equal(t, broadcast.StatusPending, b.Status, test.name)
+		assert.Equal(t, "Atualização", b.MessageFor("pt-BR").Title, test.name)
+		assert.Equal(t, "Netzwerk-Upgrade heute", b.MessageFor("de_AT").Body, test.name)
+		assert.Equal(t, "Upgrade", b.MessageFor("").Title, test.name)
+	}
+}
+
+func TestSegmentMatchesApp(t *testing.T) {
+	segment := &broadcast.Segment{MinAppVersion: "1.4", MaxAppVersion: "2.0"}
+
+	tests := []struct {
+		name   string
+		app    *watcher.AppInfo
+		expect bool
+	}{
+		{name: "should match inside the bounds", app: &watcher.AppInfo{AppVersion: "1.10.2"}, expect: true},
+		{name: "should include the bounds", app: &watcher.AppInfo{AppVersion: "2.0.0"}, expect: true},
+		{name: "should skip older apps", app: &watcher.AppInfo{AppVersion: "1.3.9"}, expect: false},
+		{name: "should skip apps without a version", app: nil, expect: false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expect, segment.MatchesApp(test.app), test.name)
+	}
+
+	var empty *broadcast.Segment
+	assert.True(t, empty.MatchesApp(nil))
+}
+
+func TestSegmentFilters(t *testing.T) {
+	filters := (&broadcast.Segment{Platforms: []string{"android"}, Tokens: []string{"amb", "usdc"}}).Filters()
+
+	assert.Equal(t, bson.M{"$ne": true}, filters["disabled"])
+	assert.Equal(t, bson.M{"$in": []string{"android"}}, filters["app.platform"])
+	assert.Equal(t, bson.A{
+		bson.M{"threshold": bson.M{"$ne": nil}},
+		bson.M{"token_alerts.token": bson.M{"$in": []string{"USDC"}}},
+	}, filters["$or"])
+}
+
+func TestCreateBroadcastValidation(t *testing.T) {
+	valid := broadcast.CreateBroadcast{Messages: map[string]*broadcast.Message{"en": {Title: "t", Body: "b"}}}
+	require.NoError(t, watcher.Validate(valid))
+
+	missingBody := broadcast.CreateBroadcast{Messages: map[string]*broadcast.Message{"en": {Title: "t"}}}
+	assert.Error(t, watcher.Validate(missingBody))
+
+	badPlatform := valid
+	badPlatform.Segment = &broadcast.Segment{Platforms: []string{"web"}}
+	assert.Error(t, watcher.Validate(badPlatform))
+
+	assert.Error(t, watcher.Validate(broadcast.CreateBroadcast{}))
+}
@@ -0,0 +1,201 @@
+package broadcast
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+//go:generate mockgen -source=repository.go -destination=mocks/repository_mock.go
+type Repository interface {
+	CreateBroadcast(ctx context.Context, broadcast *Broadcast) error
+	GetBroadcast(ctx context.Context, id primitive.ObjectID) (*Broadcast, error)
+	GetBroadcasts(ctx context.Context, page, limit int) ([]*Broadcast, error)
+	// GetResumable returns the ids of the broadcasts that are pending, or
+	// sending without progress since staleBefore
+	GetResumable(ctx context.Context, staleBefore time.Time) ([]primitive.ObjectID, error)
+
+	// Claim moves a resumable broadcast to sending and returns it, or nil
+	// when another runner holds it or it is finished
+	Claim(ctx context.Context, id primitive.ObjectID, staleBefore, now time.Time) (*Broadcast, error)
+	// SaveProgress reports false once the broadcast is no longer sending,
+	// like after a cancellation
+	SaveProgress(ctx context.Context, id primitive.ObjectID, progress Progress, cursor primitive.ObjectID, now time.Time) (bool, error)
+	Finish(ctx context.Context, id primitive.ObjectID, status, reason string, now time.Time) error
+	// Cancel reports false when the broadcast was already finished
+	Cancel(ctx context.Context, id primitive.ObjectID, now time.Time) (bool, error)
+}
+
+type repository struct {
+	db               *mongo.Client
+	dbName           string
+	dbCollectionName string
+	logger           *zap.SugaredLogger
+}
+
+func NewRepository(db *mongo.Client, dbName string, logger *zap.SugaredLogger) (Repository, error) {
+	if db == nil {
+		return nil, errors.New("[broadcast_repository] invalid user database")
+	}
+	if dbName == "" {
+		return nil, errors.New("[broadcast_repository] invalid database name")
+	}
+	if logger == nil {
+		return nil, errors.New("[broadcast_repository] invalid logger")
+	}
+
+	return &repository{db: db, dbName: dbName, dbCollectionName: "broadcasts", logger: logger}, nil
+}
+
+func resumable(staleBefore time.Time) bson.A {
+	return bson.A{
+		bson.M{"status": StatusPending},
+		bson.M{"status": StatusSending, "updated_at": bson.M{"$lt": staleBefore}},
+	}
+}
+
+func (r *repository) CreateBroadcast(ctx context.Context, broadcast *Broadcast) error {
+	if _, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).InsertOne(ctx, broadcast); err != nil {
+		r.logger.Errorf("failed to insert broadcast to db: %s", err)
+		return errors.New("failed to create broadcast")
+	}
+
+	return nil
+}
+
+func (r *repository) GetBroadcast(ctx context.Context, id primitive.ObjectID) (*Broadcast, error) {
+	var broadcast Broadcast
+
+	if err := r.db.Database(r.dbName).Collection(r.dbCollectionName).FindOne(ctx, bson.M{"_id": id}).Decode(&broadcast); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+
+		r.logger.Errorf("unable to find broadcast due to internal error: %v", err)
+		return nil, err
+	}
+
+	return &broadcast, nil
+}
+
+func (r *repository) GetBroadcasts(ctx context.Context, page, limit int) ([]*Broadcast, error) {
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+
+	cur, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).Find(ctx, bson.M{}, findOptions)
+	if err != nil {
+		r.logger.Errorf("unable to find broadcasts due to internal error: %v", err)
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	broadcasts := make([]*Broadcast, 0)
+	if err := cur.All(ctx, &broadcasts); err != nil {
+		r.logger.Errorf("unable to decode broadcasts: %v", err)
+		return nil, err
+	}
+
+	return broadcasts, nil
+}
+
+func (r *repository) GetResumable(ctx context.Context, staleBefore time.Time) ([]primitive.ObjectID, error) {
+	findOptions := options.Find().SetProjection(bson.M{"_id": 1}).SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	cur, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).Find(ctx, bson.M{"$or": resumable(staleBefore)}, findOptions)
+	if err != nil {
+		r.logger.Errorf("unable to find resumable broadcasts due to internal error: %v", err)
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var ids []primitive.ObjectID
+	for cur.Next(ctx) {
+		var item struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cur.Decode(&item); err != nil {
+			r.logger.Errorf("unable to decode broadcast id: %v", err)
+			return nil, err
+		}
+		ids = append(ids, item.ID)
+	}
+
+	if err := cur.Err(); err != nil {
+		r.logger.Errorf("cursor iteration error: %v", err)
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func (r *repository) Claim(ctx context.Context, id primitive.ObjectID, staleBefore, now time.Time) (*Broadcast, error) {
+	var broadcast Broadcast
+
+	err := r.db.Database(r.dbName).Collection(r.dbCollectionName).FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "$or": resumable(staleBefore)},
+		bson.M{
+			"$set": bson.M{"status": StatusSending, "updated_at": now},
+			// started_at is only set on the first claim
+			"$min": bson.M{"started_at": now},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&broadcast)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+
+		r.logger.Errorf("failed to claim broadcast: %s", err)
+		return nil, err
+	}
+
+	return &broadcast, nil
+}
+
+func (r *repository) SaveProgress(ctx context.Context, id primitive.ObjectID, progress Progress, cursor primitive.ObjectID, now time.Time) (bool, error) {
+	result, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).UpdateOne(ctx,
+		bson.M{"_id": id, "status": StatusSending},
+		bson.M{"$set": bson.M{"progress": progress, "cursor": cursor, "updated_at": now}})
+	if err != nil {
+		r.logger.Errorf("failed to save broadcast progress: %s", err)
+		return false, err
+	}
+
+	return result.MatchedCount == 1, nil
+}
+
+func (r *repository) Finish(ctx context.Context, id primitive.ObjectID, status, reason string, now time.Time) error {
+	update := bson.M{"status": status, "finished_at": now, "updated_at": now}
+	if reason != "" {
+		update["error"] = reason
+	}
+
+	if _, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).UpdateOne(ctx,
+		bson.M{"_id": id, "status": StatusSending},
+		bson.M{"$set": update}); err != nil {
+		r.logger.Errorf("failed to finish broadcast: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *repository) Cancel(ctx context.Context, id primitive.ObjectID, now time.Time) (bool, error) {
+	result, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).UpdateOne(ctx,
+		bson.M{"_id": id, "status": bson.M{"$in": bson.A{StatusPending, StatusSending}}},
+		bson.M{"$set": bson.M{"status": StatusCancelled, "finished_at": now, "updated_at": now}})
+	if err != nil {
+		r.logger.Errorf("failed to cancel broadcast: %s", err)
+		return false, err
+	}
+
+	return result.MatchedCount == 1, nil
+}
@@ -0,0 +1,352 @@
+package broadcast
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+
+	"airdao-mobile-api/config"
+	cloudmessaging "airdao-mobile-api/pkg/firebase/cloud-messaging"
+	"airdao-mobile-api/pkg/metrics"
+	"airdao-mobile-api/services/watcher"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const (
+	// leaseTimeout is how long a sending broadcast may go without progress
+	// before another runner takes it over, like after a crash
+	leaseTimeout = 5 * time.Minute
+	resumeEvery  = time.Minute
+)
+
+//go:generate mockgen -source=service.go -destination=mocks/service_mock.go
+type Service interface {
+	Init(ctx context.Context) error
+
+	CreateBroadcast(ctx context.Context, req CreateBroadcast, createdBy string) (*Broadcast, error)
+	GetBroadcast(ctx context.Context, id string) (*Broadcast, error)
+	GetBroadcasts(ctx context.Context, page, limit int) ([]*Broadcast, error)
+	CancelBroadcast(ctx context.Context, id string) (*Broadcast, error)
+}
+
+type service struct {
+	repository        Repository
+	watcherRepository watcher.Repository
+	watcherSvc        watcher.Service
+	cloudMessagingSvc cloudmessaging.Service
+	logger            *zap.SugaredLogger
+	cfg               config.Broadcast
+
+	sent   *metrics.Counter
+	failed *metrics.Counter
+
+	// ctx outlives the admin request creating a broadcast
+	ctx     context.Context
+	mx      sync.Mutex
+	running map[primitive.ObjectID]context.CancelFunc
+}
+
+func NewService(
+	repository Repository,
+	watcherRepository watcher.Repository,
+	watcherSvc watcher.Service,
+	cloudMessagingSvc cloudmessaging.Service,
+	registry *metrics.Registry,
+	logger *zap.SugaredLogger,
+	cfg config.Broadcast,
+) (Service, error) {
+	if repository == nil {
+		return nil, errors.New("[broadcast_service] invalid repository")
+	}
+	if watcherRepository == nil {
+		return nil, errors.New("[broadcast_service] invalid watcher repository")
+	}
+	if watcherSvc == nil {
+		return nil, errors.New("[broadcast_service] invalid watcher service")
+	}
+	if cloudMessagingSvc == nil {
+		return nil, errors.New("[broadcast_service] invalid cloud messaging service")
+	}
+	if registry == nil {
+		return nil, errors.New("[broadcast_service] invalid metrics registry")
+	}
+	if logger == nil {
+		return nil, errors.New("[broadcast_service] invalid logger")
+	}
+	if cfg.Rate <= 0 || cfg.BatchSize <= 0 {
+		return nil, errors.New("[broadcast_service] invalid broadcast config")
+	}
+
+	return &service{
+		repository:        repository,
+		watcherRepository: watcherRepository,
+		watcherSvc:        watcherSvc,
+		cloudMessagingSvc: cloudMessagingSvc,
+		logger:            logger,
+		cfg:               cfg,
+
+		sent:   registry.Counter("broadcast_messages_sent_total"),
+		failed: registry.Counter("broadcast_messages_failed_total"),
+
+		ctx:     context.Background(),
+		running: make(map[primitive.ObjectID]context.CancelFunc),
+	}, nil
+}
+
+// Init resumes the broadcasts left unfinished, now and whenever their runner
+// stops making progress.
+func (s *service) Init(ctx context.Context) error {
+	s.ctx = ctx
+
+	go func() {
+		for {
+			s.resume(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(resumeEvery):
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (s *service) resume(ctx context.Context) {
+	ids, err := s.repository.GetResumable(ctx, time.Now().Add(-leaseTimeout))
+	if err != nil {
+		s.logger.Errorf("resume repository.GetResumable error %v\n", err)
+		return
+	}
+
+	for _, id := range ids {
+		go s.run(ctx, id)
+	}
+}
+
+func (s *service) CreateBroadcast(ctx context.Context, req CreateBroadcast, createdBy string) (*Broadcast, error) {
+	broadcast, err := NewBroadcast(req, createdBy, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repository.CreateBroadcast(ctx, broadcast); err != nil {
+		return nil, err
+	}
+
+	go s.run(s.ctx, broadcast.ID)
+
+	return broadcast, nil
+}
+
+func (s *service) GetBroadcast(ctx context.Context, id string) (*Broadcast, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrBroadcastNotFound
+	}
+
+	broadcast, err := s.repository.GetBroadcast(ctx, oid)
+	if err != nil {
+		return nil, err
+	}
+	if broadcast == nil {
+		return nil, ErrBroadcastNotFound
+	}
+
+	return broadcast, nil
+}
+
+func (s *service) GetBroadcasts(ctx context.Context, page, limit int) ([]*Broadcast, error) {
+	return s.repository.GetBroadcasts(ctx, page, limit)
+}
+
+// CancelBroadcast stops a broadcast. A runner on another instance stops at
+// its next batch, when saving its progress fails.
+func (s *service) CancelBroadcast(ctx context.Context, id string) (*Broadcast, error) {
+	broadcast, err := s.GetBroadcast(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	cancelled, err := s.repository.Cancel(ctx, broadcast.ID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !cancelled {
+		return nil, ErrBroadcastFinished
+	}
+
+	s.mx.Lock()
+	if cancel, ok := s.running[broadcast.ID]; ok {
+		cancel()
+	}
+	s.mx.Unlock()
+
+	return s.GetBroadcast(ctx, id)
+}
+
+// run sends a broadcast in batches, throttled to the configured rate, and
+// saves the progress after every batch so it can be resumed. A batch that
+// couldn't be sent is not saved, the next runner sends it again from the
+// saved cursor once the lease times out.
+func (s *service) run(ctx context.Context, id primitive.ObjectID) {
+	broadcast, err := s.repository.Claim(ctx, id, time.Now().Add(-leaseTimeout), time.Now())
+	if err != nil || broadcast == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s.mx.Lock()
+	s.running[id] = cancel
+	s.mx.Unlock()
+
+	defer func() {
+		s.mx.Lock()
+		delete(s.running, id)
+		s.mx.Unlock()
+		cancel()
+	}()
+
+	if broadcast.Topic != "" {
+		s.sendTopic(ctx, broadcast)
+		return
+	}
+
+	filters := broadcast.Segment.Filters()
+	progress := broadcast.Progress
+	if broadcast.Cursor.IsZero() {
+		if progress.Total, err = s.watcherRepository.CountWatchers(ctx, filters); err != nil {
+			s.logger.Errorf("run watcherRepository.CountWatchers error %v\n", err)
+		}
+	}
+
+	// A batch has to finish well within the lease
+	batchSize := s.cfg.BatchSize
+	if batchSize > s.cfg.Rate*60 {
+		batchSize = s.cfg.Rate * 60
+	}
+
+	cursor := broadcast.Cursor
+	for {
+		watchers, err := s.watcherRepository.GetWatchersAfter(ctx, filters, cursor, batchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.finish(broadcast.ID, StatusFailed, err.Error())
+			}
+			return
+		}
+		if len(watchers) == 0 {
+			s.finish(broadcast.ID, StatusDone, "")
+			return
+		}
+
+		started := time.Now()
+
+		// batch only becomes the progress once the batch is sent
+		batch := progress
+		messages := make([]*cloudmessaging.Message, 0, len(watchers))
+		for _, item := range watchers {
+			if !broadcast.Segment.MatchesApp(item.App) {
+				continue
+			}
+			batch.Targeted++
+
+			pushToken, err := base64.StdEncoding.DecodeString(item.PushToken)
+			if err != nil || len(pushToken) == 0 {
+				batch.Failed++
+				continue
+			}
+
+			locale := ""
+			if item.App != nil {
+				locale = item.App.Locale
+			}
+			message := broadcast.MessageFor(locale)
+			messages = append(messages, &cloudmessaging.Message{
+				Title:     message.Title,
+				Body:      message.Body,
+				PushToken: string(pushToken),
+				Data:      map[string]interface{}{"type": "broadcast", "broadcast_id": broadcast.ID.Hex()},
+			})
+		}
+
+		if len(messages) > 0 {
+			results, err := s.cloudMessagingSvc.SendMessages(ctx, messages)
+			if err != nil {
+				// Some devices of the batch may get the message twice
+				s.logger.Errorf("run cloudMessagingSvc.SendMessages error %v\n", err)
+				return
+			}
+
+			sent := 0
+			var unregistered []string
+			for i, result := range results {
+				if result.Err == nil && result.MessageId != nil {
+					sent++
+				}
+				if cloudmessaging.IsUnregistered(result.Err) {
+					unregistered = append(unregistered, messages[i].PushToken)
+				}
+			}
+			batch.Sent += int64(sent)
+			batch.Failed += int64(len(messages) - sent)
+			s.sent.Add(int64(sent))
+			s.failed.Add(int64(len(messages) - sent))
+
+			if len(unregistered) > 0 {
+				if err := s.watcherSvc.RecordUnregistered(ctx, unregistered); err != nil {
+					s.logger.Errorf("run watcherSvc.RecordUnregistered error %v\n", err)
+				}
+			}
+		}
+
+		progress = batch
+		cursor = watchers[len(watchers)-1].ID
+		sending, err := s.repository.SaveProgress(ctx, broadcast.ID, progress, cursor, time.Now())
+		if err != nil || !sending {
+			return
+		}
+
+		wait := time.Duration(len(messages))*time.Second/time.Duration(s.cfg.Rate) - time.Since(started)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// sendTopic sends a topic broadcast. The app subscribes its devices to its
+// topics itself, the API doesn't manage topic subscriptions.
+func (s *service) sendTopic(ctx context.Context, broadcast *Broadcast) {
+	message := broadcast.Messages[broadcast.DefaultLocale]
+	data := map[string]interface{}{"type": "broadcast", "broadcast_id": broadcast.ID.Hex()}
+
+	if _, err := s.cloudMessagingSvc.SendTopicMessage(ctx, message.Title, message.Body, broadcast.Topic, data); err != nil {
+		s.logger.Errorf("sendTopic cloudMessagingSvc.SendTopicMessage error %v\n", err)
+		s.failed.Inc()
+		s.finish(broadcast.ID, StatusFailed, err.Error())
+		return
+	}
+
+	s.sent.Inc()
+	if _, err := s.repository.SaveProgress(ctx, broadcast.ID, Progress{Total: 1, Targeted: 1, Sent: 1}, broadcast.Cursor, time.Now()); err != nil {
+		s.logger.Errorf("sendTopic repository.SaveProgress error %v\n", err)
+	}
+	s.finish(broadcast.ID, StatusDone, "")
+}
+
+// finish uses its own context, the run one may be cancelled by then.
+func (s *service) finish(id primitive.ObjectID, status, reason string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := s.repository.Finish(ctx, id, status, reason, time.Now()); err != nil {
+		s.logger.Errorf("finish repository.Finish error %v\n", err)
+	}
+}
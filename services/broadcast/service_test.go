@@ -0,0 +1,147 @@
+package broadcast
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"airdao-mobile-api/config"
+	cloudmessaging "airdao-mobile-api/pkg/firebase/cloud-messaging"
+	"airdao-mobile-api/pkg/metrics"
+	"airdao-mobile-api/services/watcher"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+var errUnregistered = errors.New("http error status: 404; reason: app instance has been unregistered; code: registration-token-not-registered; details: Requested entity was not found.")
+
+// runRepository holds one broadcast
+type runRepository struct {
+	Repository
+
+	broadcast Broadcast
+}
+
+func (r *runRepository) Claim(ctx context.Context, id primitive.ObjectID, staleBefore, now time.Time) (*Broadcast, error) {
+	if r.broadcast.Finished() {
+		return nil, nil
+	}
+
+	r.broadcast.Status = StatusSending
+	claimed := r.broadcast
+	return &claimed, nil
+}
+
+func (r *runRepository) SaveProgress(ctx context.Context, id primitive.ObjectID, progress Progress, cursor primitive.ObjectID, now time.Time) (bool, error) {
+	r.broadcast.Progress = progress
+	r.broadcast.Cursor = cursor
+	return true, nil
+}
+
+func (r *runRepository) Finish(ctx context.Context, id primitive.ObjectID, status, reason string, now time.Time) error {
+	r.broadcast.Status = status
+	return nil
+}
+
+// runWatchers pages through watchers in id order
+type runWatchers struct {
+	watcher.Repository
+
+	watchers []*watcher.Watcher
+}
+
+func (r *runWatchers) CountWatchers(ctx context.Context, filters bson.M) (int64, error) {
+	return int64(len(r.watchers)), nil
+}
+
+func (r *runWatchers) GetWatchersAfter(ctx context.Context, filters bson.M, after primitive.ObjectID, limit int) ([]*watcher.Watcher, error) {
+	var page []*watcher.Watcher
+	for _, item := range r.watchers {
+		if item.ID.Hex() > after.Hex() && len(page) < limit {
+			page = append(page, item)
+		}
+	}
+
+	return page, nil
+}
+
+// runMessaging fails every batch while down, and the push tokens in fails
+type runMessaging struct {
+	cloudmessaging.Service
+
+	down  bool
+	fails map[string]error
+	sent  []string
+}
+
+func (m *runMessaging) SendMessages(ctx context.Context, messages []*cloudmessaging.Message) ([]*cloudmessaging.SendResult, error) {
+	results := make([]*cloudmessaging.SendResult, 0, len(messages))
+	for _, message := range messages {
+		if m.down {
+			results = append(results, &cloudmessaging.SendResult{Err: context.Canceled})
+			continue
+		}
+
+		m.sent = append(m.sent, message.PushToken)
+		if err, ok := m.fails[message.PushToken]; ok {
+			results = append(results, &cloudmessaging.SendResult{Err: err})
+			continue
+		}
+		id := "id"
+		results = append(results, &cloudmessaging.SendResult{MessageId: &id})
+	}
+
+	if m.down {
+		return results, context.Canceled
+	}
+	return results, nil
+}
+
+type runWatcherService struct {
+	watcher.Service
+
+	unregistered []string
+}
+
+func (s *runWatcherService) RecordUnregistered(ctx context.Context, pushTokens []string) error {
+	s.unregistered = append(s.unregistered, pushTokens...)
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	broadcast, err := NewBroadcast(CreateBroadcast{Messages: map[string]*Message{"en": {Title: "t", Body: "b"}}}, "key:abc", time.Now())
+	require.NoError(t, err)
+
+	watchers := &runWatchers{}
+	for _, pushToken := range []string{"first", "second", "gone"} {
+		item, err := watcher.NewWatcher(base64.StdEncoding.EncodeToString([]byte(pushToken)))
+		require.NoError(t, err)
+		watchers.watchers = append(watchers.watchers, item)
+	}
+
+	repository := &runRepository{broadcast: *broadcast}
+	messaging := &runMessaging{down: true, fails: map[string]error{"gone": errUnregistered}}
+	watcherSvc := &runWatcherService{}
+
+	svc, err := NewService(repository, watchers, watcherSvc, messaging, metrics.NewRegistry(), zap.NewNop().Sugar(), config.Broadcast{Rate: 1000, BatchSize: 2})
+	require.NoError(t, err)
+	s := svc.(*service)
+
+	s.run(context.Background(), broadcast.ID)
+	assert.Equal(t, StatusSending, repository.broadcast.Status, "should leave the broadcast to the next runner")
+	assert.True(t, repository.broadcast.Cursor.IsZero(), "should not move past an unsent batch")
+	assert.Equal(t, Progress{}, repository.broadcast.Progress)
+
+	messaging.down = false
+	s.run(context.Background(), broadcast.ID)
+	assert.Equal(t, StatusDone, repository.broadcast.Status)
+	assert.Equal(t, []string{"first", "second", "gone"}, messaging.sent)
+	assert.Equal(t, Progress{Total: 3, Targeted: 3, Sent: 2, Failed: 1}, repository.broadcast.Progress)
+	assert.Equal(t, []string{"gone"}, watcherSvc.unregistered)
+}
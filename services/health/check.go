@@ -0,0 +1,13 @@
+package health
+
+// Check is the state of one component reported on the health endpoint.
+type Check struct {
+	Name    string      `json:"-"`
+	Healthy bool        `json:"healthy"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+//go:generate mockgen -source=check.go -destination=mocks/check_mock.go
+type Checker interface {
+	HealthCheck() Check
+}
@@ -1,20 +1,54 @@
 package health
 
 import (
+	"airdao-mobile-api/pkg/metrics"
+
 	"github.com/gofiber/fiber/v2"
 )
 
+const (
+	StatusOK       = "OK"
+	StatusDegraded = "DEGRADED"
+)
+
 type Handler struct {
+	metrics  *metrics.Registry
+	checkers []Checker
 }
 
-func NewHandler() *Handler {
-	return &Handler{}
+func NewHandler(metrics *metrics.Registry, checkers ...Checker) *Handler {
+	return &Handler{metrics: metrics, checkers: checkers}
 }
 
 func (h *Handler) SetupRoutes(router fiber.Router) {
 	router.Get("/health", h.HealthCheckHandler)
+	router.Get("/metrics", h.MetricsHandler)
 }
 
 func (h *Handler) HealthCheckHandler(c *fiber.Ctx) error {
-	return c.JSON(fiber.Map{"status": "OK"})
+	if len(h.checkers) == 0 {
+		return c.JSON(fiber.Map{"status": StatusOK})
+	}
+
+	// A degraded component doesn't make the service unavailable, so the
+	// status code stays 200 and only the body reports it
+	status := StatusOK
+	checks := make(map[string]Check, len(h.checkers))
+	for _, checker := range h.checkers {
+		check := checker.HealthCheck()
+		if !check.Healthy {
+			status = StatusDegraded
+		}
+		checks[check.Name] = check
+	}
+
+	return c.JSON(fiber.Map{"status": status, "checks": checks})
+}
+
+func (h *Handler) MetricsHandler(c *fiber.Ctx) error {
+	if h.metrics == nil {
+		return c.JSON(fiber.Map{})
+	}
+
+	return c.JSON(h.metrics.Snapshot())
 }
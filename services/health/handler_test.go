@@ -73,3 +73,48 @@ func TestHeathRoute(t *testing.T) {
 		assert.Equalf(t, test.expectedBody, string(body), test.description)
 	}
 }
+
+type stubChecker struct {
+	check Check
+}
+
+func (s *stubChecker) HealthCheck() Check {
+	return s.check
+}
+
+func TestHealthChecks(t *testing.T) {
+	tests := []struct {
+		description  string
+		checkers     []Checker
+		expectedBody string
+	}{
+		{
+			description:  "report OK when every check is healthy",
+			checkers:     []Checker{&stubChecker{check: Check{Name: "explorer_callbacks", Healthy: true}}},
+			expectedBody: "{\"checks\":{\"explorer_callbacks\":{\"healthy\":true}},\"status\":\"OK\"}",
+		},
+		{
+			description: "report DEGRADED when a check is unhealthy",
+			checkers: []Checker{
+				&stubChecker{check: Check{Name: "database", Healthy: true}},
+				&stubChecker{check: Check{Name: "explorer_callbacks", Healthy: false, Details: map[string]bool{"degraded": true}}},
+			},
+			expectedBody: "{\"checks\":{\"database\":{\"healthy\":true},\"explorer_callbacks\":{\"healthy\":false,\"details\":{\"degraded\":true}}},\"status\":\"DEGRADED\"}",
+		},
+	}
+
+	for _, test := range tests {
+		app := fiber.New()
+		NewHandler(nil, test.checkers...).SetupRoutes(app)
+
+		resp, _ := app.Test(httptest.NewRequest("GET", "/health", nil), 1)
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read response body: %v", err)
+		}
+
+		assert.Equalf(t, 200, resp.StatusCode, test.description)
+		assert.Equalf(t, test.expectedBody, string(body), test.description)
+	}
+}
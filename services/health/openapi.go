@@ -0,0 +1,21 @@
+package health
+
+import (
+	"airdao-mobile-api/pkg/openapi"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Response documents the body of HealthCheckHandler.
+type Response struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks,omitempty"`
+}
+
+// DescribeRoutes documents the routes of SetupRoutes under prefix.
+func (h *Handler) DescribeRoutes(doc *openapi.Document, prefix string) {
+	tags := []string{"health"}
+
+	doc.Add(openapi.Route{Method: fiber.MethodGet, Path: prefix + "/health", Summary: "Service health", Tags: tags, Response: Response{}})
+	doc.Add(openapi.Route{Method: fiber.MethodGet, Path: prefix + "/metrics", Summary: "Counters snapshot", Tags: tags, Response: map[string]int64{}})
+}
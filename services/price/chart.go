@@ -0,0 +1,211 @@
+package price
+
+import (
+	"time"
+
+	"airdao-mobile-api/pkg/apierror"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const day = 24 * time.Hour
+
+var (
+	ErrInvalidRange    = apierror.New(fiber.StatusBadRequest, "invalid_range", "invalid range (can be 1d, 7d, 30d, 90d, 1y or all)")
+	ErrInvalidInterval = apierror.New(fiber.StatusBadRequest, "invalid_interval", "invalid interval (can be 5m, 15m, 1h, 4h or 1d)")
+
+	ErrMarketUnavailable       = apierror.New(fiber.StatusNotFound, "market_unavailable", "market data not available")
+	ErrUnsupportedCurrency     = apierror.New(fiber.StatusBadRequest, "unsupported_currency", "unsupported currency")
+	ErrCurrencyRateUnavailable = apierror.New(fiber.StatusServiceUnavailable, "currency_rate_unavailable", "currency rate is unavailable")
+)
+
+type chartRange struct {
+	duration time.Duration
+	interval time.Duration
+}
+
+// A zero duration means the whole history
+var chartRanges = map[string]chartRange{
+	"1d":  {duration: day, interval: 5 * time.Minute},
+	"7d":  {duration: 7 * day, interval: time.Hour},
+	"30d": {duration: 30 * day, interval: 4 * time.Hour},
+	"90d": {duration: 90 * day, interval: day},
+	"1y":  {duration: 365 * day, interval: day},
+	"all": {duration: 0, interval: day},
+}
+
+var chartIntervals = map[string]time.Duration{
+	"5m":  5 * time.Minute,
+	"15m": 15 * time.Minute,
+	"1h":  time.Hour,
+	"4h":  4 * time.Hour,
+	"1d":  day,
+}
+
+// ParseRange returns the start of the range ending at now and the interval
+// it is charted at unless the caller asks for another one.
+func ParseRange(name string, now time.Time) (time.Time, time.Duration, error) {
+	r, ok := chartRanges[name]
+	if !ok {
+		return time.Time{}, 0, ErrInvalidRange
+	}
+	if r.duration == 0 {
+		return time.Unix(0, 0), r.interval, nil
+	}
+
+	return now.Add(-r.duration), r.interval, nil
+}
+
+func ParseInterval(name string) (time.Duration, error) {
+	interval, ok := chartIntervals[name]
+	if !ok {
+		return 0, ErrInvalidInterval
+	}
+
+	return interval, nil
+}
+
+type Candle struct {
+	Timestamp int64   `json:"timestamp"`
+	Open      float64 `json:"open"`
+	High      float64 `json:"high"`
+	Low       float64 `json:"low"`
+	Close     float64 `json:"close"`
+}
+
+type Stats struct {
+	High          float64 `json:"high"`
+	Low           float64 `json:"low"`
+	ChangePercent float64 `json:"change_percent"`
+	Volume        float64 `json:"volume"`
+}
+
+// Candles groups ticks into interval candles. Ticks must be sorted by time.
+func Candles(ticks []*Tick, interval time.Duration) []*Candle {
+	candles := make([]*Candle, 0)
+
+	var current *Candle
+	var bucket time.Time
+	for _, tick := range ticks {
+		open, high, low, closePrice := tick.ohlc()
+
+		start := tick.Timestamp.Truncate(interval)
+		if current == nil || !start.Equal(bucket) {
+			bucket = start
+			current = &Candle{Timestamp: start.UnixMilli(), Open: open, High: high, Low: low, Close: closePrice}
+			candles = append(candles, current)
+			continue
+		}
+
+		if high > current.High {
+			current.High = high
+		}
+		if low < current.Low {
+			current.Low = low
+		}
+		current.Close = closePrice
+	}
+
+	return candles
+}
+
+// Points returns [timestamp ms, price] pairs with at most one point, the
+// last one, per interval. A zero interval keeps every tick.
+func Points(ticks []*Tick, interval time.Duration) [][]float64 {
+	points := make([][]float64, 0, len(ticks))
+
+	var bucket time.Time
+	for _, tick := range ticks {
+		point := []float64{float64(tick.Timestamp.UnixMilli()), tick.Price}
+
+		if interval > 0 {
+			start := tick.Timestamp.Truncate(interval)
+			if len(points) > 0 && start.Equal(bucket) {
+				points[len(points)-1] = point
+				continue
+			}
+			bucket = start
+		}
+
+		points = append(points, point)
+	}
+
+	return points
+}
+
+// Summarize computes the range stats. Ticks must be sorted by time.
+func Summarize(ticks []*Tick) *Stats {
+	stats := &Stats{}
+	if len(ticks) == 0 {
+		return stats
+	}
+
+	for i, tick := range ticks {
+		_, high, low, _ := tick.ohlc()
+		if i == 0 || high > stats.High {
+			stats.High = high
+		}
+		if i == 0 || low < stats.Low {
+			stats.Low = low
+		}
+	}
+	stats.Volume = rangeVolume(ticks)
+
+	first, _, _, _ := ticks[0].ohlc()
+	last := ticks[len(ticks)-1].Price
+	if first > 0 {
+		stats.ChangePercent = (last - first) / first * 100
+	}
+
+	return stats
+}
+
+// rangeVolume estimates the volume traded over the ticks. Ticks only carry
+// the rolling 24h volume at their time, so the range is cut in 24h windows
+// back from the last tick and each window counts the volume seen at its end.
+// A range up to a day is the latest 24h volume; the oldest window of a
+// longer range only counts for its part inside the range.
+func rangeVolume(ticks []*Tick) float64 {
+	start := ticks[0].Timestamp
+	end := ticks[len(ticks)-1].Timestamp
+
+	var volume float64
+	i := len(ticks) - 1
+	for latest := true; latest || end.After(start); latest, end = false, end.Add(-day) {
+		// The last tick with a volume in (end - 24h, end]
+		for i >= 0 && ticks[i].Timestamp.After(end) {
+			i--
+		}
+		j := i
+		for j >= 0 && ticks[j].Volume <= 0 && ticks[j].Timestamp.After(end.Add(-day)) {
+			j--
+		}
+		if j < 0 || !ticks[j].Timestamp.After(end.Add(-day)) {
+			continue
+		}
+
+		share := 1.0
+		if !latest && end.Sub(start) < day {
+			share = float64(end.Sub(start)) / float64(day)
+		}
+		volume += ticks[j].Volume * share
+	}
+
+	return volume
+}
+
+// Convert returns copies of ticks with prices and volume multiplied by rate.
+func Convert(ticks []*Tick, rate float64) []*Tick {
+	converted := make([]*Tick, 0, len(ticks))
+	for _, tick := range ticks {
+		t := *tick
+		t.Price *= rate
+		t.Open *= rate
+		t.High *= rate
+		t.Low *= rate
+		t.Volume *= rate
+		converted = append(converted, &t)
+	}
+
+	return converted
+}
@@ -0,0 +1,173 @@
+package price_test
+
+import (
+	"testing"
+	"time"
+
+	"airdao-mobile-api/services/price"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tick(value, volume float64, at time.Time) *price.Tick {
+	return &price.Tick{Price: value, Volume: volume, Timestamp: at}
+}
+
+func TestParseRange(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		wantFrom     time.Time
+		wantInterval time.Duration
+		wantErr      error
+	}{
+		{name: "1d", wantFrom: now.Add(-24 * time.Hour), wantInterval: 5 * time.Minute},
+		{name: "30d", wantFrom: now.Add(-30 * 24 * time.Hour), wantInterval: 4 * time.Hour},
+		{name: "all", wantFrom: time.Unix(0, 0), wantInterval: 24 * time.Hour},
+		{name: "2d", wantErr: price.ErrInvalidRange},
+	}
+
+	for _, test := range tests {
+		from, interval, err := price.ParseRange(test.name, now)
+		assert.Equal(t, test.wantErr, err, test.name)
+		assert.True(t, test.wantFrom.Equal(from), test.name)
+		assert.Equal(t, test.wantInterval, interval, test.name)
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	interval, err := price.ParseInterval("15m")
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, interval)
+
+	_, err = price.ParseInterval("2h")
+	assert.Equal(t, price.ErrInvalidInterval, err)
+}
+
+func TestCandles(t *testing.T) {
+	start := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	ticks := []*price.Tick{
+		tick(2, 0, start.Add(5*time.Minute)),
+		tick(4, 0, start.Add(20*time.Minute)),
+		tick(1, 0, start.Add(40*time.Minute)),
+		tick(3, 0, start.Add(50*time.Minute)),
+		// A downsampled tick keeps its own open, high and low
+		{Price: 6, Open: 5, High: 8, Low: 4, Timestamp: start.Add(time.Hour)},
+	}
+
+	candles := price.Candles(ticks, time.Hour)
+	require.Len(t, candles, 2)
+	assert.Equal(t, &price.Candle{Timestamp: start.UnixMilli(), Open: 2, High: 4, Low: 1, Close: 3}, candles[0])
+	assert.Equal(t, &price.Candle{Timestamp: start.Add(time.Hour).UnixMilli(), Open: 5, High: 8, Low: 4, Close: 6}, candles[1])
+
+	assert.Empty(t, price.Candles(nil, time.Hour))
+}
+
+func TestPoints(t *testing.T) {
+	start := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	ticks := []*price.Tick{
+		tick(1, 0, start),
+		tick(2, 0, start.Add(30*time.Minute)),
+		tick(3, 0, start.Add(time.Hour)),
+	}
+
+	tests := []struct {
+		name     string
+		interval time.Duration
+		expect   [][]float64
+	}{
+		{
+			name:     "should keep every tick without interval",
+			interval: 0,
+			expect: [][]float64{
+				{float64(start.UnixMilli()), 1},
+				{float64(start.Add(30 * time.Minute).UnixMilli()), 2},
+				{float64(start.Add(time.Hour).UnixMilli()), 3},
+			},
+		},
+		{
+			name:     "should keep the last tick of each interval",
+			interval: time.Hour,
+			expect: [][]float64{
+				{float64(start.Add(30 * time.Minute).UnixMilli()), 2},
+				{float64(start.Add(time.Hour).UnixMilli()), 3},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expect, price.Points(ticks, test.interval), test.name)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	end := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		ticks      []*price.Tick
+		wantHigh   float64
+		wantLow    float64
+		wantChange float64
+		wantVolume float64
+	}{
+		{
+			name: "should take the latest 24h volume over a day spanning two dates",
+			ticks: []*price.Tick{
+				tick(2, 100, end.Add(-24*time.Hour)),
+				tick(1, 150, end.Add(-12*time.Hour)),
+				tick(3, 200, end),
+			},
+			wantHigh: 3, wantLow: 1, wantChange: 50, wantVolume: 200,
+		},
+		{
+			name: "should count each day of a longer range once",
+			ticks: []*price.Tick{
+				tick(1, 100, end.Add(-48*time.Hour)),
+				tick(1, 300, end.Add(-24*time.Hour)),
+				tick(1, 999, end.Add(-23*time.Hour)),
+				tick(1, 200, end),
+			},
+			wantHigh: 1, wantLow: 1, wantChange: 0, wantVolume: 500,
+		},
+		{
+			name: "should count the part of the oldest day inside the range",
+			ticks: []*price.Tick{
+				tick(4, 100, end.Add(-36*time.Hour)),
+				tick(2, 300, end.Add(-24*time.Hour)),
+				tick(2, 200, end),
+			},
+			wantHigh: 4, wantLow: 2, wantChange: -50, wantVolume: 350,
+		},
+		{
+			name:     "should have no volume without volume",
+			ticks:    []*price.Tick{tick(1, 0, end.Add(-time.Hour)), tick(1, 0, end)},
+			wantHigh: 1, wantLow: 1,
+		},
+	}
+
+	for _, test := range tests {
+		stats := price.Summarize(test.ticks)
+		assert.Equal(t, test.wantHigh, stats.High, test.name)
+		assert.Equal(t, test.wantLow, stats.Low, test.name)
+		assert.InDelta(t, test.wantChange, stats.ChangePercent, 1e-9, test.name)
+		assert.InDelta(t, test.wantVolume, stats.Volume, 1e-9, test.name)
+	}
+
+	assert.Equal(t, &price.Stats{}, price.Summarize(nil))
+}
+
+func TestConvert(t *testing.T) {
+	at := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	ticks := []*price.Tick{{Price: 2, Open: 1, High: 3, Low: 0.5, Volume: 100, Timestamp: at}}
+
+	converted := price.Convert(ticks, 0.5)
+	require.Len(t, converted, 1)
+	assert.Equal(t, &price.Tick{Price: 1, Open: 0.5, High: 1.5, Low: 0.25, Volume: 50, Timestamp: at}, converted[0])
+
+	// The ticks given are left alone
+	assert.Equal(t, 2.0, ticks[0].Price)
+	assert.Empty(t, price.Convert(nil, 0.5))
+}
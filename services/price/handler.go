@@ -0,0 +1,59 @@
+package price
+
+import (
+	"errors"
+	"strings"
+
+	"airdao-mobile-api/pkg/pricefeed"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type Handler struct {
+	service Service
+	fx      *pricefeed.FxRates
+}
+
+func NewHandler(service Service, fx *pricefeed.FxRates) (*Handler, error) {
+	if service == nil {
+		return nil, errors.New("[price_handler] invalid price service")
+	}
+	if fx == nil {
+		return nil, errors.New("[price_handler] invalid fx rates")
+	}
+
+	return &Handler{service: service, fx: fx}, nil
+}
+
+func (h *Handler) SetupRoutes(router fiber.Router) {
+	router.Get("/market/:token", h.GetMarketHandler)
+}
+
+// GetMarketHandler serves the cached market summary, in the currency query
+// parameter when given.
+func (h *Handler) GetMarketHandler(c *fiber.Ctx) error {
+	token := strings.ToUpper(c.Params("token"))
+
+	market, ok := h.service.Market(token)
+	if !ok {
+		return ErrMarketUnavailable
+	}
+
+	if currency := strings.ToUpper(c.Query("currency")); currency != "" && currency != market.Currency {
+		if !h.fx.Supports(currency) {
+			return ErrUnsupportedCurrency
+		}
+
+		rate, ok := h.fx.Rate(currency)
+		if !ok {
+			return ErrCurrencyRateUnavailable
+		}
+
+		market = market.Convert(currency, rate)
+	}
+
+	// Clients and proxies may reuse it until the next price poll
+	c.Set(fiber.HeaderCacheControl, "public, max-age=60")
+
+	return c.JSON(market)
+}
@@ -0,0 +1,93 @@
+package price_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"airdao-mobile-api/pkg/apierror"
+	"airdao-mobile-api/pkg/pricefeed"
+	"airdao-mobile-api/services/price"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type marketService struct {
+	price.Service
+
+	markets map[string]*price.Market
+}
+
+func (s *marketService) Market(token string) (*price.Market, bool) {
+	market, ok := s.markets[token]
+	return market, ok
+}
+
+type stubRates map[string]float64
+
+func (r stubRates) Name() string { return "stub" }
+
+func (r stubRates) FetchRates(ctx context.Context, currencies []string) (map[string]float64, error) {
+	return r, nil
+}
+
+func TestGetMarketHandler(t *testing.T) {
+	fx, err := pricefeed.NewFxRates([]pricefeed.RateProvider{stubRates{"EUR": 0.5}}, pricefeed.FxConfig{
+		Currencies: []string{"EUR", "GBP"}, Interval: time.Minute, MaxAge: time.Hour,
+	}, zap.NewNop().Sugar())
+	require.NoError(t, err)
+	fx.Refresh(context.Background())
+
+	service := &marketService{markets: map[string]*price.Market{
+		"AMB": {Token: "AMB", Currency: pricefeed.BaseCurrency, Price: 2, High24h: 4, Low24h: 1, Volume24h: 100, MarketCap: 1000, Sources: []string{"coingecko"}, Fresh: true},
+	}}
+
+	h, err := price.NewHandler(service, fx)
+	require.NoError(t, err)
+
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.Handler(zap.NewNop().Sugar())})
+	app.Route("/api/v1/price", h.SetupRoutes)
+
+	tests := []struct {
+		name         string
+		route        string
+		wantCode     int
+		wantCurrency string
+		wantPrice    float64
+		wantHigh     float64
+	}{
+		{name: "should serve the market in USD", route: "/market/amb", wantCode: fiber.StatusOK, wantCurrency: "USD", wantPrice: 2, wantHigh: 4},
+		{name: "should convert to the currency", route: "/market/AMB?currency=eur", wantCode: fiber.StatusOK, wantCurrency: "EUR", wantPrice: 1, wantHigh: 2},
+		{name: "should refuse an unsupported currency", route: "/market/AMB?currency=XYZ", wantCode: fiber.StatusBadRequest},
+		{name: "should refuse a currency without rate", route: "/market/AMB?currency=GBP", wantCode: fiber.StatusServiceUnavailable},
+		{name: "should not find an untracked token", route: "/market/USDC", wantCode: fiber.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", "/api/v1/price"+test.route, nil), -1)
+		require.NoError(t, err, test.name)
+		assert.Equal(t, test.wantCode, resp.StatusCode, test.name)
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err, test.name)
+		if test.wantCode != fiber.StatusOK {
+			continue
+		}
+
+		var market price.Market
+		require.NoError(t, json.Unmarshal(body, &market), test.name)
+		assert.Equal(t, test.wantCurrency, market.Currency, test.name)
+		assert.Equal(t, test.wantPrice, market.Price, test.name)
+		assert.Equal(t, test.wantHigh, market.High24h, test.name)
+		assert.Equal(t, "public, max-age=60", resp.Header.Get(fiber.HeaderCacheControl), test.name)
+	}
+
+	// The cached market isn't converted in place
+	assert.Equal(t, 2.0, service.markets["AMB"].Price)
+}
@@ -0,0 +1,105 @@
+package price
+
+import (
+	"context"
+	"time"
+
+	"airdao-mobile-api/pkg/pricefeed"
+)
+
+// Market is the market summary of a token, in USD unless converted.
+type Market struct {
+	Token     string   `json:"token"`
+	Currency  string   `json:"currency"`
+	Price     float64  `json:"price"`
+	Change24h float64  `json:"change_24h"`
+	High24h   float64  `json:"high_24h"`
+	Low24h    float64  `json:"low_24h"`
+	Volume24h float64  `json:"volume_24h"`
+	MarketCap float64  `json:"market_cap"`
+	Sources   []string `json:"sources"`
+	// Fresh is false once the providers stopped agreeing on the price
+	Fresh bool `json:"fresh"`
+	// UpdatedAt is when the price was agreed on, StatsUpdatedAt when the
+	// 24h stats were last computed from the stored ticks
+	UpdatedAt      time.Time `json:"updated_at"`
+	StatsUpdatedAt time.Time `json:"stats_updated_at"`
+}
+
+// clone copies the market, Sources included, so callers can't change the
+// cached one.
+func (m *Market) clone() *Market {
+	cloned := *m
+	cloned.Sources = append([]string(nil), m.Sources...)
+
+	return &cloned
+}
+
+// Convert returns a copy of the market with amounts multiplied by rate.
+func (m *Market) Convert(currency string, rate float64) *Market {
+	converted := m.clone()
+	converted.Currency = currency
+	converted.Price *= rate
+	converted.High24h *= rate
+	converted.Low24h *= rate
+	converted.Volume24h *= rate
+	converted.MarketCap *= rate
+
+	return converted
+}
+
+// Market returns a copy of the cached market summary of token.
+func (s *service) Market(token string) (*Market, bool) {
+	s.marketMx.RLock()
+	defer s.marketMx.RUnlock()
+
+	market, ok := s.markets[token]
+	if !ok {
+		return nil, false
+	}
+
+	return market.clone(), true
+}
+
+// updateMarket refreshes the cached market of token with a new aggregate.
+// The 24h stats are only recomputed for fresh aggregates.
+func (s *service) updateMarket(ctx context.Context, token string, aggregate pricefeed.Aggregate) {
+	s.marketMx.RLock()
+	previous := s.markets[token]
+	s.marketMx.RUnlock()
+
+	market := &Market{
+		Token:     token,
+		Currency:  pricefeed.BaseCurrency,
+		Price:     aggregate.Price,
+		Volume24h: aggregate.Volume24h,
+		MarketCap: aggregate.MarketCap,
+		Sources:   append([]string(nil), aggregate.Sources...),
+		Fresh:     aggregate.Fresh,
+		UpdatedAt: aggregate.UpdatedAt,
+	}
+	if previous != nil {
+		market.Change24h = previous.Change24h
+		market.High24h = previous.High24h
+		market.Low24h = previous.Low24h
+		market.StatsUpdatedAt = previous.StatsUpdatedAt
+	}
+
+	if aggregate.Fresh {
+		now := time.Now()
+		ticks, err := s.History(ctx, token, now.Add(-24*time.Hour), now)
+		if err != nil {
+			s.logger.Errorf("price updateMarket %s History error %v", token, err)
+		} else if len(ticks) > 0 {
+			stats := Summarize(ticks)
+			market.Change24h = stats.ChangePercent
+			market.High24h = stats.High
+			market.Low24h = stats.Low
+			market.StatsUpdatedAt = now
+		}
+	}
+
+	s.marketMx.Lock()
+	s.markets[token] = market
+	s.marketMx.Unlock()
+}
@@ -0,0 +1,19 @@
+package price
+
+import (
+	"airdao-mobile-api/pkg/openapi"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DescribeRoutes documents the routes of SetupRoutes under prefix.
+func (h *Handler) DescribeRoutes(doc *openapi.Document, prefix string) {
+	doc.Add(openapi.Route{
+		Method:     fiber.MethodGet,
+		Path:       prefix + "/market/:token",
+		Summary:    "Market summary of a token",
+		Tags:       []string{"price"},
+		Parameters: []openapi.Parameter{{Name: "currency", In: "query", Schema: &openapi.Schema{Type: "string", Pattern: "^[A-Za-z]{3}$"}}},
+		Response:   Market{},
+	})
+}
@@ -0,0 +1,261 @@
+package price
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// minServerVersion is the MongoDB major version with time-series collections
+// and $dateTrunc
+const minServerVersion = 5
+
+//go:generate mockgen -source=repository.go -destination=mocks/repository_mock.go
+type Repository interface {
+	EnsureCollections(ctx context.Context, rawRetention, downsampledRetention time.Duration) error
+
+	InsertTicks(ctx context.Context, ticks []*Tick) error
+	InsertDownsampledTicks(ctx context.Context, ticks []*Tick) error
+
+	GetTicks(ctx context.Context, token string, from, to time.Time) ([]*Tick, error)
+	GetDownsampledTicks(ctx context.Context, token string, from, to time.Time) ([]*Tick, error)
+	GetLastDownsampledTick(ctx context.Context, token string) (*Tick, error)
+	GetFirstDownsampledTick(ctx context.Context, token string) (*Tick, error)
+
+	Downsample(ctx context.Context, token string, from, to time.Time, interval time.Duration) ([]*Tick, error)
+}
+
+type repository struct {
+	db                          *mongo.Client
+	dbName                      string
+	dbCollectionName            string
+	dbDownsampledCollectionName string
+	logger                      *zap.SugaredLogger
+}
+
+func NewRepository(db *mongo.Client, dbName string, logger *zap.SugaredLogger) (Repository, error) {
+	if db == nil {
+		return nil, errors.New("[price_repository] invalid user database")
+	}
+	if dbName == "" {
+		return nil, errors.New("[price_repository] invalid database name")
+	}
+	if logger == nil {
+		return nil, errors.New("[price_repository] invalid logger")
+	}
+
+	return &repository{
+		db:                          db,
+		dbName:                      dbName,
+		dbCollectionName:            "price_tick",
+		dbDownsampledCollectionName: "price_tick_downsampled",
+		logger:                      logger,
+	}, nil
+}
+
+// EnsureCollections creates both time-series collections, or updates their
+// retention if they already exist. A zero retention keeps data forever.
+// Time-series collections and the $dateTrunc used by Downsample need
+// MongoDB 5.0 or newer, older servers are refused here.
+func (r *repository) EnsureCollections(ctx context.Context, rawRetention, downsampledRetention time.Duration) error {
+	if err := r.checkServerVersion(ctx); err != nil {
+		return err
+	}
+
+	if err := r.ensureTimeSeries(ctx, r.dbCollectionName, "minutes", rawRetention); err != nil {
+		return err
+	}
+
+	return r.ensureTimeSeries(ctx, r.dbDownsampledCollectionName, "hours", downsampledRetention)
+}
+
+func (r *repository) checkServerVersion(ctx context.Context) error {
+	var buildInfo struct {
+		Version      string  `bson:"version"`
+		VersionArray []int32 `bson:"versionArray"`
+	}
+
+	if err := r.db.Database("admin").RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&buildInfo); err != nil {
+		r.logger.Errorf("unable to read mongodb version: %s", err)
+		return err
+	}
+
+	if len(buildInfo.VersionArray) == 0 || buildInfo.VersionArray[0] < minServerVersion {
+		return fmt.Errorf("[price_repository] price history needs MongoDB %d.0 or newer, server is %s", minServerVersion, buildInfo.Version)
+	}
+
+	return nil
+}
+
+func (r *repository) ensureTimeSeries(ctx context.Context, name, granularity string, retention time.Duration) error {
+	db := r.db.Database(r.dbName)
+
+	opts := options.CreateCollection().SetTimeSeriesOptions(
+		options.TimeSeries().SetTimeField("timestamp").SetMetaField("meta").SetGranularity(granularity))
+	if retention > 0 {
+		opts.SetExpireAfterSeconds(int64(retention.Seconds()))
+	}
+
+	err := db.CreateCollection(ctx, name, opts)
+	if err == nil {
+		return nil
+	}
+
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.Name != "NamespaceExists" {
+		r.logger.Errorf("failed to create %s collection: %s", name, err)
+		return err
+	}
+
+	var expireAfterSeconds interface{} = "off"
+	if retention > 0 {
+		expireAfterSeconds = int64(retention.Seconds())
+	}
+
+	if err := db.RunCommand(ctx, bson.D{{Key: "collMod", Value: name}, {Key: "expireAfterSeconds", Value: expireAfterSeconds}}).Err(); err != nil {
+		r.logger.Errorf("failed to update %s collection retention: %s", name, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *repository) InsertTicks(ctx context.Context, ticks []*Tick) error {
+	return r.insert(ctx, r.dbCollectionName, ticks)
+}
+
+func (r *repository) InsertDownsampledTicks(ctx context.Context, ticks []*Tick) error {
+	return r.insert(ctx, r.dbDownsampledCollectionName, ticks)
+}
+
+func (r *repository) insert(ctx context.Context, collection string, ticks []*Tick) error {
+	if len(ticks) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, 0, len(ticks))
+	for _, tick := range ticks {
+		docs = append(docs, tick)
+	}
+
+	if _, err := r.db.Database(r.dbName).Collection(collection).InsertMany(ctx, docs); err != nil {
+		r.logger.Errorf("failed to insert ticks to %s: %s", collection, err)
+		return errors.New("failed to insert price ticks")
+	}
+
+	return nil
+}
+
+func (r *repository) GetTicks(ctx context.Context, token string, from, to time.Time) ([]*Tick, error) {
+	return r.find(ctx, r.dbCollectionName, token, from, to)
+}
+
+func (r *repository) GetDownsampledTicks(ctx context.Context, token string, from, to time.Time) ([]*Tick, error) {
+	return r.find(ctx, r.dbDownsampledCollectionName, token, from, to)
+}
+
+func (r *repository) find(ctx context.Context, collection, token string, from, to time.Time) ([]*Tick, error) {
+	filter := bson.M{"meta.token": token, "timestamp": bson.M{"$gte": from, "$lte": to}}
+	findOptions := options.Find().SetSort(bson.M{"timestamp": 1})
+
+	cur, err := r.db.Database(r.dbName).Collection(collection).Find(ctx, filter, findOptions)
+	if err != nil {
+		r.logger.Errorf("unable to find ticks due to internal error: %v", err)
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var ticks []*Tick
+	if err := cur.All(ctx, &ticks); err != nil {
+		r.logger.Errorf("unable to decode tick documents: %v", err)
+		return nil, err
+	}
+
+	return ticks, nil
+}
+
+func (r *repository) GetLastDownsampledTick(ctx context.Context, token string) (*Tick, error) {
+	return r.findEdge(ctx, token, -1)
+}
+
+func (r *repository) GetFirstDownsampledTick(ctx context.Context, token string) (*Tick, error) {
+	return r.findEdge(ctx, token, 1)
+}
+
+func (r *repository) findEdge(ctx context.Context, token string, order int) (*Tick, error) {
+	var tick Tick
+
+	findOptions := options.FindOne().SetSort(bson.M{"timestamp": order})
+	if err := r.db.Database(r.dbName).Collection(r.dbDownsampledCollectionName).FindOne(ctx, bson.M{"meta.token": token}, findOptions).Decode(&tick); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+
+		r.logger.Errorf("unable to find tick due to internal error: %v", err)
+		return nil, err
+	}
+
+	return &tick, nil
+}
+
+// Downsample groups raw ticks of [from, to) into interval buckets.
+func (r *repository) Downsample(ctx context.Context, token string, from, to time.Time, interval time.Duration) ([]*Tick, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"meta.token": token, "timestamp": bson.M{"$gte": from, "$lt": to}}}},
+		{{Key: "$sort", Value: bson.M{"timestamp": 1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"$dateTrunc": bson.M{
+				"date":    "$timestamp",
+				"unit":    "minute",
+				"binSize": int64(interval.Minutes()),
+			}},
+			"open":   bson.M{"$first": "$price"},
+			"high":   bson.M{"$max": "$price"},
+			"low":    bson.M{"$min": "$price"},
+			"price":  bson.M{"$last": "$price"},
+			"volume": bson.M{"$last": "$volume"},
+		}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+
+	cur, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).Aggregate(ctx, pipeline)
+	if err != nil {
+		r.logger.Errorf("unable to downsample ticks due to internal error: %v", err)
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var buckets []struct {
+		Timestamp time.Time `bson:"_id"`
+		Open      float64   `bson:"open"`
+		High      float64   `bson:"high"`
+		Low       float64   `bson:"low"`
+		Price     float64   `bson:"price"`
+		Volume    float64   `bson:"volume"`
+	}
+	if err := cur.All(ctx, &buckets); err != nil {
+		r.logger.Errorf("unable to decode downsampled ticks: %v", err)
+		return nil, err
+	}
+
+	ticks := make([]*Tick, 0, len(buckets))
+	for _, bucket := range buckets {
+		ticks = append(ticks, &Tick{
+			Meta:      TickMeta{Token: token, Source: SourceDownsample},
+			Price:     bucket.Price,
+			Open:      bucket.Open,
+			High:      bucket.High,
+			Low:       bucket.Low,
+			Volume:    bucket.Volume,
+			Timestamp: bucket.Timestamp,
+		})
+	}
+
+	return ticks, nil
+}
@@ -0,0 +1,283 @@
+package price
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"airdao-mobile-api/config"
+	"airdao-mobile-api/pkg/pricefeed"
+
+	"go.uber.org/zap"
+)
+
+//go:generate mockgen -source=service.go -destination=mocks/service_mock.go
+type Service interface {
+	Init(ctx context.Context) error
+
+	RecordAggregate(ctx context.Context, token string, aggregate pricefeed.Aggregate) error
+	History(ctx context.Context, token string, from, to time.Time) ([]*Tick, error)
+	PriceAt(ctx context.Context, token string, at time.Time) (float64, error)
+	PricesAt(ctx context.Context, token string, times []time.Time) ([]float64, error)
+	Market(token string) (*Market, bool)
+}
+
+type service struct {
+	repository Repository
+	feeds      *pricefeed.Feeds
+	charts     map[string]*pricefeed.CoinGecko
+	logger     *zap.SugaredLogger
+
+	cfg config.PriceHistory
+
+	marketMx sync.RWMutex
+	markets  map[string]*Market
+}
+
+func NewService(
+	repository Repository,
+	feeds *pricefeed.Feeds,
+	charts map[string]*pricefeed.CoinGecko,
+	logger *zap.SugaredLogger,
+	cfg config.PriceHistory,
+) (Service, error) {
+	if repository == nil {
+		return nil, errors.New("[price_service] invalid repository")
+	}
+	if feeds == nil {
+		return nil, errors.New("[price_service] invalid price feeds")
+	}
+	if logger == nil {
+		return nil, errors.New("[price_service] invalid logger")
+	}
+	if cfg.DownsampleInterval < time.Minute || cfg.DownsampleInterval%time.Minute != 0 {
+		return nil, errors.New("[price_service] downsample interval must be a whole number of minutes")
+	}
+	if cfg.RawRetention < 0 || cfg.DownsampledRetention < 0 {
+		return nil, errors.New("[price_service] invalid retention")
+	}
+
+	return &service{
+		repository: repository,
+		feeds:      feeds,
+		charts:     charts,
+		logger:     logger,
+
+		cfg: cfg,
+
+		markets: make(map[string]*Market),
+	}, nil
+}
+
+// Init prepares the collections, starts recording every agreed price and
+// runs the downsampling of every tracked token. The CoinGecko chart is only
+// used to fill history that predates our own ticks.
+func (s *service) Init(ctx context.Context) error {
+	if err := s.repository.EnsureCollections(ctx, s.cfg.RawRetention, s.cfg.DownsampledRetention); err != nil {
+		return err
+	}
+
+	s.feeds.Subscribe(func(token string, aggregate pricefeed.Aggregate) {
+		if aggregate.Fresh {
+			if err := s.RecordAggregate(ctx, token, aggregate); err != nil {
+				s.logger.Errorf("price RecordAggregate %s error %v", token, err)
+			}
+		}
+		if aggregate.Price > 0 {
+			s.updateMarket(ctx, token, aggregate)
+		}
+	})
+
+	for _, token := range s.feeds.Tokens() {
+		go func(token string) {
+			if err := s.bootstrap(ctx, token); err != nil {
+				s.logger.Errorf("price bootstrap %s error %v", token, err)
+			}
+			s.downsampleLoop(ctx, token)
+		}(token)
+	}
+
+	return nil
+}
+
+func (s *service) RecordAggregate(ctx context.Context, token string, aggregate pricefeed.Aggregate) error {
+	return s.repository.InsertTicks(ctx, []*Tick{{
+		Meta:      TickMeta{Token: token, Source: SourceAggregate},
+		Price:     aggregate.Price,
+		Volume:    aggregate.Volume24h,
+		Sources:   aggregate.Sources,
+		Timestamp: aggregate.UpdatedAt,
+	}})
+}
+
+// History returns raw ticks where they are still kept and downsampled ticks
+// for the older part of the range.
+func (s *service) History(ctx context.Context, token string, from, to time.Time) ([]*Tick, error) {
+	raw, err := s.repository.GetTicks(ctx, token, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	gapEnd := to
+	if len(raw) > 0 {
+		gapEnd = raw[0].Timestamp
+	}
+	if gapEnd.Sub(from) <= s.cfg.DownsampleInterval {
+		return raw, nil
+	}
+
+	downsampled, err := s.repository.GetDownsampledTicks(ctx, token, from, gapEnd.Add(-time.Nanosecond))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(downsampled, raw...), nil
+}
+
+// PriceAt returns the last price recorded at or before at, looking back at
+// most two downsample intervals. It returns zero when there is none.
+func (s *service) PriceAt(ctx context.Context, token string, at time.Time) (float64, error) {
+	ticks, err := s.History(ctx, token, at.Add(-2*s.cfg.DownsampleInterval), at)
+	if err != nil {
+		return 0, err
+	}
+	if len(ticks) == 0 {
+		return 0, nil
+	}
+
+	return ticks[len(ticks)-1].Price, nil
+}
+
+// PricesAt is PriceAt for many times at once, read with one History query
+// over all of them.
+func (s *service) PricesAt(ctx context.Context, token string, times []time.Time) ([]float64, error) {
+	prices := make([]float64, len(times))
+	if len(times) == 0 {
+		return prices, nil
+	}
+
+	from, to := times[0], times[0]
+	for _, at := range times {
+		if at.Before(from) {
+			from = at
+		}
+		if at.After(to) {
+			to = at
+		}
+	}
+
+	lookback := 2 * s.cfg.DownsampleInterval
+	ticks, err := s.History(ctx, token, from.Add(-lookback), to)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, at := range times {
+		// The last tick at or before at
+		j := sort.Search(len(ticks), func(k int) bool { return ticks[k].Timestamp.After(at) }) - 1
+		if j >= 0 && !ticks[j].Timestamp.Before(at.Add(-lookback)) {
+			prices[i] = ticks[j].Price
+		}
+	}
+
+	return prices, nil
+}
+
+// bootstrap fills the downsampled collection from the CoinGecko chart for
+// the period before our first tick.
+func (s *service) bootstrap(ctx context.Context, token string) error {
+	chart, ok := s.charts[token]
+	if !ok || s.cfg.BootstrapDays <= 0 {
+		return nil
+	}
+
+	first, err := s.repository.GetFirstDownsampledTick(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	since := time.Now().AddDate(0, 0, -s.cfg.BootstrapDays)
+	if first != nil && !first.Timestamp.After(since.Add(s.cfg.DownsampleInterval)) {
+		return nil
+	}
+
+	marketChart, err := chart.MarketChart(ctx, s.cfg.BootstrapDays)
+	if err != nil {
+		return err
+	}
+
+	ticks := make([]*Tick, 0, len(marketChart.Prices))
+	for i, point := range marketChart.Prices {
+		if len(point) < 2 {
+			continue
+		}
+
+		var volume float64
+		if i < len(marketChart.TotalVolumes) && len(marketChart.TotalVolumes[i]) > 1 {
+			volume = marketChart.TotalVolumes[i][1]
+		}
+
+		timestamp := time.UnixMilli(int64(point[0]))
+		if first != nil && !timestamp.Before(first.Timestamp) {
+			break
+		}
+
+		ticks = append(ticks, &Tick{
+			Meta:      TickMeta{Token: token, Source: SourceCoinGecko},
+			Price:     point[1],
+			Open:      point[1],
+			High:      point[1],
+			Low:       point[1],
+			Volume:    volume,
+			Timestamp: timestamp,
+		})
+	}
+
+	return s.repository.InsertDownsampledTicks(ctx, ticks)
+}
+
+func (s *service) downsampleLoop(ctx context.Context, token string) {
+	for {
+		if err := s.downsample(ctx, token); err != nil {
+			s.logger.Errorf("price downsample %s error %v", token, err)
+		}
+
+		next := time.Now().Truncate(s.cfg.DownsampleInterval).Add(s.cfg.DownsampleInterval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
+
+// downsample turns every complete bucket after the last downsampled tick
+// into a downsampled tick.
+func (s *service) downsample(ctx context.Context, token string) error {
+	to := time.Now().Truncate(s.cfg.DownsampleInterval)
+
+	from := to.AddDate(0, 0, -30)
+	if s.cfg.RawRetention > 0 {
+		from = to.Add(-s.cfg.RawRetention)
+	}
+
+	last, err := s.repository.GetLastDownsampledTick(ctx, token)
+	if err != nil {
+		return err
+	}
+	if last != nil && last.Timestamp.Add(s.cfg.DownsampleInterval).After(from) {
+		from = last.Timestamp.Add(s.cfg.DownsampleInterval).Truncate(s.cfg.DownsampleInterval)
+	}
+	if !from.Before(to) {
+		return nil
+	}
+
+	ticks, err := s.repository.Downsample(ctx, token, from, to, s.cfg.DownsampleInterval)
+	if err != nil {
+		return err
+	}
+
+	return s.repository.InsertDownsampledTicks(ctx, ticks)
+}
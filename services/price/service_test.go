@@ -0,0 +1,286 @@
+package price
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"airdao-mobile-api/config"
+	"airdao-mobile-api/pkg/pricefeed"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// memoryRepository keeps ticks in memory, Downsample is not used here
+type memoryRepository struct {
+	Repository
+
+	mx          sync.Mutex
+	raw         []*Tick
+	downsampled []*Tick
+}
+
+func (r *memoryRepository) InsertTicks(ctx context.Context, ticks []*Tick) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.raw = append(r.raw, ticks...)
+	return nil
+}
+
+func (r *memoryRepository) InsertDownsampledTicks(ctx context.Context, ticks []*Tick) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.downsampled = append(r.downsampled, ticks...)
+	return nil
+}
+
+func (r *memoryRepository) GetTicks(ctx context.Context, token string, from, to time.Time) ([]*Tick, error) {
+	return r.find(r.raw, token, from, to), nil
+}
+
+func (r *memoryRepository) GetDownsampledTicks(ctx context.Context, token string, from, to time.Time) ([]*Tick, error) {
+	return r.find(r.downsampled, token, from, to), nil
+}
+
+func (r *memoryRepository) GetFirstDownsampledTick(ctx context.Context, token string) (*Tick, error) {
+	ticks := r.find(r.downsampled, token, time.Time{}, time.Now().AddDate(100, 0, 0))
+	if len(ticks) == 0 {
+		return nil, nil
+	}
+	return ticks[0], nil
+}
+
+func (r *memoryRepository) find(ticks []*Tick, token string, from, to time.Time) []*Tick {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	var out []*Tick
+	for _, tick := range ticks {
+		if tick.Meta.Token == token && !tick.Timestamp.Before(from) && !tick.Timestamp.After(to) {
+			out = append(out, tick)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Timestamp.Before(out[j].Timestamp) })
+
+	return out
+}
+
+func newTestService(repository Repository, charts map[string]*pricefeed.CoinGecko) *service {
+	return &service{
+		repository: repository,
+		charts:     charts,
+		logger:     zap.NewNop().Sugar(),
+		cfg:        config.PriceHistory{DownsampleInterval: time.Hour, RawRetention: 24 * time.Hour, BootstrapDays: 2},
+		markets:    make(map[string]*Market),
+	}
+}
+
+func tickAt(token string, price float64, at time.Time) *Tick {
+	return &Tick{Meta: TickMeta{Token: token}, Price: price, Timestamp: at}
+}
+
+func TestRecordAggregate(t *testing.T) {
+	repository := &memoryRepository{}
+	s := newTestService(repository, nil)
+
+	now := time.Now()
+	require.NoError(t, s.RecordAggregate(context.Background(), "AMB", pricefeed.Aggregate{
+		Price: 0.01, Volume24h: 1000, Sources: []string{"coingecko", "dex"}, UpdatedAt: now, Fresh: true,
+	}))
+
+	require.Len(t, repository.raw, 1)
+	tick := repository.raw[0]
+	assert.Equal(t, TickMeta{Token: "AMB", Source: SourceAggregate}, tick.Meta)
+	assert.Equal(t, 0.01, tick.Price)
+	assert.Equal(t, 1000.0, tick.Volume)
+	assert.Equal(t, []string{"coingecko", "dex"}, tick.Sources)
+	assert.Equal(t, now, tick.Timestamp)
+}
+
+func TestHistory(t *testing.T) {
+	now := time.Now().Truncate(time.Hour)
+
+	tests := []struct {
+		name        string
+		raw         []*Tick
+		downsampled []*Tick
+		from        time.Time
+		wantPrices  []float64
+	}{
+		{
+			name:       "should only read raw ticks inside their retention",
+			raw:        []*Tick{tickAt("AMB", 1, now.Add(-30*time.Minute)), tickAt("AMB", 2, now)},
+			from:       now.Add(-time.Hour),
+			wantPrices: []float64{1, 2},
+		},
+		{
+			name:        "should fill the older part with downsampled ticks",
+			raw:         []*Tick{tickAt("AMB", 3, now.Add(-time.Hour)), tickAt("AMB", 4, now)},
+			downsampled: []*Tick{tickAt("AMB", 1, now.Add(-3*time.Hour)), tickAt("AMB", 2, now.Add(-2*time.Hour)), tickAt("AMB", 9, now.Add(-time.Hour))},
+			from:        now.Add(-4 * time.Hour),
+			wantPrices:  []float64{1, 2, 3, 4},
+		},
+		{
+			name:        "should use downsampled ticks without raw ones",
+			downsampled: []*Tick{tickAt("AMB", 1, now.Add(-3*time.Hour)), tickAt("USDC", 1, now.Add(-3*time.Hour))},
+			from:        now.Add(-4 * time.Hour),
+			wantPrices:  []float64{1},
+		},
+	}
+
+	for _, test := range tests {
+		s := newTestService(&memoryRepository{raw: test.raw, downsampled: test.downsampled}, nil)
+
+		ticks, err := s.History(context.Background(), "AMB", test.from, now)
+		require.NoError(t, err, test.name)
+
+		prices := make([]float64, 0, len(ticks))
+		for _, tick := range ticks {
+			prices = append(prices, tick.Price)
+		}
+		assert.Equal(t, test.wantPrices, prices, test.name)
+	}
+}
+
+func TestPriceAt(t *testing.T) {
+	now := time.Now()
+	s := newTestService(&memoryRepository{
+		raw: []*Tick{tickAt("AMB", 1, now.Add(-90*time.Minute)), tickAt("AMB", 2, now.Add(-30*time.Minute))},
+	}, nil)
+
+	tests := []struct {
+		name   string
+		at     time.Time
+		expect float64
+	}{
+		{name: "should take the last tick before", at: now, expect: 2},
+		{name: "should not take a later tick", at: now.Add(-time.Hour), expect: 1},
+		{name: "should look back two downsample intervals at most", at: now.Add(-4 * time.Hour), expect: 0},
+	}
+
+	for _, test := range tests {
+		price, err := s.PriceAt(context.Background(), "AMB", test.at)
+		require.NoError(t, err, test.name)
+		assert.Equal(t, test.expect, price, test.name)
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	now := time.Now().Truncate(time.Hour)
+	first := now.Add(-2 * time.Hour)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/coins/amber/market_chart", r.URL.Path)
+		assert.Equal(t, "2", r.URL.Query().Get("days"))
+
+		var prices, volumes string
+		for i := 4; i >= 1; i-- {
+			at := now.Add(-time.Duration(i) * time.Hour).UnixMilli()
+			if prices != "" {
+				prices += ","
+				volumes += ","
+			}
+			prices += fmt.Sprintf("[%d,%d]", at, i)
+			volumes += fmt.Sprintf("[%d,%d]", at, i*100)
+		}
+		fmt.Fprintf(w, `{"prices":[%s],"total_volumes":[%s]}`, prices, volumes)
+	}))
+	defer server.Close()
+
+	chart, err := pricefeed.NewCoinGecko(server.URL, "amber")
+	require.NoError(t, err)
+
+	// Our own history starts 2 hours ago, only older chart points are kept
+	repository := &memoryRepository{downsampled: []*Tick{tickAt("AMB", 10, first)}}
+	s := newTestService(repository, map[string]*pricefeed.CoinGecko{"AMB": chart})
+
+	require.NoError(t, s.bootstrap(context.Background(), "AMB"))
+
+	ticks, err := repository.GetDownsampledTicks(context.Background(), "AMB", now.Add(-5*time.Hour), now)
+	require.NoError(t, err)
+	require.Len(t, ticks, 3)
+	assert.Equal(t, 4.0, ticks[0].Price)
+	assert.Equal(t, 400.0, ticks[0].Volume)
+	assert.Equal(t, SourceCoinGecko, ticks[0].Meta.Source)
+	assert.Equal(t, 3.0, ticks[1].Price)
+	assert.Equal(t, 10.0, ticks[2].Price)
+
+	// Tokens without a chart are left alone
+	require.NoError(t, s.bootstrap(context.Background(), "USDC"))
+}
+
+func TestUpdateMarket(t *testing.T) {
+	now := time.Now()
+	repository := &memoryRepository{raw: []*Tick{tickAt("AMB", 1, now.Add(-12*time.Hour)), tickAt("AMB", 2, now.Add(-time.Minute))}}
+	s := newTestService(repository, nil)
+
+	s.updateMarket(context.Background(), "AMB", pricefeed.Aggregate{Price: 2, Sources: []string{"coingecko"}, UpdatedAt: now, Fresh: true})
+
+	market, ok := s.Market("AMB")
+	require.True(t, ok)
+	assert.Equal(t, 2.0, market.High24h)
+	assert.Equal(t, 1.0, market.Low24h)
+	assert.Equal(t, 100.0, market.Change24h)
+	statsUpdatedAt := market.StatsUpdatedAt
+
+	// A stale price keeps the stats of the last fresh one
+	repository.raw = nil
+	s.updateMarket(context.Background(), "AMB", pricefeed.Aggregate{Price: 3, Sources: []string{"dex"}, UpdatedAt: now.Add(time.Minute)})
+
+	market, ok = s.Market("AMB")
+	require.True(t, ok)
+	assert.Equal(t, 3.0, market.Price)
+	assert.False(t, market.Fresh)
+	assert.Equal(t, []string{"dex"}, market.Sources)
+	assert.Equal(t, 2.0, market.High24h)
+	assert.Equal(t, 1.0, market.Low24h)
+	assert.Equal(t, 100.0, market.Change24h)
+	assert.Equal(t, statsUpdatedAt, market.StatsUpdatedAt)
+
+	_, ok = s.Market("USDC")
+	assert.False(t, ok)
+}
+
+func TestMarketReturnsCopy(t *testing.T) {
+	s := newTestService(&memoryRepository{}, nil)
+	s.updateMarket(context.Background(), "AMB", pricefeed.Aggregate{Price: 2, Sources: []string{"coingecko"}})
+
+	market, ok := s.Market("AMB")
+	require.True(t, ok)
+	market.Price = 10
+	market.Sources[0] = "changed"
+
+	market, ok = s.Market("AMB")
+	require.True(t, ok)
+	assert.Equal(t, 2.0, market.Price)
+	assert.Equal(t, []string{"coingecko"}, market.Sources)
+
+	converted := market.Convert("EUR", 0.5)
+	converted.Sources[0] = "changed"
+	assert.Equal(t, []string{"coingecko"}, market.Sources)
+}
+
+func TestPricesAt(t *testing.T) {
+	now := time.Now()
+	s := newTestService(&memoryRepository{
+		raw: []*Tick{tickAt("AMB", 1, now.Add(-90*time.Minute)), tickAt("AMB", 2, now.Add(-30*time.Minute))},
+	}, nil)
+
+	// The same answers as PriceAt, from one query
+	prices, err := s.PricesAt(context.Background(), "AMB", []time.Time{now, now.Add(-4 * time.Hour), now.Add(-time.Hour)})
+	require.NoError(t, err)
+	assert.Equal(t, []float64{2, 0, 1}, prices)
+
+	prices, err = s.PricesAt(context.Background(), "AMB", nil)
+	require.NoError(t, err)
+	assert.Empty(t, prices)
+}
@@ -0,0 +1,38 @@
+package price
+
+import "time"
+
+const (
+	DefaultToken = "AMB"
+
+	SourceAggregate  = "aggregate"
+	SourceDownsample = "downsample"
+	SourceCoinGecko  = "coingecko"
+)
+
+type TickMeta struct {
+	Token  string `json:"token" bson:"token"`
+	Source string `json:"source" bson:"source"`
+}
+
+// Tick is one price point. Raw ticks only carry Price; downsampled ticks
+// also carry the open, high and low of their bucket and Price is the close.
+// Volume is the rolling 24h volume at that time.
+type Tick struct {
+	Meta      TickMeta  `json:"meta" bson:"meta"`
+	Price     float64   `json:"price" bson:"price"`
+	Open      float64   `json:"open,omitempty" bson:"open,omitempty"`
+	High      float64   `json:"high,omitempty" bson:"high,omitempty"`
+	Low       float64   `json:"low,omitempty" bson:"low,omitempty"`
+	Volume    float64   `json:"volume,omitempty" bson:"volume,omitempty"`
+	Sources   []string  `json:"sources,omitempty" bson:"sources,omitempty"`
+	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
+}
+
+func (t *Tick) ohlc() (float64, float64, float64, float64) {
+	if t.Open == 0 {
+		return t.Price, t.Price, t.Price, t.Price
+	}
+
+	return t.Open, t.High, t.Low, t.Price
+}
@@ -0,0 +1,104 @@
+package watcher
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"airdao-mobile-api/pkg/ethsig"
+)
+
+// addressChallengeTTL is how long the wallet has to sign a challenge
+const addressChallengeTTL = 10 * time.Minute
+
+func addressChallengeMessage(address, nonce string, issuedAt, expiresAt time.Time) string {
+	return fmt.Sprintf("AirDAO Mobile wants you to prove you own %s.\n\nThis request will not trigger a transaction or cost any gas.\n\nNonce: %s\nIssued At: %s\nExpiration Time: %s",
+		address, nonce, issuedAt.UTC().Format(time.RFC3339), expiresAt.UTC().Format(time.RFC3339))
+}
+
+// CreateAddressChallenge issues the EIP-191 message the wallet of a watched
+// address signs to prove ownership. A new challenge replaces the previous one.
+func (s *service) CreateAddressChallenge(ctx context.Context, device Device, address string) (*AddressChallenge, error) {
+	watcher, err := s.GetWatcher(ctx, device)
+	if err != nil {
+		return nil, err
+	}
+
+	defer s.watcherLocks.Lock(watcher)()
+
+	watched := watcher.GetAddress(address)
+	if watched == nil {
+		return nil, ErrAddressNotWatched
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	nonce := hex.EncodeToString(b)
+	expiresAt := now.Add(addressChallengeTTL)
+	watched.Challenge = &AddressChallenge{
+		Address:   address,
+		Nonce:     nonce,
+		Message:   addressChallengeMessage(address, nonce, now, expiresAt),
+		ExpiresAt: expiresAt,
+	}
+	watcher.UpdatedAt = now
+
+	if err := s.repository.UpdateWatcher(ctx, watcher); err != nil {
+		s.logger.Errorf("CreateAddressChallenge repository.UpdateWatcher error %v\n", err)
+		return nil, err
+	}
+
+	return watched.Challenge, nil
+}
+
+// VerifyAddress recovers the signer of the pending challenge and marks the
+// address verified when it matches. A challenge can only be answered once.
+func (s *service) VerifyAddress(ctx context.Context, device Device, address string, signature string) (*Address, error) {
+	watcher, err := s.GetWatcher(ctx, device)
+	if err != nil {
+		return nil, err
+	}
+
+	defer s.watcherLocks.Lock(watcher)()
+
+	watched := watcher.GetAddress(address)
+	if watched == nil {
+		return nil, ErrAddressNotWatched
+	}
+
+	challenge := watched.Challenge
+	if challenge == nil {
+		return nil, ErrNoAddressChallenge
+	}
+
+	now := time.Now()
+	if now.After(challenge.ExpiresAt) {
+		return nil, ErrAddressChallengeExpired
+	}
+
+	ok, err := ethsig.Verify(address, challenge.Message, signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	if !ok {
+		return nil, ErrAddressSignerMismatch
+	}
+
+	watched.Verified = true
+	watched.VerifiedAt = &now
+	watched.Challenge = nil
+	watcher.UpdatedAt = now
+
+	if err := s.repository.UpdateWatcher(ctx, watcher); err != nil {
+		s.logger.Errorf("VerifyAddress repository.UpdateWatcher error %v\n", err)
+		return nil, err
+	}
+
+	return watched, nil
+}
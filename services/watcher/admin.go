@@ -0,0 +1,353 @@
+package watcher
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WatcherQuery selects watchers for the admin API. Set fields are all
+// matched; PushToken is the raw FCM token, Address matches case-insensitively.
+type WatcherQuery struct {
+	DeviceId  string
+	Address   string
+	PushToken string
+}
+
+// WatcherState is what the service holds in memory and in the explorer
+// subscriptions for one watcher.
+type WatcherState struct {
+	Watcher     *Watcher         `json:"watcher"`
+	Cached      bool             `json:"cached"`
+	PriceAlerts int              `json:"price_alerts"`
+	Credential  *CredentialState `json:"credential"`
+	Addresses   []*AddressState  `json:"addresses"`
+	Heartbeat   *HeartbeatStatus `json:"heartbeat"`
+}
+
+// CredentialState describes the device credential without its hashes.
+type CredentialState struct {
+	Active           bool      `json:"active"`
+	Version          int       `json:"version"`
+	IssuedAt         time.Time `json:"issued_at"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+type AddressState struct {
+	Address  string  `json:"address"`
+	Verified bool    `json:"verified"`
+	LastTx   *string `json:"last_tx"`
+	// Watched is set when tx callbacks of the address reach this watcher
+	Watched  bool `json:"watched"`
+	Watchers int  `json:"watchers"`
+	// Subscribed is the stored explorer subscription of the address
+	Subscribed    bool       `json:"subscribed"`
+	SubscribedAt  *time.Time `json:"subscribed_at"`
+	BalanceCached bool       `json:"balance_cached"`
+}
+
+type DailyNotifications struct {
+	Day    string `json:"day" bson:"_id"`
+	Sent   int64  `json:"sent" bson:"sent"`
+	Failed int64  `json:"failed" bson:"failed"`
+}
+
+// Stats counts watchers, watched addresses and notifications per day.
+type Stats struct {
+	Watchers         int64                 `json:"watchers"`
+	DisabledWatchers int64                 `json:"disabled_watchers"`
+	Addresses        int                   `json:"addresses"`
+	CachedWatchers   int                   `json:"cached_watchers"`
+	CachedAddresses  int                   `json:"cached_addresses"`
+	PriceAlerts      int                   `json:"price_alerts"`
+	Notifications    []*DailyNotifications `json:"notifications"`
+}
+
+// SearchWatchers returns one page of the watchers matching query.
+func (s *service) SearchWatchers(ctx context.Context, query WatcherQuery, page int) ([]*Watcher, error) {
+	filters := bson.M{}
+	if query.DeviceId != "" {
+		filters["device_id"] = query.DeviceId
+	}
+	if query.PushToken != "" {
+		filters["push_token"] = base64.StdEncoding.EncodeToString([]byte(query.PushToken))
+	}
+	if query.Address != "" {
+		filters["addresses.address"] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(query.Address) + "$", Options: "i"}
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	watchers, err := s.repository.GetWatcherList(ctx, filters, page)
+	if err != nil {
+		s.logger.Errorf("SearchWatchers repository.GetWatcherList error %v\n", err)
+		return nil, err
+	}
+
+	for i, watcher := range watchers {
+		watchers[i] = s.cachedInstance(watcher)
+	}
+	if watchers == nil {
+		watchers = []*Watcher{}
+	}
+
+	return watchers, nil
+}
+
+func (s *service) GetWatcherById(ctx context.Context, id string) (*Watcher, error) {
+	watcher, err := s.getWatcherById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if watcher == nil {
+		return nil, ErrWatcherNotFound
+	}
+
+	return watcher, nil
+}
+
+func (s *service) GetWatcherState(ctx context.Context, id string) (*WatcherState, error) {
+	watcher, err := s.GetWatcherById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer s.watcherLocks.Lock(watcher)()
+
+	state := &WatcherState{
+		Watcher:     watcher,
+		PriceAlerts: len(s.alertIndex.Entries(watcher.PushToken)),
+		Addresses:   make([]*AddressState, 0),
+		Heartbeat:   s.HeartbeatStatus(),
+	}
+	if watcher.Credential != nil {
+		state.Credential = &CredentialState{
+			Active:           watcher.Credential.Active(),
+			Version:          watcher.Credential.Version,
+			IssuedAt:         watcher.Credential.IssuedAt,
+			RefreshExpiresAt: watcher.Credential.RefreshExpiresAt,
+		}
+	}
+
+	s.mx.RLock()
+	_, state.Cached = s.cachedWatcher[watcher.PushToken]
+	s.mx.RUnlock()
+
+	if watcher.Addresses == nil {
+		return state, nil
+	}
+
+	addresses := make([]string, 0, len(*watcher.Addresses))
+	for _, address := range *watcher.Addresses {
+		addresses = append(addresses, address.Address)
+	}
+
+	subscriptions, err := s.subscriptionRepository.GetSubscriptions(ctx, addresses)
+	if err != nil {
+		s.logger.Errorf("GetWatcherState subscriptionRepository.GetSubscriptions error %v\n", err)
+		return nil, err
+	}
+
+	s.mx.RLock()
+	for _, address := range *watcher.Addresses {
+		item := &AddressState{Address: address.Address, Verified: address.Verified, LastTx: address.LastTx}
+		if items, ok := s.cachedWatcherByAddress[address.Address]; ok && items != nil {
+			_, item.Watched = items.watchers[watcher.PushToken]
+			item.Watchers = len(items.watchers)
+		}
+		if subscribedAt, ok := subscriptions[address.Address]; ok {
+			item.Subscribed = true
+			item.SubscribedAt = &subscribedAt
+		}
+		_, item.BalanceCached = s.balanceCache.Get(address.Address)
+		state.Addresses = append(state.Addresses, item)
+	}
+	s.mx.RUnlock()
+
+	return state, nil
+}
+
+// ResubscribeAddress sends the explorer subscription of a watched address
+// again, whatever the stored subscriptions say.
+func (s *service) ResubscribeAddress(ctx context.Context, address string) error {
+	if len(s.addressWatchers(address)) == 0 {
+		return ErrAddressNotWatched
+	}
+
+	if err := s.subscribe(ctx, []string{address}); err != nil {
+		s.logger.Errorf("ResubscribeAddress subscribe error %v\n", err)
+		return fmt.Errorf("%w: %v", ErrExplorerUnavailable, err)
+	}
+
+	return nil
+}
+
+// BackfillAddress notifies the watchers of one address about the txs they
+// missed. It doesn't run next to a full Backfill, which would notify twice.
+func (s *service) BackfillAddress(ctx context.Context, address string) error {
+	watchers := s.addressWatchers(address)
+	if len(watchers) == 0 {
+		return ErrAddressNotWatched
+	}
+
+	if !atomic.CompareAndSwapInt32(&s.backfilling, 0, 1) {
+		return ErrBackfillInProgress
+	}
+	defer atomic.StoreInt32(&s.backfilling, 0)
+
+	if err := s.backfillAddress(ctx, address, watchers); err != nil {
+		s.logger.Errorf("BackfillAddress backfillAddress error %v\n", err)
+		return fmt.Errorf("%w: %v", ErrExplorerUnavailable, err)
+	}
+
+	return nil
+}
+
+// SetWatcherDisabled stops or resumes the notifications of a watcher. The
+// addresses nobody else watches are unsubscribed while it is disabled.
+func (s *service) SetWatcherDisabled(ctx context.Context, id string, disabled bool) (*Watcher, error) {
+	watcher, err := s.GetWatcherById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer s.watcherLocks.Lock(watcher)()
+	if watcher.Disabled == disabled {
+		return watcher, nil
+	}
+
+	previousAt := watcher.DisabledAt
+	watcher.SetDisabled(disabled, time.Now())
+	if err := s.repository.UpdateWatcher(ctx, watcher); err != nil {
+		watcher.Disabled, watcher.DisabledAt = !disabled, previousAt
+		return nil, err
+	}
+
+	var addresses []string
+	if watcher.Addresses != nil {
+		for _, address := range *watcher.Addresses {
+			addresses = append(addresses, address.Address)
+		}
+	}
+
+	if disabled {
+		s.indexPriceAlerts(watcher)
+		if err := s.unsubscribe(ctx, s.removeWatcherForAddresses(addresses, watcher)); err != nil {
+			s.logger.Errorf("SetWatcherDisabled unsubscribe error %v\n", err)
+		}
+
+		return watcher, nil
+	}
+
+	s.mx.Lock()
+	s.cachedWatcher[watcher.PushToken] = watcher
+	s.mx.Unlock()
+
+	s.startWatching(watcher)
+	if err := s.subscribe(ctx, addresses); err != nil {
+		s.logger.Errorf("SetWatcherDisabled subscribe error %v\n", err)
+	}
+
+	return watcher, nil
+}
+
+// ResendNotification pushes the notification at index of the watcher history
+// again and records the new attempt.
+func (s *service) ResendNotification(ctx context.Context, id string, index int) (*HistoryNotification, error) {
+	watcher, err := s.GetWatcherById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer s.watcherLocks.Lock(watcher)()
+	if watcher.Disabled {
+		return nil, ErrWatcherDisabled
+	}
+	if watcher.HistoricalNotifications == nil || index < 0 || index >= len(*watcher.HistoricalNotifications) {
+		return nil, ErrNotificationNotFound
+	}
+	original := (*watcher.HistoricalNotifications)[index]
+
+	decodedPushToken, err := base64.StdEncoding.DecodeString(watcher.PushToken)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{"type": "resent-notification"}
+	response, err := s.cloudMessagingSvc.SendMessage(ctx, original.Title, original.Body, string(decodedPushToken), data)
+	if err != nil {
+		s.logger.Errorf("ResendNotification cloudMessagingSvc.SendMessage error %v\n", err)
+	}
+
+	watcher.AddNotification(original.Title, original.Body, response != nil, time.Now())
+	if err := s.repository.UpdateWatcher(ctx, watcher); err != nil {
+		return nil, err
+	}
+
+	return (*watcher.HistoricalNotifications)[len(*watcher.HistoricalNotifications)-1], nil
+}
+
+// GetStats counts the notifications of the last days, today included.
+func (s *service) GetStats(ctx context.Context, days int) (*Stats, error) {
+	watchers, err := s.repository.CountWatchers(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	disabled, err := s.repository.CountWatchers(ctx, bson.M{"disabled": true})
+	if err != nil {
+		return nil, err
+	}
+
+	addresses, err := s.repository.GetWatchedAddresses(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1-days)
+	notifications, err := s.repository.GetNotificationsPerDay(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{
+		Watchers:         watchers,
+		DisabledWatchers: disabled,
+		Addresses:        len(addresses),
+		PriceAlerts:      s.alertIndex.Len(),
+		Notifications:    notifications,
+	}
+
+	s.mx.RLock()
+	stats.CachedWatchers = len(s.cachedWatcher)
+	stats.CachedAddresses = len(s.cachedWatcherByAddress)
+	s.mx.RUnlock()
+
+	return stats, nil
+}
+
+// addressWatchers returns the watchers receiving the txs of address.
+func (s *service) addressWatchers(address string) []*Watcher {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	items, ok := s.cachedWatcherByAddress[address]
+	if !ok || items == nil {
+		return nil
+	}
+
+	watchers := make([]*Watcher, 0, len(items.watchers))
+	for _, watcher := range items.watchers {
+		watchers = append(watchers, watcher)
+	}
+
+	return watchers
+}
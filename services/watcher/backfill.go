@@ -0,0 +1,209 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// Backfill notifies watchers about txs that happened while callbacks were not
+// delivered (service restart, explorer reconnect). For every watched address
+// the explorer history is paged back to the LastTx each watcher has seen.
+func (s *service) Backfill(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&s.backfilling, 0, 1) {
+		return nil
+	}
+	defer atomic.StoreInt32(&s.backfilling, 0)
+
+	s.mx.RLock()
+	addresses := make(map[string][]*Watcher, len(s.cachedWatcherByAddress))
+	for address, items := range s.cachedWatcherByAddress {
+		for _, watcher := range items.watchers {
+			addresses[address] = append(addresses[address], watcher)
+		}
+	}
+	s.mx.RUnlock()
+
+	for address, watchers := range addresses {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := s.backfillAddress(ctx, address, watchers); err != nil {
+			s.logger.Errorf("Backfill backfillAddress %s error %v\n", address, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *service) backfillAddress(ctx context.Context, address string, addressWatchers []*Watcher) error {
+	// Only watchers that saw at least one tx have a point to backfill from.
+	// Watchers whose LastTx moves while the history is fetched were caught up
+	// by the callbacks and are left alone.
+	lastTxs := make(map[*Watcher]string)
+	wanted := make(map[string]bool)
+	for _, watcher := range addressWatchers {
+		unlock := s.watcherLocks.Lock(watcher)
+		if lastTx := watcher.LastTxFor(address); lastTx != nil {
+			lastTxs[watcher] = *lastTx
+			wanted[*lastTx] = false
+		}
+		unlock()
+	}
+	if len(wanted) == 0 {
+		return nil
+	}
+
+	txs, err := backfillTxs(wanted, s.backfillCfg.MaxPages, func(page int) ([]Tx, error) {
+		var apiAddressData *ApiAddressData
+		url := fmt.Sprintf("%s/addresses/%s/all?page=%d&limit=%d", s.explorerUrl, address, page, s.backfillCfg.PageSize)
+		if err := s.doRequest(url, nil, &apiAddressData); err != nil {
+			return nil, err
+		}
+		if apiAddressData == nil {
+			return nil, nil
+		}
+		return apiAddressData.Data, nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(txs) == 0 {
+		return nil
+	}
+
+	s.mx.RLock()
+	items := s.cachedWatcherByAddress[address]
+	s.mx.RUnlock()
+
+	for watcher, lastTx := range lastTxs {
+		s.backfillWatcher(ctx, watcher, items, address, lastTx, txs)
+	}
+
+	return nil
+}
+
+// backfillWatcher sends the txs after lastTx to the watcher, skipping those
+// the callbacks already notified, and moves its LastTx to the newest tx. A
+// failed send leaves LastTx at the last tx sent, so the next backfill picks
+// up the rest.
+func (s *service) backfillWatcher(ctx context.Context, watcher *Watcher, items *watchers, address, lastTx string, txs []Tx) {
+	defer s.watcherLocks.Lock(watcher)()
+
+	if current := watcher.LastTxFor(address); current == nil || *current != lastTx || lastTx == txs[0].Hash {
+		return
+	}
+
+	var missed []*Tx
+	all, reachedLastTx := missedTxs(txs, lastTx)
+	for _, tx := range all {
+		if !s.txCache.Has(tx.Hash + watcher.PushToken) {
+			missed = append(missed, tx)
+		}
+	}
+
+	newest := txs[0].Hash
+	if watcher.TxNotification == ON && len(missed) > 0 {
+		if backfillSummary(len(missed), reachedLastTx, s.backfillCfg.SummaryThreshold) {
+			title, body, data := txSummaryNotificationMessage(address, len(missed), !reachedLastTx)
+			if err := s.sendTxNotification(ctx, watcher, items, title, body, data); err != nil {
+				s.logger.Errorf("backfillWatcher sendTxNotification error %v\n", err)
+				return
+			}
+			for _, tx := range missed {
+				s.txCache.Add(tx.Hash + watcher.PushToken)
+			}
+		} else {
+			// Oldest first, the same order the callbacks would have come in
+			for i := len(missed) - 1; i >= 0; i-- {
+				currency := watcher.DisplayCurrency()
+				title, body, data := txNotificationMessage(missed[i], currency, s.txFiatValue(missed[i], address, currency))
+				if err := s.sendTxNotification(ctx, watcher, items, title, body, data); err != nil {
+					s.logger.Errorf("backfillWatcher sendTxNotification error %v\n", err)
+					if i == len(missed)-1 {
+						return
+					}
+					newest = missed[i+1].Hash
+					break
+				}
+				s.txCache.Add(missed[i].Hash + watcher.PushToken)
+			}
+		}
+	}
+
+	watcher.SetLastTx(address, newest)
+
+	if err := s.repository.UpdateWatcher(ctx, watcher); err != nil {
+		s.logger.Errorf("backfillWatcher repository.UpdateWatcher error %v\n", err)
+	}
+}
+
+// backfillTxs pages the explorer history, newest first, until every wanted
+// tx was seen, a page comes back empty or maxPages were read.
+func backfillTxs(wanted map[string]bool, maxPages int, fetch func(page int) ([]Tx, error)) ([]Tx, error) {
+	seen := make(map[string]bool, len(wanted))
+
+	var txs []Tx
+	for page := 1; page <= maxPages; page++ {
+		data, err := fetch(page)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			break
+		}
+
+		for _, tx := range data {
+			txs = append(txs, tx)
+			if _, ok := wanted[tx.Hash]; ok {
+				seen[tx.Hash] = true
+			}
+		}
+		if len(seen) == len(wanted) {
+			break
+		}
+	}
+
+	return txs, nil
+}
+
+// missedTxs returns the txs with a value newer than lastTx, newest first,
+// and whether lastTx was found in txs at all.
+func missedTxs(txs []Tx, lastTx string) ([]*Tx, bool) {
+	missed := make([]*Tx, 0)
+	for i := range txs {
+		if txs[i].Hash == lastTx {
+			return missed, true
+		}
+		if txs[i].Value.Ether != 0 {
+			missed = append(missed, &txs[i])
+		}
+	}
+
+	return missed, false
+}
+
+// backfillSummary tells whether missed txs go out as one summary: when there
+// are more than threshold of them, or more than the pages read could show.
+func backfillSummary(missed int, reachedLastTx bool, threshold int) bool {
+	return !reachedLastTx || missed > threshold
+}
+
+func txSummaryNotificationMessage(address string, count int, more bool) (string, string, map[string]interface{}) {
+	cutAddress := address
+	if len(address) > 10 {
+		cutAddress = fmt.Sprintf("%s...%s", address[:5], address[len(address)-5:])
+	}
+
+	countText := fmt.Sprintf("%d", count)
+	if more {
+		countText += "+"
+	}
+
+	title := "AMB-Net Tx Alert"
+	body := fmt.Sprintf("%s new transactions on %s while notifications were unavailable", countText, cutAddress)
+	data := map[string]interface{}{"type": "transaction-summary", "address": cutAddress, "count": count}
+
+	return title, body, data
+}
@@ -0,0 +1,167 @@
+package watcher
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"airdao-mobile-api/config"
+	cloudmessaging "airdao-mobile-api/pkg/firebase/cloud-messaging"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func backfillTx(hash string, value float64) Tx {
+	tx := Tx{Hash: hash}
+	tx.Value.Ether = value
+	return tx
+}
+
+func TestBackfillTxs(t *testing.T) {
+	pages := [][]Tx{
+		{backfillTx("0x6", 1), backfillTx("0x5", 1)},
+		{backfillTx("0x4", 1), backfillTx("0x3", 1)},
+		{backfillTx("0x2", 1), backfillTx("0x1", 1)},
+	}
+
+	tests := []struct {
+		name      string
+		wanted    []string
+		maxPages  int
+		wantPages int
+		wantTxs   int
+	}{
+		{name: "should stop at the page with the last tx", wanted: []string{"0x4"}, maxPages: 5, wantPages: 2, wantTxs: 4},
+		{name: "should page until every last tx was seen", wanted: []string{"0x5", "0x1"}, maxPages: 5, wantPages: 3, wantTxs: 6},
+		{name: "should stop at max pages", wanted: []string{"0x1"}, maxPages: 2, wantPages: 2, wantTxs: 4},
+		{name: "should stop at an empty page", wanted: []string{"0x0"}, maxPages: 5, wantPages: 4, wantTxs: 6},
+	}
+
+	for _, test := range tests {
+		wanted := make(map[string]bool)
+		for _, hash := range test.wanted {
+			wanted[hash] = false
+		}
+
+		fetched := 0
+		txs, err := backfillTxs(wanted, test.maxPages, func(page int) ([]Tx, error) {
+			fetched++
+			if page > len(pages) {
+				return nil, nil
+			}
+			return pages[page-1], nil
+		})
+		require.NoError(t, err, test.name)
+		assert.Equal(t, test.wantPages, fetched, test.name)
+		assert.Len(t, txs, test.wantTxs, test.name)
+	}
+
+	_, err := backfillTxs(map[string]bool{"0x1": false}, 5, func(page int) ([]Tx, error) {
+		return nil, errors.New("explorer unavailable")
+	})
+	assert.Error(t, err)
+}
+
+func TestMissedTxs(t *testing.T) {
+	txs := []Tx{backfillTx("0x4", 1), backfillTx("0x3", 0), backfillTx("0x2", 2), backfillTx("0x1", 1)}
+
+	tests := []struct {
+		name        string
+		lastTx      string
+		wantHashes  []string
+		wantReached bool
+	}{
+		{name: "should stop at the last tx and skip txs without value", lastTx: "0x1", wantHashes: []string{"0x4", "0x2"}, wantReached: true},
+		{name: "should have nothing when the last tx is the newest", lastTx: "0x4", wantHashes: []string{}, wantReached: true},
+		{name: "should report a last tx older than the pages read", lastTx: "0x0", wantHashes: []string{"0x4", "0x2", "0x1"}, wantReached: false},
+	}
+
+	for _, test := range tests {
+		missed, reached := missedTxs(txs, test.lastTx)
+
+		hashes := make([]string, 0, len(missed))
+		for _, tx := range missed {
+			hashes = append(hashes, tx.Hash)
+		}
+		assert.Equal(t, test.wantHashes, hashes, test.name)
+		assert.Equal(t, test.wantReached, reached, test.name)
+	}
+}
+
+func TestBackfillSummary(t *testing.T) {
+	tests := []struct {
+		name    string
+		missed  int
+		reached bool
+		expect  bool
+	}{
+		{name: "should send txs one by one up to the threshold", missed: 5, reached: true, expect: false},
+		{name: "should summarize above the threshold", missed: 6, reached: true, expect: true},
+		{name: "should summarize when the last tx wasn't reached", missed: 1, reached: false, expect: true},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expect, backfillSummary(test.missed, test.reached, 5), test.name)
+	}
+}
+
+type backfillMessaging struct {
+	cloudmessaging.Service
+
+	sent int
+}
+
+func (m *backfillMessaging) SendMessage(ctx context.Context, title, body, pushToken string, data map[string]interface{}) (*string, error) {
+	m.sent++
+	id := "id"
+	return &id, nil
+}
+
+type backfillRepository struct {
+	Repository
+
+	updates int
+}
+
+func (r *backfillRepository) UpdateWatcher(ctx context.Context, watcher *Watcher) error {
+	r.updates++
+	return nil
+}
+
+func TestBackfillWatcher(t *testing.T) {
+	txs := []Tx{backfillTx("0x3", 1), backfillTx("0x2", 2), backfillTx("0x1", 1)}
+
+	tests := []struct {
+		name      string
+		pushToken string
+
+		wantSent    int
+		wantLastTx  string
+		wantUpdates int
+	}{
+		{name: "should send the missed txs and move to the newest", pushToken: base64.StdEncoding.EncodeToString([]byte("push-token")), wantSent: 2, wantLastTx: "0x3", wantUpdates: 1},
+		{name: "should keep the last tx when nothing was sent", pushToken: "not base64", wantLastTx: "0x1"},
+	}
+
+	for _, test := range tests {
+		messaging := &backfillMessaging{}
+		repository := &backfillRepository{}
+		s := newAlertService(t, time.Now(), 0.02, nil, messaging, repository)
+		s.txCache = NewTxCache(time.Minute)
+		s.backfillCfg = config.Backfill{SummaryThreshold: 5}
+
+		watcher := &Watcher{PushToken: test.pushToken, TxNotification: ON}
+		watcher.AddAddress("0xaddress")
+		watcher.SetLastTx("0xaddress", "0x1")
+
+		s.backfillWatcher(context.Background(), watcher, nil, "0xaddress", "0x1", txs)
+
+		assert.Equal(t, test.wantSent, messaging.sent, test.name)
+		require.NotNil(t, watcher.LastTxFor("0xaddress"), test.name)
+		assert.Equal(t, test.wantLastTx, *watcher.LastTxFor("0xaddress"), test.name)
+		assert.Equal(t, test.wantUpdates, repository.updates, test.name)
+	}
+}
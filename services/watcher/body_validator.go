@@ -1,23 +1,33 @@
 package watcher
 
 import (
-	"errors"
-	"fmt"
+	"reflect"
 	"strings"
 
+	"airdao-mobile-api/pkg/apierror"
+
 	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
 )
 
 func msgForTag(tag string) string {
 	switch tag {
 	case "required":
 		return "is required"
-	case "addresses":
+	case "addresses", "address":
 		return "incorrect address"
+	case "signature":
+		return "incorrect signature"
 	case "threshold":
 		return "incorrect threshold (can be 5, 8 or 10)"
 	case "notification":
 		return "incorrect notification (can be on or off)"
+	case "oneof":
+		return "incorrect value"
+	case "gt":
+		return "must be greater than zero"
+	case "currency":
+		return "incorrect currency (ISO 4217 code like USD or EUR)"
 	}
 	return ""
 }
@@ -38,6 +48,16 @@ func Validate(data interface{}) error {
 		return true
 	})
 
+	_ = validate.RegisterValidation("address", func(fl validator.FieldLevel) bool {
+		addressBytes := HexToBytes(fl.Field().String())
+		return addressBytes != nil && len(addressBytes) == 20
+	})
+
+	_ = validate.RegisterValidation("signature", func(fl validator.FieldLevel) bool {
+		signatureBytes := HexToBytes(fl.Field().String())
+		return signatureBytes != nil && len(signatureBytes) == 65
+	})
+
 	_ = validate.RegisterValidation("threshold", func(fl validator.FieldLevel) bool {
 		threshold := fl.Field().Int()
 
@@ -58,17 +78,41 @@ func Validate(data interface{}) error {
 		return false
 	})
 
+	_ = validate.RegisterValidation("currency", func(fl validator.FieldLevel) bool {
+		currency := fl.Field().String()
+
+		if len(currency) != 3 {
+			return false
+		}
+		for _, c := range currency {
+			if c < 'A' || c > 'Z' {
+				return false
+			}
+		}
+
+		return true
+	})
+
+	// Fields are reported by their JSON names, as clients know them
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "" || name == "-" {
+			return field.Name
+		}
+		return name
+	})
+
 	if err := validate.Struct(data); err != nil {
 		if _, ok := err.(*validator.InvalidValidationError); ok {
-			return errors.New("invalid request body")
+			return apierror.New(fiber.StatusBadRequest, apierror.CodeInvalidBody, "invalid request body")
 		}
 
-		var out []string
+		var fields []apierror.FieldError
 		for _, err := range err.(validator.ValidationErrors) {
-			out = append(out, fmt.Sprintf("%s - %s", err.Field(), msgForTag(err.Tag())))
+			fields = append(fields, apierror.FieldError{Field: err.Field(), Rule: err.Tag(), Message: msgForTag(err.Tag())})
 		}
 
-		return errors.New(strings.Join(out, ", "))
+		return apierror.Validation(fields)
 	}
 
 	return nil
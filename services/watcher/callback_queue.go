@@ -0,0 +1,261 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"airdao-mobile-api/pkg/apierror"
+	"airdao-mobile-api/pkg/metrics"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const (
+	// callbackRetries is how often a failed item is queued again before it
+	// is left pending in its batch until the next Start
+	callbackRetries    = 3
+	callbackRetryDelay = time.Second
+)
+
+var ErrCallbackQueueFull = apierror.New(fiber.StatusServiceUnavailable, "callback_queue_full", "callback queue is full")
+
+//go:generate mockgen -source=callback_queue.go -destination=mocks/callback_queue_mock.go
+type CallbackQueue interface {
+	Start(ctx context.Context) error
+	Enqueue(ctx context.Context, items []WatcherCallbackItem) (string, error)
+}
+
+type callbackJob struct {
+	batchId primitive.ObjectID
+	index   int
+	address string
+	txHash  string
+	// attempts counts the failed runs of the job
+	attempts int
+}
+
+// callbackQueue persists every callback batch and hands its items to a fixed
+// set of workers. Items are sharded by address, so each address is always
+// processed by the same worker and in the order it was received.
+type callbackQueue struct {
+	service    Service
+	repository CallbackRepository
+	logger     *zap.SugaredLogger
+
+	mx     sync.Mutex
+	shards []chan *callbackJob
+
+	enqueued  *metrics.Counter
+	processed *metrics.Counter
+	failed    *metrics.Counter
+	rejected  *metrics.Counter
+	depth     *metrics.Gauge
+}
+
+func NewCallbackQueue(
+	service Service,
+	repository CallbackRepository,
+	logger *zap.SugaredLogger,
+	registry *metrics.Registry,
+	workers int,
+	queueSize int,
+) (CallbackQueue, error) {
+	if service == nil {
+		return nil, errors.New("[callback_queue] invalid watcher service")
+	}
+	if repository == nil {
+		return nil, errors.New("[callback_queue] invalid repository")
+	}
+	if logger == nil {
+		return nil, errors.New("[callback_queue] invalid logger")
+	}
+	if registry == nil {
+		return nil, errors.New("[callback_queue] invalid metrics registry")
+	}
+	if workers <= 0 {
+		return nil, errors.New("[callback_queue] invalid workers count")
+	}
+	if queueSize <= 0 {
+		return nil, errors.New("[callback_queue] invalid queue size")
+	}
+
+	shards := make([]chan *callbackJob, workers)
+	for i := range shards {
+		shards[i] = make(chan *callbackJob, queueSize)
+	}
+
+	return &callbackQueue{
+		service:    service,
+		repository: repository,
+		logger:     logger,
+
+		shards: shards,
+
+		enqueued:  registry.Counter("callback_queue_enqueued_total"),
+		processed: registry.Counter("callback_queue_processed_total"),
+		failed:    registry.Counter("callback_queue_failed_total"),
+		rejected:  registry.Counter("callback_queue_rejected_total"),
+		depth:     registry.Gauge("callback_queue_depth"),
+	}, nil
+}
+
+// Start runs the workers and re-queues items of batches that were persisted
+// but not finished before the previous shutdown. It returns once every
+// recovered item is in its shard, so callbacks enqueued afterwards come
+// after them.
+func (q *callbackQueue) Start(ctx context.Context) error {
+	batches, err := q.repository.GetPendingBatches(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, shard := range q.shards {
+		go q.work(ctx, shard)
+	}
+
+	for _, batch := range batches {
+		for i, item := range batch.Items {
+			if item.Done {
+				continue
+			}
+
+			job := &callbackJob{batchId: batch.ID, index: i, address: item.Address, txHash: item.TxHash}
+			if !q.pushWhenFree(ctx, job) {
+				return ctx.Err()
+			}
+		}
+	}
+
+	return nil
+}
+
+// Enqueue stores the batch and queues its items. If any worker has no room
+// for its share of the batch nothing is stored and ErrCallbackQueueFull is
+// returned, so the explorer retries the whole batch later.
+func (q *callbackQueue) Enqueue(ctx context.Context, items []WatcherCallbackItem) (string, error) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	needed := make(map[chan *callbackJob]int)
+	for _, item := range items {
+		needed[q.shardFor(item.Address)]++
+	}
+	for shard, n := range needed {
+		if cap(shard)-len(shard) < n {
+			q.rejected.Inc()
+			return "", ErrCallbackQueueFull
+		}
+	}
+
+	batch := &CallbackBatch{
+		ID:        primitive.NewObjectID(),
+		Items:     make([]*CallbackBatchItem, 0, len(items)),
+		CreatedAt: time.Now(),
+	}
+	for _, item := range items {
+		batch.Items = append(batch.Items, &CallbackBatchItem{Address: item.Address, TxHash: item.TxHash})
+	}
+
+	if err := q.repository.CreateBatch(ctx, batch); err != nil {
+		return "", err
+	}
+
+	// Every shard was checked for room above and all writers hold q.mx, so
+	// these sends don't block
+	for i, item := range items {
+		q.push(&callbackJob{batchId: batch.ID, index: i, address: item.Address, txHash: item.TxHash})
+	}
+
+	return batch.ID.Hex(), nil
+}
+
+func (q *callbackQueue) tryPush(job *callbackJob) bool {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	shard := q.shardFor(job.address)
+	if len(shard) == cap(shard) {
+		return false
+	}
+	q.push(job)
+
+	return true
+}
+
+// pushWhenFree waits for room in the shard of the job. It reports false
+// when ctx is done first.
+func (q *callbackQueue) pushWhenFree(ctx context.Context, job *callbackJob) bool {
+	for !q.tryPush(job) {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(time.Second):
+		}
+	}
+
+	return true
+}
+
+// push must be called with q.mx held
+func (q *callbackQueue) push(job *callbackJob) {
+	q.shardFor(job.address) <- job
+	q.enqueued.Inc()
+	q.depth.Add(1)
+}
+
+func (q *callbackQueue) work(ctx context.Context, jobs chan *callbackJob) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-jobs:
+			q.depth.Add(-1)
+
+			if err := q.service.TransactionWatch(ctx, job.address, job.txHash); err != nil {
+				q.logger.Errorf("callbackQueue service.TransactionWatch error %v\n", err)
+				q.failed.Inc()
+				q.retry(ctx, job)
+				continue
+			}
+
+			if err := q.repository.MarkItemDone(ctx, job.batchId, job.index); err != nil {
+				q.logger.Errorf("callbackQueue repository.MarkItemDone error %v\n", err)
+				q.failed.Inc()
+				continue
+			}
+
+			q.processed.Inc()
+		}
+	}
+}
+
+// retry queues a failed job again after a growing delay. Once out of
+// attempts the item stays pending in its stored batch.
+func (q *callbackQueue) retry(ctx context.Context, job *callbackJob) {
+	job.attempts++
+	if job.attempts > callbackRetries {
+		return
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(job.attempts) * callbackRetryDelay):
+		}
+
+		q.pushWhenFree(ctx, job)
+	}()
+}
+
+func (q *callbackQueue) shardFor(address string) chan *callbackJob {
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(address)))
+	return q.shards[h.Sum32()%uint32(len(q.shards))]
+}
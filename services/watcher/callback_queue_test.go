@@ -0,0 +1,226 @@
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"airdao-mobile-api/pkg/metrics"
+	"airdao-mobile-api/services/watcher"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+// queueService fails the first fails[txHash] runs of a tx
+type queueService struct {
+	watcher.Service
+
+	mx    sync.Mutex
+	fails map[string]int
+	runs  map[string]int
+	order []string
+}
+
+func (s *queueService) TransactionWatch(ctx context.Context, address string, txHash string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.runs[txHash]++
+	s.order = append(s.order, txHash)
+	if s.runs[txHash] <= s.fails[txHash] {
+		return errors.New("explorer unavailable")
+	}
+
+	return nil
+}
+
+func (s *queueService) Runs(txHash string) int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.runs[txHash]
+}
+
+func (s *queueService) Order() []string {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return append([]string(nil), s.order...)
+}
+
+type callbackRepository struct {
+	mx      sync.Mutex
+	batches map[primitive.ObjectID]*watcher.CallbackBatch
+}
+
+func (r *callbackRepository) CreateBatch(ctx context.Context, batch *watcher.CallbackBatch) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.batches[batch.ID] = batch
+	return nil
+}
+
+func (r *callbackRepository) MarkItemDone(ctx context.Context, batchId primitive.ObjectID, index int) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	batch, ok := r.batches[batchId]
+	if !ok {
+		return nil
+	}
+	batch.Items[index].Done = true
+	for _, item := range batch.Items {
+		if !item.Done {
+			return nil
+		}
+	}
+	delete(r.batches, batchId)
+
+	return nil
+}
+
+func (r *callbackRepository) GetPendingBatches(ctx context.Context) ([]*watcher.CallbackBatch, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	var batches []*watcher.CallbackBatch
+	for _, batch := range r.batches {
+		batches = append(batches, batch)
+	}
+	sort.Slice(batches, func(i, j int) bool { return batches[i].ID.Hex() < batches[j].ID.Hex() })
+
+	return batches, nil
+}
+
+func (r *callbackRepository) Pending() int {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	return len(r.batches)
+}
+
+func newQueue(t *testing.T, service watcher.Service, repository watcher.CallbackRepository, queueSize int) watcher.CallbackQueue {
+	queue, err := watcher.NewCallbackQueue(service, repository, zap.NewNop().Sugar(), metrics.NewRegistry(), 2, queueSize)
+	require.NoError(t, err)
+
+	return queue
+}
+
+func TestCallbackQueueProcessesAndRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		fails    int
+		wantRuns int
+		wantDone bool
+	}{
+		{name: "should mark a processed item done", fails: 0, wantRuns: 1, wantDone: true},
+		{name: "should retry a failed item", fails: 1, wantRuns: 2, wantDone: true},
+	}
+
+	for _, test := range tests {
+		service := &queueService{fails: map[string]int{"0xtx": test.fails}, runs: make(map[string]int)}
+		repository := &callbackRepository{batches: make(map[primitive.ObjectID]*watcher.CallbackBatch)}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		queue := newQueue(t, service, repository, 10)
+		require.NoError(t, queue.Start(ctx))
+
+		_, err := queue.Enqueue(ctx, []watcher.WatcherCallbackItem{{Address: "0xaddress", TxHash: "0xtx"}})
+		require.NoError(t, err)
+
+		assert.Eventually(t, func() bool { return service.Runs("0xtx") == test.wantRuns }, 3*time.Second, 10*time.Millisecond, test.name)
+		assert.Eventually(t, func() bool { return (repository.Pending() == 0) == test.wantDone }, time.Second, 10*time.Millisecond, test.name)
+
+		cancel()
+	}
+}
+
+func TestCallbackQueueKeepsFailedItemsPending(t *testing.T) {
+	service := &queueService{fails: map[string]int{"0xtx": 100}, runs: make(map[string]int)}
+	repository := &callbackRepository{batches: make(map[primitive.ObjectID]*watcher.CallbackBatch)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	queue := newQueue(t, service, repository, 10)
+	require.NoError(t, queue.Start(ctx))
+
+	_, err := queue.Enqueue(ctx, []watcher.WatcherCallbackItem{{Address: "0xaddress", TxHash: "0xtx"}})
+	require.NoError(t, err)
+
+	// The first run and every retry
+	assert.Eventually(t, func() bool { return service.Runs("0xtx") == 4 }, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, repository.Pending())
+}
+
+func TestCallbackQueueStartRequeuesPendingItems(t *testing.T) {
+	service := &queueService{runs: make(map[string]int)}
+	batch := &watcher.CallbackBatch{
+		ID: primitive.NewObjectID(),
+		Items: []*watcher.CallbackBatchItem{
+			{Address: "0xaddress", TxHash: "0xdone", Done: true},
+			{Address: "0xaddress", TxHash: "0xpending"},
+		},
+	}
+	repository := &callbackRepository{batches: map[primitive.ObjectID]*watcher.CallbackBatch{batch.ID: batch}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	queue := newQueue(t, service, repository, 10)
+	require.NoError(t, queue.Start(ctx))
+
+	assert.Eventually(t, func() bool { return repository.Pending() == 0 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, service.Runs("0xdone"))
+	assert.Equal(t, 1, service.Runs("0xpending"))
+}
+
+func TestCallbackQueueStartKeepsAddressOrder(t *testing.T) {
+	service := &queueService{runs: make(map[string]int)}
+	batch := &watcher.CallbackBatch{ID: primitive.NewObjectID()}
+	var want []string
+	for _, txHash := range []string{"0xtx1", "0xtx2", "0xtx3", "0xtx4", "0xtx5"} {
+		batch.Items = append(batch.Items, &watcher.CallbackBatchItem{Address: "0xaddress", TxHash: txHash})
+		want = append(want, txHash)
+	}
+	repository := &callbackRepository{batches: map[primitive.ObjectID]*watcher.CallbackBatch{batch.ID: batch}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The recovered items don't fit in the shard at once
+	queue := newQueue(t, service, repository, 2)
+	require.NoError(t, queue.Start(ctx))
+
+	// Like the explorer, retry while the shard is full
+	assert.Eventually(t, func() bool {
+		_, err := queue.Enqueue(ctx, []watcher.WatcherCallbackItem{{Address: "0xaddress", TxHash: "0xnew"}})
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	want = append(want, "0xnew")
+	assert.Eventually(t, func() bool { return len(service.Order()) == len(want) }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, want, service.Order(), "should notify the recovered txs of an address first")
+}
+
+func TestCallbackQueueRejectsWhenFull(t *testing.T) {
+	service := &queueService{runs: make(map[string]int)}
+	repository := &callbackRepository{batches: make(map[primitive.ObjectID]*watcher.CallbackBatch)}
+
+	// Not started, nothing drains the shards
+	queue := newQueue(t, service, repository, 1)
+
+	_, err := queue.Enqueue(context.Background(), []watcher.WatcherCallbackItem{
+		{Address: "0xaddress", TxHash: "0xtx1"},
+		{Address: "0xaddress", TxHash: "0xtx2"},
+	})
+	assert.Equal(t, watcher.ErrCallbackQueueFull, err)
+	assert.Equal(t, 0, repository.Pending())
+}
@@ -0,0 +1,112 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type CallbackBatchItem struct {
+	Address string `bson:"address"`
+	TxHash  string `bson:"tx_hash"`
+	Done    bool   `bson:"done"`
+}
+
+type CallbackBatch struct {
+	ID        primitive.ObjectID   `bson:"_id"`
+	Items     []*CallbackBatchItem `bson:"items"`
+	CreatedAt time.Time            `bson:"created_at"`
+}
+
+//go:generate mockgen -source=callback_repository.go -destination=mocks/callback_repository_mock.go
+type CallbackRepository interface {
+	CreateBatch(ctx context.Context, batch *CallbackBatch) error
+	MarkItemDone(ctx context.Context, batchId primitive.ObjectID, index int) error
+	GetPendingBatches(ctx context.Context) ([]*CallbackBatch, error)
+}
+
+type callbackRepository struct {
+	db               *mongo.Client
+	dbName           string
+	dbCollectionName string
+	logger           *zap.SugaredLogger
+}
+
+func NewCallbackRepository(db *mongo.Client, dbName string, logger *zap.SugaredLogger) (CallbackRepository, error) {
+	if db == nil {
+		return nil, errors.New("[callback_repository] invalid user database")
+	}
+	if dbName == "" {
+		return nil, errors.New("[callback_repository] invalid database name")
+	}
+	if logger == nil {
+		return nil, errors.New("[callback_repository] invalid logger")
+	}
+
+	return &callbackRepository{db: db, dbName: dbName, dbCollectionName: "callback_batch", logger: logger}, nil
+}
+
+func (r *callbackRepository) CreateBatch(ctx context.Context, batch *CallbackBatch) error {
+	if _, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).InsertOne(ctx, batch); err != nil {
+		r.logger.Errorf("failed to insert callback batch to db: %s", err)
+		return errors.New("failed to create callback batch")
+	}
+
+	return nil
+}
+
+// MarkItemDone flags one item as processed and drops the batch once every
+// item in it is done, so the collection only ever holds unfinished work.
+func (r *callbackRepository) MarkItemDone(ctx context.Context, batchId primitive.ObjectID, index int) error {
+	collection := r.db.Database(r.dbName).Collection(r.dbCollectionName)
+
+	if _, err := collection.UpdateOne(ctx,
+		bson.M{"_id": batchId},
+		bson.M{"$set": bson.M{fmt.Sprintf("items.%d.done", index): true}}); err != nil {
+		r.logger.Errorf("failed to mark callback batch item as done: %s", err)
+		return errors.New("failed to update callback batch")
+	}
+
+	if _, err := collection.DeleteOne(ctx, bson.M{"_id": batchId, "items.done": bson.M{"$ne": false}}); err != nil {
+		r.logger.Errorf("failed to delete finished callback batch: %s", err)
+		return errors.New("failed to delete callback batch")
+	}
+
+	return nil
+}
+
+func (r *callbackRepository) GetPendingBatches(ctx context.Context) ([]*CallbackBatch, error) {
+	// Oldest first, so that recovered items keep their per-address order
+	findOptions := options.Find().SetSort(bson.M{"_id": 1})
+
+	cur, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).Find(ctx, bson.M{}, findOptions)
+	if err != nil {
+		r.logger.Errorf("unable to find callback batches due to internal error: %v", err)
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var batches []*CallbackBatch
+	for cur.Next(ctx) {
+		batch := new(CallbackBatch)
+		if err := cur.Decode(batch); err != nil {
+			r.logger.Errorf("unable to decode callback batch document: %v", err)
+			return nil, err
+		}
+		batches = append(batches, batch)
+	}
+
+	if err := cur.Err(); err != nil {
+		r.logger.Errorf("cursor iteration error: %v", err)
+		return nil, err
+	}
+
+	return batches, nil
+}
@@ -0,0 +1,42 @@
+package watcher
+
+import (
+	"fmt"
+	"strconv"
+
+	"airdao-mobile-api/pkg/pricefeed"
+)
+
+var currencySymbols = map[string]string{
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"TRY": "₺",
+	"JPY": "¥",
+}
+
+// formatFiat renders an amount with the currency symbol, or with the currency
+// code when the currency has no well known symbol.
+func formatFiat(amount float64, currency string, decimals int) string {
+	if currency == "" {
+		currency = pricefeed.BaseCurrency
+	}
+
+	value := strconv.FormatFloat(amount, 'f', decimals, 64)
+	if symbol, ok := currencySymbols[currency]; ok {
+		return symbol + value
+	}
+
+	return fmt.Sprintf("%s %s", value, currency)
+}
+
+// priceIn returns the current price of token in currency and whether it is
+// fresh enough to act on.
+func (s *service) priceIn(token, currency string) (float64, bool) {
+	usd, fresh := s.currentPrice(token)
+	if !fresh {
+		return 0, false
+	}
+
+	return s.fx.Convert(usd, currency)
+}
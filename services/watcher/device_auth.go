@@ -0,0 +1,279 @@
+package watcher
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"airdao-mobile-api/pkg/deviceauth"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DeviceTokens is the credential issued to a device. The refresh token is
+// single use: every refresh returns a new one.
+type DeviceTokens struct {
+	// Id is the id of the device watcher, used by the v2 routes
+	Id               string    `json:"id"`
+	TokenType        string    `json:"token_type"`
+	AccessToken      string    `json:"access_token"`
+	AccessExpiresAt  time.Time `json:"access_expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// Device is the device a request acts for. Authenticated is set once the
+// access token of the device proved the push token; otherwise the push
+// token only identifies it, which watchers holding a credential refuse.
+type Device struct {
+	PushToken     string
+	Authenticated bool
+}
+
+// cachedInstance returns the cached copy of a watcher loaded from the
+// repository, so updates don't race with the copy the notifications use.
+func (s *service) cachedInstance(watcher *Watcher) *Watcher {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	if cached, ok := s.cachedWatcher[watcher.PushToken]; ok && cached != nil {
+		return cached
+	}
+
+	return watcher
+}
+
+func (s *service) getWatcherById(ctx context.Context, id string) (*Watcher, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, nil
+	}
+
+	watcher, err := s.repository.GetWatcher(ctx, bson.M{"_id": oid})
+	if err != nil || watcher == nil {
+		return nil, err
+	}
+
+	return s.cachedInstance(watcher), nil
+}
+
+// RegisterDevice issues the first credential of a device, creating its
+// watcher when needed. A watcher that already holds a live credential can't
+// be claimed again, otherwise knowing its push token would be enough.
+func (s *service) RegisterDevice(ctx context.Context, pushToken string, deviceId string) (*DeviceTokens, error) {
+	encodePushToken := base64.StdEncoding.EncodeToString([]byte(pushToken))
+
+	watcher, err := s.repository.GetWatcher(ctx, bson.M{"push_token": encodePushToken})
+	if err != nil {
+		return nil, err
+	}
+	if watcher == nil && deviceId != "" {
+		if watcher, err = s.repository.GetWatcher(ctx, bson.M{"device_id": deviceId}); err != nil {
+			return nil, err
+		}
+	}
+
+	if watcher != nil && watcher.Credential.Active() {
+		return nil, ErrDeviceAlreadyRegistered
+	}
+
+	// CreateWatcher moves an existing watcher of the device to the new push token
+	if watcher == nil || watcher.PushToken != encodePushToken {
+		if err := s.CreateWatcher(ctx, pushToken, deviceId); err != nil {
+			return nil, err
+		}
+	}
+
+	if watcher, err = s.GetWatcher(ctx, Device{PushToken: pushToken}); err != nil {
+		return nil, err
+	}
+
+	s.credentialMx.Lock()
+	defer s.credentialMx.Unlock()
+	defer s.watcherLocks.Lock(watcher)()
+
+	return s.issueDeviceTokens(ctx, watcher, "")
+}
+
+// RefreshDevice rotates the refresh token. Presenting the refresh token that
+// was already rotated away means it leaked, so every credential of the
+// device is revoked, unless it comes within the grace period of the rotation:
+// the device then likely lost the response and gets the same refresh token.
+func (s *service) RefreshDevice(ctx context.Context, refreshToken string) (*DeviceTokens, error) {
+	id, _, ok := strings.Cut(refreshToken, ".")
+	if !ok {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	s.credentialMx.Lock()
+	defer s.credentialMx.Unlock()
+
+	watcher, err := s.getWatcherById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if watcher == nil || watcher.Credential == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	defer s.watcherLocks.Lock(watcher)()
+
+	hash := deviceauth.HashToken(refreshToken)
+	switch {
+	case watcher.Credential.RefreshTokenHash != "" && hash == watcher.Credential.RefreshTokenHash:
+		if !watcher.Credential.Active() {
+			return nil, ErrInvalidRefreshToken
+		}
+		return s.issueDeviceTokens(ctx, watcher, hash)
+	case watcher.Credential.PreviousRefreshTokenHash != "" && hash == watcher.Credential.PreviousRefreshTokenHash:
+		if watcher.Credential.Active() && time.Since(watcher.Credential.IssuedAt) <= s.deviceAuthCfg.RefreshGrace {
+			return s.reissueDeviceTokens(watcher)
+		}
+
+		s.logger.Warnf("RefreshDevice refresh token reuse for watcher %s, revoking credentials", watcher.ID.Hex())
+
+		watcher.Credential = &DeviceCredential{Version: watcher.Credential.Version + 1}
+		watcher.UpdatedAt = time.Now()
+		if err := s.repository.UpdateWatcher(ctx, watcher); err != nil {
+			s.logger.Errorf("RefreshDevice repository.UpdateWatcher error %v\n", err)
+			return nil, err
+		}
+
+		return nil, ErrRefreshTokenReused
+	default:
+		return nil, ErrInvalidRefreshToken
+	}
+}
+
+// AuthenticateDevice verifies an access token and returns the push token of
+// the watcher it was issued for.
+func (s *service) AuthenticateDevice(ctx context.Context, accessToken string) (string, error) {
+	claims, err := s.deviceSigner.Verify(accessToken)
+	if err != nil {
+		if errors.Is(err, deviceauth.ErrNotConfigured) {
+			return "", ErrDeviceAuthUnavailable
+		}
+		return "", fmt.Errorf("%w: %v", ErrInvalidCredential, err)
+	}
+
+	watcher, err := s.getWatcherById(ctx, claims.Subject)
+	if err != nil {
+		return "", err
+	}
+	if watcher == nil || watcher.Credential == nil || watcher.Credential.Version != claims.Version {
+		return "", ErrCredentialRevoked
+	}
+
+	pushToken, err := base64.StdEncoding.DecodeString(watcher.PushToken)
+	if err != nil {
+		return "", err
+	}
+
+	return string(pushToken), nil
+}
+
+// issueDeviceTokens stores a new refresh token for the watcher, keeping the
+// hash of the rotated one to detect its reuse. Callers hold credentialMx and
+// the watcher lock.
+func (s *service) issueDeviceTokens(ctx context.Context, watcher *Watcher, previousHash string) (*DeviceTokens, error) {
+	version := 0
+	if watcher.Credential != nil {
+		version = watcher.Credential.Version
+	}
+
+	accessToken, accessExpiresAt, err := s.deviceSigner.Issue(watcher.ID.Hex(), version)
+	if err != nil {
+		if errors.Is(err, deviceauth.ErrNotConfigured) {
+			return nil, ErrDeviceAuthUnavailable
+		}
+		return nil, err
+	}
+
+	secret, err := s.refreshSecret(previousHash)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	refreshToken := watcher.ID.Hex() + "." + secret
+	credential := &DeviceCredential{
+		RefreshTokenHash:         deviceauth.HashToken(refreshToken),
+		PreviousRefreshTokenHash: previousHash,
+		RefreshExpiresAt:         now.Add(s.deviceAuthCfg.RefreshTokenTTL),
+		Version:                  version,
+		IssuedAt:                 now,
+	}
+
+	previous := watcher.Credential
+	watcher.Credential = credential
+	watcher.UpdatedAt = now
+	if err := s.repository.UpdateWatcher(ctx, watcher); err != nil {
+		s.logger.Errorf("issueDeviceTokens repository.UpdateWatcher error %v\n", err)
+		watcher.Credential = previous
+		return nil, err
+	}
+
+	return &DeviceTokens{
+		Id:               watcher.ID.Hex(),
+		TokenType:        "Bearer",
+		AccessToken:      accessToken,
+		AccessExpiresAt:  accessExpiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: credential.RefreshExpiresAt,
+	}, nil
+}
+
+// reissueDeviceTokens answers a retried refresh with the refresh token the
+// first one stored and a new access token. Callers hold credentialMx and the
+// watcher lock.
+func (s *service) reissueDeviceTokens(watcher *Watcher) (*DeviceTokens, error) {
+	credential := watcher.Credential
+
+	secret, err := s.refreshSecret(credential.PreviousRefreshTokenHash)
+	if err != nil {
+		return nil, err
+	}
+
+	// The signing secret may have been rotated since
+	refreshToken := watcher.ID.Hex() + "." + secret
+	if deviceauth.HashToken(refreshToken) != credential.RefreshTokenHash {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	accessToken, accessExpiresAt, err := s.deviceSigner.Issue(watcher.ID.Hex(), credential.Version)
+	if err != nil {
+		if errors.Is(err, deviceauth.ErrNotConfigured) {
+			return nil, ErrDeviceAuthUnavailable
+		}
+		return nil, err
+	}
+
+	return &DeviceTokens{
+		Id:               watcher.ID.Hex(),
+		TokenType:        "Bearer",
+		AccessToken:      accessToken,
+		AccessExpiresAt:  accessExpiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: credential.RefreshExpiresAt,
+	}, nil
+}
+
+// refreshSecret returns the secret part of a new refresh token. The first
+// one of a device is random, the following ones are derived from the token
+// they replace so a retried refresh gets the same one.
+func (s *service) refreshSecret(previousHash string) (string, error) {
+	if previousHash == "" {
+		return deviceauth.NewRefreshToken()
+	}
+
+	secret, err := s.deviceSigner.RotatedRefreshToken(previousHash)
+	if errors.Is(err, deviceauth.ErrNotConfigured) {
+		return "", ErrDeviceAuthUnavailable
+	}
+
+	return secret, err
+}
@@ -0,0 +1,200 @@
+package watcher
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"airdao-mobile-api/config"
+	"airdao-mobile-api/pkg/deviceauth"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// deviceRepository keeps watchers in memory and, like the unique index,
+// refuses a push token another watcher has
+type deviceRepository struct {
+	Repository
+
+	mx       sync.Mutex
+	watchers []*Watcher
+	updates  int
+}
+
+func (r *deviceRepository) find(filters bson.M) *Watcher {
+	for _, watcher := range r.watchers {
+		if id, ok := filters["_id"].(primitive.ObjectID); ok && id == watcher.ID {
+			return watcher
+		}
+		if pushToken, ok := filters["push_token"].(string); ok && pushToken == watcher.PushToken {
+			return watcher
+		}
+		if deviceId, ok := filters["device_id"].(string); ok && deviceId == watcher.DeviceId {
+			return watcher
+		}
+	}
+
+	return nil
+}
+
+// GetWatcher returns a copy, like a document loaded from the database
+func (r *deviceRepository) GetWatcher(ctx context.Context, filters bson.M) (*Watcher, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	watcher := r.find(filters)
+	if watcher == nil {
+		return nil, nil
+	}
+
+	stored := *watcher
+	return &stored, nil
+}
+
+func (r *deviceRepository) CreateWatcher(ctx context.Context, watcher *Watcher) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if r.find(bson.M{"push_token": watcher.PushToken}) != nil {
+		return ErrWatcherAlreadyExists
+	}
+
+	stored := *watcher
+	r.watchers = append(r.watchers, &stored)
+	return nil
+}
+
+func (r *deviceRepository) UpdateWatcher(ctx context.Context, watcher *Watcher) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if owner := r.find(bson.M{"push_token": watcher.PushToken}); owner != nil && owner.ID != watcher.ID {
+		return ErrWatcherAlreadyExists
+	}
+
+	stored := r.find(bson.M{"_id": watcher.ID})
+	if stored == nil {
+		return errors.New("failed to update watcher")
+	}
+
+	*stored = *watcher
+	r.updates++
+	return nil
+}
+
+func (r *deviceRepository) UpdateWatchers(ctx context.Context, watchers []*Watcher) error {
+	for _, watcher := range watchers {
+		if err := r.UpdateWatcher(ctx, watcher); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *deviceRepository) DeleteWatcher(ctx context.Context, filters bson.M) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	deleted := r.find(filters)
+	for i, watcher := range r.watchers {
+		if watcher == deleted {
+			r.watchers = append(r.watchers[:i], r.watchers[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// newDeviceService returns a service issuing device credentials with a
+// watcher of each push token
+func newDeviceService(t *testing.T, pushTokens ...string) (*service, *deviceRepository) {
+	signer, err := deviceauth.NewSigner([]string{"secret"}, time.Hour)
+	require.NoError(t, err)
+
+	repository := &deviceRepository{}
+	s := newAlertService(t, time.Now(), 0.02, nil, nil, repository)
+	s.feeds = newTestFeeds(t)
+	s.deviceSigner = signer
+	s.deviceAuthCfg = config.DeviceAuth{RefreshTokenTTL: 24 * time.Hour, RefreshGrace: 30 * time.Second}
+
+	for _, pushToken := range pushTokens {
+		require.NoError(t, s.CreateWatcher(context.Background(), pushToken, ""))
+	}
+
+	return s, repository
+}
+
+func TestRefreshDevice(t *testing.T) {
+	s, repository := newDeviceService(t, "push-token")
+
+	registered, err := s.RegisterDevice(context.Background(), "push-token", "")
+	require.NoError(t, err)
+	updates := repository.updates
+
+	refreshed, err := s.RefreshDevice(context.Background(), registered.RefreshToken)
+	require.NoError(t, err)
+	assert.NotEqual(t, registered.RefreshToken, refreshed.RefreshToken)
+
+	// A retry of the same refresh within the grace period gets the same tokens
+	retried, err := s.RefreshDevice(context.Background(), registered.RefreshToken)
+	require.NoError(t, err)
+	assert.Equal(t, refreshed.RefreshToken, retried.RefreshToken)
+	assert.Equal(t, refreshed.RefreshExpiresAt, retried.RefreshExpiresAt)
+	assert.Equal(t, updates+1, repository.updates, "should not store a retried refresh")
+
+	_, err = s.AuthenticateDevice(context.Background(), retried.AccessToken)
+	assert.NoError(t, err)
+
+	// The rotated token keeps working
+	next, err := s.RefreshDevice(context.Background(), retried.RefreshToken)
+	require.NoError(t, err)
+
+	// Past the grace period the reuse revokes the device
+	s.cachedWatcher[base64.StdEncoding.EncodeToString([]byte("push-token"))].Credential.IssuedAt = time.Now().Add(-time.Minute)
+	_, err = s.RefreshDevice(context.Background(), retried.RefreshToken)
+	assert.ErrorIs(t, err, ErrRefreshTokenReused)
+
+	_, err = s.RefreshDevice(context.Background(), next.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	_, err = s.AuthenticateDevice(context.Background(), next.AccessToken)
+	assert.ErrorIs(t, err, ErrCredentialRevoked)
+}
+
+func TestRefreshDeviceUnknownToken(t *testing.T) {
+	s, _ := newDeviceService(t, "push-token")
+
+	registered, err := s.RegisterDevice(context.Background(), "push-token", "")
+	require.NoError(t, err)
+
+	_, err = s.RefreshDevice(context.Background(), registered.Id+".forged")
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+
+	_, err = s.RefreshDevice(context.Background(), "forged")
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+}
+
+func TestGetWatcherRegisteredDevice(t *testing.T) {
+	s, _ := newDeviceService(t, "push-token")
+
+	// The push token identifies a watcher without credential
+	_, err := s.GetWatcher(context.Background(), Device{PushToken: "push-token"})
+	require.NoError(t, err)
+
+	_, err = s.RegisterDevice(context.Background(), "push-token", "")
+	require.NoError(t, err)
+
+	_, err = s.GetWatcher(context.Background(), Device{PushToken: "push-token"})
+	assert.ErrorIs(t, err, ErrMissingCredential, "should refuse the push token of a registered device")
+
+	watcher, err := s.GetWatcher(context.Background(), Device{PushToken: "push-token", Authenticated: true})
+	require.NoError(t, err)
+	assert.True(t, watcher.Credential.Active())
+}
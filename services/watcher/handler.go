@@ -2,60 +2,302 @@ package watcher
 
 import (
 	"errors"
+	"fmt"
 	"net/url"
+	"strings"
+
+	"airdao-mobile-api/pkg/apierror"
+	"airdao-mobile-api/pkg/hmacauth"
+	"airdao-mobile-api/pkg/idempotency"
+	"airdao-mobile-api/pkg/ratelimit"
 
 	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+const (
+	CallbackTimestampHeader = "X-Callback-Timestamp"
+	CallbackNonceHeader     = "X-Callback-Nonce"
+	CallbackSignatureHeader = "X-Callback-Signature"
+
+	// pushTokenLocal carries the push token of the authenticated device
+	pushTokenLocal = "push_token"
 )
 
 type Handler struct {
-	service Service
+	service       Service
+	callbackQueue CallbackQueue
+
+	callbackVerifier       *hmacauth.Verifier
+	allowUnsignedCallbacks bool
+
+	allowPushTokenAuth bool
+
+	authLimit  fiber.Handler
+	readLimit  fiber.Handler
+	writeLimit fiber.Handler
+
+	idempotent fiber.Handler
+	// authIdempotent scopes the keys of the credential routes by IP, the
+	// device is not known yet
+	authIdempotent fiber.Handler
+
+	logger *zap.SugaredLogger
 }
 
-func NewHandler(service Service) (*Handler, error) {
+func NewHandler(service Service, callbackQueue CallbackQueue, callbackVerifier *hmacauth.Verifier, allowUnsignedCallbacks bool, allowPushTokenAuth bool, limiter *ratelimit.Limiter, idempotencyKeys *idempotency.Keys, logger *zap.SugaredLogger) (*Handler, error) {
 	if service == nil {
 		return nil, errors.New("[watcher_handler] invalid watcher service")
 	}
+	if callbackQueue == nil {
+		return nil, errors.New("[watcher_handler] invalid callback queue")
+	}
+	if callbackVerifier == nil {
+		return nil, errors.New("[watcher_handler] invalid callback verifier")
+	}
+	if !allowUnsignedCallbacks && !callbackVerifier.Enabled() {
+		return nil, errors.New("[watcher_handler] unsigned callbacks are disabled but no callback secrets are set")
+	}
+	if limiter == nil {
+		return nil, errors.New("[watcher_handler] invalid rate limiter")
+	}
+	if idempotencyKeys == nil {
+		return nil, errors.New("[watcher_handler] invalid idempotency keys")
+	}
+	if logger == nil {
+		return nil, errors.New("[watcher_handler] invalid logger")
+	}
+
+	return &Handler{
+		service:       service,
+		callbackQueue: callbackQueue,
+
+		callbackVerifier:       callbackVerifier,
+		allowUnsignedCallbacks: allowUnsignedCallbacks,
+
+		allowPushTokenAuth: allowPushTokenAuth,
 
-	return &Handler{service: service}, nil
+		authLimit:  limiter.Middleware(RateLimitAuth, ratelimit.IPKey),
+		readLimit:  limiter.Middleware(RateLimitRead, deviceKey),
+		writeLimit: limiter.Middleware(RateLimitWrite, deviceKey),
+
+		idempotent:     idempotencyKeys.Middleware(deviceKey),
+		authIdempotent: idempotencyKeys.Middleware(ratelimit.IPKey),
+
+		logger: logger,
+	}, nil
 }
 
 func (h *Handler) SetupRoutes(router fiber.Router) {
-	router.Get("/watcher/:token", h.GetWatcherHandler)
-	router.Get("/watcher-historical-prices", h.GetWatcherHistoryPricesHandler)
+	router.Post("/device/register", h.authLimit, h.authIdempotent, h.RegisterDeviceHandler)
+	router.Post("/device/refresh", h.authLimit, h.authIdempotent, h.RefreshDeviceHandler)
+
+	router.Get("/watcher", h.deviceAuth, h.deviceLimit, h.GetWatcherHandler)
+	router.Get("/watcher/portfolio", h.deviceAuth, h.deviceLimit, h.GetWatcherPortfolioHandler)
+	router.Get("/watcher/addresses/:address/transactions", h.deviceAuth, h.deviceLimit, h.GetWatcherAddressTransactionsHandler)
+
+	// Push tokens in the path end up in access logs, these routes only stay
+	// for apps without a device credential
+	if h.allowPushTokenAuth {
+		router.Get("/watcher/:token", h.deviceAuth, h.deviceLimit, h.GetWatcherHandler)
+		router.Get("/watcher/:token/portfolio", h.deviceAuth, h.deviceLimit, h.GetWatcherPortfolioHandler)
+		router.Get("/watcher/:token/addresses/:address/transactions", h.deviceAuth, h.deviceLimit, h.GetWatcherAddressTransactionsHandler)
+	}
 
-	router.Post("/watcher", h.CreateWatcherHandler)
-	router.Put("/watcher", h.UpdateWatcherHandler)
+	router.Get("/watcher-historical-prices", h.deviceLimit, h.GetWatcherHistoryPricesHandler)
+	router.Get("/watcher-price-tokens", h.deviceLimit, h.GetPriceTokensHandler)
 
-	router.Delete("/watcher", h.DeleteWatcherHandler)
-	router.Delete("/watcher-addresses", h.DeleteWatcherAddressesHandler)
+	router.Post("/watcher", h.deviceAuth, h.deviceLimit, h.idempotent, h.CreateWatcherHandler)
+	router.Put("/watcher", h.deviceAuth, h.deviceLimit, h.idempotent, h.UpdateWatcherHandler)
+
+	router.Delete("/watcher", h.deviceAuth, h.deviceLimit, h.idempotent, h.DeleteWatcherHandler)
+	router.Delete("/watcher-addresses", h.deviceAuth, h.deviceLimit, h.idempotent, h.DeleteWatcherAddressesHandler)
+
+	router.Post("/watcher-address-challenge", h.deviceAuth, h.deviceLimit, h.idempotent, h.CreateAddressChallengeHandler)
+	router.Post("/watcher-address-verify", h.deviceAuth, h.deviceLimit, h.idempotent, h.VerifyAddressHandler)
+
+	router.Put("/watcher-token-alerts", h.deviceAuth, h.deviceLimit, h.idempotent, h.UpdateWatcherTokenAlertsHandler)
+	router.Delete("/watcher-token-alerts", h.deviceAuth, h.deviceLimit, h.idempotent, h.DeleteWatcherTokenAlertsHandler)
 
 	router.Post("/explorer-callback", h.WatcherCallbackHandler)
 
-	router.Put("/push-token", h.UpdateWatcherPushTokenHandler)
+	router.Put("/push-token", h.deviceAuth, h.deviceLimit, h.idempotent, h.UpdateWatcherPushTokenHandler)
+
+	router.Post("/watcher/test-notification", h.deviceAuth, h.deviceLimit, h.idempotent, h.TestNotificationHandler)
 }
 
-func (h *Handler) GetWatcherHandler(c *fiber.Ctx) error {
-	paramToken := c.Params("token")
+// deviceAuth checks the bearer access token of the device. Without one the
+// request falls through to push token identification while that is allowed.
+func (h *Handler) deviceAuth(c *fiber.Ctx) error {
+	authorization := c.Get(fiber.HeaderAuthorization)
+	if authorization == "" {
+		if h.allowPushTokenAuth {
+			return c.Next()
+		}
+		return ErrMissingCredential
+	}
+
+	accessToken := strings.TrimPrefix(authorization, "Bearer ")
+	if accessToken == authorization || accessToken == "" {
+		return ErrMissingCredential
+	}
+
+	pushToken, err := h.service.AuthenticateDevice(c.Context(), accessToken)
+	if err != nil {
+		return err
+	}
+
+	c.Locals(pushTokenLocal, pushToken)
+
+	return c.Next()
+}
 
-	decodedParamToken, err := url.QueryUnescape(paramToken)
+// requestDevice returns the authenticated device, or the device of the
+// legacy push token the request carries.
+func requestDevice(c *fiber.Ctx, legacy string) (Device, error) {
+	if v, ok := c.Locals(pushTokenLocal).(string); ok && v != "" {
+		return Device{PushToken: v, Authenticated: true}, nil
+	}
+
+	if legacy == "" {
+		return Device{}, ErrMissingCredential
+	}
+
+	return Device{PushToken: legacy}, nil
+}
+
+// paramDevice is requestDevice for the routes carrying the push token in
+// the path.
+func paramDevice(c *fiber.Ctx) (Device, error) {
+	decodedParamToken, err := url.QueryUnescape(c.Params("token"))
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		return Device{}, ErrInvalidParams
+	}
+
+	return requestDevice(c, decodedParamToken)
+}
+
+type RegisterDevice struct {
+	PushToken string   `json:"push_token" validate:"required"`
+	DeviceId  string   `json:"device_id" validate:"omitempty"`
+	App       *AppInfo `json:"app" validate:"omitempty"`
+}
+
+func (h *Handler) RegisterDeviceHandler(c *fiber.Ctx) error {
+	var reqBody RegisterDevice
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return apierror.InvalidBody(err)
 	}
 
-	if decodedParamToken == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid params"})
+	if err := Validate(reqBody); err != nil {
+		return err
 	}
 
-	watcher, err := h.service.GetWatcher(c.Context(), decodedParamToken)
+	tokens, err := h.service.RegisterDevice(c.Context(), reqBody.PushToken, reqBody.DeviceId)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		return err
+	}
+
+	// The device now holds a credential, this request is its first use
+	c.Locals(pushTokenLocal, reqBody.PushToken)
+
+	if reqBody.App != nil {
+		if err := h.service.UpdateWatcherApp(c.Context(), Device{PushToken: reqBody.PushToken, Authenticated: true}, *reqBody.App); err != nil {
+			return err
+		}
+	}
+
+	return c.JSON(tokens)
+}
+
+type RefreshDevice struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+func (h *Handler) RefreshDeviceHandler(c *fiber.Ctx) error {
+	var reqBody RefreshDevice
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return apierror.InvalidBody(err)
+	}
+
+	if err := Validate(reqBody); err != nil {
+		return err
+	}
+
+	tokens, err := h.service.RefreshDevice(c.Context(), reqBody.RefreshToken)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(tokens)
+}
+
+func (h *Handler) GetWatcherHandler(c *fiber.Ctx) error {
+	device, err := paramDevice(c)
+	if err != nil {
+		return err
+	}
+
+	watcher, err := h.service.GetWatcher(c.Context(), device)
+	if err != nil {
+		return err
 	}
 
 	return c.JSON(watcher)
 }
 
+func (h *Handler) GetWatcherPortfolioHandler(c *fiber.Ctx) error {
+	device, err := paramDevice(c)
+	if err != nil {
+		return err
+	}
+
+	portfolio, err := h.service.GetWatcherPortfolio(c.Context(), device)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(portfolio)
+}
+
+func (h *Handler) GetWatcherAddressTransactionsHandler(c *fiber.Ctx) error {
+	device, err := paramDevice(c)
+	if err != nil {
+		return err
+	}
+
+	address := c.Params("address")
+	if address == "" {
+		return ErrInvalidParams
+	}
+
+	txs, err := h.service.GetWatcherAddressTransactions(c.Context(), device, address, c.QueryInt("page", 1), c.QueryInt("limit", TxHistoryDefaultLimit))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(txs)
+}
+
 func (h *Handler) GetWatcherHistoryPricesHandler(c *fiber.Ctx) error {
-	return c.JSON(h.service.GetWatcherHistoryPrices(c.Context()))
+	history, err := h.service.GetWatcherHistoryPrices(c.Context(), HistoryQuery{
+		Range:    c.Query("range"),
+		Interval: c.Query("interval"),
+		Format:   c.Query("format"),
+		Currency: strings.ToUpper(c.Query("currency")),
+		Token:    strings.ToUpper(c.Query("token")),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(history)
+}
+
+func (h *Handler) GetPriceTokensHandler(c *fiber.Ctx) error {
+	return c.JSON(PriceTokensResponse{Tokens: h.service.GetPriceTokens()})
 }
 
 type CreateWatcher struct {
@@ -67,76 +309,125 @@ func (h *Handler) CreateWatcherHandler(c *fiber.Ctx) error {
 	var reqBody CreateWatcher
 
 	if err := c.BodyParser(&reqBody); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"message": err.Error(),
-		})
+		return apierror.InvalidBody(err)
 	}
 
 	if err := Validate(reqBody); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		return err
 	}
 	if err := h.service.CreateWatcher(c.Context(), reqBody.PushToken, reqBody.DeviceId); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		return err
 	}
 
 	return c.JSON(fiber.Map{"status": "OK"})
 }
 
 type UpdateWatcher struct {
-	PushToken string    `json:"push_token" validate:"required"`
+	PushToken string    `json:"push_token" validate:"omitempty"`
 	Addresses *[]string `json:"addresses" validate:"omitempty,addresses"`
 	// Threshold *int      `json:"threshold" validate:"omitempty,threshold"`
 	Threshold         *float64 `json:"threshold" validate:"omitempty"`
 	TxNotification    *string  `json:"tx_notification" validate:"omitempty,notification"`
 	PriceNotification *string  `json:"price_notification" validate:"omitempty,notification"`
+	Currency          *string  `json:"currency" validate:"omitempty,currency"`
+	App               *AppInfo `json:"app" validate:"omitempty"`
 }
 
 func (h *Handler) UpdateWatcherHandler(c *fiber.Ctx) error {
 	var reqBody UpdateWatcher
 
 	if err := c.BodyParser(&reqBody); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"message": err.Error(),
-		})
+		return apierror.InvalidBody(err)
+	}
+
+	// Currencies are upper cased, like in the currency query
+	if reqBody.Currency != nil {
+		*reqBody.Currency = strings.ToUpper(*reqBody.Currency)
 	}
 
 	if err := Validate(reqBody); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		return err
+	}
+
+	device, err := requestDevice(c, reqBody.PushToken)
+	if err != nil {
+		return err
 	}
 
-	if err := h.service.UpdateWatcher(c.Context(), reqBody.PushToken, reqBody.Addresses, reqBody.Threshold, reqBody.TxNotification, reqBody.PriceNotification); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	if err := h.service.UpdateWatcher(c.Context(), device, reqBody.Addresses, reqBody.Threshold, reqBody.TxNotification, reqBody.PriceNotification, reqBody.Currency); err != nil {
+		return err
+	}
+
+	if reqBody.App != nil {
+		if err := h.service.UpdateWatcherApp(c.Context(), device, *reqBody.App); err != nil {
+			return err
+		}
 	}
 
 	return c.JSON(fiber.Map{"status": "OK"})
 }
 
 type DeleteWatcher struct {
-	PushToken string `json:"push_token" validate:"required"`
+	PushToken string `json:"push_token" validate:"omitempty"`
 }
 
 func (h *Handler) DeleteWatcherHandler(c *fiber.Ctx) error {
 	var reqBody DeleteWatcher
 
 	if err := c.BodyParser(&reqBody); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"message": err.Error(),
-		})
+		return apierror.InvalidBody(err)
 	}
 
 	if err := Validate(reqBody); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		return err
+	}
+
+	device, err := requestDevice(c, reqBody.PushToken)
+	if err != nil {
+		return err
 	}
 
-	if err := h.service.DeleteWatcher(c.Context(), reqBody.PushToken); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	if err := h.service.DeleteWatcher(c.Context(), device); err != nil {
+		return err
 	}
 
 	return c.JSON(fiber.Map{"status": "OK"})
 }
 
+type TestNotification struct {
+	PushToken string `json:"push_token" validate:"omitempty"`
+}
+
+// TestNotificationHandler sends sample alerts to the device and returns the
+// delivery report for support.
+func (h *Handler) TestNotificationHandler(c *fiber.Ctx) error {
+	var reqBody TestNotification
+
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&reqBody); err != nil {
+			return apierror.InvalidBody(err)
+		}
+	}
+
+	if err := Validate(reqBody); err != nil {
+		return err
+	}
+
+	device, err := requestDevice(c, reqBody.PushToken)
+	if err != nil {
+		return err
+	}
+
+	report, err := h.service.TestNotification(c.Context(), device)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(report)
+}
+
 type DeleteWatcherAddresses struct {
-	PushToken string   `json:"push_token" validate:"required"`
+	PushToken string   `json:"push_token" validate:"omitempty"`
 	Addresses []string `json:"addresses" validate:"required,addresses"`
 }
 
@@ -144,24 +435,162 @@ func (h *Handler) DeleteWatcherAddressesHandler(c *fiber.Ctx) error {
 	var reqBody DeleteWatcherAddresses
 
 	if err := c.BodyParser(&reqBody); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"message": err.Error(),
-		})
+		return apierror.InvalidBody(err)
+	}
+
+	if err := Validate(reqBody); err != nil {
+		return err
+	}
+
+	device, err := requestDevice(c, reqBody.PushToken)
+	if err != nil {
+		return err
+	}
+
+	if err := h.service.DeleteWatcherAddresses(c.Context(), device, reqBody.Addresses); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"status": "OK"})
+}
+
+type CreateAddressChallenge struct {
+	PushToken string `json:"push_token" validate:"omitempty"`
+	Address   string `json:"address" validate:"required,address"`
+}
+
+func (h *Handler) CreateAddressChallengeHandler(c *fiber.Ctx) error {
+	var reqBody CreateAddressChallenge
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return apierror.InvalidBody(err)
+	}
+
+	if err := Validate(reqBody); err != nil {
+		return err
+	}
+
+	device, err := requestDevice(c, reqBody.PushToken)
+	if err != nil {
+		return err
+	}
+
+	challenge, err := h.service.CreateAddressChallenge(c.Context(), device, reqBody.Address)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(challenge)
+}
+
+type VerifyAddress struct {
+	PushToken string `json:"push_token" validate:"omitempty"`
+	Address   string `json:"address" validate:"required,address"`
+	// Signature is the hex personal_sign signature of the challenge message
+	Signature string `json:"signature" validate:"required,signature"`
+}
+
+func (h *Handler) VerifyAddressHandler(c *fiber.Ctx) error {
+	var reqBody VerifyAddress
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return apierror.InvalidBody(err)
+	}
+
+	if err := Validate(reqBody); err != nil {
+		return err
+	}
+
+	device, err := requestDevice(c, reqBody.PushToken)
+	if err != nil {
+		return err
+	}
+
+	address, err := h.service.VerifyAddress(c.Context(), device, reqBody.Address, reqBody.Signature)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(address)
+}
+
+type TokenAlertUpdate struct {
+	Token        string   `json:"token" validate:"required"`
+	Threshold    *float64 `json:"threshold" validate:"omitempty,gt=0"`
+	Notification *string  `json:"notification" validate:"omitempty,notification"`
+	// Mode is one of last_alert, window_1h, window_24h or pinned
+	Mode *string `json:"mode" validate:"omitempty,oneof=last_alert window_1h window_24h pinned"`
+	// ReferencePrice is the pinned price, in the watcher display currency
+	ReferencePrice *float64 `json:"reference_price" validate:"omitempty,gt=0"`
+}
+
+type UpdateWatcherTokenAlerts struct {
+	PushToken string             `json:"push_token" validate:"omitempty"`
+	Alerts    []TokenAlertUpdate `json:"alerts" validate:"required,dive"`
+}
+
+func (h *Handler) UpdateWatcherTokenAlertsHandler(c *fiber.Ctx) error {
+	var reqBody UpdateWatcherTokenAlerts
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return apierror.InvalidBody(err)
 	}
 
 	if err := Validate(reqBody); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		return err
+	}
+
+	device, err := requestDevice(c, reqBody.PushToken)
+	if err != nil {
+		return err
 	}
 
-	if err := h.service.DeleteWatcherAddresses(c.Context(), reqBody.PushToken, reqBody.Addresses); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	// Token symbols are tracked upper cased, like in the price queries
+	for i := range reqBody.Alerts {
+		reqBody.Alerts[i].Token = strings.ToUpper(reqBody.Alerts[i].Token)
+	}
+
+	if err := h.service.UpdateWatcherTokenAlerts(c.Context(), device, reqBody.Alerts); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"status": "OK"})
+}
+
+type DeleteWatcherTokenAlerts struct {
+	PushToken string   `json:"push_token" validate:"omitempty"`
+	Tokens    []string `json:"tokens" validate:"required"`
+}
+
+func (h *Handler) DeleteWatcherTokenAlertsHandler(c *fiber.Ctx) error {
+	var reqBody DeleteWatcherTokenAlerts
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return apierror.InvalidBody(err)
+	}
+
+	if err := Validate(reqBody); err != nil {
+		return err
+	}
+
+	device, err := requestDevice(c, reqBody.PushToken)
+	if err != nil {
+		return err
+	}
+
+	for i := range reqBody.Tokens {
+		reqBody.Tokens[i] = strings.ToUpper(reqBody.Tokens[i])
+	}
+
+	if err := h.service.DeleteWatcherTokenAlerts(c.Context(), device, reqBody.Tokens); err != nil {
+		return err
 	}
 
 	return c.JSON(fiber.Map{"status": "OK"})
 }
 
 type UpdateWatcherPushToken struct {
-	OldPushToken string `json:"old_push_token" validate:"required"`
+	OldPushToken string `json:"old_push_token" validate:"omitempty"`
 	NewPushToken string `json:"new_push_token" validate:"required"`
 	DeviceId     string `json:"device_id" validate:"omitempty"`
 }
@@ -170,17 +599,20 @@ func (h *Handler) UpdateWatcherPushTokenHandler(c *fiber.Ctx) error {
 	var reqBody UpdateWatcherPushToken
 
 	if err := c.BodyParser(&reqBody); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"message": err.Error(),
-		})
+		return apierror.InvalidBody(err)
 	}
 
 	if err := Validate(reqBody); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		return err
+	}
+
+	device, err := requestDevice(c, reqBody.OldPushToken)
+	if err != nil {
+		return err
 	}
 
-	if err := h.service.UpdateWatcherPushToken(c.Context(), reqBody.OldPushToken, reqBody.NewPushToken, reqBody.DeviceId); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	if err := h.service.UpdateWatcherPushToken(c.Context(), device, reqBody.NewPushToken, reqBody.DeviceId); err != nil {
+		return err
 	}
 
 	return nil
@@ -193,31 +625,63 @@ type WatcherCallbackItem struct {
 
 type WatcherCallback struct {
 	Id    string                `json:"id" validate:"required"`
-	Items []WatcherCallbackItem `json:"items" validate:"required"`
+	Items []WatcherCallbackItem `json:"items" validate:"required,dive"`
+}
+
+// verifyCallback checks the HMAC signature of an explorer callback. It
+// reports whether the request was signed; unsigned requests are only let
+// through while allowUnsignedCallbacks is set. Without secrets a signature
+// can't be checked, so a signed request counts as unsigned, like while the
+// explorer signs before the API has the secrets.
+func (h *Handler) verifyCallback(c *fiber.Ctx) (bool, error) {
+	signature := c.Get(CallbackSignatureHeader)
+	if signature != "" && !h.callbackVerifier.Enabled() {
+		h.logger.Warnln("verifyCallback signed callback but no callback secrets are set, handling it as unsigned")
+		signature = ""
+	}
+	if signature == "" {
+		if h.allowUnsignedCallbacks {
+			return false, nil
+		}
+		return false, hmacauth.ErrMissingSignature
+	}
+
+	if err := h.callbackVerifier.Verify(c.Get(CallbackTimestampHeader), c.Get(CallbackNonceHeader), signature, c.Body()); err != nil {
+		return false, err
+	}
+
+	return true, nil
 }
 
 func (h *Handler) WatcherCallbackHandler(c *fiber.Ctx) error {
+	signed, err := h.verifyCallback(c)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCallbackSignature, err)
+	}
+
 	var reqBody WatcherCallback
 
 	if err := c.BodyParser(&reqBody); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"message": err.Error(),
-		})
+		return apierror.InvalidBody(err)
 	}
 
 	if err := Validate(reqBody); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		return err
 	}
 
-	if reqBody.Id != h.service.GetExplorerId() {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Wrong Explorer API ID"})
+	if !signed && reqBody.Id != h.service.GetExplorerId() {
+		return ErrWrongExplorerId
 	}
 
-	cache := make(map[string]bool)
-	ctx := c.Context()
-	for _, item := range reqBody.Items {
-		h.service.TransactionWatch(ctx, item.Address, item.TxHash, cache)
+	h.service.RecordCallback()
+
+	batchId, err := h.callbackQueue.Enqueue(c.Context(), reqBody.Items)
+	if err != nil {
+		if errors.Is(err, ErrCallbackQueueFull) {
+			c.Set(fiber.HeaderRetryAfter, "5")
+		}
+		return err
 	}
 
-	return c.JSON(fiber.Map{"status": "OK"})
+	return c.Status(fiber.StatusAccepted).JSON(CallbackResponse{Status: "OK", BatchId: batchId})
 }
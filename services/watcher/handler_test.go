@@ -0,0 +1,68 @@
+package watcher
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"airdao-mobile-api/pkg/hmacauth"
+	"airdao-mobile-api/pkg/idempotency"
+	"airdao-mobile-api/pkg/metrics"
+	"airdao-mobile-api/pkg/ratelimit"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type stubCallbackService struct{ Service }
+
+func TestVerifyCallback(t *testing.T) {
+	tests := []struct {
+		name          string
+		secrets       []string
+		allowUnsigned bool
+		signature     string
+
+		wantStatus int
+	}{
+		{name: "should let an unsigned callback through", allowUnsigned: true, wantStatus: fiber.StatusOK},
+		{name: "should handle a signed callback as unsigned without secrets", allowUnsigned: true, signature: "signature", wantStatus: fiber.StatusOK},
+		{name: "should refuse an unsigned callback", secrets: []string{"secret"}, wantStatus: fiber.StatusUnauthorized},
+		{name: "should refuse a wrong signature", secrets: []string{"secret"}, allowUnsigned: true, signature: "signature", wantStatus: fiber.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		verifier, err := hmacauth.NewVerifier(test.secrets, time.Minute)
+		require.NoError(t, err, test.name)
+
+		limiter, err := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), nil, metrics.NewRegistry(), zap.NewNop().Sugar())
+		require.NoError(t, err, test.name)
+
+		keys, err := idempotency.New(idempotency.NewMemoryStore(), time.Hour, metrics.NewRegistry(), zap.NewNop().Sugar())
+		require.NoError(t, err, test.name)
+
+		h, err := NewHandler(stubCallbackService{}, idleQueue{}, verifier, test.allowUnsigned, false, limiter, keys, zap.NewNop().Sugar())
+		require.NoError(t, err, test.name)
+
+		app := fiber.New()
+		app.Post("/callback", func(c *fiber.Ctx) error {
+			if _, err := h.verifyCallback(c); err != nil {
+				return fiber.ErrUnauthorized
+			}
+			return nil
+		})
+
+		req := httptest.NewRequest(fiber.MethodPost, "/callback", nil)
+		if test.signature != "" {
+			req.Header.Set(CallbackTimestampHeader, "1")
+			req.Header.Set(CallbackNonceHeader, "nonce")
+			req.Header.Set(CallbackSignatureHeader, test.signature)
+		}
+
+		resp, err := app.Test(req)
+		require.NoError(t, err, test.name)
+		assert.Equal(t, test.wantStatus, resp.StatusCode, test.name)
+	}
+}
@@ -0,0 +1,462 @@
+package watcher
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"airdao-mobile-api/pkg/apierror"
+	"airdao-mobile-api/services/price"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// watcherLocal carries the watcher of the authenticated device
+	watcherLocal = "watcher"
+
+	notificationsDefaultLimit = 50
+	notificationsMaxLimit     = 500
+)
+
+// SetupRoutesV2 registers the resource oriented routes. Devices are
+// addressed by the id returned on registration and always authenticate
+// with their credential.
+func (h *Handler) SetupRoutesV2(router fiber.Router) {
+	router.Post("/devices", h.authLimit, h.authIdempotent, h.CreateDeviceV2Handler)
+	router.Post("/devices/:id/tokens", h.authLimit, h.authIdempotent, h.CreateDeviceTokensV2Handler)
+
+	device := router.Group("/devices/:id", h.deviceAuthV2, h.deviceLimit, h.idempotent)
+
+	device.Get("", h.GetDeviceV2Handler)
+	device.Patch("", h.UpdateDeviceV2Handler)
+	device.Delete("", h.DeleteDeviceV2Handler)
+
+	device.Get("/portfolio", h.GetDevicePortfolioV2Handler)
+
+	device.Get("/addresses", h.GetDeviceAddressesV2Handler)
+	device.Post("/addresses", h.CreateDeviceAddressV2Handler)
+	device.Get("/addresses/:address", h.GetDeviceAddressV2Handler)
+	device.Delete("/addresses/:address", h.DeleteDeviceAddressV2Handler)
+	device.Get("/addresses/:address/transactions", h.GetDeviceAddressTransactionsV2Handler)
+	device.Post("/addresses/:address/challenge", h.CreateDeviceAddressChallengeV2Handler)
+	device.Post("/addresses/:address/verification", h.VerifyDeviceAddressV2Handler)
+
+	device.Get("/alerts", h.GetDeviceAlertsV2Handler)
+	device.Put("/alerts/:token", h.PutDeviceAlertV2Handler)
+	device.Delete("/alerts/:token", h.DeleteDeviceAlertV2Handler)
+
+	device.Get("/notifications", h.GetDeviceNotificationsV2Handler)
+}
+
+// deviceAuthV2 requires the bearer access token of the device in the path.
+// Another device's id answers not found, so ids can't be probed.
+func (h *Handler) deviceAuthV2(c *fiber.Ctx) error {
+	accessToken := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if accessToken == "" || accessToken == c.Get(fiber.HeaderAuthorization) {
+		return ErrMissingCredential
+	}
+
+	pushToken, err := h.service.AuthenticateDevice(c.Context(), accessToken)
+	if err != nil {
+		return err
+	}
+
+	c.Locals(pushTokenLocal, pushToken)
+
+	watcher, err := h.service.GetWatcher(c.Context(), Device{PushToken: pushToken, Authenticated: true})
+	if err != nil {
+		return err
+	}
+	if watcher.ID.Hex() != c.Params("id") {
+		return ErrDeviceNotFound
+	}
+
+	c.Locals(watcherLocal, watcher)
+
+	return c.Next()
+}
+
+func deviceWatcher(c *fiber.Ctx) (Device, *Watcher) {
+	pushToken, _ := c.Locals(pushTokenLocal).(string)
+	watcher, _ := c.Locals(watcherLocal).(*Watcher)
+	return Device{PushToken: pushToken, Authenticated: true}, watcher
+}
+
+func (h *Handler) CreateDeviceV2Handler(c *fiber.Ctx) error {
+	var reqBody RegisterDevice
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return apierror.InvalidBody(err)
+	}
+
+	if err := Validate(reqBody); err != nil {
+		return err
+	}
+
+	tokens, err := h.service.RegisterDevice(c.Context(), reqBody.PushToken, reqBody.DeviceId)
+	if err != nil {
+		return err
+	}
+
+	// The device now holds a credential, this request is its first use
+	c.Locals(pushTokenLocal, reqBody.PushToken)
+
+	if reqBody.App != nil {
+		if err := h.service.UpdateWatcherApp(c.Context(), Device{PushToken: reqBody.PushToken, Authenticated: true}, *reqBody.App); err != nil {
+			return err
+		}
+	}
+
+	c.Location("devices/" + tokens.Id)
+
+	return c.Status(fiber.StatusCreated).JSON(tokens)
+}
+
+func (h *Handler) CreateDeviceTokensV2Handler(c *fiber.Ctx) error {
+	var reqBody RefreshDevice
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return apierror.InvalidBody(err)
+	}
+
+	if err := Validate(reqBody); err != nil {
+		return err
+	}
+
+	// Refresh tokens are prefixed with the id of their device
+	if !strings.HasPrefix(reqBody.RefreshToken, c.Params("id")+".") {
+		return ErrInvalidRefreshToken
+	}
+
+	tokens, err := h.service.RefreshDevice(c.Context(), reqBody.RefreshToken)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(tokens)
+}
+
+func (h *Handler) GetDeviceV2Handler(c *fiber.Ctx) error {
+	_, watcher := deviceWatcher(c)
+	return c.JSON(watcher)
+}
+
+type UpdateDeviceV2 struct {
+	PushToken         *string  `json:"push_token" validate:"omitempty,min=1"`
+	Threshold         *float64 `json:"threshold" validate:"omitempty,gt=0"`
+	TxNotification    *string  `json:"tx_notification" validate:"omitempty,notification"`
+	PriceNotification *string  `json:"price_notification" validate:"omitempty,notification"`
+	Currency          *string  `json:"currency" validate:"omitempty,currency"`
+	App               *AppInfo `json:"app" validate:"omitempty"`
+}
+
+func (h *Handler) UpdateDeviceV2Handler(c *fiber.Ctx) error {
+	var reqBody UpdateDeviceV2
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return apierror.InvalidBody(err)
+	}
+
+	if reqBody.Currency != nil {
+		*reqBody.Currency = strings.ToUpper(*reqBody.Currency)
+	}
+
+	if err := Validate(reqBody); err != nil {
+		return err
+	}
+
+	device, _ := deviceWatcher(c)
+
+	watcher, err := h.service.UpdateDevice(c.Context(), device, DeviceUpdate{
+		PushToken:         reqBody.PushToken,
+		Threshold:         reqBody.Threshold,
+		TxNotification:    reqBody.TxNotification,
+		PriceNotification: reqBody.PriceNotification,
+		Currency:          reqBody.Currency,
+		App:               reqBody.App,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(watcher)
+}
+
+func (h *Handler) DeleteDeviceV2Handler(c *fiber.Ctx) error {
+	device, _ := deviceWatcher(c)
+
+	if err := h.service.DeleteWatcher(c.Context(), device); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *Handler) GetDevicePortfolioV2Handler(c *fiber.Ctx) error {
+	device, _ := deviceWatcher(c)
+
+	portfolio, err := h.service.GetWatcherPortfolio(c.Context(), device)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(portfolio)
+}
+
+func (h *Handler) GetDeviceAddressesV2Handler(c *fiber.Ctx) error {
+	_, watcher := deviceWatcher(c)
+
+	addresses := make([]*Address, 0)
+	if watcher.Addresses != nil {
+		addresses = append(addresses, *watcher.Addresses...)
+	}
+
+	return c.JSON(AddressesResponse{Addresses: addresses})
+}
+
+type CreateDeviceAddressV2 struct {
+	Address string `json:"address" validate:"required,address"`
+}
+
+func (h *Handler) CreateDeviceAddressV2Handler(c *fiber.Ctx) error {
+	var reqBody CreateDeviceAddressV2
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return apierror.InvalidBody(err)
+	}
+
+	if err := Validate(reqBody); err != nil {
+		return err
+	}
+
+	device, watcher := deviceWatcher(c)
+
+	if err := h.service.UpdateWatcher(c.Context(), device, &[]string{reqBody.Address}, nil, nil, nil, nil); err != nil {
+		return err
+	}
+
+	c.Location("addresses/" + reqBody.Address)
+
+	return c.Status(fiber.StatusCreated).JSON(watcher.GetAddress(reqBody.Address))
+}
+
+// pathAddress returns the watched address of the path.
+func pathAddress(c *fiber.Ctx, watcher *Watcher) (*Address, error) {
+	address, err := url.PathUnescape(c.Params("address"))
+	if err != nil {
+		return nil, err
+	}
+
+	watched := watcher.GetAddress(address)
+	if watched == nil {
+		return nil, ErrAddressNotWatched
+	}
+
+	return watched, nil
+}
+
+func (h *Handler) GetDeviceAddressV2Handler(c *fiber.Ctx) error {
+	_, watcher := deviceWatcher(c)
+
+	address, err := pathAddress(c, watcher)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(address)
+}
+
+func (h *Handler) DeleteDeviceAddressV2Handler(c *fiber.Ctx) error {
+	device, watcher := deviceWatcher(c)
+
+	address, err := pathAddress(c, watcher)
+	if err != nil {
+		return err
+	}
+
+	if err := h.service.DeleteWatcherAddresses(c.Context(), device, []string{address.Address}); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *Handler) GetDeviceAddressTransactionsV2Handler(c *fiber.Ctx) error {
+	device, watcher := deviceWatcher(c)
+
+	address, err := pathAddress(c, watcher)
+	if err != nil {
+		return err
+	}
+
+	txs, err := h.service.GetWatcherAddressTransactions(c.Context(), device, address.Address, c.QueryInt("page", 1), c.QueryInt("limit", TxHistoryDefaultLimit))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(txs)
+}
+
+func (h *Handler) CreateDeviceAddressChallengeV2Handler(c *fiber.Ctx) error {
+	device, watcher := deviceWatcher(c)
+
+	address, err := pathAddress(c, watcher)
+	if err != nil {
+		return err
+	}
+
+	challenge, err := h.service.CreateAddressChallenge(c.Context(), device, address.Address)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(challenge)
+}
+
+type VerifyDeviceAddressV2 struct {
+	Signature string `json:"signature" validate:"required,signature"`
+}
+
+func (h *Handler) VerifyDeviceAddressV2Handler(c *fiber.Ctx) error {
+	var reqBody VerifyDeviceAddressV2
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return apierror.InvalidBody(err)
+	}
+
+	if err := Validate(reqBody); err != nil {
+		return err
+	}
+
+	device, watcher := deviceWatcher(c)
+
+	address, err := pathAddress(c, watcher)
+	if err != nil {
+		return err
+	}
+
+	verified, err := h.service.VerifyAddress(c.Context(), device, address.Address, reqBody.Signature)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(verified)
+}
+
+// AlertV2 is a price alert of a device, AMB included.
+type AlertV2 struct {
+	Token          string   `json:"token"`
+	Threshold      *float64 `json:"threshold"`
+	ReferencePrice *float64 `json:"reference_price"`
+	Notification   string   `json:"notification"`
+
+	PriceAlertState
+}
+
+func deviceAlert(watcher *Watcher, token string) *AlertV2 {
+	threshold, reference, notification, ok := watcher.PriceAlert(token)
+	if !ok {
+		return nil
+	}
+
+	alert := &AlertV2{Token: token, Threshold: threshold, ReferencePrice: reference, Notification: notification}
+	if state := watcher.AlertState(token); state != nil {
+		alert.PriceAlertState = *state
+		alert.Mode = state.AlertMode()
+	}
+
+	return alert
+}
+
+func (h *Handler) GetDeviceAlertsV2Handler(c *fiber.Ctx) error {
+	_, watcher := deviceWatcher(c)
+
+	alerts := []*AlertV2{deviceAlert(watcher, price.DefaultToken)}
+	if watcher.TokenAlerts != nil {
+		for _, alert := range *watcher.TokenAlerts {
+			alerts = append(alerts, deviceAlert(watcher, alert.Token))
+		}
+	}
+
+	return c.JSON(AlertsResponse{Alerts: alerts})
+}
+
+func (h *Handler) PutDeviceAlertV2Handler(c *fiber.Ctx) error {
+	var reqBody TokenAlertUpdate
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return apierror.InvalidBody(err)
+	}
+
+	reqBody.Token = strings.ToUpper(c.Params("token"))
+	if err := Validate(reqBody); err != nil {
+		return err
+	}
+
+	device, watcher := deviceWatcher(c)
+	_, _, _, exists := watcher.PriceAlert(reqBody.Token)
+
+	if err := h.service.UpdateWatcherTokenAlerts(c.Context(), device, []TokenAlertUpdate{reqBody}); err != nil {
+		return err
+	}
+
+	status := fiber.StatusOK
+	if !exists {
+		status = fiber.StatusCreated
+	}
+
+	return c.Status(status).JSON(deviceAlert(watcher, reqBody.Token))
+}
+
+// DeleteDeviceAlertV2Handler removes a token alert. The AMB alert can't be
+// removed, deleting it turns its notifications off.
+func (h *Handler) DeleteDeviceAlertV2Handler(c *fiber.Ctx) error {
+	device, watcher := deviceWatcher(c)
+
+	token := strings.ToUpper(c.Params("token"))
+	if _, _, _, ok := watcher.PriceAlert(token); !ok {
+		return ErrAlertNotFound
+	}
+
+	if err := h.service.DeleteWatcherTokenAlerts(c.Context(), device, []string{token}); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetDeviceNotificationsV2Handler pages through the notification history,
+// newest first.
+func (h *Handler) GetDeviceNotificationsV2Handler(c *fiber.Ctx) error {
+	_, watcher := deviceWatcher(c)
+
+	page := c.QueryInt("page", 1)
+	if page <= 0 {
+		page = 1
+	}
+	limit := c.QueryInt("limit", notificationsDefaultLimit)
+	if limit <= 0 || limit > notificationsMaxLimit {
+		limit = notificationsDefaultLimit
+	}
+
+	notifications := make([]*HistoryNotification, 0)
+	if watcher.HistoricalNotifications != nil {
+		notifications = append(notifications, *watcher.HistoricalNotifications...)
+	}
+	sort.SliceStable(notifications, func(i, j int) bool {
+		return notifications[i].Timestamp.After(notifications[j].Timestamp)
+	})
+
+	total := len(notifications)
+	from := (page - 1) * limit
+	if from > total {
+		from = total
+	}
+	to := from + limit
+	if to > total {
+		to = total
+	}
+
+	return c.JSON(NotificationsResponse{Notifications: notifications[from:to], Page: page, Limit: limit, Total: total})
+}
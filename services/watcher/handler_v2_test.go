@@ -0,0 +1,178 @@
+package watcher
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"airdao-mobile-api/pkg/apierror"
+	"airdao-mobile-api/pkg/hmacauth"
+	"airdao-mobile-api/pkg/idempotency"
+	"airdao-mobile-api/pkg/metrics"
+	"airdao-mobile-api/pkg/ratelimit"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+)
+
+type idleQueue struct{ CallbackQueue }
+
+// deviceApp serves the v2 routes of s
+func deviceApp(t *testing.T, s *service) *fiber.App {
+	verifier, err := hmacauth.NewVerifier(nil, time.Minute)
+	require.NoError(t, err)
+
+	limiter, err := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), nil, metrics.NewRegistry(), zap.NewNop().Sugar())
+	require.NoError(t, err)
+
+	keys, err := idempotency.New(idempotency.NewMemoryStore(), time.Hour, metrics.NewRegistry(), zap.NewNop().Sugar())
+	require.NoError(t, err)
+
+	h, err := NewHandler(s, idleQueue{}, verifier, true, false, limiter, keys, zap.NewNop().Sugar())
+	require.NoError(t, err)
+
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.Handler(zap.NewNop().Sugar())})
+	app.Route("/api/v2", h.SetupRoutesV2)
+
+	return app
+}
+
+// call sends body as JSON with the access token and decodes the response into out
+func call(t *testing.T, app *fiber.App, method, path, accessToken, body string, out interface{}) int {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if accessToken != "" {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+accessToken)
+	}
+
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	if out != nil {
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, out), string(data))
+	}
+
+	return resp.StatusCode
+}
+
+func TestDeviceV2Handlers(t *testing.T) {
+	s, _ := newDeviceService(t)
+	app := deviceApp(t, s)
+
+	var device DeviceTokens
+	status := call(t, app, fiber.MethodPost, "/api/v2/devices", "", `{"push_token":"push-token","device_id":"device"}`, &device)
+	require.Equal(t, fiber.StatusCreated, status)
+	assert.NotEmpty(t, device.AccessToken)
+
+	var other DeviceTokens
+	status = call(t, app, fiber.MethodPost, "/api/v2/devices", "", `{"push_token":"other-push-token","device_id":"other-device"}`, &other)
+	require.Equal(t, fiber.StatusCreated, status)
+
+	status = call(t, app, fiber.MethodPost, "/api/v2/devices", "", `{"push_token":"push-token"}`, nil)
+	assert.Equal(t, fiber.StatusConflict, status, "should not register a device twice")
+
+	var refreshed DeviceTokens
+	status = call(t, app, fiber.MethodPost, "/api/v2/devices/"+device.Id+"/tokens", "", `{"refresh_token":"`+device.RefreshToken+`"}`, &refreshed)
+	require.Equal(t, fiber.StatusCreated, status)
+
+	var apiErr apierror.Response
+	status = call(t, app, fiber.MethodGet, "/api/v2/devices/"+device.Id, "", "", &apiErr)
+	assert.Equal(t, fiber.StatusUnauthorized, status)
+	assert.Equal(t, ErrMissingCredential.Code, apiErr.Code)
+
+	status = call(t, app, fiber.MethodGet, "/api/v2/devices/"+other.Id, refreshed.AccessToken, "", &apiErr)
+	assert.Equal(t, fiber.StatusNotFound, status, "should hide the other device")
+	assert.Equal(t, ErrDeviceNotFound.Code, apiErr.Code)
+
+	// Taking the push token of the other device fails the whole update
+	status = call(t, app, fiber.MethodPatch, "/api/v2/devices/"+device.Id, refreshed.AccessToken, `{"push_token":"other-push-token","threshold":10}`, &apiErr)
+	assert.Equal(t, fiber.StatusConflict, status)
+	assert.Equal(t, ErrPushTokenTaken.Code, apiErr.Code)
+
+	var watcher Watcher
+	status = call(t, app, fiber.MethodGet, "/api/v2/devices/"+device.Id, refreshed.AccessToken, "", &watcher)
+	require.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("push-token")), watcher.PushToken)
+	require.NotNil(t, watcher.Threshold)
+	assert.Equal(t, 5.0, *watcher.Threshold, "should not apply part of a refused update")
+
+	status = call(t, app, fiber.MethodPatch, "/api/v2/devices/"+device.Id, refreshed.AccessToken, `{"push_token":"new-push-token","threshold":10}`, &watcher)
+	require.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("new-push-token")), watcher.PushToken)
+	assert.Equal(t, 10.0, *watcher.Threshold)
+
+	status = call(t, app, fiber.MethodPatch, "/api/v2/devices/"+device.Id, refreshed.AccessToken, `{"currency":"usd"}`, &watcher)
+	assert.Equal(t, fiber.StatusOK, status, "should take a lower cased currency like the query does")
+	assert.Equal(t, "USD", watcher.DisplayCurrency())
+
+	// The access token still authenticates the device under its new push token
+	status = call(t, app, fiber.MethodDelete, "/api/v2/devices/"+device.Id, refreshed.AccessToken, "", nil)
+	assert.Equal(t, fiber.StatusNoContent, status)
+
+	status = call(t, app, fiber.MethodGet, "/api/v2/devices/"+device.Id, refreshed.AccessToken, "", &apiErr)
+	assert.Equal(t, fiber.StatusUnauthorized, status)
+	assert.Equal(t, ErrCredentialRevoked.Code, apiErr.Code)
+
+	status = call(t, app, fiber.MethodGet, "/api/v2/devices/"+other.Id, other.AccessToken, "", &watcher)
+	assert.Equal(t, fiber.StatusOK, status, "should keep the other device")
+}
+
+func TestUpdateWatcherPushTokenOwnership(t *testing.T) {
+	s, repository := newDeviceService(t, "push-token", "other-push-token")
+
+	err := s.UpdateWatcherPushToken(context.Background(), Device{PushToken: "push-token"}, "other-push-token", "device")
+	assert.ErrorIs(t, err, ErrPushTokenTaken)
+
+	watcher, err := s.GetWatcher(context.Background(), Device{PushToken: "push-token"})
+	require.NoError(t, err)
+	assert.Empty(t, watcher.DeviceId, "should leave the watcher unchanged")
+
+	require.NoError(t, s.UpdateWatcherPushToken(context.Background(), Device{PushToken: "push-token"}, "new-push-token", "device"))
+	stored, err := repository.GetWatcher(context.Background(), bson.M{"device_id": "device"})
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("new-push-token")), stored.PushToken)
+}
+
+func TestCreateDeviceV2Idempotent(t *testing.T) {
+	s, _ := newDeviceService(t)
+	app := deviceApp(t, s)
+
+	register := func(key string) (int, string, string) {
+		req := httptest.NewRequest(fiber.MethodPost, "/api/v2/devices", strings.NewReader(`{"push_token":"push-token"}`))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		req.Header.Set(idempotency.HeaderKey, key)
+
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return resp.StatusCode, string(body), resp.Header.Get(idempotency.HeaderReplayed)
+	}
+
+	status, first, _ := register("key")
+	require.Equal(t, fiber.StatusCreated, status)
+
+	// The retry of a registration whose response got lost gets the same tokens
+	status, retried, replayed := register("key")
+	assert.Equal(t, fiber.StatusCreated, status)
+	assert.Equal(t, "true", replayed)
+	assert.Equal(t, first, retried)
+
+	status, _, _ = register("other-key")
+	assert.Equal(t, fiber.StatusConflict, status)
+}
@@ -0,0 +1,168 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"airdao-mobile-api/services/health"
+)
+
+const heartbeatRateWindow = 5 * time.Minute
+
+// heartbeat tracks when the explorer last delivered a callback and how many
+// callbacks arrived recently.
+type heartbeat struct {
+	mx          sync.Mutex
+	startedAt   time.Time
+	lastAt      time.Time
+	recent      []time.Time
+	degraded    bool
+	recoveredAt time.Time
+}
+
+type HeartbeatStatus struct {
+	LastCallbackAt     *time.Time `json:"last_callback_at"`
+	CallbacksPerMinute float64    `json:"callbacks_per_minute"`
+	Subscriptions      int        `json:"subscriptions"`
+	Degraded           bool       `json:"degraded"`
+	LastRecoveryAt     *time.Time `json:"last_recovery_at,omitempty"`
+}
+
+func (h *heartbeat) beat(now time.Time) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	h.lastAt = now
+	h.degraded = false
+	h.recent = append(h.trim(now), now)
+}
+
+// trim drops callbacks that fell out of the rate window. It must be called
+// with h.mx held.
+func (h *heartbeat) trim(now time.Time) []time.Time {
+	i := 0
+	for i < len(h.recent) && now.Sub(h.recent[i]) > heartbeatRateWindow {
+		i++
+	}
+	h.recent = h.recent[i:]
+
+	return h.recent
+}
+
+// RecordCallback marks that the explorer delivered a callback just now.
+func (s *service) RecordCallback() {
+	s.heartbeat.beat(time.Now())
+	s.metrics.Counter("explorer_callbacks_total").Inc()
+}
+
+func (s *service) HeartbeatStatus() *HeartbeatStatus {
+	now := time.Now()
+
+	s.heartbeat.mx.Lock()
+	status := &HeartbeatStatus{
+		CallbacksPerMinute: float64(len(s.heartbeat.trim(now))) / heartbeatRateWindow.Minutes(),
+		Degraded:           s.heartbeat.degraded,
+	}
+	if !s.heartbeat.lastAt.IsZero() {
+		lastAt := s.heartbeat.lastAt
+		status.LastCallbackAt = &lastAt
+	}
+	if !s.heartbeat.recoveredAt.IsZero() {
+		recoveredAt := s.heartbeat.recoveredAt
+		status.LastRecoveryAt = &recoveredAt
+	}
+	s.heartbeat.mx.Unlock()
+
+	status.Subscriptions = s.watchedAddressCount()
+
+	return status
+}
+
+func (s *service) HealthCheck() health.Check {
+	status := s.HeartbeatStatus()
+
+	return health.Check{Name: "explorer_callbacks", Healthy: !status.Degraded, Details: status}
+}
+
+func (s *service) watchedAddressCount() int {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	count := 0
+	for _, items := range s.cachedWatcherByAddress {
+		if items != nil && !items.IsEmpty() {
+			count++
+		}
+	}
+
+	return count
+}
+
+// heartbeatLoop watches for the explorer going quiet. When no callback came
+// in for SilenceTimeout while addresses are subscribed, the explorer is
+// re-initialised, subscriptions are reconciled and missed txs backfilled.
+// The service is degraded until a callback comes in or the recovery shows
+// the explorer answers, the addresses may just be quiet.
+func (s *service) heartbeatLoop(ctx context.Context) {
+	ticker := time.NewTicker(s.heartbeatCfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if s.watchedAddressCount() == 0 {
+				continue
+			}
+
+			s.heartbeat.mx.Lock()
+			since := s.heartbeat.lastAt
+			if since.IsZero() {
+				since = s.heartbeat.startedAt
+			}
+			// Don't start another recovery until the previous one had time to work
+			if s.heartbeat.recoveredAt.After(since) {
+				since = s.heartbeat.recoveredAt
+			}
+			silent := now.Sub(since) > s.heartbeatCfg.SilenceTimeout
+			if silent {
+				s.heartbeat.degraded = true
+				s.heartbeat.recoveredAt = now
+			}
+			s.heartbeat.mx.Unlock()
+
+			if silent {
+				s.logger.Warnf("heartbeatLoop no explorer callbacks since %v, recovering", since)
+				s.metrics.Counter("explorer_callback_recoveries_total").Inc()
+				if s.recoverCallbacks(ctx) {
+					s.heartbeat.mx.Lock()
+					s.heartbeat.degraded = false
+					s.heartbeat.mx.Unlock()
+				}
+			}
+		}
+	}
+}
+
+// recoverCallbacks reports whether every step succeeded.
+func (s *service) recoverCallbacks(ctx context.Context) bool {
+	if err := s.explorerWatch(&watchRequest{Action: "init", Url: s.callbackUrl}, nil); err != nil {
+		s.logger.Errorf("recoverCallbacks explorerWatch init error %v", err)
+		return false
+	}
+
+	recovered := true
+	if _, err := s.Reconcile(ctx, true); err != nil {
+		s.logger.Errorf("recoverCallbacks Reconcile error %v", err)
+		recovered = false
+	}
+
+	if err := s.Backfill(ctx); err != nil {
+		s.logger.Errorf("recoverCallbacks Backfill error %v", err)
+		recovered = false
+	}
+
+	return recovered
+}
@@ -0,0 +1,65 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"airdao-mobile-api/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type heartbeatRepository struct{ Repository }
+
+func (heartbeatRepository) GetWatchedAddresses(ctx context.Context) ([]string, error) {
+	return []string{"0xaddress"}, nil
+}
+
+func TestHeartbeatLoopRecovery(t *testing.T) {
+	tests := []struct {
+		name         string
+		explorerDown bool
+
+		wantDegraded bool
+	}{
+		{name: "should clear degraded once the explorer answers the recovery"},
+		{name: "should stay degraded while the explorer is down", explorerDown: true, wantDegraded: true},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if test.explorerDown {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprint(w, `{"addresses":["0xaddress"]}`)
+		}))
+
+		// The address is subscribed but quiet
+		s := newAlertService(t, time.Now(), 0.02, nil, nil, heartbeatRepository{})
+		s.explorerUrl = server.URL
+		s.heartbeatCfg = config.Heartbeat{SilenceTimeout: 20 * time.Millisecond, CheckInterval: 5 * time.Millisecond}
+		s.heartbeat = heartbeat{startedAt: time.Now().Add(-time.Minute)}
+		watcher := &Watcher{PushToken: "push-token"}
+		s.cachedWatcherByAddress = map[string]*watchers{"0xaddress": {watchers: map[string]*Watcher{watcher.PushToken: watcher}}}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go s.heartbeatLoop(ctx)
+
+		require.Eventually(t, func() bool { return s.HeartbeatStatus().LastRecoveryAt != nil }, time.Second, 5*time.Millisecond, test.name)
+		if test.wantDegraded {
+			time.Sleep(50 * time.Millisecond)
+			assert.True(t, s.HeartbeatStatus().Degraded, test.name)
+		} else {
+			assert.Eventually(t, func() bool { return !s.HeartbeatStatus().Degraded }, time.Second, time.Millisecond, test.name)
+		}
+
+		cancel()
+		server.Close()
+	}
+}
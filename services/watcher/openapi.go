@@ -0,0 +1,190 @@
+package watcher
+
+import (
+	"strconv"
+
+	"airdao-mobile-api/pkg/idempotency"
+	"airdao-mobile-api/pkg/openapi"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DeviceSecurity is the security scheme of routes taking a device access
+// token.
+const DeviceSecurity = "device"
+
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+type CallbackResponse struct {
+	Status  string `json:"status"`
+	BatchId string `json:"batch_id"`
+}
+
+type PriceTokensResponse struct {
+	Tokens []string `json:"tokens"`
+}
+
+type AddressesResponse struct {
+	Addresses []*Address `json:"addresses"`
+}
+
+type AlertsResponse struct {
+	Alerts []*AlertV2 `json:"alerts"`
+}
+
+type NotificationsResponse struct {
+	Notifications []*HistoryNotification `json:"notifications"`
+	Page          int                    `json:"page"`
+	Limit         int                    `json:"limit"`
+	Total         int                    `json:"total"`
+}
+
+func describeValidators(doc *openapi.Document) {
+	address := func(s *openapi.Schema) { s.Pattern = "^0x[0-9a-fA-F]{40}$" }
+
+	doc.Validator("address", address)
+	doc.Validator("addresses", func(s *openapi.Schema) {
+		if s.Items != nil {
+			address(s.Items)
+		}
+	})
+	doc.Validator("signature", func(s *openapi.Schema) { s.Pattern = "^0x[0-9a-fA-F]{130}$" })
+	doc.Validator("notification", func(s *openapi.Schema) { s.Enum = []string{"on", "off"} })
+	doc.Validator("currency", func(s *openapi.Schema) { s.Pattern = "^[A-Za-z]{3}$" })
+
+	doc.SecurityScheme(DeviceSecurity, openapi.SecurityScheme{
+		Type:         "http",
+		Scheme:       "bearer",
+		BearerFormat: "JWT",
+		Description:  "Device access token from /device/register or /devices",
+	})
+}
+
+func pageQuery(defaultLimit int) []openapi.Parameter {
+	return []openapi.Parameter{
+		{Name: "page", In: "query", Schema: &openapi.Schema{Type: "integer"}},
+		{Name: "limit", In: "query", Schema: &openapi.Schema{Type: "integer"}, Description: "page size, " + strconv.Itoa(defaultLimit) + " by default"},
+	}
+}
+
+// withIdempotencyKey documents the Idempotency-Key header of the device
+// routes changing something.
+func withIdempotencyKey(route openapi.Route) openapi.Route {
+	if route.Method == fiber.MethodGet || len(route.Security) == 0 {
+		return route
+	}
+
+	return idempotent(route)
+}
+
+// idempotent documents the Idempotency-Key header of route, for the
+// credential routes which take one without being device routes.
+func idempotent(route openapi.Route) openapi.Route {
+	route.Parameters = append(route.Parameters, openapi.Parameter{
+		Name:        idempotency.HeaderKey,
+		In:          "header",
+		Description: "retries with the same key get the response of the first request",
+		Schema:      &openapi.Schema{Type: "string"},
+	})
+
+	return route
+}
+
+// DescribeRoutes documents the routes of SetupRoutes under prefix. The push
+// token routes are only documented while they are served.
+func (h *Handler) DescribeRoutes(doc *openapi.Document, prefix string) {
+	describeValidators(doc)
+
+	tags := []string{"watcher"}
+	security := []string{DeviceSecurity}
+
+	txQuery := pageQuery(TxHistoryDefaultLimit)
+	historyQuery := []openapi.Parameter{
+		{Name: "token", In: "query", Schema: &openapi.Schema{Type: "string"}},
+		{Name: "range", In: "query", Schema: &openapi.Schema{Type: "string"}},
+		{Name: "interval", In: "query", Schema: &openapi.Schema{Type: "string"}},
+		{Name: "format", In: "query", Schema: &openapi.Schema{Type: "string"}},
+		{Name: "currency", In: "query", Schema: &openapi.Schema{Type: "string", Pattern: "^[A-Za-z]{3}$"}},
+	}
+
+	routes := []openapi.Route{
+		idempotent(openapi.Route{Method: fiber.MethodPost, Path: "/device/register", Summary: "Register a device credential", Body: RegisterDevice{}, Response: DeviceTokens{}}),
+		idempotent(openapi.Route{Method: fiber.MethodPost, Path: "/device/refresh", Summary: "Rotate the device tokens", Body: RefreshDevice{}, Response: DeviceTokens{}}),
+
+		{Method: fiber.MethodGet, Path: "/watcher", Summary: "Get the watcher of the device", Response: Watcher{}, Security: security},
+		{Method: fiber.MethodGet, Path: "/watcher/portfolio", Summary: "Get the portfolio of the watched addresses", Response: Portfolio{}, Security: security},
+		{Method: fiber.MethodGet, Path: "/watcher/addresses/:address/transactions", Summary: "List the transactions of a watched address", Parameters: txQuery, Response: AddressTxs{}, Security: security},
+
+		{Method: fiber.MethodGet, Path: "/watcher-historical-prices", Summary: "Get historical prices", Parameters: historyQuery, Response: HistoryPrices{}},
+		{Method: fiber.MethodGet, Path: "/watcher-price-tokens", Summary: "List the tokens with prices", Response: PriceTokensResponse{}},
+
+		{Method: fiber.MethodPost, Path: "/watcher", Summary: "Create a watcher", Body: CreateWatcher{}, Response: StatusResponse{}, Security: security},
+		{Method: fiber.MethodPut, Path: "/watcher", Summary: "Update the watcher settings and addresses", Body: UpdateWatcher{}, Response: StatusResponse{}, Security: security},
+		{Method: fiber.MethodDelete, Path: "/watcher", Summary: "Delete the watcher", Body: DeleteWatcher{}, Response: StatusResponse{}, Security: security},
+		{Method: fiber.MethodDelete, Path: "/watcher-addresses", Summary: "Stop watching addresses", Body: DeleteWatcherAddresses{}, Response: StatusResponse{}, Security: security},
+
+		{Method: fiber.MethodPost, Path: "/watcher-address-challenge", Summary: "Create an address ownership challenge", Body: CreateAddressChallenge{}, Response: AddressChallenge{}, Security: security},
+		{Method: fiber.MethodPost, Path: "/watcher-address-verify", Summary: "Verify address ownership with a signed challenge", Body: VerifyAddress{}, Response: Address{}, Security: security},
+
+		{Method: fiber.MethodPut, Path: "/watcher-token-alerts", Summary: "Update token price alerts", Body: UpdateWatcherTokenAlerts{}, Response: StatusResponse{}, Security: security},
+		{Method: fiber.MethodDelete, Path: "/watcher-token-alerts", Summary: "Delete token price alerts", Body: DeleteWatcherTokenAlerts{}, Response: StatusResponse{}, Security: security},
+
+		{Method: fiber.MethodPost, Path: "/explorer-callback", Summary: "Explorer transaction callback", Body: WatcherCallback{}, Response: CallbackResponse{}, Status: fiber.StatusAccepted},
+
+		{Method: fiber.MethodPut, Path: "/push-token", Summary: "Replace the push token of the device", Body: UpdateWatcherPushToken{}, Security: security},
+
+		{Method: fiber.MethodPost, Path: "/watcher/test-notification", Summary: "Send sample alerts to the device and report their delivery", Body: TestNotification{}, Response: NotificationReport{}, Security: security},
+	}
+
+	if h.allowPushTokenAuth {
+		routes = append(routes,
+			openapi.Route{Method: fiber.MethodGet, Path: "/watcher/:token", Summary: "Get a watcher by push token", Response: Watcher{}, Deprecated: true},
+			openapi.Route{Method: fiber.MethodGet, Path: "/watcher/:token/portfolio", Summary: "Get a portfolio by push token", Response: Portfolio{}, Deprecated: true},
+			openapi.Route{Method: fiber.MethodGet, Path: "/watcher/:token/addresses/:address/transactions", Summary: "List address transactions by push token", Parameters: txQuery, Response: AddressTxs{}, Deprecated: true},
+		)
+	}
+
+	for _, route := range routes {
+		route.Path = prefix + route.Path
+		route.Tags = tags
+		doc.Add(withIdempotencyKey(route))
+	}
+}
+
+// DescribeRoutesV2 documents the routes of SetupRoutesV2 under prefix.
+func (h *Handler) DescribeRoutesV2(doc *openapi.Document, prefix string) {
+	describeValidators(doc)
+
+	security := []string{DeviceSecurity}
+
+	routes := []openapi.Route{
+		idempotent(openapi.Route{Method: fiber.MethodPost, Path: "/devices", Summary: "Register a device", Tags: []string{"devices"}, Body: RegisterDevice{}, Response: DeviceTokens{}, Status: fiber.StatusCreated}),
+		idempotent(openapi.Route{Method: fiber.MethodPost, Path: "/devices/:id/tokens", Summary: "Rotate the device tokens", Tags: []string{"devices"}, Body: RefreshDevice{}, Response: DeviceTokens{}, Status: fiber.StatusCreated}),
+
+		{Method: fiber.MethodGet, Path: "/devices/:id", Summary: "Get a device", Tags: []string{"devices"}, Response: Watcher{}, Security: security},
+		{Method: fiber.MethodPatch, Path: "/devices/:id", Summary: "Update the device settings", Tags: []string{"devices"}, Body: UpdateDeviceV2{}, Response: Watcher{}, Security: security},
+		{Method: fiber.MethodDelete, Path: "/devices/:id", Summary: "Delete a device", Tags: []string{"devices"}, Status: fiber.StatusNoContent, Security: security},
+		{Method: fiber.MethodGet, Path: "/devices/:id/portfolio", Summary: "Get the portfolio of the device", Tags: []string{"devices"}, Response: Portfolio{}, Security: security},
+
+		{Method: fiber.MethodGet, Path: "/devices/:id/addresses", Summary: "List watched addresses", Tags: []string{"addresses"}, Response: AddressesResponse{}, Security: security},
+		{Method: fiber.MethodPost, Path: "/devices/:id/addresses", Summary: "Watch an address", Tags: []string{"addresses"}, Body: CreateDeviceAddressV2{}, Response: Address{}, Status: fiber.StatusCreated, Security: security},
+		{Method: fiber.MethodGet, Path: "/devices/:id/addresses/:address", Summary: "Get a watched address", Tags: []string{"addresses"}, Response: Address{}, Security: security},
+		{Method: fiber.MethodDelete, Path: "/devices/:id/addresses/:address", Summary: "Stop watching an address", Tags: []string{"addresses"}, Status: fiber.StatusNoContent, Security: security},
+		{Method: fiber.MethodGet, Path: "/devices/:id/addresses/:address/transactions", Summary: "List the transactions of an address", Tags: []string{"addresses"}, Parameters: pageQuery(TxHistoryDefaultLimit), Response: AddressTxs{}, Security: security},
+		{Method: fiber.MethodPost, Path: "/devices/:id/addresses/:address/challenge", Summary: "Create an ownership challenge", Tags: []string{"addresses"}, Response: AddressChallenge{}, Status: fiber.StatusCreated, Security: security},
+		{Method: fiber.MethodPost, Path: "/devices/:id/addresses/:address/verification", Summary: "Verify ownership with a signed challenge", Tags: []string{"addresses"}, Body: VerifyDeviceAddressV2{}, Response: Address{}, Security: security},
+
+		{Method: fiber.MethodGet, Path: "/devices/:id/alerts", Summary: "List price alerts", Tags: []string{"alerts"}, Response: AlertsResponse{}, Security: security},
+		{Method: fiber.MethodPut, Path: "/devices/:id/alerts/:token", Summary: "Create or replace a price alert", Tags: []string{"alerts"}, Body: TokenAlertUpdate{}, Response: AlertV2{}, Security: security},
+		{Method: fiber.MethodDelete, Path: "/devices/:id/alerts/:token", Summary: "Delete a price alert", Tags: []string{"alerts"}, Status: fiber.StatusNoContent, Security: security},
+
+		{Method: fiber.MethodGet, Path: "/devices/:id/notifications", Summary: "List sent notifications, newest first", Tags: []string{"notifications"}, Parameters: pageQuery(notificationsDefaultLimit), Response: NotificationsResponse{}, Security: security},
+	}
+
+	for _, route := range routes {
+		route.Path = prefix + route.Path
+		doc.Add(withIdempotencyKey(route))
+	}
+}
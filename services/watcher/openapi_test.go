@@ -0,0 +1,101 @@
+package watcher_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"airdao-mobile-api/pkg/hmacauth"
+	"airdao-mobile-api/pkg/idempotency"
+	"airdao-mobile-api/pkg/metrics"
+	"airdao-mobile-api/pkg/openapi"
+	"airdao-mobile-api/pkg/pricefeed"
+	"airdao-mobile-api/pkg/ratelimit"
+	"airdao-mobile-api/services/health"
+	"airdao-mobile-api/services/price"
+	"airdao-mobile-api/services/watcher"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// Routes are only registered, never called, so the dependencies stay empty
+type stubService struct{ watcher.Service }
+
+type stubQueue struct{ watcher.CallbackQueue }
+
+type stubPriceService struct{ price.Service }
+
+type stubRates struct{}
+
+func (stubRates) Name() string { return "stub" }
+
+func (stubRates) FetchRates(ctx context.Context, currencies []string) (map[string]float64, error) {
+	return nil, nil
+}
+
+func registeredRoutes(app *fiber.App) []string {
+	var out []string
+	for _, route := range app.GetRoutes(true) {
+		// fiber adds HEAD to every GET
+		if route.Method == fiber.MethodHead {
+			continue
+		}
+		out = append(out, route.Method+" "+route.Path)
+	}
+	sort.Strings(out)
+
+	return out
+}
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	tests := []struct {
+		description        string
+		allowPushTokenAuth bool
+	}{
+		{description: "with push token routes", allowPushTokenAuth: true},
+		{description: "without push token routes", allowPushTokenAuth: false},
+	}
+
+	for _, test := range tests {
+		verifier, err := hmacauth.NewVerifier(nil, time.Minute)
+		require.NoError(t, err)
+
+		limiter, err := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), nil, metrics.NewRegistry(), zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		keys, err := idempotency.New(idempotency.NewMemoryStore(), time.Hour, metrics.NewRegistry(), zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		h, err := watcher.NewHandler(stubService{}, stubQueue{}, verifier, true, test.allowPushTokenAuth, limiter, keys, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		fx, err := pricefeed.NewFxRates([]pricefeed.RateProvider{stubRates{}}, pricefeed.FxConfig{Interval: time.Hour, MaxAge: time.Hour}, zap.NewNop().Sugar())
+		require.NoError(t, err)
+
+		priceHandler, err := price.NewHandler(stubPriceService{}, fx)
+		require.NoError(t, err)
+
+		healthHandler := health.NewHandler(nil)
+
+		// Mounted and described like cmd/main.go does
+		app := fiber.New()
+		app.Route("/api/v1", func(router fiber.Router) {
+			healthHandler.SetupRoutes(router)
+			h.SetupRoutes(router)
+			priceHandler.SetupRoutes(router)
+		})
+		app.Route("/api/v2", h.SetupRoutesV2)
+
+		doc := openapi.New("test", "1", nil)
+		healthHandler.DescribeRoutes(doc, "/api/v1")
+		h.DescribeRoutes(doc, "/api/v1")
+		priceHandler.DescribeRoutes(doc, "/api/v1")
+		h.DescribeRoutesV2(doc, "/api/v2")
+
+		assert.Equalf(t, registeredRoutes(app), doc.Operations(), test.description)
+	}
+}
@@ -0,0 +1,217 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"airdao-mobile-api/services/price"
+)
+
+// balanceCacheTTL bounds how long balances are served from cache when no tx
+// callback invalidates them, as txs may be missed while callbacks are down
+const balanceCacheTTL = 10 * time.Minute
+
+type TokenBalance struct {
+	Token   string  `json:"token"`
+	Address string  `json:"address,omitempty"`
+	Balance float64 `json:"balance"`
+	// Price and Value are nil for contracts the price layer doesn't track
+	Price *float64 `json:"price"`
+	Value *float64 `json:"value"`
+}
+
+type AddressPortfolio struct {
+	Address string          `json:"address"`
+	Native  *TokenBalance   `json:"native"`
+	Tokens  []*TokenBalance `json:"tokens"`
+	Value   float64         `json:"value"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// Portfolio values every verified watcher address in the watcher display
+// currency.
+// The 24h change values today's balances at the prices of 24h ago, so it
+// only reflects price moves.
+type Portfolio struct {
+	Currency         string              `json:"currency"`
+	Addresses        []*AddressPortfolio `json:"addresses"`
+	Total            float64             `json:"total"`
+	Change24h        float64             `json:"change_24h"`
+	ChangePercent24h float64             `json:"change_percent_24h"`
+	UpdatedAt        time.Time           `json:"updated_at"`
+}
+
+type addressBalances struct {
+	native    float64
+	tokens    []ApiTokenBalance
+	fetchedAt time.Time
+}
+
+// balanceCache keeps explorer balances per address until a tx of the address
+// comes in or the entry expires.
+type balanceCache struct {
+	mx       sync.RWMutex
+	balances map[string]*addressBalances
+}
+
+func newBalanceCache() *balanceCache {
+	return &balanceCache{balances: make(map[string]*addressBalances)}
+}
+
+func (c *balanceCache) Get(address string) (*addressBalances, bool) {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+
+	balances, ok := c.balances[address]
+	if !ok || time.Since(balances.fetchedAt) > balanceCacheTTL {
+		return nil, false
+	}
+
+	return balances, true
+}
+
+func (c *balanceCache) Set(address string, balances *addressBalances) {
+	c.mx.Lock()
+	c.balances[address] = balances
+	c.mx.Unlock()
+}
+
+func (c *balanceCache) Invalidate(address string) {
+	c.mx.Lock()
+	delete(c.balances, address)
+	c.mx.Unlock()
+}
+
+func (s *service) getAddressBalances(address string) (*addressBalances, error) {
+	if balances, ok := s.balanceCache.Get(address); ok {
+		return balances, nil
+	}
+
+	var apiAddressData *ApiAddressData
+	if err := s.doRequest(fmt.Sprintf("%s/addresses/%s/all?page=1&limit=1", s.explorerUrl, address), nil, &apiAddressData); err != nil {
+		return nil, err
+	}
+	if apiAddressData == nil {
+		return nil, errors.New("empty address response")
+	}
+
+	balances := &addressBalances{native: apiAddressData.Account.Balance.Ether, fetchedAt: time.Now()}
+
+	// An address without tokens is still worth showing
+	var apiTokensData *ApiTokensData
+	if err := s.doRequest(fmt.Sprintf("%s/addresses/%s/tokens", s.explorerUrl, address), nil, &apiTokensData); err != nil {
+		s.logger.Errorf("getAddressBalances tokens %s error %v\n", address, err)
+	} else if apiTokensData != nil {
+		balances.tokens = apiTokensData.Data
+	}
+
+	s.balanceCache.Set(address, balances)
+
+	return balances, nil
+}
+
+func (s *service) GetWatcherPortfolio(ctx context.Context, device Device) (*Portfolio, error) {
+	watcher, err := s.GetWatcher(ctx, device)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	currency := watcher.DisplayCurrency()
+	portfolio := &Portfolio{Currency: currency, Addresses: make([]*AddressPortfolio, 0), UpdatedAt: now}
+
+	// Prices now and 24h ago, looked up once per token
+	prices := make(map[string]*float64)
+	pricesDayAgo := make(map[string]*float64)
+	priceOf := func(token string) (*float64, *float64) {
+		if v, ok := prices[token]; ok {
+			return v, pricesDayAgo[token]
+		}
+
+		prices[token], pricesDayAgo[token] = nil, nil
+		if tokenPrice, ok := s.priceIn(token, currency); ok {
+			prices[token] = &tokenPrice
+
+			usd, err := s.priceSvc.PriceAt(ctx, token, now.Add(-24*time.Hour))
+			if err != nil {
+				s.logger.Errorf("GetWatcherPortfolio priceSvc.PriceAt error %v\n", err)
+			}
+			if dayAgo, ok := s.fx.Convert(usd, currency); ok && dayAgo > 0 {
+				pricesDayAgo[token] = &dayAgo
+			}
+		}
+
+		return prices[token], pricesDayAgo[token]
+	}
+
+	var totalDayAgo float64
+	value := func(balance *TokenBalance, token string) {
+		tokenPrice, dayAgo := priceOf(token)
+		if tokenPrice == nil {
+			return
+		}
+
+		v := balance.Balance * *tokenPrice
+		balance.Price = tokenPrice
+		balance.Value = &v
+
+		// Without history the token counts as unchanged
+		if dayAgo != nil {
+			totalDayAgo += balance.Balance * *dayAgo
+		} else {
+			totalDayAgo += v
+		}
+	}
+
+	if watcher.Addresses != nil {
+		for _, address := range *watcher.Addresses {
+			item := &AddressPortfolio{Address: address.Address, Tokens: make([]*TokenBalance, 0)}
+			portfolio.Addresses = append(portfolio.Addresses, item)
+
+			// Balances are only shown for wallets the device proved it owns
+			if !address.Verified {
+				item.Error = ErrAddressNotVerified.Error()
+				continue
+			}
+
+			balances, err := s.getAddressBalances(address.Address)
+			if err != nil {
+				s.logger.Errorf("GetWatcherPortfolio getAddressBalances error %v\n", err)
+				item.Error = "balances unavailable"
+				continue
+			}
+
+			item.Native = &TokenBalance{Token: price.DefaultToken, Balance: balances.native}
+			value(item.Native, price.DefaultToken)
+			if item.Native.Value != nil {
+				item.Value += *item.Native.Value
+			}
+
+			for _, token := range balances.tokens {
+				balance := &TokenBalance{Token: token.Symbol, Address: token.Address, Balance: token.Balance.Ether}
+				// Any contract can take the symbol of a tracked token, so
+				// only the tracked contracts are priced
+				if tracked, ok := s.feeds.TokenOf(token.Address); ok {
+					value(balance, tracked)
+				}
+				if balance.Value != nil {
+					item.Value += *balance.Value
+				}
+				item.Tokens = append(item.Tokens, balance)
+			}
+
+			portfolio.Total += item.Value
+		}
+	}
+
+	portfolio.Change24h = portfolio.Total - totalDayAgo
+	if totalDayAgo > 0 {
+		portfolio.ChangePercent24h = math.Round(portfolio.Change24h/totalDayAgo*10000) / 100
+	}
+
+	return portfolio, nil
+}
@@ -0,0 +1,187 @@
+package watcher
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"airdao-mobile-api/pkg/pricefeed"
+	"airdao-mobile-api/services/price"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const (
+	usdcContract = "0xFF9F502976E7bD2b4901aD7Dd1131Bb81E5567de"
+	spamContract = "0x000000000000000000000000000000000000dEaD"
+)
+
+type idleProvider struct{}
+
+func (idleProvider) Name() string { return "idle" }
+
+func (idleProvider) Fetch(ctx context.Context) (*pricefeed.Quote, error) {
+	return nil, errors.New("not polled in tests")
+}
+
+// newTestFeeds tracks AMB and USDC, USDC at usdcContract
+func newTestFeeds(t *testing.T) *pricefeed.Feeds {
+	cfg := pricefeed.AggregatorConfig{Interval: time.Minute, MaxAge: time.Minute, MaxDeviation: 5, MinSources: 1}
+
+	feeds := make(map[string]*pricefeed.Aggregator)
+	for _, token := range []string{price.DefaultToken, "USDC"} {
+		aggregator, err := pricefeed.NewAggregator([]pricefeed.Provider{idleProvider{}}, cfg, zap.NewNop().Sugar())
+		require.NoError(t, err)
+		feeds[token] = aggregator
+	}
+
+	f, err := pricefeed.NewFeeds(feeds, map[string]string{usdcContract: "USDC"})
+	require.NoError(t, err)
+
+	return f
+}
+
+// dayAgoPriceService answers PriceAt with the price of each token 24h ago
+type dayAgoPriceService struct {
+	price.Service
+
+	prices map[string]float64
+}
+
+func (s *dayAgoPriceService) PriceAt(ctx context.Context, token string, at time.Time) (float64, error) {
+	return s.prices[token], nil
+}
+
+// explorerStub serves the balances of one address and counts the requests
+type explorerStub struct {
+	mx       sync.Mutex
+	requests int
+}
+
+func (e *explorerStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mx.Lock()
+	e.requests++
+	e.mx.Unlock()
+
+	switch r.URL.Path {
+	case "/addresses/0xverified/all":
+		fmt.Fprint(w, `{"data":[],"account":{"balance":{"ether":100}}}`)
+	case "/addresses/0xverified/tokens":
+		fmt.Fprintf(w, `{"data":[
+			{"address":"%s","name":"USD Coin","symbol":"USDC","balance":{"ether":10}},
+			{"address":"%s","name":"Free USDC","symbol":"USDC","balance":{"ether":1000}}
+		]}`, usdcContract, spamContract)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (e *explorerStub) Requests() int {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	return e.requests
+}
+
+func TestBalanceCache(t *testing.T) {
+	cache := newBalanceCache()
+
+	_, ok := cache.Get("0xa")
+	assert.False(t, ok)
+
+	cache.Set("0xa", &addressBalances{native: 1, fetchedAt: time.Now()})
+	balances, ok := cache.Get("0xa")
+	require.True(t, ok)
+	assert.Equal(t, 1.0, balances.native)
+
+	cache.Invalidate("0xa")
+	_, ok = cache.Get("0xa")
+	assert.False(t, ok, "should drop invalidated balances")
+
+	cache.Set("0xb", &addressBalances{native: 1, fetchedAt: time.Now().Add(-balanceCacheTTL - time.Second)})
+	_, ok = cache.Get("0xb")
+	assert.False(t, ok, "should expire balances")
+}
+
+func TestGetWatcherPortfolio(t *testing.T) {
+	explorer := &explorerStub{}
+	server := httptest.NewServer(explorer)
+	defer server.Close()
+
+	priceSvc := &dayAgoPriceService{prices: map[string]float64{price.DefaultToken: 0.01, "USDC": 1}}
+	s := newAlertService(t, time.Now(), 0.02, priceSvc, nil, nil)
+	s.cachedPrices["USDC"] = pricefeed.Aggregate{Price: 1, Fresh: true}
+	s.feeds = newTestFeeds(t)
+	s.balanceCache = newBalanceCache()
+	s.explorerUrl = server.URL
+
+	pushToken := "push-token"
+	watcher, err := NewWatcher(base64.StdEncoding.EncodeToString([]byte(pushToken)))
+	require.NoError(t, err)
+	watcher.Addresses = &[]*Address{{Address: "0xverified", Verified: true}, {Address: "0xunverified"}}
+	s.cachedWatcher[watcher.PushToken] = watcher
+
+	portfolio, err := s.GetWatcherPortfolio(context.Background(), Device{PushToken: pushToken})
+	require.NoError(t, err)
+	require.Len(t, portfolio.Addresses, 2)
+
+	verified := portfolio.Addresses[0]
+	assert.Empty(t, verified.Error)
+	require.NotNil(t, verified.Native.Value)
+	assert.InDelta(t, 2, *verified.Native.Value, 1e-9)
+
+	require.Len(t, verified.Tokens, 2)
+	require.NotNil(t, verified.Tokens[0].Value)
+	assert.InDelta(t, 10, *verified.Tokens[0].Value, 1e-9)
+	assert.Nil(t, verified.Tokens[1].Price, "should not price another contract using a tracked symbol")
+	assert.Nil(t, verified.Tokens[1].Value)
+	assert.InDelta(t, 12, verified.Value, 1e-9)
+
+	unverified := portfolio.Addresses[1]
+	assert.Equal(t, ErrAddressNotVerified.Error(), unverified.Error)
+	assert.Nil(t, unverified.Native)
+	assert.Equal(t, 2, explorer.Requests(), "should not fetch the balances of unverified addresses")
+
+	// 100 AMB went from 0.01 to 0.02, USDC stayed at 1
+	assert.Equal(t, "USD", portfolio.Currency)
+	assert.InDelta(t, 12, portfolio.Total, 1e-9)
+	assert.InDelta(t, 1, portfolio.Change24h, 1e-9)
+	assert.Equal(t, 9.09, portfolio.ChangePercent24h)
+
+	// Balances come from the cache until a tx invalidates them
+	_, err = s.GetWatcherPortfolio(context.Background(), Device{PushToken: pushToken})
+	require.NoError(t, err)
+	assert.Equal(t, 2, explorer.Requests())
+}
+
+func TestGetWatcherPortfolioWithoutHistory(t *testing.T) {
+	explorer := &explorerStub{}
+	server := httptest.NewServer(explorer)
+	defer server.Close()
+
+	// Without a price 24h ago the balance counts as unchanged
+	s := newAlertService(t, time.Now(), 0.02, &dayAgoPriceService{}, nil, nil)
+	s.feeds = newTestFeeds(t)
+	s.balanceCache = newBalanceCache()
+	s.explorerUrl = server.URL
+
+	pushToken := "push-token"
+	watcher, err := NewWatcher(base64.StdEncoding.EncodeToString([]byte(pushToken)))
+	require.NoError(t, err)
+	watcher.Addresses = &[]*Address{{Address: "0xverified", Verified: true}}
+	s.cachedWatcher[watcher.PushToken] = watcher
+
+	portfolio, err := s.GetWatcherPortfolio(context.Background(), Device{PushToken: pushToken})
+	require.NoError(t, err)
+	assert.InDelta(t, 2, portfolio.Total, 1e-9)
+	assert.Equal(t, 0.0, portfolio.Change24h)
+	assert.Equal(t, 0.0, portfolio.ChangePercent24h)
+}
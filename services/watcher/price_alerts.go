@@ -0,0 +1,301 @@
+package watcher
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"time"
+
+	"airdao-mobile-api/pkg/alertindex"
+	cloudmessaging "airdao-mobile-api/pkg/firebase/cloud-messaging"
+	"airdao-mobile-api/services/price"
+)
+
+// priceAlertBook names the index book of token prices in currency
+func priceAlertBook(token, currency string) string {
+	return token + "/" + currency
+}
+
+// indexPriceAlerts puts the watcher price alerts in the threshold index, in
+// place of whatever it had there before. Disabled watchers are removed.
+func (s *service) indexPriceAlerts(watcher *Watcher) {
+	if watcher.Disabled {
+		s.alertIndex.Remove(watcher.PushToken)
+		s.metrics.Gauge("price_alerts_indexed").Set(int64(s.alertIndex.Len()))
+		return
+	}
+
+	currency := watcher.DisplayCurrency()
+
+	tokens := []string{price.DefaultToken}
+	if watcher.TokenAlerts != nil {
+		for _, alert := range *watcher.TokenAlerts {
+			tokens = append(tokens, alert.Token)
+		}
+	}
+
+	entries := make([]alertindex.Entry, 0, len(tokens))
+	for _, token := range tokens {
+		threshold, reference, _, ok := watcher.PriceAlert(token)
+		if !ok || threshold == nil {
+			continue
+		}
+
+		// Window references move with time and a fired pinned alert has to
+		// see the price come back to re-arm, so those are checked on every
+		// price by leaving their reference out
+		entry := alertindex.Entry{Book: priceAlertBook(token, currency), Threshold: *threshold}
+		state := watcher.AlertState(token)
+		switch {
+		case reference == nil:
+		case state.AlertMode() == AlertModeLastAlert,
+			state.AlertMode() == AlertModePinned && state.Triggered == 0:
+			entry.Reference = *reference
+		}
+		entries = append(entries, entry)
+	}
+
+	s.alertIndex.Replace(watcher.PushToken, entries)
+	s.metrics.Gauge("price_alerts_indexed").Set(int64(s.alertIndex.Len()))
+}
+
+type pendingPriceAlert struct {
+	watcher *Watcher
+	title   string
+	body    string
+}
+
+// EvaluatePriceAlerts runs on every fresh price of token. The threshold index
+// narrows the watchers down to the ones whose alert the price may trigger;
+// their notifications go out through SendMessages and the changed alerts are
+// saved in one bulk write.
+//
+// A fired alert waits for the cooldown before it fires again. Alerts that
+// don't move their reference, pinned and rolling window ones, also fire only
+// once per excursion past the threshold: they re-arm when the change falls
+// back inside the threshold by the hysteresis margin.
+//
+// alertMx keeps two prices of a token from being evaluated at once, the
+// watchers themselves are only changed under their watcher lock.
+func (s *service) EvaluatePriceAlerts(ctx context.Context, token string) {
+	s.alertMx.Lock()
+	defer s.alertMx.Unlock()
+
+	now := s.clock()
+	changed := make(map[string]*Watcher)
+	var alerts []*pendingPriceAlert
+	var messages []*cloudmessaging.Message
+
+	// USD price at the start of each rolling window, looked up once
+	windowPrices := make(map[string]float64)
+	windowPrice := func(mode string) float64 {
+		if v, ok := windowPrices[mode]; ok {
+			return v
+		}
+
+		window := time.Hour
+		if mode == AlertModeWindow24h {
+			window = 24 * time.Hour
+		}
+
+		v, err := s.priceSvc.PriceAt(ctx, token, now.Add(-window))
+		if err != nil {
+			s.logger.Errorf("EvaluatePriceAlerts priceSvc.PriceAt error %v\n", err)
+		}
+		windowPrices[mode] = v
+
+		return v
+	}
+
+	for _, currency := range s.fx.Currencies() {
+		tokenPrice, fresh := s.priceIn(token, currency)
+		if !fresh {
+			continue
+		}
+
+		for _, pushToken := range s.alertIndex.Triggered(priceAlertBook(token, currency), tokenPrice) {
+			s.mx.RLock()
+			watcher, ok := s.cachedWatcher[pushToken]
+			s.mx.RUnlock()
+			if !ok || watcher == nil {
+				continue
+			}
+
+			alert, message, stateChanged := s.evaluatePriceAlert(watcher, token, currency, tokenPrice, now, windowPrice)
+			if stateChanged {
+				changed[pushToken] = watcher
+			}
+			if message != nil {
+				alerts = append(alerts, alert)
+				messages = append(messages, message)
+			}
+		}
+	}
+
+	if len(messages) > 0 {
+		results, err := s.cloudMessagingSvc.SendMessages(ctx, messages)
+		if err != nil {
+			s.logger.Errorf("EvaluatePriceAlerts cloudMessagingSvc.SendMessages error %v\n", err)
+		}
+
+		for i, alert := range alerts {
+			var result *cloudmessaging.SendResult
+			if i < len(results) {
+				result = results[i]
+			}
+			s.recordPriceAlert(alert, result, now)
+		}
+
+		s.metrics.Counter("price_alerts_sent_total").Add(int64(len(messages)))
+	}
+
+	if len(changed) == 0 {
+		return
+	}
+
+	updated := make([]*Watcher, 0, len(changed))
+	for _, watcher := range changed {
+		updated = append(updated, watcher)
+	}
+
+	unlock := s.watcherLocks.LockAll(updated)
+	defer unlock()
+
+	for _, watcher := range updated {
+		s.indexPriceAlerts(watcher)
+	}
+
+	if err := s.repository.UpdateWatchers(ctx, updated); err != nil {
+		s.logger.Errorf("EvaluatePriceAlerts repository.UpdateWatchers error %v\n", err)
+	}
+}
+
+// evaluatePriceAlert checks the token alert of one watcher against the price
+// under the watcher lock. It returns the notification to send, if any, and
+// whether the alert state changed.
+func (s *service) evaluatePriceAlert(watcher *Watcher, token, currency string, tokenPrice float64, now time.Time, windowPrice func(mode string) float64) (*pendingPriceAlert, *cloudmessaging.Message, bool) {
+	defer s.watcherLocks.Lock(watcher)()
+
+	if watcher.DisplayCurrency() != currency {
+		return nil, nil, false
+	}
+
+	threshold, reference, notification, ok := watcher.PriceAlert(token)
+	if !ok || threshold == nil {
+		return nil, nil, false
+	}
+
+	state := watcher.AlertState(token)
+	mode := state.AlertMode()
+	changed := false
+
+	switch mode {
+	case AlertModeWindow1h, AlertModeWindow24h:
+		start, ok := s.fx.Convert(windowPrice(mode), currency)
+		if !ok || start <= 0 {
+			return nil, nil, false
+		}
+		reference = &start
+	default:
+		if reference == nil || *reference <= 0 {
+			// The token had no price yet when the alert was set up
+			if mode == AlertModeLastAlert {
+				watcher.SetReferencePrice(token, tokenPrice)
+				return nil, nil, true
+			}
+			return nil, nil, false
+		}
+	}
+
+	percentage := (tokenPrice - *reference) / *reference * 100
+
+	if mode != AlertModeLastAlert && state.Triggered != 0 {
+		rearm := *threshold - s.alertCfg.Hysteresis
+		if (state.Triggered > 0 && percentage < rearm) || (state.Triggered < 0 && percentage > -rearm) {
+			state.Triggered = 0
+			changed = true
+		}
+	}
+
+	direction := 0
+	if percentage >= *threshold {
+		direction = 1
+	} else if percentage <= -*threshold {
+		direction = -1
+	}
+	if direction == 0 || (mode != AlertModeLastAlert && state.Triggered == direction) {
+		return nil, nil, changed
+	}
+	if state.LastAlertAt != nil && now.Sub(*state.LastAlertAt) < s.alertCfg.Cooldown {
+		return nil, nil, changed
+	}
+
+	alertAt := now
+	state.LastAlertAt = &alertAt
+	if mode == AlertModeLastAlert {
+		watcher.SetReferencePrice(token, tokenPrice)
+	} else {
+		state.Triggered = direction
+	}
+
+	if notification != ON {
+		return nil, nil, true
+	}
+
+	decodedPushToken, err := base64.StdEncoding.DecodeString(watcher.PushToken)
+	if err != nil {
+		s.logger.Errorf("EvaluatePriceAlerts base64.StdEncoding.DecodeString error %v\n", err)
+		return nil, nil, true
+	}
+
+	title, body, data := priceAlertMessage(token, mode, percentage, tokenPrice, currency)
+	alert := &pendingPriceAlert{watcher: watcher, title: title, body: body}
+	message := &cloudmessaging.Message{Title: title, Body: body, PushToken: string(decodedPushToken), Data: data}
+
+	return alert, message, true
+}
+
+// recordPriceAlert records the outcome of a sent price alert on its watcher.
+// A nil result means SendMessages gave up before the alert.
+func (s *service) recordPriceAlert(alert *pendingPriceAlert, result *cloudmessaging.SendResult, now time.Time) {
+	defer s.watcherLocks.Lock(alert.watcher)()
+
+	sent := false
+	if result != nil {
+		if result.Err != nil {
+			s.logger.Errorf("EvaluatePriceAlerts send error %v\n", result.Err)
+			if cloudmessaging.IsUnregistered(result.Err) {
+				// Set date of fail and remove watcher if success date more than 7 days earlier than this date
+				alert.watcher.SetLastFailDate(now)
+				return
+			}
+		}
+		sent = result.MessageId != nil
+	}
+
+	// Set date of success to compare with date of fail
+	alert.watcher.SetLastSuccessDate(now)
+	alert.watcher.AddNotification(alert.title, alert.body, sent, now)
+}
+
+var alertModePeriods = map[string]string{
+	AlertModeWindow1h:  " in the last hour",
+	AlertModeWindow24h: " in the last 24 hours",
+	AlertModePinned:    " from your reference price",
+}
+
+func priceAlertMessage(token, mode string, percentage, tokenPrice float64, currency string) (string, string, map[string]interface{}) {
+	roundedPercentage := math.Abs((math.Round(percentage*100) / 100))
+	roundedPrice := formatFiat(tokenPrice, currency, 5)
+	period := alertModePeriods[mode]
+
+	data := map[string]interface{}{"type": "price-alert", "percentage": roundedPercentage, "currency": currency, "token": token, "mode": mode}
+	title := "Price Alert"
+	body := fmt.Sprintf("🚀 %s Price changed on +%v%s%s! Current price %v\n", token, roundedPercentage, "%", period, roundedPrice)
+	if percentage < 0 {
+		body = fmt.Sprintf("🔻 %s Price changed on -%v%s%s! Current price %v\n", token, roundedPercentage, "%", period, roundedPrice)
+	}
+
+	return title, body, data
+}
@@ -0,0 +1,278 @@
+package watcher
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"airdao-mobile-api/config"
+	"airdao-mobile-api/pkg/alertindex"
+	cloudmessaging "airdao-mobile-api/pkg/firebase/cloud-messaging"
+	"airdao-mobile-api/pkg/metrics"
+	"airdao-mobile-api/pkg/pricefeed"
+	"airdao-mobile-api/services/price"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+)
+
+var errUnregistered = errors.New("http error status: 404; reason: app instance has been unregistered; code: registration-token-not-registered; details: Requested entity was not found.")
+
+// alertPriceService answers PriceAt with the window start price
+type alertPriceService struct {
+	price.Service
+
+	mx    sync.Mutex
+	start float64
+	asked []time.Time
+}
+
+func (s *alertPriceService) PriceAt(ctx context.Context, token string, at time.Time) (float64, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.asked = append(s.asked, at)
+	return s.start, nil
+}
+
+// alertMessaging fails the push tokens in fails
+type alertMessaging struct {
+	cloudmessaging.Service
+
+	mx    sync.Mutex
+	fails map[string]error
+	sent  []*cloudmessaging.Message
+}
+
+func (m *alertMessaging) SendMessages(ctx context.Context, messages []*cloudmessaging.Message) ([]*cloudmessaging.SendResult, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	results := make([]*cloudmessaging.SendResult, 0, len(messages))
+	for _, message := range messages {
+		m.sent = append(m.sent, message)
+		if err, ok := m.fails[message.PushToken]; ok {
+			results = append(results, &cloudmessaging.SendResult{Err: err})
+			continue
+		}
+		id := "id"
+		results = append(results, &cloudmessaging.SendResult{MessageId: &id})
+	}
+
+	return results, nil
+}
+
+type alertRepository struct {
+	Repository
+
+	mx      sync.Mutex
+	updated []*Watcher
+}
+
+func (r *alertRepository) UpdateWatchers(ctx context.Context, watchers []*Watcher) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.updated = append(r.updated, watchers...)
+	return nil
+}
+
+type usdRates struct{}
+
+func (usdRates) Name() string { return "usd" }
+
+func (usdRates) FetchRates(ctx context.Context, currencies []string) (map[string]float64, error) {
+	return nil, nil
+}
+
+func newAlertService(t *testing.T, now time.Time, tokenPrice float64, priceSvc price.Service, messaging cloudmessaging.Service, repository Repository) *service {
+	fx, err := pricefeed.NewFxRates([]pricefeed.RateProvider{usdRates{}}, pricefeed.FxConfig{Interval: time.Hour, MaxAge: time.Hour}, zap.NewNop().Sugar())
+	require.NoError(t, err)
+
+	return &service{
+		repository:        repository,
+		cloudMessagingSvc: messaging,
+		logger:            zap.NewNop().Sugar(),
+		metrics:           metrics.NewRegistry(),
+		fx:                fx,
+		priceSvc:          priceSvc,
+		alertCfg:          config.PriceAlert{Cooldown: 30 * time.Minute, Hysteresis: 1},
+		alertIndex:        alertindex.New(),
+		clock:             func() time.Time { return now },
+		cachedWatcher:     make(map[string]*Watcher),
+		cachedPrices: map[string]pricefeed.Aggregate{
+			price.DefaultToken: {Price: tokenPrice, Fresh: true},
+		},
+	}
+}
+
+func TestEvaluatePriceAlerts(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	recently := now.Add(-5 * time.Minute)
+	longAgo := now.Add(-2 * time.Hour)
+
+	tests := []struct {
+		name         string
+		mode         string
+		reference    float64
+		triggered    int
+		lastAlertAt  *time.Time
+		notification string
+		windowStart  float64
+		tokenPrice   float64
+		fails        error
+
+		wantSent          bool
+		wantSaved         bool
+		wantReference     float64
+		wantTriggered     int
+		wantLastAlertAt   *time.Time
+		wantNotifications int
+		wantFailed        bool
+	}{
+		{
+			name: "should alert and move the reference in the last alert mode",
+			mode: AlertModeLastAlert, reference: 1, tokenPrice: 1.1, notification: ON,
+			wantSent: true, wantSaved: true, wantReference: 1.1, wantLastAlertAt: &now, wantNotifications: 1,
+		},
+		{
+			name: "should not alert inside the threshold",
+			mode: AlertModeLastAlert, reference: 1, tokenPrice: 1.02, notification: ON,
+			wantReference: 1,
+		},
+		{
+			name: "should not alert during the cooldown",
+			mode: AlertModeLastAlert, reference: 1, tokenPrice: 1.1, lastAlertAt: &recently, notification: ON,
+			wantReference: 1, wantLastAlertAt: &recently,
+		},
+		{
+			name: "should alert again after the cooldown",
+			mode: AlertModeLastAlert, reference: 1, tokenPrice: 0.9, lastAlertAt: &longAgo, notification: ON,
+			wantSent: true, wantSaved: true, wantReference: 0.9, wantLastAlertAt: &now, wantNotifications: 1,
+		},
+		{
+			name: "should set the missing reference without alerting",
+			mode: AlertModeLastAlert, tokenPrice: 1.1, notification: ON,
+			wantSaved: true, wantReference: 1.1,
+		},
+		{
+			name: "should move the reference without notification",
+			mode: AlertModeLastAlert, reference: 1, tokenPrice: 1.1, notification: OFF,
+			wantSaved: true, wantReference: 1.1, wantLastAlertAt: &now,
+		},
+		{
+			name: "should alert once per excursion in the pinned mode",
+			mode: AlertModePinned, reference: 1, tokenPrice: 1.1, notification: ON,
+			wantSent: true, wantSaved: true, wantReference: 1, wantTriggered: 1, wantLastAlertAt: &now, wantNotifications: 1,
+		},
+		{
+			name: "should stay quiet while past the threshold in the pinned mode",
+			mode: AlertModePinned, reference: 1, triggered: 1, lastAlertAt: &longAgo, tokenPrice: 1.2, notification: ON,
+			wantReference: 1, wantTriggered: 1, wantLastAlertAt: &longAgo,
+		},
+		{
+			name: "should not re-arm inside the hysteresis margin",
+			mode: AlertModePinned, reference: 1, triggered: 1, lastAlertAt: &longAgo, tokenPrice: 1.045, notification: ON,
+			wantReference: 1, wantTriggered: 1, wantLastAlertAt: &longAgo,
+		},
+		{
+			name: "should re-arm back inside the threshold and the margin",
+			mode: AlertModePinned, reference: 1, triggered: 1, lastAlertAt: &longAgo, tokenPrice: 1.03, notification: ON,
+			wantSaved: true, wantReference: 1, wantLastAlertAt: &longAgo,
+		},
+		{
+			name: "should alert the other way right away in the pinned mode",
+			mode: AlertModePinned, reference: 1, triggered: 1, lastAlertAt: &longAgo, tokenPrice: 0.9, notification: ON,
+			wantSent: true, wantSaved: true, wantReference: 1, wantTriggered: -1, wantLastAlertAt: &now, wantNotifications: 1,
+		},
+		{
+			name: "should measure from the window start",
+			mode: AlertModeWindow1h, windowStart: 2, tokenPrice: 2.2, notification: ON,
+			wantSent: true, wantSaved: true, wantTriggered: 1, wantLastAlertAt: &now, wantNotifications: 1,
+		},
+		{
+			name: "should skip a window without start price",
+			mode: AlertModeWindow24h, tokenPrice: 2.2, notification: ON,
+		},
+		{
+			name: "should record the failure of an unregistered token",
+			mode: AlertModeLastAlert, reference: 1, tokenPrice: 1.1, notification: ON, fails: errUnregistered,
+			wantSent: true, wantSaved: true, wantReference: 1.1, wantLastAlertAt: &now, wantFailed: true,
+		},
+		{
+			name: "should record an unsent notification",
+			mode: AlertModeLastAlert, reference: 1, tokenPrice: 1.1, notification: ON, fails: errors.New("http error status: 500"),
+			wantSent: true, wantSaved: true, wantReference: 1.1, wantLastAlertAt: &now, wantNotifications: 1,
+		},
+	}
+
+	for _, test := range tests {
+		pushToken := "push-token"
+		messaging := &alertMessaging{fails: make(map[string]error)}
+		if test.fails != nil {
+			messaging.fails[pushToken] = test.fails
+		}
+		priceSvc := &alertPriceService{start: test.windowStart}
+		repository := &alertRepository{}
+		s := newAlertService(t, now, test.tokenPrice, priceSvc, messaging, repository)
+
+		watcher, err := NewWatcher(base64.StdEncoding.EncodeToString([]byte(pushToken)))
+		require.NoError(t, err, test.name)
+		watcher.SetThreshold(5)
+		watcher.SetPriceNotification(test.notification)
+		if test.reference != 0 {
+			watcher.SetTokenPrice(test.reference)
+		}
+		watcher.PriceAlertState = &PriceAlertState{Mode: test.mode, Triggered: test.triggered, LastAlertAt: test.lastAlertAt}
+
+		s.cachedWatcher[watcher.PushToken] = watcher
+		s.indexPriceAlerts(watcher)
+
+		s.EvaluatePriceAlerts(context.Background(), price.DefaultToken)
+
+		assert.Equal(t, test.wantSent, len(messaging.sent) == 1, test.name)
+		assert.Equal(t, test.wantSaved, len(repository.updated) == 1, test.name)
+		if test.wantReference != 0 {
+			require.NotNil(t, watcher.TokenPrice, test.name)
+			assert.InDelta(t, test.wantReference, *watcher.TokenPrice, 1e-9, test.name)
+		}
+		assert.Equal(t, test.wantTriggered, watcher.PriceAlertState.Triggered, test.name)
+		assert.Equal(t, test.wantLastAlertAt, watcher.PriceAlertState.LastAlertAt, test.name)
+
+		notifications := 0
+		if watcher.HistoricalNotifications != nil {
+			notifications = len(*watcher.HistoricalNotifications)
+		}
+		assert.Equal(t, test.wantNotifications, notifications, test.name)
+		assert.Equal(t, test.wantFailed, watcher.LastFailDate.Equal(now), test.name)
+
+		if test.mode == AlertModeWindow1h {
+			assert.Equal(t, []time.Time{now.Add(-time.Hour)}, priceSvc.asked, test.name)
+		}
+	}
+}
+
+func TestRecordUnregistered(t *testing.T) {
+	s, repository := newDeviceService(t, "push-token", "other-push-token")
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	s.clock = func() time.Time { return now }
+
+	require.NoError(t, s.RecordUnregistered(context.Background(), []string{"push-token", "unknown-push-token"}))
+
+	watcher, err := s.GetWatcher(context.Background(), Device{PushToken: "push-token"})
+	require.NoError(t, err)
+	assert.Equal(t, now, watcher.LastFailDate)
+
+	stored, err := repository.GetWatcher(context.Background(), bson.M{"push_token": watcher.PushToken})
+	require.NoError(t, err)
+	assert.Equal(t, now, stored.LastFailDate, "should save the fail date")
+
+	other, err := s.GetWatcher(context.Background(), Device{PushToken: "other-push-token"})
+	require.NoError(t, err)
+	assert.True(t, other.LastFailDate.IsZero())
+}
@@ -0,0 +1,39 @@
+package watcher
+
+import (
+	"airdao-mobile-api/pkg/deviceauth"
+	"airdao-mobile-api/pkg/ratelimit"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Rate limited route groups
+const (
+	// RateLimitAuth covers device registration and token refresh, per IP
+	RateLimitAuth = "auth"
+	// RateLimitRead covers the GET routes, per device
+	RateLimitRead = "read"
+	// RateLimitWrite covers the routes changing a watcher, per device
+	RateLimitWrite = "write"
+)
+
+// deviceKey identifies the authenticated device for rate limits and
+// idempotency keys, or the IP for requests without a device credential. The
+// push token is hashed so it doesn't end up in a shared store.
+func deviceKey(c *fiber.Ctx) string {
+	if pushToken, ok := c.Locals(pushTokenLocal).(string); ok && pushToken != "" {
+		return "device:" + deviceauth.HashToken(pushToken)
+	}
+
+	return ratelimit.IPKey(c)
+}
+
+// deviceLimit rate limits a device route, reads and writes in separate
+// buckets. It runs after device authentication to know the device.
+func (h *Handler) deviceLimit(c *fiber.Ctx) error {
+	if c.Method() == fiber.MethodGet {
+		return h.readLimit(c)
+	}
+
+	return h.writeLimit(c)
+}
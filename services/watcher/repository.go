@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
@@ -14,12 +15,19 @@ import (
 
 //go:generate mockgen -source=repository.go -destination=mocks/repository_mock.go
 type Repository interface {
+	EnsureIndexes(ctx context.Context) error
+
 	GetWatcher(ctx context.Context, filters bson.M) (*Watcher, error)
 	GetAllWatchers(ctx context.Context) ([]*Watcher, error)
 	GetWatcherList(ctx context.Context, filters bson.M, page int) ([]*Watcher, error)
+	GetWatchersAfter(ctx context.Context, filters bson.M, after primitive.ObjectID, limit int) ([]*Watcher, error)
+	GetWatchedAddresses(ctx context.Context) ([]string, error)
+	CountWatchers(ctx context.Context, filters bson.M) (int64, error)
+	GetNotificationsPerDay(ctx context.Context, from time.Time) ([]*DailyNotifications, error)
 
 	CreateWatcher(ctx context.Context, watcher *Watcher) error
 	UpdateWatcher(ctx context.Context, watcher *Watcher) error
+	UpdateWatchers(ctx context.Context, watchers []*Watcher) error
 	DeleteWatcher(ctx context.Context, filters bson.M) error
 	DeleteWatchersWithStaleData(ctx context.Context) error
 }
@@ -45,6 +53,21 @@ func NewRepository(db *mongo.Client, dbName string, logger *zap.SugaredLogger) (
 	return &repository{db: db, dbName: dbName, dbCollectionName: "watcher", logger: logger}, nil
 }
 
+// EnsureIndexes makes push tokens unique, so a device can't take the push
+// token of another one.
+func (r *repository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "push_token", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		r.logger.Errorf("failed to create watcher indexes: %s", err)
+		return errors.New("failed to create watcher indexes")
+	}
+
+	return nil
+}
+
 func (r *repository) GetWatcher(ctx context.Context, filters bson.M) (*Watcher, error) {
 	var watcher Watcher
 
@@ -145,12 +168,101 @@ func (r *repository) GetWatcherList(ctx context.Context, filters bson.M, page in
 	return watchers, nil
 }
 
+// GetWatchersAfter returns up to limit watchers matching filters with an id
+// above after, in id order. Unlike pages, this stays consistent while
+// watchers are added or removed.
+func (r *repository) GetWatchersAfter(ctx context.Context, filters bson.M, after primitive.ObjectID, limit int) ([]*Watcher, error) {
+	query := bson.M{"_id": bson.M{"$gt": after}}
+	for key, value := range filters {
+		query[key] = value
+	}
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+
+	cur, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).Find(ctx, query, findOptions)
+	if err != nil {
+		r.logger.Errorf("unable to find watchers due to internal error: %v", err)
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	watchers := make([]*Watcher, 0, limit)
+	if err := cur.All(ctx, &watchers); err != nil {
+		r.logger.Errorf("unable to decode watcher documents: %v", err)
+		return nil, err
+	}
+
+	return watchers, nil
+}
+
+func (r *repository) GetWatchedAddresses(ctx context.Context) ([]string, error) {
+	values, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).Distinct(ctx, "addresses.address", bson.M{"disabled": bson.M{"$ne": true}})
+	if err != nil {
+		r.logger.Errorf("unable to find watched addresses due to internal error: %v", err)
+		return nil, err
+	}
+
+	addresses := make([]string, 0, len(values))
+	for _, value := range values {
+		if address, ok := value.(string); ok {
+			addresses = append(addresses, address)
+		}
+	}
+
+	return addresses, nil
+}
+
+func (r *repository) CountWatchers(ctx context.Context, filters bson.M) (int64, error) {
+	count, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).CountDocuments(ctx, filters)
+	if err != nil {
+		r.logger.Errorf("unable to count watchers due to internal error: %v", err)
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// GetNotificationsPerDay counts the notifications of every watcher since
+// from, per UTC day, oldest day first.
+func (r *repository) GetNotificationsPerDay(ctx context.Context, from time.Time) ([]*DailyNotifications, error) {
+	since := bson.M{"historical_notifications.timestamp": bson.M{"$gte": from}}
+	sent := bson.M{"$cond": bson.A{"$historical_notifications.sent", 1, 0}}
+	failed := bson.M{"$cond": bson.A{"$historical_notifications.sent", 0, 1}}
+
+	pipeline := bson.A{
+		bson.M{"$match": since},
+		bson.M{"$unwind": "$historical_notifications"},
+		bson.M{"$match": since},
+		bson.M{"$group": bson.M{
+			"_id":    bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$historical_notifications.timestamp"}},
+			"sent":   bson.M{"$sum": sent},
+			"failed": bson.M{"$sum": failed},
+		}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+	}
+
+	cur, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).Aggregate(ctx, pipeline)
+	if err != nil {
+		r.logger.Errorf("unable to count notifications due to internal error: %v", err)
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	days := make([]*DailyNotifications, 0)
+	if err := cur.All(ctx, &days); err != nil {
+		r.logger.Errorf("unable to decode notification counts: %v", err)
+		return nil, err
+	}
+
+	return days, nil
+}
+
 func (r *repository) CreateWatcher(ctx context.Context, watcher *Watcher) error {
 	_, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).InsertOne(ctx, watcher)
 	if err != nil {
 		if mongo.IsDuplicateKeyError(err) {
 			r.logger.Errorf("failed to insert watcher to db due duplicate error: %s", err)
-			return errors.New("watcher already exist")
+			return ErrWatcherAlreadyExists
 		}
 
 		r.logger.Errorf("failed to insert watcher to db: %s", err)
@@ -168,7 +280,7 @@ func (r *repository) UpdateWatcher(ctx context.Context, watcher *Watcher) error
 	if err != nil {
 		if mongo.IsDuplicateKeyError(err) {
 			r.logger.Errorf("failed to insert watcher to db due duplicate error: %s", err)
-			return errors.New("watcher already exist")
+			return ErrWatcherAlreadyExists
 		}
 
 		r.logger.Errorf("failed to update watcher: %s", err)
@@ -178,6 +290,27 @@ func (r *repository) UpdateWatcher(ctx context.Context, watcher *Watcher) error
 	return nil
 }
 
+// UpdateWatchers saves many watchers in one unordered bulk write.
+func (r *repository) UpdateWatchers(ctx context.Context, watchers []*Watcher) error {
+	if len(watchers) == 0 {
+		return nil
+	}
+
+	models := make([]mongo.WriteModel, 0, len(watchers))
+	for _, watcher := range watchers {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": watcher.ID}).
+			SetUpdate(bson.M{"$set": watcher}))
+	}
+
+	if _, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		r.logger.Errorf("failed to update watchers: %s", err)
+		return errors.New("failed to update watchers")
+	}
+
+	return nil
+}
+
 func (r *repository) DeleteWatcher(ctx context.Context, filters bson.M) error {
 	_, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).DeleteOne(ctx, filters)
 	if err != nil {
@@ -1,7 +1,6 @@
 package watcher
 
 import (
-	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
@@ -14,7 +13,14 @@ import (
 	"sync"
 	"time"
 
+	"airdao-mobile-api/config"
+	"airdao-mobile-api/pkg/alertindex"
+	"airdao-mobile-api/pkg/deviceauth"
 	cloudmessaging "airdao-mobile-api/pkg/firebase/cloud-messaging"
+	"airdao-mobile-api/pkg/metrics"
+	"airdao-mobile-api/pkg/pricefeed"
+	"airdao-mobile-api/services/health"
+	"airdao-mobile-api/services/price"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.uber.org/zap"
@@ -29,21 +35,49 @@ const (
 type Service interface {
 	Init(ctx context.Context) error
 
-	TransactionWatch(ctx context.Context, address string, txHash string, cache map[string]bool)
+	TransactionWatch(ctx context.Context, address string, txHash string) error
 	ApiPriceWatch(ctx context.Context)
-	PriceWatch(ctx context.Context, watcherId string, stopChan chan struct{})
-	CGWatch(ctx context.Context)
+	EvaluatePriceAlerts(ctx context.Context, token string)
+	Backfill(ctx context.Context) error
+	Reconcile(ctx context.Context, resubscribe bool) (*ReconcileReport, error)
+	RecordCallback()
+	HeartbeatStatus() *HeartbeatStatus
+	HealthCheck() health.Check
 
 	GetExplorerId() string
 
-	GetWatcher(ctx context.Context, pushToken string) (*Watcher, error)
-	GetWatcherHistoryPrices(ctx context.Context) *CGData
+	RegisterDevice(ctx context.Context, pushToken string, deviceId string) (*DeviceTokens, error)
+	RefreshDevice(ctx context.Context, refreshToken string) (*DeviceTokens, error)
+	AuthenticateDevice(ctx context.Context, accessToken string) (string, error)
+
+	GetWatcher(ctx context.Context, device Device) (*Watcher, error)
+	GetWatcherHistoryPrices(ctx context.Context, query HistoryQuery) (*HistoryPrices, error)
+	GetWatcherPortfolio(ctx context.Context, device Device) (*Portfolio, error)
+	GetWatcherAddressTransactions(ctx context.Context, device Device, address string, page, limit int) (*AddressTxs, error)
+	GetPriceTokens() []string
 	CreateWatcher(ctx context.Context, pushToken string, deviceId string) error
-	UpdateWatcher(ctx context.Context, pushToken string, addresses *[]string, threshold *float64, txNotification, priceNotification *string) error
-	DeleteWatcher(ctx context.Context, pushToken string) error
-	DeleteWatcherAddresses(ctx context.Context, pushToken string, addresses []string) error
+	UpdateWatcher(ctx context.Context, device Device, addresses *[]string, threshold *float64, txNotification, priceNotification, currency *string) error
+	DeleteWatcher(ctx context.Context, device Device) error
+	DeleteWatcherAddresses(ctx context.Context, device Device, addresses []string) error
+	CreateAddressChallenge(ctx context.Context, device Device, address string) (*AddressChallenge, error)
+	VerifyAddress(ctx context.Context, device Device, address string, signature string) (*Address, error)
+	UpdateWatcherTokenAlerts(ctx context.Context, device Device, alerts []TokenAlertUpdate) error
+	DeleteWatcherTokenAlerts(ctx context.Context, device Device, tokens []string) error
 	DeleteWatchersWithStaleData(ctx context.Context) error
-	UpdateWatcherPushToken(ctx context.Context, olpPushToken string, newPushToken string, deviceId string) error
+	RecordUnregistered(ctx context.Context, pushTokens []string) error
+	UpdateWatcherPushToken(ctx context.Context, device Device, newPushToken string, deviceId string) error
+	UpdateWatcherApp(ctx context.Context, device Device, app AppInfo) error
+	UpdateDevice(ctx context.Context, device Device, update DeviceUpdate) (*Watcher, error)
+	TestNotification(ctx context.Context, device Device) (*NotificationReport, error)
+
+	SearchWatchers(ctx context.Context, query WatcherQuery, page int) ([]*Watcher, error)
+	GetWatcherById(ctx context.Context, id string) (*Watcher, error)
+	GetWatcherState(ctx context.Context, id string) (*WatcherState, error)
+	ResubscribeAddress(ctx context.Context, address string) error
+	BackfillAddress(ctx context.Context, address string) error
+	SetWatcherDisabled(ctx context.Context, id string, disabled bool) (*Watcher, error)
+	ResendNotification(ctx context.Context, id string, index int) (*HistoryNotification, error)
+	GetStats(ctx context.Context, days int) (*Stats, error)
 }
 
 type watchers struct {
@@ -51,63 +85,155 @@ type watchers struct {
 }
 
 type service struct {
-	repository        Repository
-	cloudMessagingSvc cloudmessaging.Service
-	logger            *zap.SugaredLogger
+	repository             Repository
+	subscriptionRepository SubscriptionRepository
+	txHistoryRepository    TxHistoryRepository
+	cloudMessagingSvc      cloudmessaging.Service
+	logger                 *zap.SugaredLogger
+	metrics                *metrics.Registry
+
+	feeds    *pricefeed.Feeds
+	fx       *pricefeed.FxRates
+	priceSvc price.Service
 
 	explorerUrl   string
-	tokenPriceUrl string
 	callbackUrl   string
 	explorerToken string
 
+	backfillCfg  config.Backfill
+	backfilling  int32
+	reconcileCfg config.Reconcile
+	heartbeatCfg config.Heartbeat
+	heartbeat    heartbeat
+	alertCfg     config.PriceAlert
+
+	deviceSigner  *deviceauth.Signer
+	deviceAuthCfg config.DeviceAuth
+	credentialMx  sync.Mutex
+
+	subscriptionMx sync.Mutex
+
+	watcherLocks watcherLocks
+	// txCache is shared by the callbacks and backfill, so a tx reaches a
+	// device once whichever reports it first
+	txCache *TxCache
+
+	alertIndex *alertindex.Index
+	alertMx    sync.Mutex
+	// clock is time.Now, fixed in the price alert tests
+	clock func() time.Time
+
+	balanceCache *balanceCache
+
 	mx                     sync.RWMutex
 	cachedWatcher          map[string]*Watcher
 	cachedWatcherByAddress map[string]*watchers
-	cachedChan             map[string]chan struct{}
-	cachedPrice            float64
-	cachedCgPrice          [][]float64
+	cachedPrices           map[string]pricefeed.Aggregate
 }
 
 func NewService(
 	repository Repository,
+	subscriptionRepository SubscriptionRepository,
+	txHistoryRepository TxHistoryRepository,
 	cloudMessagingSvc cloudmessaging.Service,
 	logger *zap.SugaredLogger,
+	metrics *metrics.Registry,
+	feeds *pricefeed.Feeds,
+	fx *pricefeed.FxRates,
+	priceSvc price.Service,
 	explorerUrl string,
-	tokenPriceUrl string,
 	callbackUrl string,
 	explorerToken string,
+	backfillCfg config.Backfill,
+	reconcileCfg config.Reconcile,
+	heartbeatCfg config.Heartbeat,
+	alertCfg config.PriceAlert,
+	deviceSigner *deviceauth.Signer,
+	deviceAuthCfg config.DeviceAuth,
 ) (Service, error) {
 	if repository == nil {
 		return nil, errors.New("[watcher_service] invalid repository")
 	}
+	if subscriptionRepository == nil {
+		return nil, errors.New("[watcher_service] invalid subscription repository")
+	}
+	if txHistoryRepository == nil {
+		return nil, errors.New("[watcher_service] invalid tx history repository")
+	}
 	if cloudMessagingSvc == nil {
 		return nil, errors.New("[watcher_service] cloud messaging service")
 	}
 	if logger == nil {
 		return nil, errors.New("[watcher_service] invalid logger")
 	}
+	if metrics == nil {
+		return nil, errors.New("[watcher_service] invalid metrics registry")
+	}
 	if explorerUrl == "" {
 		return nil, errors.New("[watcher_service] invalid explorer url")
 	}
-	if tokenPriceUrl == "" {
-		return nil, errors.New("[watcher_service] invalid token price url")
+	if feeds == nil {
+		return nil, errors.New("[watcher_service] invalid price feeds")
+	}
+	if fx == nil {
+		return nil, errors.New("[watcher_service] invalid fx rates")
+	}
+	if priceSvc == nil {
+		return nil, errors.New("[watcher_service] invalid price service")
+	}
+	if backfillCfg.MaxPages <= 0 || backfillCfg.PageSize <= 0 {
+		return nil, errors.New("[watcher_service] invalid backfill config")
+	}
+	if reconcileCfg.Interval <= 0 || reconcileCfg.ChunkSize <= 0 {
+		return nil, errors.New("[watcher_service] invalid reconcile config")
+	}
+	if heartbeatCfg.SilenceTimeout <= 0 || heartbeatCfg.CheckInterval <= 0 {
+		return nil, errors.New("[watcher_service] invalid heartbeat config")
+	}
+	if alertCfg.Cooldown < 0 || alertCfg.Hysteresis < 0 {
+		return nil, errors.New("[watcher_service] invalid price alert config")
+	}
+	if deviceSigner == nil {
+		return nil, errors.New("[watcher_service] invalid device signer")
+	}
+	if deviceAuthCfg.RefreshTokenTTL <= 0 || deviceAuthCfg.RefreshGrace < 0 {
+		return nil, errors.New("[watcher_service] invalid device auth config")
 	}
 
 	return &service{
-		repository:        repository,
-		cloudMessagingSvc: cloudMessagingSvc,
-		logger:            logger,
+		repository:             repository,
+		subscriptionRepository: subscriptionRepository,
+		txHistoryRepository:    txHistoryRepository,
+		cloudMessagingSvc:      cloudMessagingSvc,
+		logger:                 logger,
+		metrics:                metrics,
+
+		feeds:    feeds,
+		fx:       fx,
+		priceSvc: priceSvc,
 
 		explorerUrl:   explorerUrl,
-		tokenPriceUrl: tokenPriceUrl,
 		callbackUrl:   callbackUrl,
 		explorerToken: explorerToken,
 
-		cachedChan:             make(map[string]chan struct{}),
+		backfillCfg:  backfillCfg,
+		reconcileCfg: reconcileCfg,
+		heartbeatCfg: heartbeatCfg,
+		heartbeat:    heartbeat{startedAt: time.Now()},
+		alertCfg:     alertCfg,
+
+		deviceSigner:  deviceSigner,
+		deviceAuthCfg: deviceAuthCfg,
+
+		alertIndex: alertindex.New(),
+		clock:      time.Now,
+
+		balanceCache: newBalanceCache(),
+		txCache:      NewTxCache(10 * time.Minute),
+
 		cachedWatcher:          make(map[string]*Watcher),
 		cachedWatcherByAddress: make(map[string]*watchers),
-		cachedPrice:            0,
-		cachedCgPrice:          [][]float64{},
+		cachedPrices:           make(map[string]pricefeed.Aggregate),
 	}, nil
 }
 
@@ -129,18 +255,31 @@ func (self *watchers) IsEmpty() bool {
 }
 
 func (s *service) Init(ctx context.Context) error {
-	var priceData *PriceData
-	if err := s.doRequest(s.tokenPriceUrl, nil, &priceData); err != nil {
-		return err
-	}
+	s.feeds.Subscribe(func(token string, aggregate pricefeed.Aggregate) {
+		s.mx.Lock()
+		s.cachedPrices[token] = aggregate
+		s.mx.Unlock()
+
+		// Alerts only fire on a price the providers currently agree on
+		if aggregate.Fresh {
+			go s.EvaluatePriceAlerts(ctx, token)
+		}
+	})
+	s.feeds.Refresh(ctx)
+	s.fx.Refresh(ctx)
 
-	if priceData != nil {
-		s.cachedPrice = priceData.Data.PriceUSD
+	if err := s.repository.EnsureIndexes(ctx); err != nil {
+		s.logger.Errorf("Init repository.EnsureIndexes error %v\n", err)
+	}
+	if err := s.txHistoryRepository.EnsureIndexes(ctx, txHistoryCacheTTL); err != nil {
+		s.logger.Errorf("Init txHistoryRepository.EnsureIndexes error %v\n", err)
 	}
 
-	go s.CGWatch(ctx)
 	go s.ApiPriceWatch(ctx)
+	go s.fx.Run(ctx)
 	go s.keepAlive(ctx)
+	go s.reconcileLoop(ctx)
+	go s.heartbeatLoop(ctx)
 
 	return nil
 }
@@ -148,13 +287,7 @@ func (s *service) Init(ctx context.Context) error {
 func (s *service) keepAlive(ctx context.Context) {
 	loadWatchers := true
 	for {
-		var req bytes.Buffer
-		req.WriteString("{\"id\":\"")
-		req.WriteString(s.explorerToken)
-		req.WriteString("\",\"action\":\"init\",\"url\":\"")
-		req.WriteString(s.callbackUrl)
-		req.WriteString("\"}")
-		if err := s.doRequest(fmt.Sprintf("%s/watch", s.explorerUrl), &req, nil); err != nil {
+		if err := s.explorerWatch(&watchRequest{Action: "init", Url: s.callbackUrl}, nil); err != nil {
 			s.logger.Errorln(err)
 			time.Sleep(5 * time.Second)
 			continue
@@ -179,48 +312,28 @@ func (s *service) keepAlive(ctx context.Context) {
 					s.cachedWatcher[watcher.PushToken] = watcher
 					s.mx.Unlock()
 
-					s.setUpStopChanAndStartWatchers(ctx, watcher)
+					s.startWatching(watcher)
 				}
 
 				page++
 			}
-		} else {
-			watchers := make([]*Watcher, 0)
-			s.mx.Lock()
-			for _, watcher := range s.cachedWatcher {
-				watchers = append(watchers, watcher)
-			}
-			s.mx.Unlock()
-			for _, watcher := range watchers {
-				if watcher.Addresses != nil && len(*watcher.Addresses) > 0 {
-					var req bytes.Buffer
-					req.WriteString("{\"id\":\"")
-					req.WriteString(s.explorerToken)
-					req.WriteString("\",\"action\":\"subscribe\",\"addresses\":[")
-					for i, address := range *watcher.Addresses {
-						if i != 0 {
-							req.WriteString(",\"")
-						} else {
-							req.WriteString("\"")
-						}
-						req.WriteString(address.Address)
-						req.WriteString("\"")
-					}
-					req.WriteString("]}")
-					if err := s.doRequest(fmt.Sprintf("%s/watch", s.explorerUrl), &req, nil); err != nil {
-						s.logger.Errorln(err)
-					}
-				}
-			}
 		}
 
+		// The explorer may have dropped subscriptions while we were disconnected
+		if _, err := s.Reconcile(ctx, true); err != nil {
+			s.logger.Errorf("keepAlive Reconcile error %v", err)
+		}
+
+		// Catch up on txs missed while callbacks were not delivered
+		go func() {
+			if err := s.Backfill(ctx); err != nil {
+				s.logger.Errorf("keepAlive Backfill error %v", err)
+			}
+		}()
+
 		tries := 6
 		for {
-			req.Reset()
-			req.WriteString("{\"id\":\"")
-			req.WriteString(s.explorerToken)
-			req.WriteString("\",\"action\":\"check\"}")
-			if err := s.doRequest(fmt.Sprintf("%s/watch", s.explorerUrl), &req, nil); err != nil {
+			if err := s.explorerWatch(&watchRequest{Action: "check"}, nil); err != nil {
 				s.logger.Errorln(err)
 				if tries != 0 {
 					tries--
@@ -235,229 +348,231 @@ func (s *service) keepAlive(ctx context.Context) {
 	}
 }
 
-func (s *service) CGWatch(ctx context.Context) {
-	for {
-		var cgData *CGData
-		if err := s.doRequest("https://api.coingecko.com/api/v3/coins/amber/market_chart?vs_currency=usd&days=30", nil, &cgData); err != nil {
-			s.logger.Errorln(err)
-		}
+// ApiPriceWatch polls the price providers until ctx is done. Every new
+// aggregate is picked up by the subscription made in Init.
+func (s *service) ApiPriceWatch(ctx context.Context) {
+	s.feeds.Run(ctx)
+}
 
-		s.cachedCgPrice = cgData.Prices
+// currentPrice returns the cached USD price of token and whether the
+// providers agreed on it recently enough to act on.
+func (s *service) currentPrice(token string) (float64, bool) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
 
-		time.Sleep(12 * time.Hour)
+	aggregate, ok := s.cachedPrices[token]
+	if !ok {
+		return 0, false
 	}
+
+	return aggregate.Price, aggregate.Fresh
 }
 
-func (s *service) ApiPriceWatch(ctx context.Context) {
-	for {
-		var priceData *PriceData
-		if err := s.doRequest(s.tokenPriceUrl, nil, &priceData); err != nil {
-			s.logger.Errorln(err)
+// TransactionWatch notifies the watchers of address about the tx. It fails
+// when the tx can't be fetched, so that the callback is retried; watchers
+// notified by an earlier attempt or by backfill are skipped.
+func (s *service) TransactionWatch(ctx context.Context, address string, txHash string) error {
+	// Balances and history changed whether anybody gets notified or not
+	s.balanceCache.Invalidate(address)
+	if err := s.txHistoryRepository.DeleteAddress(ctx, address); err != nil {
+		s.logger.Errorf("TransactionWatch txHistoryRepository.DeleteAddress error %v\n", err)
+	}
+
+	s.mx.RLock()
+	items, ok := s.cachedWatcherByAddress[address]
+	var targets []*Watcher
+	if ok {
+		for _, watcher := range items.watchers {
+			targets = append(targets, watcher)
+		}
+	}
+	s.mx.RUnlock()
+
+	if len(targets) == 0 {
+		s.recordUnwatchedCallback(ctx, address)
+		return nil
+	}
+
+	var tx *Tx
+	getTx := func() (*Tx, error) {
+		if tx != nil {
+			return tx, nil
 		}
 
-		if priceData != nil {
-			s.cachedPrice = priceData.Data.PriceUSD
+		var err error
+		tx, err = s.getTx(txHash)
+		return tx, err
+	}
+
+	for _, watcher := range targets {
+		if watcher == nil {
+			continue
 		}
 
-		time.Sleep(5 * time.Minute)
+		if err := s.notifyTx(ctx, watcher, items, address, txHash, getTx); err != nil {
+			s.logger.Errorf("TransactionWatch getTx error %v\n", err)
+			return err
+		}
 	}
+
+	return nil
 }
 
-func (s *service) PriceWatch(ctx context.Context, watcherId string, stopChan chan struct{}) {
-	s.mx.RLock()
-	watcher, ok := s.cachedWatcher[watcherId]
-	s.mx.RUnlock()
-	if !ok || watcher == nil {
-		return
+// notifyTx sends the tx alert to one watcher, unless it already got it, and
+// moves its LastTx. Only a failure to fetch the tx is returned.
+func (s *service) notifyTx(ctx context.Context, watcher *Watcher, items *watchers, address, txHash string, getTx func() (*Tx, error)) error {
+	defer s.watcherLocks.Lock(watcher)()
+
+	if watcher.TxNotification != ON || watcher.Addresses == nil || len(*watcher.Addresses) == 0 {
+		return nil
 	}
 
-	decodedPushToken, err := base64.StdEncoding.DecodeString(watcher.PushToken)
-	if err != nil {
-		s.logger.Errorf("PriceWatch base64.StdEncoding.DecodeString error %v\n", err)
-		return
+	itemId := txHash + watcher.PushToken
+	if !s.txCache.Has(itemId) {
+		tx, err := getTx()
+		if err != nil {
+			return err
+		}
+		if tx.Value.Ether == 0 {
+			return nil
+		}
+
+		currency := watcher.DisplayCurrency()
+		title, body, data := txNotificationMessage(tx, currency, s.txFiatValue(tx, address, currency))
+		if err := s.sendTxNotification(ctx, watcher, items, title, body, data); err != nil {
+			s.logger.Errorf("notifyTx sendTxNotification error %v\n", err)
+			return nil
+		}
+		s.txCache.Add(itemId)
 	}
 
-	for {
-		select {
-		case <-stopChan:
-			// fmt.Println("Stopping price goroutine...")
-			return
-		default:
-			if watcher.Threshold != nil && watcher.TokenPrice != nil {
-				percentage := (s.cachedPrice - *watcher.TokenPrice) / *watcher.TokenPrice * 100
-				roundedPercentage := math.Abs((math.Round(percentage*100) / 100))
-				roundedPrice := strconv.FormatFloat(s.cachedPrice, 'f', 5, 64)
-
-				if percentage >= float64(*watcher.Threshold) {
-					if watcher.PriceNotification == ON {
-						data := map[string]interface{}{"type": "price-alert", "percentage": roundedPercentage}
-						title := "Price Alert"
-						body := fmt.Sprintf("🚀 AMB Price changed on +%v%s! Current price $%v\n", roundedPercentage, "%", roundedPrice)
-						sent := false
-
-						response, err := s.cloudMessagingSvc.SendMessage(ctx, title, body, string(decodedPushToken), data)
-						if err != nil {
-							s.logger.Errorf("PriceWatch (Up) cloudMessagingSvc.SendMessage error %v\n", err)
-							if err.Error() == "http error status: 404; reason: app instance has been unregistered; code: registration-token-not-registered; details: Requested entity was not found." {
-								// Set date of fail and remove watcher if success date more than 7 days earlier than this date
-								watcher.SetLastFailDate(time.Now())
-								return
-							}
-						}
-
-						if response != nil {
-							sent = true
-						}
-
-						// Set date of success to compare with date of fail
-						watcher.SetLastSuccessDate(time.Now())
-						watcher.AddNotification(title, body, sent, time.Now())
-					}
-
-					watcher.SetTokenPrice(s.cachedPrice)
-
-					if err := s.repository.UpdateWatcher(ctx, watcher); err != nil {
-						s.logger.Errorf("PriceWatch (Up) repository.UpdateWatcher error %v\n", err)
-					}
-				}
+	watcher.SetLastTx(address, txHash)
 
-				if percentage <= -float64(*watcher.Threshold) {
-					if watcher.PriceNotification == ON {
-						data := map[string]interface{}{"type": "price-alert", "percentage": roundedPercentage}
-						title := "Price Alert"
-						body := fmt.Sprintf("🔻 AMB Price changed on -%v%s! Current price $%v\n", roundedPercentage, "%", roundedPrice)
-						sent := false
-
-						response, err := s.cloudMessagingSvc.SendMessage(ctx, title, body, string(decodedPushToken), data)
-						if err != nil {
-							s.logger.Errorf("PriceWatch (Down) cloudMessagingSvc.SendMessage error %v\n", err)
-							if err.Error() == "http error status: 404; reason: app instance has been unregistered; code: registration-token-not-registered; details: Requested entity was not found." {
-								// Set date of fail and remove watcher if success date more than 7 days earlier than this date
-								watcher.SetLastFailDate(time.Now())
-								return
-							}
-						}
-
-						if response != nil {
-							sent = true
-						}
-
-						// Set date of success to compare with date of fail
-						watcher.SetLastSuccessDate(time.Now())
-						watcher.AddNotification(title, body, sent, time.Now())
-					}
-
-					watcher.SetTokenPrice(s.cachedPrice)
-
-					if err := s.repository.UpdateWatcher(ctx, watcher); err != nil {
-						s.logger.Errorf("PriceWatch (Down) repository.UpdateWatcher error %v\n", err)
-					}
-				}
+	if err := s.repository.UpdateWatcher(ctx, watcher); err != nil {
+		s.logger.Errorf("notifyTx repository.UpdateWatcher error %v\n", err)
+	}
 
-			}
+	return nil
+}
 
-			time.Sleep(330 * time.Second)
-		}
+func (s *service) getTx(txHash string) (*Tx, error) {
+	var apiTxData *ApiTxData
+	if err := s.doRequest(fmt.Sprintf("%s/transactions/%s", s.explorerUrl, txHash), nil, &apiTxData); err != nil {
+		return nil, err
+	}
+	if apiTxData == nil || len(apiTxData.Data) == 0 {
+		return nil, errors.New("empty tx response")
 	}
+
+	return &apiTxData.Data[0], nil
 }
 
-func (s *service) TransactionWatch(ctx context.Context, address string, txHash string, cache map[string]bool) {
-	s.mx.RLock()
-	watchers, ok := s.cachedWatcherByAddress[address]
-	s.mx.RUnlock()
+// txFiatValue returns the value of a transfer of address in currency at the
+// current price, or nil when its token isn't tracked.
+func (s *service) txFiatValue(tx *Tx, address, currency string) *float64 {
+	var tokens []ApiTokenBalance
+	if tx.Value.Symbol != nil {
+		balances, err := s.getAddressBalances(address)
+		if err != nil {
+			s.logger.Errorf("txFiatValue getAddressBalances error %v\n", err)
+			return nil
+		}
+		tokens = balances.tokens
+	}
+
+	token, ok := s.txPriceToken(tx, tokens)
 	if !ok {
-		return
+		return nil
+	}
+
+	tokenPrice, ok := s.priceIn(token, currency)
+	if !ok {
+		return nil
 	}
 
+	value := tx.Value.Ether * tokenPrice
+	return &value
+}
+
+func txNotificationMessage(tx *Tx, currency string, fiatValue *float64) (string, string, map[string]interface{}) {
 	var cutFromAddress string
 	var cutToAddress string
-	var roundedAmount string
 	var tokenSymbol string
-	var data map[string]interface{}
-	takeTx := true
-
-	for _, watcher := range watchers.watchers {
-		if watcher != nil && watcher.TxNotification == ON && (watcher.Addresses != nil && len(*watcher.Addresses) > 0) {
-			itemId := txHash + watcher.PushToken
-			if _, ok := cache[itemId]; !ok {
-				if takeTx {
-					takeTx = false
-					var apiTxData *ApiTxData
-					if err := s.doRequest(fmt.Sprintf("%s/transactions/%s", s.explorerUrl, txHash), nil, &apiTxData); err != nil {
-						s.logger.Errorf("TransactionWatch doRequest error %v\n", err)
-						return
-					}
-					if apiTxData == nil || len(apiTxData.Data) == 0 {
-						s.logger.Errorln("TransactionWatch empty tx response")
-						return
-					}
-					tx := &apiTxData.Data[0]
-					if tx.Value.Ether == 0 {
-						return
-					}
-					if len(tx.From) > 0 && tx.From != "" {
-						cutFromAddress = fmt.Sprintf("%s...%s", tx.From[:5], tx.From[len(tx.From)-5:])
-					}
-					if len(tx.To) > 0 && tx.To != "" {
-						cutToAddress = fmt.Sprintf("%s...%s", tx.To[:5], tx.To[len(tx.To)-5:])
-					}
-					roundedAmount = strconv.FormatFloat(tx.Value.Ether, 'f', 2, 64)
-					if tx.Value.Symbol == nil {
-						tokenSymbol = "AMB"
-					} else {
-						if *tx.Value.Symbol == "" {
-							tokenSymbol = "HPT"
-						} else {
-							tokenSymbol = *tx.Value.Symbol
-						}
-					}
-					data = map[string]interface{}{"type": "transaction-alert", "timestamp": tx.Timestamp, "sender": cutFromAddress, "to": cutToAddress}
-				}
 
-				decodedPushToken, err := base64.StdEncoding.DecodeString(watcher.PushToken)
-				if err != nil {
-					s.logger.Errorf("TransactionWatch base64.StdEncoding.DecodeString error %v\n", err)
-					continue
-				}
+	if len(tx.From) > 0 && tx.From != "" {
+		cutFromAddress = fmt.Sprintf("%s...%s", tx.From[:5], tx.From[len(tx.From)-5:])
+	}
+	if len(tx.To) > 0 && tx.To != "" {
+		cutToAddress = fmt.Sprintf("%s...%s", tx.To[:5], tx.To[len(tx.To)-5:])
+	}
+	roundedAmount := strconv.FormatFloat(tx.Value.Ether, 'f', 2, 64)
+	tokenSymbol = txTokenSymbol(tx)
 
-				title := "AMB-Net Tx Alert"
-				body := fmt.Sprintf("From: %s\nTo: %s\nAmount: %s %s", cutFromAddress, cutToAddress, roundedAmount, tokenSymbol)
-				sent := false
+	title := "AMB-Net Tx Alert"
+	body := fmt.Sprintf("From: %s\nTo: %s\nAmount: %s %s", cutFromAddress, cutToAddress, roundedAmount, tokenSymbol)
+	data := map[string]interface{}{"type": "transaction-alert", "timestamp": tx.Timestamp, "sender": cutFromAddress, "to": cutToAddress}
 
-				response, err := s.cloudMessagingSvc.SendMessage(ctx, title, body, string(decodedPushToken), data)
-				if err != nil {
-					s.logger.Errorf("TransactionWatch cloudMessagingSvc.SendMessage error %v\n", err)
-					if err.Error() == "http error status: 404; reason: app instance has been unregistered; code: registration-token-not-registered; details: Requested entity was not found." {
-						// Set date of fail and remove watcher if success date more than 7 days earlier than this date
-						watcher.SetLastFailDate(time.Now())
-
-						s.mx.RLock()
-						watchers.Remove(watcher.PushToken)
-						s.mx.RUnlock()
-					}
-				}
+	if fiatValue != nil {
+		body += fmt.Sprintf(" (%s)", formatFiat(*fiatValue, currency, 2))
+		data["fiat_value"] = math.Round(*fiatValue*100) / 100
+		data["currency"] = currency
+	}
 
-				if response != nil {
-					sent = true
-				}
+	return title, body, data
+}
 
-				// Set date of success to compare with date of fail
-				watcher.SetLastSuccessDate(time.Now())
-				watcher.AddNotification(title, body, sent, time.Now())
+// sendTxNotification pushes a tx alert to the watcher and records it in the
+// watcher history. A watcher whose push token is no longer registered is
+// dropped from the address watchers.
+func (s *service) sendTxNotification(ctx context.Context, watcher *Watcher, watchers *watchers, title, body string, data map[string]interface{}) error {
+	decodedPushToken, err := base64.StdEncoding.DecodeString(watcher.PushToken)
+	if err != nil {
+		return err
+	}
 
-				cache[itemId] = true
-			}
+	sent := false
 
-			watcher.SetLastTx(address, txHash)
+	response, err := s.cloudMessagingSvc.SendMessage(ctx, title, body, string(decodedPushToken), data)
+	if err != nil {
+		s.logger.Errorf("sendTxNotification cloudMessagingSvc.SendMessage error %v\n", err)
+		if err.Error() == "http error status: 404; reason: app instance has been unregistered; code: registration-token-not-registered; details: Requested entity was not found." {
+			// Set date of fail and remove watcher if success date more than 7 days earlier than this date
+			watcher.SetLastFailDate(time.Now())
 
-			if err := s.repository.UpdateWatcher(ctx, watcher); err != nil {
-				s.logger.Errorf("TransactionWatch repository.UpdateWatcher error %v\n", err)
-			}
+			s.mx.Lock()
+			watchers.Remove(watcher.PushToken)
+			s.mx.Unlock()
 		}
 	}
+
+	if response != nil {
+		sent = true
+	}
+
+	// Set date of success to compare with date of fail
+	watcher.SetLastSuccessDate(time.Now())
+	watcher.AddNotification(title, body, sent, time.Now())
+
+	return nil
+}
+
+// GetWatcher returns the watcher of the device. A watcher holding a device
+// credential is only returned to an authenticated device: knowing the push
+// token is no longer enough once the device registered.
+func (s *service) GetWatcher(ctx context.Context, device Device) (*Watcher, error) {
+	watcher, err := s.lookupWatcher(ctx, device.PushToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if watcher.Credential.Active() && !device.Authenticated {
+		return nil, ErrMissingCredential
+	}
+
+	return watcher, nil
 }
 
-func (s *service) GetWatcher(ctx context.Context, pushToken string) (*Watcher, error) {
+func (s *service) lookupWatcher(ctx context.Context, pushToken string) (*Watcher, error) {
 	encodePushToken := base64.StdEncoding.EncodeToString([]byte(pushToken))
 	var watcher *Watcher
 	var ok bool
@@ -476,7 +591,7 @@ func (s *service) GetWatcher(ctx context.Context, pushToken string) (*Watcher, e
 	}
 
 	if watcher == nil {
-		return nil, errors.New("watcher not found")
+		return nil, ErrWatcherNotFound
 	}
 
 	s.mx.Lock()
@@ -486,19 +601,96 @@ func (s *service) GetWatcher(ctx context.Context, pushToken string) (*Watcher, e
 	return watcher, nil
 }
 
-func (s *service) GetWatcherHistoryPrices(ctx context.Context) *CGData {
-	return &CGData{Prices: s.cachedCgPrice}
+func (s *service) GetWatcherHistoryPrices(ctx context.Context, query HistoryQuery) (*HistoryPrices, error) {
+	now := time.Now()
+
+	// Without a range keep the legacy response: every point of the last 30 days
+	from := now.AddDate(0, 0, -30)
+	var interval time.Duration
+	if query.Range != "" {
+		var err error
+		from, interval, err = price.ParseRange(query.Range, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if query.Interval != "" {
+		var err error
+		interval, err = price.ParseInterval(query.Interval)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rate := 1.0
+	if query.Currency != "" {
+		if !s.fx.Supports(query.Currency) {
+			return nil, ErrUnsupportedCurrency
+		}
+
+		var ok bool
+		if rate, ok = s.fx.Rate(query.Currency); !ok {
+			return nil, ErrCurrencyRateUnavailable
+		}
+	}
+
+	token := price.DefaultToken
+	if query.Token != "" {
+		if !s.feeds.Has(query.Token) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownToken, query.Token)
+		}
+		token = query.Token
+	}
+
+	ticks, err := s.priceSvc.History(ctx, token, from, now)
+	if err != nil {
+		s.logger.Errorf("GetWatcherHistoryPrices priceSvc.History error %v", err)
+		return nil, err
+	}
+
+	// FX history isn't stored, the whole range is converted at today's rate
+	if rate != 1 {
+		ticks = price.Convert(ticks, rate)
+	}
+
+	history := &HistoryPrices{Stats: price.Summarize(ticks)}
+
+	switch query.Format {
+	case "", HistoryFormatRaw:
+		prices := price.Points(ticks, interval)
+		history.Prices = &prices
+	case HistoryFormatOHLC:
+		if interval == 0 {
+			interval = time.Hour
+		}
+		history.Candles = price.Candles(ticks, interval)
+	default:
+		return nil, ErrInvalidHistoryFormat
+	}
+
+	return history, nil
+}
+
+func (s *service) GetPriceTokens() []string {
+	return s.feeds.Tokens()
 }
 
 func (s *service) CreateWatcher(ctx context.Context, pushToken string, deviceId string) error {
 	//if watcher with deviceId exists then update, not create
-	dbWatcher, err := s.repository.GetWatcher(ctx, bson.M{"device_id": deviceId})
-	if err != nil {
-		return err
+	var dbWatcher *Watcher
+	var err error
+	if deviceId != "" {
+		if dbWatcher, err = s.repository.GetWatcher(ctx, bson.M{"device_id": deviceId}); err != nil {
+			return err
+		}
 	}
 
 	if dbWatcher != nil {
-		return s.UpdateWatcherPushToken(ctx, dbWatcher.PushToken, pushToken, deviceId)
+		// A registered device moves its push token with its credential
+		if dbWatcher.Credential.Active() {
+			return ErrDeviceAlreadyRegistered
+		}
+		return s.UpdateWatcherPushToken(ctx, Device{PushToken: dbWatcher.PushToken}, pushToken, deviceId)
 	}
 
 	encodePushToken := base64.StdEncoding.EncodeToString([]byte(pushToken))
@@ -509,7 +701,7 @@ func (s *service) CreateWatcher(ctx context.Context, pushToken string, deviceId
 	}
 
 	if dbWatcher != nil {
-		return errors.New("watcher for this address and token already exist")
+		return ErrWatcherAlreadyExists
 	}
 
 	watcher, err := NewWatcher(encodePushToken)
@@ -522,13 +714,8 @@ func (s *service) CreateWatcher(ctx context.Context, pushToken string, deviceId
 	watcher.SetPriceNotification(ON)
 	watcher.SetDeviceId(deviceId)
 
-	var priceData *PriceData
-	if err := s.doRequest(s.tokenPriceUrl, nil, &priceData); err != nil {
-		return err
-	}
-
-	if priceData != nil {
-		watcher.SetTokenPrice(priceData.Data.PriceUSD)
+	if latest, ok := s.feeds.Latest(price.DefaultToken); ok && latest.Price > 0 {
+		watcher.SetTokenPrice(latest.Price)
 	}
 
 	if err := s.repository.CreateWatcher(ctx, watcher); err != nil {
@@ -539,48 +726,38 @@ func (s *service) CreateWatcher(ctx context.Context, pushToken string, deviceId
 	s.cachedWatcher[watcher.PushToken] = watcher
 	s.mx.Unlock()
 
-	s.setUpStopChanAndStartWatchers(ctx, watcher)
+	s.startWatching(watcher)
 
 	return nil
 }
 
-func (s *service) UpdateWatcher(ctx context.Context, pushToken string, addresses *[]string, threshold *float64, txNotification, priceNotification *string) error {
-	watcher, err := s.GetWatcher(ctx, pushToken)
+func (s *service) UpdateWatcher(ctx context.Context, device Device, addresses *[]string, threshold *float64, txNotification, priceNotification, currency *string) error {
+	watcher, err := s.GetWatcher(ctx, device)
 	if err != nil {
 		return err
 	}
 	if watcher == nil {
-		return errors.New("watcher not found")
+		return ErrWatcherNotFound
 	}
 
-	if addresses != nil && len(*addresses) > 0 {
-		var req bytes.Buffer
-		req.WriteString("{\"id\":\"")
-		req.WriteString(s.explorerToken)
-		req.WriteString("\",\"action\":\"subscribe\",\"addresses\":[")
-		for i, address := range *addresses {
-			if watcher.Addresses != nil {
-				for _, v := range *watcher.Addresses {
-					if address == v.Address {
-						return errors.New("address already is watching")
-					}
-				}
-			}
+	defer s.watcherLocks.Lock(watcher)()
 
-			if i != 0 {
-				req.WriteString(",\"")
-			} else {
-				req.WriteString("\"")
+	if addresses != nil && len(*addresses) > 0 {
+		for _, address := range *addresses {
+			if watcher.HasAddress(address) {
+				return ErrAddressAlreadyWatched
 			}
-			req.WriteString(address)
-			req.WriteString("\"")
+		}
 
+		for _, address := range *addresses {
 			watcher.AddAddress(address)
 			s.addWatcherForAddress(address, watcher)
 		}
-		req.WriteString("]}")
-		if err := s.doRequest(fmt.Sprintf("%s/watch", s.explorerUrl), &req, nil); err != nil {
-			s.logger.Errorln(err)
+
+		if !watcher.Disabled {
+			if err := s.subscribe(ctx, *addresses); err != nil {
+				s.logger.Errorln(err)
+			}
 		}
 	}
 
@@ -592,10 +769,18 @@ func (s *service) UpdateWatcher(ctx context.Context, pushToken string, addresses
 		watcher.SetTxNotification(*txNotification)
 	}
 
+	if currency != nil && *currency != "" && *currency != watcher.DisplayCurrency() {
+		if err := s.setWatcherCurrency(watcher, *currency); err != nil {
+			return err
+		}
+	}
+
 	if priceNotification != nil && *priceNotification != "" {
 		watcher.SetPriceNotification(*priceNotification)
 	}
 
+	s.indexPriceAlerts(watcher)
+
 	if err := s.repository.UpdateWatcher(ctx, watcher); err != nil {
 		return err
 	}
@@ -603,110 +788,202 @@ func (s *service) UpdateWatcher(ctx context.Context, pushToken string, addresses
 	return nil
 }
 
-func (s *service) DeleteWatcher(ctx context.Context, pushToken string) error {
-	encodePushToken := base64.StdEncoding.EncodeToString([]byte(pushToken))
+// UpdateWatcherTokenAlerts creates or updates the price alerts of the given
+// tokens. AMB updates go to the original watcher fields.
+func (s *service) UpdateWatcherTokenAlerts(ctx context.Context, device Device, alerts []TokenAlertUpdate) error {
+	watcher, err := s.GetWatcher(ctx, device)
+	if err != nil {
+		return err
+	}
+	if watcher == nil {
+		return ErrWatcherNotFound
+	}
 
-	watcher, err := s.GetWatcher(ctx, pushToken)
+	defer s.watcherLocks.Lock(watcher)()
+
+	for _, update := range alerts {
+		if !s.feeds.Has(update.Token) {
+			return fmt.Errorf("%w: %s", ErrUnknownToken, update.Token)
+		}
+
+		// Switching to the pinned mode needs the price to pin
+		if update.Mode != nil && *update.Mode == AlertModePinned && update.ReferencePrice == nil {
+			if state := watcher.AlertState(update.Token); state == nil || state.AlertMode() != AlertModePinned {
+				return ErrMissingReferencePrice
+			}
+		}
+	}
+
+	for _, update := range alerts {
+		if update.Token == price.DefaultToken {
+			if update.Threshold != nil {
+				watcher.SetThreshold(*update.Threshold)
+			}
+			if update.Notification != nil {
+				watcher.SetPriceNotification(*update.Notification)
+			}
+		} else {
+			alert := watcher.TokenAlert(update.Token)
+			if alert == nil {
+				threshold := 5.0
+				alert = &TokenAlert{Token: update.Token, Threshold: &threshold, Notification: ON}
+				if tokenPrice, ok := s.priceIn(update.Token, watcher.DisplayCurrency()); ok {
+					alert.TokenPrice = &tokenPrice
+				}
+				watcher.AddTokenAlert(alert)
+			}
+
+			if update.Threshold != nil {
+				threshold := *update.Threshold
+				alert.Threshold = &threshold
+			}
+			if update.Notification != nil {
+				alert.Notification = *update.Notification
+			}
+		}
+
+		s.setAlertMode(watcher, update)
+		watcher.UpdatedAt = time.Now()
+	}
+
+	s.indexPriceAlerts(watcher)
+
+	return s.repository.UpdateWatcher(ctx, watcher)
+}
+
+func (s *service) DeleteWatcherTokenAlerts(ctx context.Context, device Device, tokens []string) error {
+	watcher, err := s.GetWatcher(ctx, device)
 	if err != nil {
 		return err
 	}
 	if watcher == nil {
-		return errors.New("watcher not found")
+		return ErrWatcherNotFound
 	}
 
-	if err := s.repository.DeleteWatcher(ctx, bson.M{"push_token": encodePushToken}); err != nil {
+	defer s.watcherLocks.Lock(watcher)()
+
+	for _, token := range tokens {
+		if token == price.DefaultToken {
+			watcher.SetPriceNotification(OFF)
+			continue
+		}
+		watcher.DeleteTokenAlert(token)
+	}
+
+	s.indexPriceAlerts(watcher)
+
+	return s.repository.UpdateWatcher(ctx, watcher)
+}
+
+// setAlertMode applies the mode and reference price of an alert update. A
+// new mode starts armed; leaving the pinned mode measures from the current
+// price again.
+func (s *service) setAlertMode(watcher *Watcher, update TokenAlertUpdate) {
+	state := watcher.AlertState(update.Token)
+
+	if update.ReferencePrice != nil {
+		watcher.SetReferencePrice(update.Token, *update.ReferencePrice)
+		state.Triggered = 0
+	}
+
+	if update.Mode == nil || *update.Mode == state.AlertMode() {
+		return
+	}
+
+	if state.AlertMode() == AlertModePinned {
+		if tokenPrice, ok := s.priceIn(update.Token, watcher.DisplayCurrency()); ok {
+			watcher.SetReferencePrice(update.Token, tokenPrice)
+		}
+	}
+
+	state.Mode = *update.Mode
+	state.Triggered = 0
+}
+
+// setWatcherCurrency switches the watcher display currency and converts the
+// reference price so a pending price alert isn't triggered by the switch.
+func (s *service) setWatcherCurrency(watcher *Watcher, currency string) error {
+	if !s.fx.Supports(currency) {
+		return ErrUnsupportedCurrency
+	}
+
+	fromRate, fromOk := s.fx.Rate(watcher.DisplayCurrency())
+	toRate, toOk := s.fx.Rate(currency)
+	if !fromOk || !toOk {
+		return ErrCurrencyRateUnavailable
+	}
+
+	if watcher.TokenPrice != nil {
+		watcher.SetTokenPrice(*watcher.TokenPrice / fromRate * toRate)
+	}
+	if watcher.TokenAlerts != nil {
+		for _, alert := range *watcher.TokenAlerts {
+			if alert.TokenPrice != nil {
+				tokenPrice := *alert.TokenPrice / fromRate * toRate
+				alert.TokenPrice = &tokenPrice
+			}
+		}
+	}
+
+	watcher.SetCurrency(currency)
+
+	return nil
+}
+
+func (s *service) DeleteWatcher(ctx context.Context, device Device) error {
+	encodePushToken := base64.StdEncoding.EncodeToString([]byte(device.PushToken))
+
+	watcher, err := s.GetWatcher(ctx, device)
+	if err != nil {
 		return err
 	}
+	if watcher == nil {
+		return ErrWatcherNotFound
+	}
 
-	s.mx.RLock()
-	watcherStopChan := s.cachedChan[watcher.PushToken]
-	s.mx.RUnlock()
+	defer s.watcherLocks.Lock(watcher)()
 
-	close(watcherStopChan)
-	s.mx.RLock()
+	if err := s.repository.DeleteWatcher(ctx, bson.M{"push_token": encodePushToken}); err != nil {
+		return err
+	}
+
+	s.mx.Lock()
 	delete(s.cachedWatcher, watcher.PushToken)
-	delete(s.cachedChan, watcher.PushToken)
-	s.mx.RUnlock()
+	s.mx.Unlock()
+
+	s.alertIndex.Remove(watcher.PushToken)
 
 	if watcher.Addresses != nil && len(*watcher.Addresses) > 0 {
-		var req bytes.Buffer
-		req.WriteString("{\"id\":\"")
-		req.WriteString(s.explorerToken)
-		req.WriteString("\",\"action\":\"unsubscribe\",\"addresses\":[")
-		first := true
-		empty := true
+		addresses := make([]string, 0, len(*watcher.Addresses))
 		for _, address := range *watcher.Addresses {
-			remove := true
-			s.mx.RLock()
-			if watchers, ok := s.cachedWatcherByAddress[address.Address]; ok {
-				watchers.Remove(watcher.PushToken)
-				remove = watchers.IsEmpty()
-			}
-			s.mx.RUnlock()
-			if remove {
-				empty = false
-				if first {
-					first = false
-					req.WriteString("\"")
-				} else {
-					req.WriteString(",\"")
-				}
-				req.WriteString(address.Address)
-				req.WriteString("\"")
-			}
+			addresses = append(addresses, address.Address)
 		}
-		if !empty {
-			req.WriteString("]}")
-			if err := s.doRequest(fmt.Sprintf("%s/watch", s.explorerUrl), &req, nil); err != nil {
-				s.logger.Errorln(err)
-			}
+
+		if err := s.unsubscribe(ctx, s.removeWatcherForAddresses(addresses, watcher)); err != nil {
+			s.logger.Errorln(err)
 		}
 	}
 
 	return nil
 }
 
-func (s *service) DeleteWatcherAddresses(ctx context.Context, pushToken string, addresses []string) error {
-	watcher, err := s.GetWatcher(ctx, pushToken)
+func (s *service) DeleteWatcherAddresses(ctx context.Context, device Device, addresses []string) error {
+	watcher, err := s.GetWatcher(ctx, device)
 	if err != nil {
 		return err
 	}
 	if watcher == nil {
-		return errors.New("watcher not found")
+		return ErrWatcherNotFound
 	}
 
-	var req bytes.Buffer
-	req.WriteString("{\"id\":\"")
-	req.WriteString(s.explorerToken)
-	req.WriteString("\",\"action\":\"unsubscribe\",\"addresses\":[")
-	first := true
-	empty := true
+	defer s.watcherLocks.Lock(watcher)()
+
 	for _, address := range addresses {
-		remove := true
 		watcher.DeleteAddress(address)
-		s.mx.RLock()
-		if watchers, ok := s.cachedWatcherByAddress[address]; ok {
-			watchers.Remove(watcher.PushToken)
-			remove = watchers.IsEmpty()
-		}
-		s.mx.RUnlock()
-		if remove {
-			empty = false
-			if first {
-				first = false
-				req.WriteString("\"")
-			} else {
-				req.WriteString(",\"")
-			}
-			req.WriteString(address)
-			req.WriteString("\"")
-		}
 	}
-	if !empty {
-		req.WriteString("]}")
-		if err := s.doRequest(fmt.Sprintf("%s/watch", s.explorerUrl), &req, nil); err != nil {
-			s.logger.Errorln(err)
-		}
+
+	if err := s.unsubscribe(ctx, s.removeWatcherForAddresses(addresses, watcher)); err != nil {
+		s.logger.Errorln(err)
 	}
 
 	if err := s.repository.UpdateWatcher(ctx, watcher); err != nil {
@@ -723,22 +1000,53 @@ func (s *service) DeleteWatchersWithStaleData(ctx context.Context) error {
 	}
 
 	// Delete from cache
-	s.mx.RLock()
+	s.mx.Lock()
 	for pushToken, watcher := range s.cachedWatcher {
 		if watcher.LastFailDate.Before(watcher.LastSuccessDate.Add(-7 * 24 * time.Hour)) {
 			delete(s.cachedWatcher, pushToken)
-			delete(s.cachedChan, pushToken)
+			s.alertIndex.Remove(pushToken)
 		}
 	}
-	s.mx.RUnlock()
+	s.mx.Unlock()
 
 	return nil
 }
 
-func (s *service) UpdateWatcherPushToken(ctx context.Context, olpPushToken string, newPushToken string, deviceId string) error {
-	encodePushToken := base64.StdEncoding.EncodeToString([]byte(olpPushToken))
+// RecordUnregistered sets the fail date of the watchers whose push tokens FCM
+// no longer knows, so DeleteWatchersWithStaleData drops them.
+func (s *service) RecordUnregistered(ctx context.Context, pushTokens []string) error {
+	now := s.clock()
+
+	watchers := make([]*Watcher, 0, len(pushTokens))
+	for _, pushToken := range pushTokens {
+		watcher, err := s.lookupWatcher(ctx, pushToken)
+		if errors.Is(err, ErrWatcherNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		watchers = append(watchers, watcher)
+	}
+
+	if len(watchers) == 0 {
+		return nil
+	}
+
+	unlock := s.watcherLocks.LockAll(watchers)
+	defer unlock()
 
-	watcher, err := s.GetWatcher(ctx, olpPushToken)
+	for _, watcher := range watchers {
+		watcher.SetLastFailDate(now)
+	}
+
+	return s.repository.UpdateWatchers(ctx, watchers)
+}
+
+func (s *service) UpdateWatcherPushToken(ctx context.Context, device Device, newPushToken string, deviceId string) error {
+	encodePushToken := base64.StdEncoding.EncodeToString([]byte(device.PushToken))
+
+	watcher, err := s.GetWatcher(ctx, device)
 	if err != nil {
 		s.logger.Errorf("UpdateWatcherPushToken GetWatcher error %v\n", err)
 		return err
@@ -748,54 +1056,185 @@ func (s *service) UpdateWatcherPushToken(ctx context.Context, olpPushToken strin
 		return err
 	}
 
+	defer s.watcherLocks.Lock(watcher)()
+
+	if err := s.checkPushTokenOwner(ctx, watcher, newPushToken); err != nil {
+		return err
+	}
+
+	previousDeviceId := watcher.DeviceId
 	watcher.SetPushToken(base64.StdEncoding.EncodeToString([]byte(newPushToken)))
 	watcher.SetDeviceId(deviceId)
 
 	if err := s.repository.UpdateWatcher(ctx, watcher); err != nil {
 		s.logger.Errorf("UpdateWatcherPushToken repository.UpdateWatcher error %v\n", err)
+		watcher.SetPushToken(encodePushToken)
+		watcher.SetDeviceId(previousDeviceId)
+		return pushTokenError(err)
+	}
+
+	s.movePushToken(watcher, encodePushToken)
+	s.indexPriceAlerts(watcher)
+
+	return nil
+}
+
+// DeviceUpdate is a partial update of the device settings.
+type DeviceUpdate struct {
+	PushToken         *string
+	Threshold         *float64
+	TxNotification    *string
+	PriceNotification *string
+	Currency          *string
+	App               *AppInfo
+}
+
+// UpdateDevice applies update in a single write. The changes that can be
+// refused are checked before any is made, and the watcher is reloaded when
+// the write fails, so the update is applied whole or not at all.
+func (s *service) UpdateDevice(ctx context.Context, device Device, update DeviceUpdate) (*Watcher, error) {
+	encodePushToken := base64.StdEncoding.EncodeToString([]byte(device.PushToken))
+
+	watcher, err := s.GetWatcher(ctx, device)
+	if err != nil {
+		return nil, err
+	}
+	if watcher == nil {
+		return nil, ErrWatcherNotFound
+	}
+
+	defer s.watcherLocks.Lock(watcher)()
+
+	movePushToken := update.PushToken != nil && *update.PushToken != device.PushToken
+	if movePushToken {
+		if err := s.checkPushTokenOwner(ctx, watcher, *update.PushToken); err != nil {
+			return nil, err
+		}
+	}
+
+	// setWatcherCurrency checks the currency before converting anything
+	if update.Currency != nil && *update.Currency != "" && *update.Currency != watcher.DisplayCurrency() {
+		if err := s.setWatcherCurrency(watcher, *update.Currency); err != nil {
+			return nil, err
+		}
+	}
+
+	if update.Threshold != nil {
+		watcher.SetThreshold(*update.Threshold)
+	}
+	if update.TxNotification != nil && *update.TxNotification != "" {
+		watcher.SetTxNotification(*update.TxNotification)
+	}
+	if update.PriceNotification != nil && *update.PriceNotification != "" {
+		watcher.SetPriceNotification(*update.PriceNotification)
+	}
+	if update.App != nil {
+		watcher.SetApp(*update.App)
+	}
+	if movePushToken {
+		watcher.SetPushToken(base64.StdEncoding.EncodeToString([]byte(*update.PushToken)))
+	}
+
+	if err := s.repository.UpdateWatcher(ctx, watcher); err != nil {
+		s.logger.Errorf("UpdateDevice repository.UpdateWatcher error %v\n", err)
+		s.restoreWatcher(ctx, watcher)
+		return nil, pushTokenError(err)
+	}
+
+	if movePushToken {
+		s.movePushToken(watcher, encodePushToken)
+	}
+	s.indexPriceAlerts(watcher)
+
+	return watcher, nil
+}
+
+// checkPushTokenOwner refuses pushToken when it identifies another watcher.
+// The unique index on push tokens covers a race with another device.
+func (s *service) checkPushTokenOwner(ctx context.Context, watcher *Watcher, pushToken string) error {
+	owner, err := s.repository.GetWatcher(ctx, bson.M{"push_token": base64.StdEncoding.EncodeToString([]byte(pushToken))})
+	if err != nil {
 		return err
 	}
+	if owner != nil && owner.ID != watcher.ID {
+		return ErrPushTokenTaken
+	}
+
+	return nil
+}
+
+// pushTokenError reports a duplicate key on a watcher update as the push
+// token of another device, the only unique field of the watchers.
+func pushTokenError(err error) error {
+	if errors.Is(err, ErrWatcherAlreadyExists) {
+		return ErrPushTokenTaken
+	}
 
+	return err
+}
+
+// movePushToken caches the watcher under its new push token. Callers hold the
+// watcher lock.
+func (s *service) movePushToken(watcher *Watcher, previousPushToken string) {
 	s.mx.Lock()
-	delete(s.cachedWatcher, encodePushToken)
+	delete(s.cachedWatcher, previousPushToken)
 	s.cachedWatcher[watcher.PushToken] = watcher
 	s.mx.Unlock()
 
-	return nil
+	s.alertIndex.Remove(previousPushToken)
 }
 
-func (s *service) setUpStopChanAndStartWatchers(ctx context.Context, watcher *Watcher) {
-	s.mx.Lock()
-	stopChan := make(chan struct{})
-	s.cachedChan[watcher.PushToken] = stopChan
-	s.mx.Unlock()
+// restoreWatcher reverts the changes a failed write left on the cached
+// watcher. Callers hold the watcher lock.
+func (s *service) restoreWatcher(ctx context.Context, watcher *Watcher) {
+	stored, err := s.repository.GetWatcher(ctx, bson.M{"_id": watcher.ID})
+	if err != nil || stored == nil {
+		s.logger.Errorf("restoreWatcher repository.GetWatcher error %v\n", err)
+		return
+	}
 
-	if watcher.Addresses != nil && len(*watcher.Addresses) > 0 {
-		var req bytes.Buffer
-		req.WriteString("{\"id\":\"")
-		req.WriteString(s.explorerToken)
-		req.WriteString("\",\"action\":\"subscribe\",\"addresses\":[")
-		for i, address := range *watcher.Addresses {
-			if i != 0 {
-				req.WriteString(",\"")
-			} else {
-				req.WriteString("\"")
-			}
-			req.WriteString(address.Address)
-			req.WriteString("\"")
+	*watcher = *stored
+}
+
+// UpdateWatcherApp stores what the app reported about itself.
+func (s *service) UpdateWatcherApp(ctx context.Context, device Device, app AppInfo) error {
+	watcher, err := s.GetWatcher(ctx, device)
+	if err != nil {
+		return err
+	}
+
+	defer s.watcherLocks.Lock(watcher)()
+
+	if !watcher.SetApp(app) {
+		return nil
+	}
+
+	if err := s.repository.UpdateWatcher(ctx, watcher); err != nil {
+		s.logger.Errorf("UpdateWatcherApp repository.UpdateWatcher error %v\n", err)
+		return err
+	}
 
+	return nil
+}
+
+// startWatching caches the watcher addresses and indexes its price alerts.
+// Disabled watchers are left out.
+func (s *service) startWatching(watcher *Watcher) {
+	// Explorer subscriptions for these addresses are sent by Reconcile
+	if watcher.Addresses != nil && !watcher.Disabled {
+		for _, address := range *watcher.Addresses {
 			s.addWatcherForAddress(address.Address, watcher)
 		}
-		req.WriteString("]}")
-		if err := s.doRequest(fmt.Sprintf("%s/watch", s.explorerUrl), &req, nil); err != nil {
-			s.logger.Errorln(err)
-		}
 	}
 
-	go s.PriceWatch(ctx, watcher.PushToken, stopChan)
+	s.indexPriceAlerts(watcher)
 }
 
 func (s *service) addWatcherForAddress(address string, watcher *Watcher) {
+	if watcher.Disabled {
+		return
+	}
+
 	var items *watchers
 	var ok bool
 	s.mx.Lock()
@@ -808,6 +1247,27 @@ func (s *service) addWatcherForAddress(address string, watcher *Watcher) {
 	s.mx.Unlock()
 }
 
+// removeWatcherForAddresses drops the watcher from the address cache and
+// returns the addresses nobody watches anymore.
+func (s *service) removeWatcherForAddresses(addresses []string, watcher *Watcher) []string {
+	var unwatched []string
+
+	s.mx.Lock()
+	for _, address := range addresses {
+		remove := true
+		if watchers, ok := s.cachedWatcherByAddress[address]; ok {
+			watchers.Remove(watcher.PushToken)
+			remove = watchers.IsEmpty()
+		}
+		if remove {
+			unwatched = append(unwatched, address)
+		}
+	}
+	s.mx.Unlock()
+
+	return unwatched
+}
+
 func (s *service) doRequest(url string, body io.Reader, res interface{}) error {
 	client := &http.Client{}
 	var method string
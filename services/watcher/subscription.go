@@ -0,0 +1,267 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	subscriptionSourceExplorer = "explorer"
+	subscriptionSourceStored   = "stored"
+)
+
+type watchRequest struct {
+	Id        string   `json:"id"`
+	Action    string   `json:"action"`
+	Url       string   `json:"url,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+type watchListResponse struct {
+	Addresses []string `json:"addresses"`
+}
+
+// ReconcileReport describes the difference found between the addresses the
+// repository wants watched and the addresses the explorer holds.
+type ReconcileReport struct {
+	Source       string    `json:"source"`
+	Desired      int       `json:"desired"`
+	Current      int       `json:"current"`
+	Missing      int       `json:"missing"`
+	Stale        int       `json:"stale"`
+	Subscribed   int       `json:"subscribed"`
+	Unsubscribed int       `json:"unsubscribed"`
+	CheckedAt    time.Time `json:"checked_at"`
+}
+
+func (s *service) explorerWatch(req *watchRequest, res interface{}) error {
+	req.Id = s.explorerToken
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	return s.doRequest(fmt.Sprintf("%s/watch", s.explorerUrl), bytes.NewReader(body), res)
+}
+
+func (s *service) subscribe(ctx context.Context, addresses []string) error {
+	for _, chunk := range chunkAddresses(addresses, s.reconcileCfg.ChunkSize) {
+		if err := s.watchChunk(ctx, "subscribe", chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *service) unsubscribe(ctx context.Context, addresses []string) error {
+	for _, chunk := range chunkAddresses(addresses, s.reconcileCfg.ChunkSize) {
+		if err := s.watchChunk(ctx, "unsubscribe", chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// watchChunk sends one subscribe or unsubscribe call and stores its result.
+// The lock only covers one call, so a reconcile doesn't hold up the
+// handlers. Addresses watched again by the time an unsubscribe gets the
+// lock are kept: their watcher is cached before it subscribes, so its own
+// subscribe comes after this call.
+func (s *service) watchChunk(ctx context.Context, action string, addresses []string) error {
+	s.subscriptionMx.Lock()
+	defer s.subscriptionMx.Unlock()
+
+	if action == "unsubscribe" {
+		addresses = s.unwatchedAddresses(addresses)
+		if len(addresses) == 0 {
+			return nil
+		}
+	}
+
+	if err := s.explorerWatch(&watchRequest{Action: action, Addresses: addresses}, nil); err != nil {
+		return err
+	}
+
+	if action == "unsubscribe" {
+		return s.subscriptionRepository.RemoveSubscribedAddresses(ctx, addresses)
+	}
+	return s.subscriptionRepository.AddSubscribedAddresses(ctx, addresses)
+}
+
+func (s *service) unwatchedAddresses(addresses []string) []string {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	unwatched := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		if items, ok := s.cachedWatcherByAddress[address]; !ok || items == nil || items.IsEmpty() {
+			unwatched = append(unwatched, address)
+		}
+	}
+
+	return unwatched
+}
+
+// recordUnwatchedCallback stores an address the explorer sent a callback for
+// while no watcher wants it. The explorer can't always list what it holds,
+// and stored state only knows the subscriptions made since it exists, so
+// this is how subscriptions left from before get found: the next Reconcile
+// sees the address as stale and unsubscribes it.
+func (s *service) recordUnwatchedCallback(ctx context.Context, address string) {
+	if err := s.subscriptionRepository.AddSubscribedAddresses(ctx, []string{address}); err != nil {
+		s.logger.Errorf("recordUnwatchedCallback subscriptionRepository.AddSubscribedAddresses error %v\n", err)
+	}
+}
+
+// Reconcile brings the explorer subscriptions in line with the addresses of
+// all watchers, sending only the subscribe and unsubscribe calls needed.
+// The explorer state comes from its list action when it supports one and
+// from the stored subscriptions otherwise. With resubscribe set and only
+// stored state available, every desired address is subscribed again, since
+// an explorer re-init may have dropped them.
+func (s *service) Reconcile(ctx context.Context, resubscribe bool) (*ReconcileReport, error) {
+	report := &ReconcileReport{CheckedAt: time.Now()}
+
+	// Current state is read before the desired one, so an address subscribed
+	// in between is never seen as stale
+	var current []string
+	var list *watchListResponse
+	if err := s.explorerWatch(&watchRequest{Action: "list"}, &list); err == nil && list != nil && list.Addresses != nil {
+		current = list.Addresses
+		report.Source = subscriptionSourceExplorer
+	} else {
+		stored, err := s.subscriptionRepository.GetSubscribedAddresses(ctx)
+		if err != nil {
+			return nil, err
+		}
+		current = stored
+		report.Source = subscriptionSourceStored
+	}
+
+	desired, err := s.repository.GetWatchedAddresses(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Addresses that are already cached but not yet stored are wanted too
+	s.mx.RLock()
+	for address, items := range s.cachedWatcherByAddress {
+		if items != nil && !items.IsEmpty() {
+			desired = append(desired, address)
+		}
+	}
+	s.mx.RUnlock()
+
+	diff := diffSubscriptions(desired, current, resubscribe && report.Source == subscriptionSourceStored)
+	report.Desired = diff.Desired
+	report.Current = diff.Current
+	report.Missing = diff.Missing
+	report.Stale = diff.Stale
+
+	if err := s.subscribe(ctx, diff.Subscribe); err != nil {
+		return nil, err
+	}
+	report.Subscribed = len(diff.Subscribe)
+
+	if err := s.unsubscribe(ctx, diff.Unsubscribe); err != nil {
+		return nil, err
+	}
+	report.Unsubscribed = len(diff.Unsubscribe)
+
+	s.metrics.Counter("explorer_reconcile_runs_total").Inc()
+	s.metrics.Gauge("explorer_subscriptions_desired").Set(int64(report.Desired))
+	s.metrics.Gauge("explorer_subscriptions_missing").Set(int64(report.Missing))
+	s.metrics.Gauge("explorer_subscriptions_stale").Set(int64(report.Stale))
+
+	if report.Missing > 0 || report.Stale > 0 {
+		s.logger.Warnf("Reconcile subscription drift: source %s, desired %d, current %d, missing %d, stale %d",
+			report.Source, report.Desired, report.Current, report.Missing, report.Stale)
+	}
+
+	return report, nil
+}
+
+type subscriptionDiff struct {
+	Desired     int
+	Current     int
+	Missing     int
+	Stale       int
+	Subscribe   []string
+	Unsubscribe []string
+}
+
+// diffSubscriptions compares addresses case-insensitively. With
+// resubscribeAll every desired address is subscribed, not only the missing
+// ones. Both lists come out sorted.
+func diffSubscriptions(desired, current []string, resubscribeAll bool) subscriptionDiff {
+	desiredSet := addressSet(desired)
+	currentSet := addressSet(current)
+	diff := subscriptionDiff{Desired: len(desiredSet), Current: len(currentSet)}
+
+	for key, address := range desiredSet {
+		if _, ok := currentSet[key]; !ok {
+			diff.Missing++
+			diff.Subscribe = append(diff.Subscribe, address)
+		} else if resubscribeAll {
+			diff.Subscribe = append(diff.Subscribe, address)
+		}
+	}
+	for key, address := range currentSet {
+		if _, ok := desiredSet[key]; !ok {
+			diff.Stale++
+			diff.Unsubscribe = append(diff.Unsubscribe, address)
+		}
+	}
+
+	sort.Strings(diff.Subscribe)
+	sort.Strings(diff.Unsubscribe)
+
+	return diff
+}
+
+func (s *service) reconcileLoop(ctx context.Context) {
+	ticker := time.NewTicker(s.reconcileCfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Reconcile(ctx, false); err != nil {
+				s.logger.Errorf("reconcileLoop Reconcile error %v", err)
+			}
+		}
+	}
+}
+
+// addressSet maps lower cased addresses to their original spelling, since the
+// explorer and the app don't agree on checksum casing.
+func addressSet(addresses []string) map[string]string {
+	set := make(map[string]string, len(addresses))
+	for _, address := range addresses {
+		set[strings.ToLower(address)] = address
+	}
+
+	return set
+}
+
+func chunkAddresses(addresses []string, size int) [][]string {
+	var chunks [][]string
+	for size < len(addresses) {
+		addresses, chunks = addresses[size:], append(chunks, addresses[:size])
+	}
+	if len(addresses) > 0 {
+		chunks = append(chunks, addresses)
+	}
+
+	return chunks
+}
@@ -0,0 +1,143 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type subscription struct {
+	Address   string    `bson:"_id"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// SubscriptionRepository keeps the set of addresses the explorer was last
+// told to watch. It's the fallback view of explorer state when the explorer
+// can't list its subscriptions itself.
+//
+//go:generate mockgen -source=subscription_repository.go -destination=mocks/subscription_repository_mock.go
+type SubscriptionRepository interface {
+	GetSubscribedAddresses(ctx context.Context) ([]string, error)
+	GetSubscriptions(ctx context.Context, addresses []string) (map[string]time.Time, error)
+	AddSubscribedAddresses(ctx context.Context, addresses []string) error
+	RemoveSubscribedAddresses(ctx context.Context, addresses []string) error
+}
+
+type subscriptionRepository struct {
+	db               *mongo.Client
+	dbName           string
+	dbCollectionName string
+	logger           *zap.SugaredLogger
+}
+
+func NewSubscriptionRepository(db *mongo.Client, dbName string, logger *zap.SugaredLogger) (SubscriptionRepository, error) {
+	if db == nil {
+		return nil, errors.New("[subscription_repository] invalid user database")
+	}
+	if dbName == "" {
+		return nil, errors.New("[subscription_repository] invalid database name")
+	}
+	if logger == nil {
+		return nil, errors.New("[subscription_repository] invalid logger")
+	}
+
+	return &subscriptionRepository{db: db, dbName: dbName, dbCollectionName: "explorer_subscription", logger: logger}, nil
+}
+
+func (r *subscriptionRepository) GetSubscribedAddresses(ctx context.Context) ([]string, error) {
+	cur, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).Find(ctx, bson.M{})
+	if err != nil {
+		r.logger.Errorf("unable to find subscriptions due to internal error: %v", err)
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var addresses []string
+	for cur.Next(ctx) {
+		var item subscription
+		if err := cur.Decode(&item); err != nil {
+			r.logger.Errorf("unable to decode subscription document: %v", err)
+			return nil, err
+		}
+		addresses = append(addresses, item.Address)
+	}
+
+	if err := cur.Err(); err != nil {
+		r.logger.Errorf("cursor iteration error: %v", err)
+		return nil, err
+	}
+
+	return addresses, nil
+}
+
+// GetSubscriptions returns when each of the addresses that are subscribed
+// was last subscribed.
+func (r *subscriptionRepository) GetSubscriptions(ctx context.Context, addresses []string) (map[string]time.Time, error) {
+	subscriptions := make(map[string]time.Time, len(addresses))
+	if len(addresses) == 0 {
+		return subscriptions, nil
+	}
+
+	cur, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).Find(ctx, bson.M{"_id": bson.M{"$in": addresses}})
+	if err != nil {
+		r.logger.Errorf("unable to find subscriptions due to internal error: %v", err)
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var item subscription
+		if err := cur.Decode(&item); err != nil {
+			r.logger.Errorf("unable to decode subscription document: %v", err)
+			return nil, err
+		}
+		subscriptions[item.Address] = item.UpdatedAt
+	}
+
+	if err := cur.Err(); err != nil {
+		r.logger.Errorf("cursor iteration error: %v", err)
+		return nil, err
+	}
+
+	return subscriptions, nil
+}
+
+func (r *subscriptionRepository) AddSubscribedAddresses(ctx context.Context, addresses []string) error {
+	if len(addresses) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	models := make([]mongo.WriteModel, 0, len(addresses))
+	for _, address := range addresses {
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": address}).
+			SetReplacement(&subscription{Address: address, UpdatedAt: now}).
+			SetUpsert(true))
+	}
+
+	if _, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		r.logger.Errorf("failed to add subscriptions: %s", err)
+		return errors.New("failed to add subscriptions")
+	}
+
+	return nil
+}
+
+func (r *subscriptionRepository) RemoveSubscribedAddresses(ctx context.Context, addresses []string) error {
+	if len(addresses) == 0 {
+		return nil
+	}
+
+	if _, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).DeleteMany(ctx, bson.M{"_id": bson.M{"$in": addresses}}); err != nil {
+		r.logger.Errorf("failed to remove subscriptions: %s", err)
+		return errors.New("failed to remove subscriptions")
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package watcher
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffSubscriptions(t *testing.T) {
+	tests := []struct {
+		name            string
+		desired         []string
+		current         []string
+		resubscribeAll  bool
+		wantSubscribe   []string
+		wantUnsubscribe []string
+		wantMissing     int
+		wantStale       int
+	}{
+		{
+			name:            "should subscribe missing and unsubscribe stale addresses",
+			desired:         []string{"0xA", "0xB"},
+			current:         []string{"0xB", "0xC"},
+			wantSubscribe:   []string{"0xA"},
+			wantUnsubscribe: []string{"0xC"},
+			wantMissing:     1,
+			wantStale:       1,
+		},
+		{
+			name:    "should ignore the address casing",
+			desired: []string{"0xAbC", "0xabc"},
+			current: []string{"0xABC"},
+		},
+		{
+			name:           "should resubscribe every desired address",
+			desired:        []string{"0xB", "0xA"},
+			current:        []string{"0xA"},
+			resubscribeAll: true,
+			wantSubscribe:  []string{"0xA", "0xB"},
+			wantMissing:    1,
+		},
+		{
+			name:            "should unsubscribe everything when nothing is watched",
+			current:         []string{"0xB", "0xA"},
+			wantUnsubscribe: []string{"0xA", "0xB"},
+			wantStale:       2,
+		},
+	}
+
+	for _, test := range tests {
+		diff := diffSubscriptions(test.desired, test.current, test.resubscribeAll)
+		assert.Equal(t, test.wantSubscribe, diff.Subscribe, test.name)
+		assert.Equal(t, test.wantUnsubscribe, diff.Unsubscribe, test.name)
+		assert.Equal(t, test.wantMissing, diff.Missing, test.name)
+		assert.Equal(t, test.wantStale, diff.Stale, test.name)
+	}
+}
+
+func TestChunkAddresses(t *testing.T) {
+	tests := []struct {
+		name      string
+		addresses []string
+		size      int
+		expect    [][]string
+	}{
+		{name: "should have no chunk without addresses", addresses: nil, size: 2, expect: nil},
+		{name: "should fit in one chunk", addresses: []string{"a", "b"}, size: 2, expect: [][]string{{"a", "b"}}},
+		{name: "should keep the rest in a last chunk", addresses: []string{"a", "b", "c"}, size: 2, expect: [][]string{{"a", "b"}, {"c"}}},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expect, chunkAddresses(test.addresses, test.size), test.name)
+	}
+}
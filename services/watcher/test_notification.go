@@ -0,0 +1,208 @@
+package watcher
+
+import (
+	"context"
+	"encoding/base64"
+	"time"
+
+	cloudmessaging "airdao-mobile-api/pkg/firebase/cloud-messaging"
+	"airdao-mobile-api/services/price"
+)
+
+const (
+	TestNotificationTx         = "transaction"
+	TestNotificationPriceAlert = "price_alert"
+
+	// sampleAddress stands in for the counterparty of the sample tx
+	sampleAddress = "0x0000000000000000000000000000000000000000"
+)
+
+// TestDelivery is what FCM answered to one test notification.
+type TestDelivery struct {
+	Kind      string  `json:"kind" bson:"kind"`
+	Title     string  `json:"title" bson:"title"`
+	Body      string  `json:"body" bson:"body"`
+	Sent      bool    `json:"sent" bson:"sent"`
+	MessageId *string `json:"message_id" bson:"message_id"`
+	Error     string  `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// NotificationReport tells support whether FCM, the push token or the app
+// settings keep a device from getting alerts. The last success and fail
+// dates are the ones from before the test.
+type NotificationReport struct {
+	TokenValid bool `json:"token_valid" bson:"token_valid"`
+	// TokenUnregistered is set when FCM no longer knows the push token, the
+	// app has to send a new one
+	TokenUnregistered bool `json:"token_unregistered" bson:"token_unregistered"`
+
+	Disabled          bool   `json:"disabled" bson:"disabled"`
+	TxNotification    bool   `json:"tx_notification" bson:"tx_notification"`
+	PriceNotification bool   `json:"price_notification" bson:"price_notification"`
+	Addresses         int    `json:"addresses" bson:"addresses"`
+	PriceAlerts       int    `json:"price_alerts" bson:"price_alerts"`
+	Currency          string `json:"currency" bson:"currency"`
+
+	LastSuccessDate *time.Time `json:"last_success_date" bson:"last_success_date"`
+	LastFailDate    *time.Time `json:"last_fail_date" bson:"last_fail_date"`
+
+	Deliveries []*TestDelivery `json:"deliveries" bson:"deliveries"`
+	// Problems are the likely reasons for missing alerts, empty when none
+	Problems []string `json:"problems" bson:"problems"`
+
+	CheckedAt time.Time `json:"checked_at" bson:"checked_at"`
+}
+
+// TestNotification sends a sample tx alert and a sample price alert the way
+// real ones are sent, and reports how the device is set up to get them. The
+// report is kept on the watcher for support to look up later.
+func (s *service) TestNotification(ctx context.Context, device Device) (*NotificationReport, error) {
+	watcher, err := s.GetWatcher(ctx, device)
+	if err != nil {
+		return nil, err
+	}
+
+	defer s.watcherLocks.Lock(watcher)()
+
+	now := time.Now()
+	report := notificationReport(watcher, now)
+
+	decodedPushToken, err := base64.StdEncoding.DecodeString(watcher.PushToken)
+	if err != nil || len(decodedPushToken) == 0 {
+		report.Problems = append(report.Problems, "the stored push token is malformed")
+		return report, s.saveNotificationReport(ctx, watcher, report)
+	}
+
+	currency := watcher.DisplayCurrency()
+
+	// Tx alerts go one by one
+	tx := &Tx{From: sampleAddress, To: sampleAddress, Timestamp: float64(now.Unix())}
+	tx.Value.Ether = 1
+	if watcher.Addresses != nil && len(*watcher.Addresses) > 0 {
+		tx.To = (*watcher.Addresses)[0].Address
+	}
+	title, body, data := txNotificationMessage(tx, currency, s.txFiatValue(tx, tx.To, currency))
+	data["test"] = true
+
+	messageId, err := s.cloudMessagingSvc.SendMessage(ctx, title, body, string(decodedPushToken), data)
+	if err != nil {
+		s.logger.Errorf("TestNotification cloudMessagingSvc.SendMessage error %v\n", err)
+	}
+	report.addDelivery(TestNotificationTx, title, body, messageId, err)
+
+	// Price alerts go in batches
+	tokenPrice, _ := s.priceIn(price.DefaultToken, currency)
+	title, body, data = priceAlertMessage(price.DefaultToken, AlertModeLastAlert, 5, tokenPrice, currency)
+	data["test"] = true
+
+	var result *cloudmessaging.SendResult
+	results, err := s.cloudMessagingSvc.SendMessages(ctx, []*cloudmessaging.Message{
+		{Title: title, Body: body, PushToken: string(decodedPushToken), Data: data},
+	})
+	if err != nil {
+		s.logger.Errorf("TestNotification cloudMessagingSvc.SendMessages error %v\n", err)
+	}
+	if len(results) > 0 {
+		result = results[0]
+	} else {
+		result = &cloudmessaging.SendResult{Err: err}
+	}
+	report.addDelivery(TestNotificationPriceAlert, title, body, result.MessageId, result.Err)
+
+	if report.TokenValid {
+		watcher.SetLastSuccessDate(now)
+	} else if report.TokenUnregistered {
+		watcher.SetLastFailDate(now)
+	}
+
+	return report, s.saveNotificationReport(ctx, watcher, report)
+}
+
+func (s *service) saveNotificationReport(ctx context.Context, watcher *Watcher, report *NotificationReport) error {
+	watcher.LastTestNotification = report
+
+	if err := s.repository.UpdateWatcher(ctx, watcher); err != nil {
+		s.logger.Errorf("TestNotification repository.UpdateWatcher error %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func notificationReport(watcher *Watcher, now time.Time) *NotificationReport {
+	report := &NotificationReport{
+		Disabled:       watcher.Disabled,
+		TxNotification: watcher.TxNotification == ON,
+		Currency:       watcher.DisplayCurrency(),
+		Deliveries:     make([]*TestDelivery, 0, 2),
+		Problems:       make([]string, 0),
+		CheckedAt:      now,
+	}
+
+	if watcher.Addresses != nil {
+		report.Addresses = len(*watcher.Addresses)
+	}
+
+	tokens := []string{price.DefaultToken}
+	if watcher.TokenAlerts != nil {
+		for _, alert := range *watcher.TokenAlerts {
+			tokens = append(tokens, alert.Token)
+		}
+	}
+	for _, token := range tokens {
+		threshold, _, notification, ok := watcher.PriceAlert(token)
+		if !ok || threshold == nil {
+			continue
+		}
+		report.PriceAlerts++
+		if notification == ON {
+			report.PriceNotification = true
+		}
+	}
+
+	if !watcher.LastSuccessDate.IsZero() {
+		date := watcher.LastSuccessDate
+		report.LastSuccessDate = &date
+	}
+	if !watcher.LastFailDate.IsZero() {
+		date := watcher.LastFailDate
+		report.LastFailDate = &date
+	}
+
+	if watcher.Disabled {
+		report.Problems = append(report.Problems, "the watcher is disabled")
+	}
+	if !report.TxNotification {
+		report.Problems = append(report.Problems, "tx notifications are switched off")
+	} else if report.Addresses == 0 {
+		report.Problems = append(report.Problems, "no address is watched")
+	}
+	if report.PriceAlerts == 0 {
+		report.Problems = append(report.Problems, "no price alert is set")
+	} else if !report.PriceNotification {
+		report.Problems = append(report.Problems, "price notifications are switched off")
+	}
+
+	return report
+}
+
+func (r *NotificationReport) addDelivery(kind, title, body string, messageId *string, err error) {
+	delivery := &TestDelivery{Kind: kind, Title: title, Body: body, MessageId: messageId}
+	delivery.Sent = err == nil && messageId != nil
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	r.Deliveries = append(r.Deliveries, delivery)
+
+	switch {
+	case delivery.Sent:
+		r.TokenValid = true
+	case cloudmessaging.IsUnregistered(err):
+		if !r.TokenUnregistered {
+			r.Problems = append(r.Problems, "FCM no longer knows the push token, the app has to register a new one")
+		}
+		r.TokenUnregistered = true
+	default:
+		r.Problems = append(r.Problems, "FCM did not accept the "+kind+" notification")
+	}
+}
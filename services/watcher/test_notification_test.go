@@ -0,0 +1,111 @@
+package watcher
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotificationReport(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name              string
+		disabled          bool
+		txNotification    string
+		priceNotification string
+		addresses         []string
+		threshold         float64
+
+		wantProblems []string
+	}{
+		{
+			name:           "should find nothing wrong with a set up watcher",
+			txNotification: ON, priceNotification: ON, addresses: []string{"0xa"}, threshold: 5,
+			wantProblems: []string{},
+		},
+		{
+			name:     "should report a disabled watcher",
+			disabled: true, txNotification: ON, priceNotification: ON, addresses: []string{"0xa"}, threshold: 5,
+			wantProblems: []string{"the watcher is disabled"},
+		},
+		{
+			name:           "should report switched off notifications",
+			txNotification: OFF, priceNotification: OFF, addresses: []string{"0xa"}, threshold: 5,
+			wantProblems: []string{"tx notifications are switched off", "price notifications are switched off"},
+		},
+		{
+			name:           "should report a watcher without addresses nor price alerts",
+			txNotification: ON, priceNotification: ON,
+			wantProblems: []string{"no address is watched", "no price alert is set"},
+		},
+	}
+
+	for _, test := range tests {
+		watcher, err := NewWatcher(base64.StdEncoding.EncodeToString([]byte("push-token")))
+		require.NoError(t, err, test.name)
+		watcher.Disabled = test.disabled
+		watcher.SetTxNotification(test.txNotification)
+		watcher.SetPriceNotification(test.priceNotification)
+		for _, address := range test.addresses {
+			watcher.AddAddress(address)
+		}
+		if test.threshold != 0 {
+			watcher.SetThreshold(test.threshold)
+		}
+
+		report := notificationReport(watcher, now)
+		assert.Equal(t, test.wantProblems, report.Problems, test.name)
+		assert.Equal(t, test.disabled, report.Disabled, test.name)
+		assert.Equal(t, len(test.addresses), report.Addresses, test.name)
+		assert.Equal(t, now, report.CheckedAt, test.name)
+	}
+}
+
+func TestNotificationReportAddDelivery(t *testing.T) {
+	messageId := "id"
+
+	tests := []struct {
+		name      string
+		messageId *string
+		errs      []error
+
+		wantSent         bool
+		wantValid        bool
+		wantUnregistered bool
+		wantProblems     []string
+	}{
+		{
+			name: "should mark the token valid once a notification is sent", messageId: &messageId, errs: []error{nil, nil},
+			wantSent: true, wantValid: true, wantProblems: []string{},
+		},
+		{
+			name: "should report an unregistered token once", errs: []error{errUnregistered, errUnregistered},
+			wantUnregistered: true, wantProblems: []string{"FCM no longer knows the push token, the app has to register a new one"},
+		},
+		{
+			name: "should report each failed send", errs: []error{errors.New("http error status: 500"), errors.New("http error status: 500")},
+			wantProblems: []string{"FCM did not accept the transaction notification", "FCM did not accept the price_alert notification"},
+		},
+	}
+
+	for _, test := range tests {
+		report := &NotificationReport{Problems: make([]string, 0)}
+		report.addDelivery(TestNotificationTx, "title", "body", test.messageId, test.errs[0])
+		report.addDelivery(TestNotificationPriceAlert, "title", "body", test.messageId, test.errs[1])
+
+		require.Len(t, report.Deliveries, 2, test.name)
+		assert.Equal(t, TestNotificationTx, report.Deliveries[0].Kind, test.name)
+		assert.Equal(t, test.wantSent, report.Deliveries[0].Sent, test.name)
+		if test.errs[0] != nil {
+			assert.Equal(t, test.errs[0].Error(), report.Deliveries[0].Error, test.name)
+		}
+		assert.Equal(t, test.wantValid, report.TokenValid, test.name)
+		assert.Equal(t, test.wantUnregistered, report.TokenUnregistered, test.name)
+		assert.Equal(t, test.wantProblems, report.Problems, test.name)
+	}
+}
@@ -0,0 +1,54 @@
+package watcher
+
+import (
+	"sync"
+	"time"
+)
+
+// TxCache remembers which tx notifications were already sent so that the
+// same tx reported for several addresses (or redelivered by the explorer)
+// only reaches a device once. It is safe for concurrent use.
+type TxCache struct {
+	ttl time.Duration
+
+	mx        sync.Mutex
+	items     map[string]time.Time
+	lastPurge time.Time
+}
+
+func NewTxCache(ttl time.Duration) *TxCache {
+	return &TxCache{ttl: ttl, items: make(map[string]time.Time)}
+}
+
+// Has reports whether the key was marked and didn't expire yet.
+func (c *TxCache) Has(key string) bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	expiresAt, ok := c.items[key]
+	return ok && time.Now().Before(expiresAt)
+}
+
+// Add marks the key as seen. It returns false if the key was already there.
+func (c *TxCache) Add(key string) bool {
+	now := time.Now()
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if now.Sub(c.lastPurge) > c.ttl {
+		for k, expiresAt := range c.items {
+			if now.After(expiresAt) {
+				delete(c.items, k)
+			}
+		}
+		c.lastPurge = now
+	}
+
+	if expiresAt, ok := c.items[key]; ok && now.Before(expiresAt) {
+		return false
+	}
+	c.items[key] = now.Add(c.ttl)
+
+	return true
+}
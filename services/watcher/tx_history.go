@@ -0,0 +1,271 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"airdao-mobile-api/services/price"
+)
+
+const (
+	// txHistoryCacheTTL bounds how long a page is served from cache when no
+	// tx callback invalidates it
+	txHistoryCacheTTL = 10 * time.Minute
+
+	TxHistoryDefaultLimit = 20
+	TxHistoryMaxLimit     = 100
+
+	TxDirectionIn   = "in"
+	TxDirectionOut  = "out"
+	TxDirectionSelf = "self"
+)
+
+type TxToken struct {
+	Symbol  string `json:"symbol"`
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address,omitempty"`
+	Native  bool   `json:"native"`
+}
+
+type AddressTx struct {
+	Hash      string  `json:"hash"`
+	BlockHash string  `json:"block_hash"`
+	From      string  `json:"from"`
+	To        string  `json:"to"`
+	Timestamp float64 `json:"timestamp"`
+	Amount    float64 `json:"amount"`
+	Token     TxToken `json:"token"`
+	Direction string  `json:"direction"`
+	// FiatValue is the value at tx time in the watcher display currency, nil
+	// when the token wasn't priced then
+	FiatValue *float64 `json:"fiat_value"`
+}
+
+type AddressTxs struct {
+	Address      string       `json:"address"`
+	Page         int          `json:"page"`
+	Limit        int          `json:"limit"`
+	Currency     string       `json:"currency"`
+	Transactions []*AddressTx `json:"transactions"`
+	HasMore      bool         `json:"has_more"`
+	Cached       bool         `json:"cached"`
+}
+
+// txTokenSymbol names the token moved by tx. The explorer leaves the symbol
+// out for AMB and empty for HPT.
+func txTokenSymbol(tx *Tx) string {
+	if tx.Value.Symbol == nil {
+		return price.DefaultToken
+	}
+	if *tx.Value.Symbol == "" {
+		return "HPT"
+	}
+
+	return *tx.Value.Symbol
+}
+
+// txTokenContract returns the token of the address using the symbol moved by
+// tx, when a single one does. The explorer only gives the symbol of a token
+// transfer, and whoever deploys a contract picks its symbol.
+func txTokenContract(tx *Tx, tokens []ApiTokenBalance) (ApiTokenBalance, bool) {
+	symbol := txTokenSymbol(tx)
+
+	var found ApiTokenBalance
+	matches := 0
+	for _, token := range tokens {
+		if token.Symbol == symbol {
+			found = token
+			matches++
+		}
+	}
+
+	return found, matches == 1
+}
+
+// txPriceToken returns the tracked token moved by tx. Token transfers are
+// only priced when their contract, resolved from the tokens of the address,
+// is the one of a tracked token.
+func (s *service) txPriceToken(tx *Tx, tokens []ApiTokenBalance) (string, bool) {
+	if tx.Value.Symbol == nil {
+		return price.DefaultToken, true
+	}
+
+	token, ok := txTokenContract(tx, tokens)
+	if !ok {
+		return "", false
+	}
+
+	return s.feeds.TokenOf(token.Address)
+}
+
+// clampTxPage defaults and bounds the page and limit of a tx history request.
+func clampTxPage(page, limit int) (int, int) {
+	if page <= 0 {
+		page = 1
+	}
+	if limit <= 0 {
+		limit = TxHistoryDefaultLimit
+	}
+	if limit > TxHistoryMaxLimit {
+		limit = TxHistoryMaxLimit
+	}
+
+	return page, limit
+}
+
+func txDirection(tx *Tx, address string) string {
+	from := strings.EqualFold(tx.From, address)
+	to := strings.EqualFold(tx.To, address)
+
+	switch {
+	case from && to:
+		return TxDirectionSelf
+	case from:
+		return TxDirectionOut
+	default:
+		return TxDirectionIn
+	}
+}
+
+// fetchTxHistoryPage gets a page of address txs from the explorer and values
+// each at its time in USD, so the cached page serves every display currency.
+// Prices are read with one query per token.
+func (s *service) fetchTxHistoryPage(ctx context.Context, address string, tokens []ApiTokenBalance, page, limit int) (*TxHistoryPage, error) {
+	var apiAddressData *ApiAddressData
+	if err := s.doRequest(fmt.Sprintf("%s/addresses/%s/all?page=%d&limit=%d", s.explorerUrl, address, page, limit), nil, &apiAddressData); err != nil {
+		return nil, err
+	}
+	if apiAddressData == nil {
+		return nil, errors.New("empty address response")
+	}
+
+	txHistoryPage := &TxHistoryPage{
+		Address:   address,
+		Page:      page,
+		Limit:     limit,
+		Txs:       apiAddressData.Data,
+		UsdValues: make([]*float64, len(apiAddressData.Data)),
+		CreatedAt: time.Now(),
+	}
+	if txHistoryPage.Txs == nil {
+		txHistoryPage.Txs = []Tx{}
+	}
+
+	// Indexes of the txs of each priced token
+	byToken := make(map[string][]int)
+	for i := range txHistoryPage.Txs {
+		if token, ok := s.txPriceToken(&txHistoryPage.Txs[i], tokens); ok {
+			byToken[token] = append(byToken[token], i)
+		}
+	}
+
+	for token, indexes := range byToken {
+		times := make([]time.Time, 0, len(indexes))
+		for _, i := range indexes {
+			times = append(times, time.Unix(int64(txHistoryPage.Txs[i].Timestamp), 0))
+		}
+
+		prices, err := s.priceSvc.PricesAt(ctx, token, times)
+		if err != nil {
+			s.logger.Errorf("fetchTxHistoryPage priceSvc.PricesAt error %v\n", err)
+			continue
+		}
+
+		for j, i := range indexes {
+			if prices[j] > 0 {
+				value := txHistoryPage.Txs[i].Value.Ether * prices[j]
+				txHistoryPage.UsdValues[i] = &value
+			}
+		}
+	}
+
+	return txHistoryPage, nil
+}
+
+func (s *service) GetWatcherAddressTransactions(ctx context.Context, device Device, address string, page, limit int) (*AddressTxs, error) {
+	watcher, err := s.GetWatcher(ctx, device)
+	if err != nil {
+		return nil, err
+	}
+
+	if !watcher.HasAddress(address) {
+		return nil, ErrAddressNotWatched
+	}
+	// Like the portfolio, the history of an address is private to its owner
+	if !watcher.IsVerified(address) {
+		return nil, ErrAddressNotVerified
+	}
+
+	page, limit = clampTxPage(page, limit)
+
+	// Token names and contracts come from the balances of the address, which
+	// only list tokens it still holds
+	var tokens []ApiTokenBalance
+	if balances, err := s.getAddressBalances(address); err != nil {
+		s.logger.Errorf("GetWatcherAddressTransactions getAddressBalances error %v\n", err)
+	} else {
+		tokens = balances.tokens
+	}
+
+	txHistoryPage, err := s.txHistoryRepository.GetPage(ctx, address, page, limit)
+	if err != nil {
+		s.logger.Errorf("GetWatcherAddressTransactions txHistoryRepository.GetPage error %v\n", err)
+	}
+
+	// The TTL monitor runs about once a minute, so expiry is checked here too
+	cached := txHistoryPage != nil && time.Since(txHistoryPage.CreatedAt) <= txHistoryCacheTTL
+	if !cached {
+		if txHistoryPage, err = s.fetchTxHistoryPage(ctx, address, tokens, page, limit); err != nil {
+			s.logger.Errorf("GetWatcherAddressTransactions fetchTxHistoryPage error %v\n", err)
+			return nil, ErrExplorerUnavailable
+		}
+
+		if err := s.txHistoryRepository.SavePage(ctx, txHistoryPage); err != nil {
+			s.logger.Errorf("GetWatcherAddressTransactions txHistoryRepository.SavePage error %v\n", err)
+		}
+	}
+
+	currency := watcher.DisplayCurrency()
+	result := &AddressTxs{
+		Address:      address,
+		Page:         page,
+		Limit:        limit,
+		Currency:     currency,
+		Transactions: make([]*AddressTx, 0, len(txHistoryPage.Txs)),
+		HasMore:      len(txHistoryPage.Txs) == limit,
+		Cached:       cached,
+	}
+
+	for i := range txHistoryPage.Txs {
+		tx := &txHistoryPage.Txs[i]
+		symbol := txTokenSymbol(tx)
+
+		item := &AddressTx{
+			Hash:      tx.Hash,
+			BlockHash: tx.BlockHash,
+			From:      tx.From,
+			To:        tx.To,
+			Timestamp: tx.Timestamp,
+			Amount:    tx.Value.Ether,
+			Token:     TxToken{Symbol: symbol, Native: tx.Value.Symbol == nil},
+			Direction: txDirection(tx, address),
+		}
+		if token, ok := txTokenContract(tx, tokens); ok && !item.Token.Native {
+			item.Token.Name = token.Name
+			item.Token.Address = token.Address
+		}
+
+		if i < len(txHistoryPage.UsdValues) && txHistoryPage.UsdValues[i] != nil {
+			if value, ok := s.fx.Convert(*txHistoryPage.UsdValues[i], currency); ok {
+				item.FiatValue = &value
+			}
+		}
+
+		result.Transactions = append(result.Transactions, item)
+	}
+
+	return result, nil
+}
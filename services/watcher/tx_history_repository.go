@@ -0,0 +1,112 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// TxHistoryPage is one cached explorer page of address txs. UsdValues holds
+// the USD value of each tx at its time, nil where it couldn't be priced.
+type TxHistoryPage struct {
+	Address   string     `bson:"address"`
+	Page      int        `bson:"page"`
+	Limit     int        `bson:"limit"`
+	Txs       []Tx       `bson:"txs"`
+	UsdValues []*float64 `bson:"usd_values"`
+	CreatedAt time.Time  `bson:"created_at"`
+}
+
+//go:generate mockgen -source=tx_history_repository.go -destination=mocks/tx_history_repository_mock.go
+type TxHistoryRepository interface {
+	EnsureIndexes(ctx context.Context, ttl time.Duration) error
+
+	GetPage(ctx context.Context, address string, page, limit int) (*TxHistoryPage, error)
+	SavePage(ctx context.Context, page *TxHistoryPage) error
+	DeleteAddress(ctx context.Context, address string) error
+}
+
+type txHistoryRepository struct {
+	db               *mongo.Client
+	dbName           string
+	dbCollectionName string
+	logger           *zap.SugaredLogger
+}
+
+func NewTxHistoryRepository(db *mongo.Client, dbName string, logger *zap.SugaredLogger) (TxHistoryRepository, error) {
+	if db == nil {
+		return nil, errors.New("[tx_history_repository] invalid user database")
+	}
+	if dbName == "" {
+		return nil, errors.New("[tx_history_repository] invalid database name")
+	}
+	if logger == nil {
+		return nil, errors.New("[tx_history_repository] invalid logger")
+	}
+
+	return &txHistoryRepository{db: db, dbName: dbName, dbCollectionName: "address_tx_history", logger: logger}, nil
+}
+
+// EnsureIndexes creates the page lookup index and the TTL index that expires
+// pages no tx callback invalidated.
+func (r *txHistoryRepository) EnsureIndexes(ctx context.Context, ttl time.Duration) error {
+	_, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "address", Value: 1}, {Key: "page", Value: 1}, {Key: "limit", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(ttl.Seconds())),
+		},
+	})
+	if err != nil {
+		r.logger.Errorf("failed to create tx history indexes: %s", err)
+		return errors.New("failed to create tx history indexes")
+	}
+
+	return nil
+}
+
+func (r *txHistoryRepository) GetPage(ctx context.Context, address string, page, limit int) (*TxHistoryPage, error) {
+	var txHistoryPage TxHistoryPage
+
+	if err := r.db.Database(r.dbName).Collection(r.dbCollectionName).FindOne(ctx, bson.M{"address": address, "page": page, "limit": limit}).Decode(&txHistoryPage); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+
+		r.logger.Errorf("unable to find tx history page due to internal error: %v", err)
+		return nil, err
+	}
+
+	return &txHistoryPage, nil
+}
+
+func (r *txHistoryRepository) SavePage(ctx context.Context, page *TxHistoryPage) error {
+	if _, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).ReplaceOne(ctx,
+		bson.M{"address": page.Address, "page": page.Page, "limit": page.Limit},
+		page,
+		options.Replace().SetUpsert(true)); err != nil {
+		r.logger.Errorf("failed to save tx history page: %s", err)
+		return errors.New("failed to save tx history page")
+	}
+
+	return nil
+}
+
+// DeleteAddress drops every cached page of the address, as a new tx shifts
+// all of them.
+func (r *txHistoryRepository) DeleteAddress(ctx context.Context, address string) error {
+	if _, err := r.db.Database(r.dbName).Collection(r.dbCollectionName).DeleteMany(ctx, bson.M{"address": address}); err != nil {
+		r.logger.Errorf("failed to delete tx history pages: %s", err)
+		return errors.New("failed to delete tx history pages")
+	}
+
+	return nil
+}
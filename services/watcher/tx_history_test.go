@@ -0,0 +1,189 @@
+package watcher
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"airdao-mobile-api/services/price"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func symbolTx(symbol *string, from, to string) *Tx {
+	tx := &Tx{From: from, To: to}
+	tx.Value.Symbol = symbol
+	return tx
+}
+
+func symbolOf(symbol string) *string {
+	return &symbol
+}
+
+func TestTxTokenSymbol(t *testing.T) {
+	tests := []struct {
+		name   string
+		symbol *string
+		expect string
+	}{
+		{name: "should name AMB without symbol", symbol: nil, expect: price.DefaultToken},
+		{name: "should name HPT with an empty symbol", symbol: symbolOf(""), expect: "HPT"},
+		{name: "should keep the token symbol", symbol: symbolOf("USDC"), expect: "USDC"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expect, txTokenSymbol(symbolTx(test.symbol, "", "")), test.name)
+	}
+}
+
+func TestTxDirection(t *testing.T) {
+	tests := []struct {
+		name   string
+		from   string
+		to     string
+		expect string
+	}{
+		{name: "should be in to the address", from: "0xother", to: "0xAddress", expect: TxDirectionIn},
+		{name: "should be out from the address", from: "0xaddress", to: "0xother", expect: TxDirectionOut},
+		{name: "should be self to itself", from: "0xADDRESS", to: "0xaddress", expect: TxDirectionSelf},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expect, txDirection(symbolTx(nil, test.from, test.to), "0xaddress"), test.name)
+	}
+}
+
+func TestClampTxPage(t *testing.T) {
+	tests := []struct {
+		name      string
+		page      int
+		limit     int
+		wantPage  int
+		wantLimit int
+	}{
+		{name: "should default the page and limit", page: 0, limit: 0, wantPage: 1, wantLimit: TxHistoryDefaultLimit},
+		{name: "should default negative values", page: -1, limit: -5, wantPage: 1, wantLimit: TxHistoryDefaultLimit},
+		{name: "should keep values in bounds", page: 3, limit: 50, wantPage: 3, wantLimit: 50},
+		{name: "should cap the limit", page: 2, limit: 1000, wantPage: 2, wantLimit: TxHistoryMaxLimit},
+	}
+
+	for _, test := range tests {
+		page, limit := clampTxPage(test.page, test.limit)
+		assert.Equal(t, test.wantPage, page, test.name)
+		assert.Equal(t, test.wantLimit, limit, test.name)
+	}
+}
+
+func heldToken(address, symbol string) ApiTokenBalance {
+	return ApiTokenBalance{Address: address, Name: symbol, Symbol: symbol}
+}
+
+func TestTxPriceToken(t *testing.T) {
+	s := &service{feeds: newTestFeeds(t)}
+
+	tests := []struct {
+		name      string
+		symbol    *string
+		tokens    []ApiTokenBalance
+		wantToken string
+		wantOk    bool
+	}{
+		{name: "should price native txs as AMB", symbol: nil, wantToken: price.DefaultToken, wantOk: true},
+		{name: "should price the tracked contract", symbol: symbolOf("USDC"), tokens: []ApiTokenBalance{heldToken(usdcContract, "USDC")}, wantToken: "USDC", wantOk: true},
+		{name: "should not price another contract with the symbol", symbol: symbolOf("USDC"), tokens: []ApiTokenBalance{heldToken(spamContract, "USDC")}},
+		{name: "should not price a symbol held through two contracts", symbol: symbolOf("USDC"), tokens: []ApiTokenBalance{heldToken(usdcContract, "USDC"), heldToken(spamContract, "USDC")}},
+		{name: "should not price a token no longer held", symbol: symbolOf("USDC")},
+	}
+
+	for _, test := range tests {
+		token, ok := s.txPriceToken(symbolTx(test.symbol, "", ""), test.tokens)
+		assert.Equal(t, test.wantOk, ok, test.name)
+		assert.Equal(t, test.wantToken, token, test.name)
+	}
+}
+
+// rangePriceService prices every token at the same price and counts the
+// PricesAt queries per token
+type rangePriceService struct {
+	price.Service
+
+	mx      sync.Mutex
+	prices  map[string]float64
+	queries map[string]int
+}
+
+func (s *rangePriceService) PricesAt(ctx context.Context, token string, times []time.Time) ([]float64, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.queries[token]++
+	prices := make([]float64, len(times))
+	for i := range times {
+		prices[i] = s.prices[token]
+	}
+
+	return prices, nil
+}
+
+func TestFetchTxHistoryPage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/addresses/0xaddress/all", r.URL.Path)
+		assert.Equal(t, "2", r.URL.Query().Get("page"))
+		fmt.Fprint(w, `{"data":[
+			{"hash":"0x1","value":{"ether":10},"timestamp":1715342400},
+			{"hash":"0x2","value":{"ether":5,"symbol":"USDC"},"timestamp":1715338800},
+			{"hash":"0x3","value":{"ether":2},"timestamp":1715335200},
+			{"hash":"0x4","value":{"ether":7,"symbol":"SPAM"},"timestamp":1715331600}
+		]}`)
+	}))
+	defer server.Close()
+
+	priceSvc := &rangePriceService{prices: map[string]float64{price.DefaultToken: 0.01, "USDC": 1}, queries: make(map[string]int)}
+	s := newAlertService(t, time.Now(), 0.02, priceSvc, nil, nil)
+	s.feeds = newTestFeeds(t)
+	s.explorerUrl = server.URL
+
+	tokens := []ApiTokenBalance{heldToken(usdcContract, "USDC"), heldToken(spamContract, "SPAM")}
+	page, err := s.fetchTxHistoryPage(context.Background(), "0xaddress", tokens, 2, 4)
+	require.NoError(t, err)
+	require.Len(t, page.UsdValues, 4)
+
+	require.NotNil(t, page.UsdValues[0])
+	assert.InDelta(t, 0.1, *page.UsdValues[0], 1e-9)
+	require.NotNil(t, page.UsdValues[1])
+	assert.InDelta(t, 5, *page.UsdValues[1], 1e-9)
+	require.NotNil(t, page.UsdValues[2])
+	assert.InDelta(t, 0.02, *page.UsdValues[2], 1e-9)
+	assert.Nil(t, page.UsdValues[3])
+
+	assert.Equal(t, map[string]int{price.DefaultToken: 1, "USDC": 1}, priceSvc.queries)
+}
+
+func TestGetWatcherAddressTransactionsOwnership(t *testing.T) {
+	explorer := &explorerStub{}
+	server := httptest.NewServer(explorer)
+	defer server.Close()
+
+	s := newAlertService(t, time.Now(), 0.02, &rangePriceService{}, nil, nil)
+	s.explorerUrl = server.URL
+
+	pushToken := "push-token"
+	watcher, err := NewWatcher(base64.StdEncoding.EncodeToString([]byte(pushToken)))
+	require.NoError(t, err)
+	watcher.Addresses = &[]*Address{{Address: "0xunverified"}}
+	s.cachedWatcher[watcher.PushToken] = watcher
+
+	_, err = s.GetWatcherAddressTransactions(context.Background(), Device{PushToken: pushToken}, "0xunverified", 1, 10)
+	assert.ErrorIs(t, err, ErrAddressNotVerified)
+
+	_, err = s.GetWatcherAddressTransactions(context.Background(), Device{PushToken: pushToken}, "0xother", 1, 10)
+	assert.ErrorIs(t, err, ErrAddressNotWatched)
+
+	assert.Equal(t, 0, explorer.Requests(), "should not fetch the history of an address the device doesn't own")
+}
@@ -1,5 +1,58 @@
 package watcher
 
+import (
+	"airdao-mobile-api/pkg/apierror"
+	"airdao-mobile-api/services/price"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	HistoryFormatRaw  = "raw"
+	HistoryFormatOHLC = "ohlc"
+)
+
+// Domain errors of the watcher API. Handlers return them as they are and the
+// central error handler renders their status and code.
+var (
+	ErrWatcherNotFound          = apierror.New(fiber.StatusNotFound, "watcher_not_found", "watcher not found")
+	ErrWatcherAlreadyExists     = apierror.New(fiber.StatusConflict, "watcher_already_exists", "watcher for this address and token already exist")
+	ErrInvalidPushToken         = apierror.New(fiber.StatusBadRequest, "invalid_push_token", "invalid push token")
+	ErrPushTokenTaken           = apierror.New(fiber.StatusConflict, "push_token_taken", "push token belongs to another device")
+	ErrAddressAlreadyWatched    = apierror.New(fiber.StatusConflict, "address_already_watched", "address already is watching")
+	ErrAddressNotWatched        = apierror.New(fiber.StatusNotFound, "address_not_watched", "address is not watched")
+	ErrDeviceNotFound           = apierror.New(fiber.StatusNotFound, "device_not_found", "device not found")
+	ErrAlertNotFound            = apierror.New(fiber.StatusNotFound, "alert_not_found", "alert not found")
+	ErrInvalidParams            = apierror.New(fiber.StatusBadRequest, "invalid_params", "invalid params")
+	ErrInvalidCallbackSignature = apierror.New(fiber.StatusUnauthorized, "invalid_callback_signature", "invalid callback signature")
+	ErrWrongExplorerId          = apierror.New(fiber.StatusBadRequest, "wrong_explorer_id", "Wrong Explorer API ID")
+	ErrExplorerUnavailable      = apierror.New(fiber.StatusBadGateway, "explorer_unavailable", "transactions unavailable")
+
+	ErrInvalidHistoryFormat    = apierror.New(fiber.StatusBadRequest, "invalid_history_format", "invalid format (can be raw or ohlc)")
+	ErrUnsupportedCurrency     = price.ErrUnsupportedCurrency
+	ErrCurrencyRateUnavailable = price.ErrCurrencyRateUnavailable
+	ErrUnknownToken            = apierror.New(fiber.StatusBadRequest, "unknown_token", "unknown token")
+	ErrMissingReferencePrice   = apierror.New(fiber.StatusBadRequest, "missing_reference_price", "pinned alerts need a reference price")
+
+	ErrMissingCredential       = apierror.New(fiber.StatusUnauthorized, "missing_credential", "missing device credential")
+	ErrInvalidCredential       = apierror.New(fiber.StatusUnauthorized, "invalid_credential", "invalid device credential")
+	ErrCredentialRevoked       = apierror.New(fiber.StatusUnauthorized, "credential_revoked", "device credential was revoked")
+	ErrDeviceAuthUnavailable   = apierror.New(fiber.StatusServiceUnavailable, "device_auth_unavailable", "device credentials are not configured")
+	ErrDeviceAlreadyRegistered = apierror.New(fiber.StatusConflict, "device_already_registered", "device is already registered")
+	ErrInvalidRefreshToken     = apierror.New(fiber.StatusUnauthorized, "invalid_refresh_token", "invalid refresh token")
+	ErrRefreshTokenReused      = apierror.New(fiber.StatusUnauthorized, "refresh_token_reused", "refresh token was already used, device credentials are revoked")
+
+	ErrNoAddressChallenge      = apierror.New(fiber.StatusNotFound, "address_challenge_not_found", "no ownership challenge for this address")
+	ErrAddressChallengeExpired = apierror.New(fiber.StatusGone, "address_challenge_expired", "ownership challenge has expired")
+	ErrInvalidSignature        = apierror.New(fiber.StatusBadRequest, "invalid_signature", "invalid signature")
+	ErrAddressSignerMismatch   = apierror.New(fiber.StatusForbidden, "address_signer_mismatch", "signature was not made by this address")
+	ErrAddressNotVerified      = apierror.New(fiber.StatusForbidden, "address_not_verified", "address ownership is not verified")
+
+	ErrWatcherDisabled      = apierror.New(fiber.StatusConflict, "watcher_disabled", "watcher is disabled")
+	ErrNotificationNotFound = apierror.New(fiber.StatusNotFound, "notification_not_found", "notification not found")
+	ErrBackfillInProgress   = apierror.New(fiber.StatusConflict, "backfill_in_progress", "a backfill is already running")
+)
+
 type Account struct {
 	Balance struct {
 		Wei   string  `json:"wei"`
@@ -25,16 +78,38 @@ type ApiAddressData struct {
 	Account Account `json:"account"`
 }
 
+// ApiTokenBalance is a token held by an address, from the explorer
+// /addresses/{address}/tokens endpoint
+type ApiTokenBalance struct {
+	Address string `json:"address"`
+	Name    string `json:"name"`
+	Symbol  string `json:"symbol"`
+	Balance struct {
+		Wei   string  `json:"wei"`
+		Ether float64 `json:"ether"`
+	} `json:"balance"`
+}
+
+type ApiTokensData struct {
+	Data []ApiTokenBalance `json:"data"`
+}
+
 type ApiTxData struct {
 	Data []Tx `json:"data"`
 }
 
-type PriceData struct {
-	Data struct {
-		PriceUSD float64 `json:"price_usd"`
-	} `json:"data"`
+type HistoryQuery struct {
+	Range    string
+	Interval string
+	Format   string
+	Currency string
+	Token    string
 }
 
-type CGData struct {
-	Prices [][]float64 `json:"prices"`
+// HistoryPrices keeps the legacy "prices" shape for the raw format and
+// carries candles instead when the OHLC format is requested
+type HistoryPrices struct {
+	Prices  *[][]float64    `json:"prices,omitempty"`
+	Candles []*price.Candle `json:"candles,omitempty"`
+	Stats   *price.Stats    `json:"stats"`
 }
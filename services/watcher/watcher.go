@@ -1,16 +1,31 @@
 package watcher
 
 import (
-	"errors"
 	"fmt"
 	"time"
 
+	"airdao-mobile-api/pkg/pricefeed"
+	"airdao-mobile-api/services/price"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Address struct {
 	Address string  `json:"address" bson:"address"`
 	LastTx  *string `json:"last_tx" bson:"last_tx"`
+	// Verified is set once the device proved it holds the address key
+	Verified   bool       `json:"verified" bson:"verified"`
+	VerifiedAt *time.Time `json:"verified_at" bson:"verified_at"`
+
+	Challenge *AddressChallenge `json:"-" bson:"challenge"`
+}
+
+// AddressChallenge is the message the wallet has to sign to prove ownership.
+type AddressChallenge struct {
+	Address   string    `json:"address" bson:"-"`
+	Nonce     string    `json:"nonce" bson:"nonce"`
+	Message   string    `json:"message" bson:"message"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
 }
 
 type HistoryNotification struct {
@@ -20,6 +35,75 @@ type HistoryNotification struct {
 	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
 }
 
+const (
+	// AlertModeLastAlert measures change from the price of the last alert
+	AlertModeLastAlert = "last_alert"
+	// AlertModeWindow1h and AlertModeWindow24h measure change over a rolling window
+	AlertModeWindow1h  = "window_1h"
+	AlertModeWindow24h = "window_24h"
+	// AlertModePinned measures change from a reference price set by the user
+	AlertModePinned = "pinned"
+)
+
+// PriceAlertState is the mode of a price alert and what it last fired on.
+// Triggered is the direction of the last alert, 1 up or -1 down, until the
+// change falls back inside the threshold. It is unused in the last alert
+// mode, where every alert moves the reference price.
+type PriceAlertState struct {
+	Mode        string     `json:"mode" bson:"mode"`
+	Triggered   int        `json:"triggered" bson:"triggered"`
+	LastAlertAt *time.Time `json:"last_alert_at" bson:"last_alert_at"`
+}
+
+func (st *PriceAlertState) AlertMode() string {
+	if st.Mode == "" {
+		return AlertModeLastAlert
+	}
+
+	return st.Mode
+}
+
+// TokenAlert is a price alert on a token other than AMB, which keeps the
+// original Threshold, TokenPrice and PriceNotification watcher fields.
+// TokenPrice is the reference price in the watcher display currency.
+type TokenAlert struct {
+	Token        string   `json:"token" bson:"token"`
+	Threshold    *float64 `json:"threshold" bson:"threshold"`
+	TokenPrice   *float64 `json:"token_price" bson:"token_price"`
+	Notification string   `json:"notification" bson:"notification"`
+
+	PriceAlertState `bson:",inline"`
+}
+
+// DeviceCredential is the refresh token state of the device owning the
+// watcher. Only token hashes are stored. Version is carried by access tokens
+// and bumped on revocation, which invalidates every token issued before.
+type DeviceCredential struct {
+	RefreshTokenHash         string    `bson:"refresh_token_hash"`
+	PreviousRefreshTokenHash string    `bson:"previous_refresh_token_hash"`
+	RefreshExpiresAt         time.Time `bson:"refresh_expires_at"`
+	Version                  int       `bson:"version"`
+	IssuedAt                 time.Time `bson:"issued_at"`
+}
+
+// Active reports whether the device holds a refresh token it can still use.
+func (dc *DeviceCredential) Active() bool {
+	return dc != nil && dc.RefreshTokenHash != "" && time.Now().Before(dc.RefreshExpiresAt)
+}
+
+const (
+	PlatformIOS     = "ios"
+	PlatformAndroid = "android"
+)
+
+// AppInfo is what the app reports about itself. Broadcasts use it to target
+// a segment of the devices.
+type AppInfo struct {
+	Platform   string `json:"platform" bson:"platform" validate:"omitempty,oneof=ios android"`
+	Locale     string `json:"locale" bson:"locale" validate:"omitempty,max=35"`
+	AppVersion string `json:"app_version" bson:"app_version" validate:"omitempty,max=32"`
+}
+
 type Watcher struct {
 	ID primitive.ObjectID `json:"id" bson:"_id"`
 
@@ -29,21 +113,40 @@ type Watcher struct {
 	TokenPrice        *float64 `json:"token_price" bson:"token_price"`
 	TxNotification    string   `json:"tx_notification" bson:"tx_notification"`
 	PriceNotification string   `json:"price_notification" bson:"price_notification"`
+	// Currency is the display currency of prices, thresholds and tx values.
+	// TokenPrice is kept in this currency. Empty means USD.
+	Currency string `json:"currency" bson:"currency"`
+
+	TokenAlerts *[]*TokenAlert `json:"token_alerts" bson:"token_alerts"`
+	// PriceAlertState is the state of the AMB price alert
+	PriceAlertState *PriceAlertState `json:"price_alert_state" bson:"price_alert_state"`
 
 	Addresses *[]*Address `json:"addresses" bson:"addresses"`
 
+	App *AppInfo `json:"app" bson:"app"`
+
+	Credential *DeviceCredential `json:"-" bson:"credential"`
+
+	// Disabled watchers are kept but get no notifications and their
+	// addresses are not watched
+	Disabled   bool       `json:"disabled" bson:"disabled"`
+	DisabledAt *time.Time `json:"disabled_at" bson:"disabled_at"`
+
 	HistoricalNotifications *[]*HistoryNotification `json:"historical_notifications" bson:"historical_notifications"`
 
 	LastSuccessDate time.Time `json:"last_success_date" bson:"last_success_date"`
 	LastFailDate    time.Time `json:"last_fail_date" bson:"last_fail_date"`
 
+	// LastTestNotification is the report of the last test the device asked for
+	LastTestNotification *NotificationReport `json:"last_test_notification" bson:"last_test_notification"`
+
 	CreatedAt time.Time `json:"created_at" bson:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
 }
 
 func NewWatcher(pushToken string) (*Watcher, error) {
 	if pushToken == "" {
-		return nil, errors.New("invalid push token")
+		return nil, ErrInvalidPushToken
 	}
 
 	return &Watcher{
@@ -99,6 +202,54 @@ func (w *Watcher) SetLastTx(address string, tx string) {
 	w.UpdatedAt = time.Now()
 }
 
+func (w *Watcher) HasAddress(address string) bool {
+	if w.Addresses == nil {
+		return false
+	}
+
+	for _, v := range *w.Addresses {
+		if v.Address == address {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetAddress returns the watched address, or nil when it isn't watched.
+func (w *Watcher) GetAddress(address string) *Address {
+	if w.Addresses == nil {
+		return nil
+	}
+
+	for _, v := range *w.Addresses {
+		if v.Address == address {
+			return v
+		}
+	}
+
+	return nil
+}
+
+func (w *Watcher) IsVerified(address string) bool {
+	v := w.GetAddress(address)
+	return v != nil && v.Verified
+}
+
+func (w *Watcher) LastTxFor(address string) *string {
+	if w.Addresses == nil {
+		return nil
+	}
+
+	for _, v := range *w.Addresses {
+		if v.Address == address {
+			return v.LastTx
+		}
+	}
+
+	return nil
+}
+
 func (w *Watcher) SetThreshold(threshold float64) {
 	fmt.Printf("SetThreshold %v\n", threshold)
 	w.Threshold = &threshold
@@ -129,6 +280,100 @@ func (w *Watcher) AddNotification(title, body string, sent bool, timestamp time.
 
 }
 
+func (w *Watcher) TokenAlert(token string) *TokenAlert {
+	if w.TokenAlerts == nil {
+		return nil
+	}
+
+	for _, v := range *w.TokenAlerts {
+		if v.Token == token {
+			return v
+		}
+	}
+
+	return nil
+}
+
+func (w *Watcher) AddTokenAlert(alert *TokenAlert) {
+	if w.TokenAlerts == nil {
+		w.TokenAlerts = &[]*TokenAlert{}
+	}
+
+	*w.TokenAlerts = append(*w.TokenAlerts, alert)
+	w.UpdatedAt = time.Now()
+}
+
+func (w *Watcher) DeleteTokenAlert(token string) {
+	if w.TokenAlerts != nil {
+		for i, v := range *w.TokenAlerts {
+			if v.Token == token {
+				*w.TokenAlerts = append((*w.TokenAlerts)[:i], (*w.TokenAlerts)[i+1:]...)
+				w.UpdatedAt = time.Now()
+				break
+			}
+		}
+	}
+}
+
+// PriceAlert returns the threshold, reference price and notification switch
+// of the token price alert. AMB keeps the original watcher fields.
+func (w *Watcher) PriceAlert(token string) (*float64, *float64, string, bool) {
+	if token == price.DefaultToken {
+		return w.Threshold, w.TokenPrice, w.PriceNotification, true
+	}
+
+	alert := w.TokenAlert(token)
+	if alert == nil {
+		return nil, nil, "", false
+	}
+
+	return alert.Threshold, alert.TokenPrice, alert.Notification, true
+}
+
+// AlertState returns the mode and state of the token price alert, or nil
+// when the watcher has no alert for token.
+func (w *Watcher) AlertState(token string) *PriceAlertState {
+	if token == price.DefaultToken {
+		if w.PriceAlertState == nil {
+			w.PriceAlertState = &PriceAlertState{}
+		}
+		return w.PriceAlertState
+	}
+
+	alert := w.TokenAlert(token)
+	if alert == nil {
+		return nil
+	}
+
+	return &alert.PriceAlertState
+}
+
+// SetReferencePrice sets the price the next alert of token is measured from.
+func (w *Watcher) SetReferencePrice(token string, v float64) {
+	if token == price.DefaultToken {
+		w.SetTokenPrice(v)
+		return
+	}
+
+	if alert := w.TokenAlert(token); alert != nil {
+		alert.TokenPrice = &v
+		w.UpdatedAt = time.Now()
+	}
+}
+
+func (w *Watcher) DisplayCurrency() string {
+	if w.Currency == "" {
+		return pricefeed.BaseCurrency
+	}
+
+	return w.Currency
+}
+
+func (w *Watcher) SetCurrency(v string) {
+	w.Currency = v
+	w.UpdatedAt = time.Now()
+}
+
 func (w *Watcher) SetTxNotification(v string) {
 	w.TxNotification = v
 	w.UpdatedAt = time.Now()
@@ -158,3 +403,40 @@ func (w *Watcher) SetDeviceId(v string) {
 	w.DeviceId = v
 	w.UpdatedAt = time.Now()
 }
+
+func (w *Watcher) SetDisabled(v bool, date time.Time) {
+	w.Disabled = v
+	w.DisabledAt = nil
+	if v {
+		w.DisabledAt = &date
+	}
+	w.UpdatedAt = time.Now()
+}
+
+// SetApp keeps the reported app fields, the empty ones don't clear the
+// stored value. It reports whether anything changed.
+func (w *Watcher) SetApp(app AppInfo) bool {
+	current := AppInfo{}
+	if w.App != nil {
+		current = *w.App
+	}
+
+	updated := current
+	if app.Platform != "" {
+		updated.Platform = app.Platform
+	}
+	if app.Locale != "" {
+		updated.Locale = app.Locale
+	}
+	if app.AppVersion != "" {
+		updated.AppVersion = app.AppVersion
+	}
+	if updated == current {
+		return false
+	}
+
+	w.App = &updated
+	w.UpdatedAt = time.Now()
+
+	return true
+}
@@ -0,0 +1,58 @@
+package watcher
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+const watcherLockStripes = 64
+
+// watcherLocks serializes the changes to watchers. The same *Watcher is
+// shared by the callback workers, backfill, price alerts and the handlers,
+// which all change it in memory before saving it. Watchers are striped by
+// id, so a lock is never taken while holding another one except through
+// LockAll.
+//
+// A watcher lock may be taken before s.mx, never while holding it.
+type watcherLocks struct {
+	stripes [watcherLockStripes]sync.Mutex
+}
+
+func (l *watcherLocks) stripe(watcher *Watcher) int {
+	h := fnv.New32a()
+	h.Write(watcher.ID[:])
+	return int(h.Sum32() % watcherLockStripes)
+}
+
+// Lock locks the watcher and returns the func unlocking it.
+func (l *watcherLocks) Lock(watcher *Watcher) func() {
+	mx := &l.stripes[l.stripe(watcher)]
+	mx.Lock()
+	return mx.Unlock
+}
+
+// LockAll locks every watcher, in stripe order so that two callers can't
+// deadlock, and returns the func unlocking them.
+func (l *watcherLocks) LockAll(watchers []*Watcher) func() {
+	seen := make(map[int]bool, len(watchers))
+	stripes := make([]int, 0, len(watchers))
+	for _, watcher := range watchers {
+		stripe := l.stripe(watcher)
+		if !seen[stripe] {
+			seen[stripe] = true
+			stripes = append(stripes, stripe)
+		}
+	}
+	sort.Ints(stripes)
+
+	for _, stripe := range stripes {
+		l.stripes[stripe].Lock()
+	}
+
+	return func() {
+		for i := len(stripes) - 1; i >= 0; i-- {
+			l.stripes[stripes[i]].Unlock()
+		}
+	}
+}